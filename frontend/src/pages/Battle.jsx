@@ -139,10 +139,13 @@ function Battle() {
         setGameId(data.battle.gameId);
       }
 
-      // Check if both users have answered and calculate damage preview
+      // Check if both users have answered and calculate damage preview. Students only get the
+      // correct answers once the round is logged.
       if (
         data.attackerQuestion?.submittedAt &&
         data.defenderQuestion?.submittedAt &&
+        data.attackerQuestion.answer &&
+        data.defenderQuestion.answer &&
         !showResults
       ) {
         // Parse questions to get correct answers
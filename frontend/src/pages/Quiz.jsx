@@ -1,4 +1,4 @@
-import { useState, useEffect } from "react";
+import { useState, useEffect, useRef } from "react";
 import { useParams, useNavigate, useSearchParams } from "react-router-dom";
 import "./Quiz.css";
 
@@ -22,6 +22,18 @@ function Quiz() {
   const [selectedAsset, setSelectedAsset] = useState(null);
   const [avatarId, setAvatarId] = useState(null);
   const [isRetake, setIsRetake] = useState(false);
+  // Seconds spent on each question (by question ID) and when the current question was shown
+  const timeSpentRef = useRef({});
+  const questionShownAtRef = useRef(null);
+
+  // Add the time since the current question was shown to its total
+  const recordTimeSpent = (questionId) => {
+    if (questionShownAtRef.current === null) return;
+    const seconds = Math.round((Date.now() - questionShownAtRef.current) / 1000);
+    timeSpentRef.current[questionId] =
+      (timeSpentRef.current[questionId] || 0) + seconds;
+    questionShownAtRef.current = null;
+  };
 
   // Fetch user's assets
   useEffect(() => {
@@ -128,32 +140,10 @@ function Quiz() {
             // Reconstruct results from saved data
             let totalCoins = 0;
             const questionResults = quizData.map((q) => {
-              // Use is_correct if available (standardized format)
-              let isCorrect = q.is_correct !== undefined ? q.is_correct : false;
+              // The server grades every submitted answer into is_correct
+              const isCorrect = q.is_correct === true;
               const userAnswer = q.user_answer || q.userAnswer || "No answer";
 
-              // If is_correct is not available, calculate it
-              if (q.is_correct === undefined) {
-                // Check if answer is correct
-                if (q.type === "multiple" || q.type === "multiple-choice") {
-                  // For multiple choice, find the index of the user's answer
-                  const optionsList = q.options || q.answer;
-                  const userAnswerIndex = optionsList.findIndex(
-                    (opt) => opt === userAnswer,
-                  );
-                  isCorrect = q.correct === userAnswerIndex;
-                } else if (q.type === "typed" || q.type === "input") {
-                  // For typed answers, check against acceptable answers
-                  const acceptableAnswers = Array.isArray(q.answer)
-                    ? q.answer
-                    : [q.answer];
-                  isCorrect = acceptableAnswers.some(
-                    (correct) =>
-                      correct.toLowerCase() === userAnswer.toLowerCase().trim(),
-                  );
-                }
-              }
-
               const coinsEarned = isCorrect ? q.coins_worth : 0;
               totalCoins += coinsEarned;
 
@@ -190,6 +180,10 @@ function Quiz() {
     if (quizStarted && !quizCompleted && questions.length > 0) {
       const currentQ = questions[currentQuestion];
       setQuestionTimeLeft(currentQ.time_alloted || 30); // Default 30 seconds if not specified
+
+      // Count the time on this question until the student moves on
+      questionShownAtRef.current = Date.now();
+      return () => recordTimeSpent(currentQ.id);
     }
   }, [quizStarted, currentQuestion, questions, quizCompleted]);
 
@@ -260,6 +254,7 @@ function Quiz() {
     setAnswers({});
     setTypedAnswers({});
     setCurrentQuestion(0);
+    timeSpentRef.current = {};
   };
 
   const handleAnswer = (questionId, answerIndex) => {
@@ -300,97 +295,35 @@ function Quiz() {
       }
     }
 
-    // Calculate results
-    let totalCoins = 0;
-    const questionResults = questions.map((q) => {
-      let isCorrect = false;
+    recordTimeSpent(questions[currentQuestion].id);
+
+    // Collect the answers; the server grades them and sends back the results
+    const userAnswersForBackend = questions.map((q) => {
       let userAnswer = "No answer";
-      let correctAnswer = "";
 
       // Handle multiple choice questions (both "multiple" and "multiple-choice" types)
       if (q.type === "multiple" || q.type === "multiple-choice") {
         const answerIndex = answers[q.id];
         // Support both "answer" and "options" field names
         const optionsList = q.options || q.answer;
-
-        // Get correct answer
-        if (q.correct !== undefined && q.correct !== null) {
-          correctAnswer = optionsList[q.correct];
-        }
-
         if (answerIndex !== undefined) {
           userAnswer = optionsList[answerIndex];
-          isCorrect = q.correct === answerIndex;
         }
       }
       // Handle input/typed questions (both "typed" and "input" types)
       else if (q.type === "typed" || q.type === "input") {
-        const typedAnswer = typedAnswers[q.id];
-
-        // For typed answers, q.answer should be an array of acceptable answers
-        const acceptableAnswers = Array.isArray(q.answer)
-          ? q.answer
-          : [q.answer];
-
-        // Set correct answer (show first acceptable answer)
-        correctAnswer = Array.isArray(q.answer) ? q.answer[0] : q.answer;
-
-        if (typedAnswer) {
-          userAnswer = typedAnswer;
-          isCorrect = acceptableAnswers.some(
-            (correct) =>
-              correct.toLowerCase() === typedAnswer.toLowerCase().trim(),
-          );
-        }
-      }
-
-      // Calculate coins earned for this question
-      let coinsEarned = 0;
-      if (isCorrect) {
-        coinsEarned = q.coins_worth;
-        // Apply 20% for retakes
-        if (isRetake) {
-          coinsEarned = Math.floor(q.coins_worth * 0.2);
+        if (typedAnswers[q.id]) {
+          userAnswer = typedAnswers[q.id];
         }
-        totalCoins += coinsEarned;
       }
 
       return {
         questionId: q.id,
-        question: q.question,
-        type: q.type,
         userAnswer,
-        correctAnswer,
-        isCorrect,
-        coinsEarned,
+        timeSpent: timeSpentRef.current[q.id], // Seconds, for the teacher's analytics
       };
     });
 
-    const resultsData = {
-      totalCoins,
-      questionResults,
-      completedAt: new Date().toISOString(),
-    };
-
-    setResults(resultsData);
-
-    // Prepare user answers for backend storage
-    const userAnswersForBackend = questionResults.map((result) => ({
-      questionId: result.questionId,
-      userAnswer: result.userAnswer,
-      isCorrect: result.isCorrect,
-    }));
-
-    // Calculate success rate for XP
-    const correctAnswers = questionResults.filter((r) => r.isCorrect).length;
-    const successRate = (correctAnswers / questions.length) * 100;
-    let xpGain = Math.floor(successRate / 2); // XP gain is half of success rate
-
-    // Apply 20% for retakes
-    if (isRetake) {
-      xpGain = Math.floor(xpGain * 0.2);
-    }
-
     // Submit to backend
     try {
       const token = localStorage.getItem("token");
@@ -402,11 +335,8 @@ function Quiz() {
         },
         body: JSON.stringify({
           assignmentId: assignment.id, // Use the database ID, not the category assignmentId
-          coinsReceived: totalCoins,
           userAnswers: userAnswersForBackend, // Include user answers
           assetId: selectedAsset?.id, // Include selected asset ID
-          xpGain: xpGain, // Include XP gain
-          isRetake: isRetake, // Include retake flag
         }),
       });
 
@@ -422,28 +352,44 @@ function Quiz() {
       }
 
       const data = await response.json();
-      if (data.success) {
-        console.log(
-          "Assignment submitted successfully. New coins:",
-          data.coins,
-        );
-
-        // Update results with asset data if available
-        if (data.assetData) {
-          setResults({
-            ...resultsData,
-            assetLeveledUp: data.assetLeveledUp,
-            assetData: data.assetData,
-          });
-        }
-      } else {
+      if (!data.success) {
         alert(
           `Failed to submit assignment: ${data.message || "Unknown error"}`,
         );
+        return;
       }
+      console.log("Assignment submitted successfully. New coins:", data.coins);
+
+      // The correct answers only come back with the graded results
+      const gradedResults = {};
+      (data.results || []).forEach((result) => {
+        gradedResults[result.questionId] = result;
+      });
+
+      const questionResults = questions.map((q, index) => {
+        const graded = gradedResults[q.id] || {};
+        return {
+          questionId: q.id,
+          question: q.question,
+          type: q.type,
+          userAnswer: userAnswersForBackend[index].userAnswer,
+          correctAnswer: graded.correctAnswer ?? "",
+          isCorrect: graded.isCorrect === true,
+          coinsEarned: graded.coinsEarned || 0,
+        };
+      });
+
+      setResults({
+        totalCoins: data.coinsReceived,
+        questionResults,
+        completedAt: new Date().toISOString(),
+        assetLeveledUp: data.assetLeveledUp,
+        assetData: data.assetData,
+      });
     } catch (error) {
       console.error("Error submitting assignment:", error);
       alert(`Error submitting assignment: ${error.message}`);
+      return;
     }
 
     setQuizCompleted(true);
@@ -10,6 +10,6 @@ require (
 require github.com/golang-jwt/jwt/v5 v5.3.0
 
 require (
-	github.com/gorilla/websocket v1.5.3 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
+	github.com/gorilla/websocket v1.5.3
+	github.com/joho/godotenv v1.5.1
 )
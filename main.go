@@ -1,22 +1,33 @@
 package main
 
 import (
+	"archive/zip"
+	crand "crypto/rand"
 	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"math/rand"
+	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
 	"github.com/joho/godotenv"
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -171,34 +182,121 @@ type Assignment struct {
 	CoinsReceived int             `json:"coinsReceived"`
 	Data          json.RawMessage `json:"data,omitempty"`
 	RetakeCount   int             `json:"retakeCount"`
+	ShuffleSeed   *int64          `json:"shuffleSeed,omitempty"` // Seed used to shuffle this student's questions (admin views only)
 }
 
 // QuizQuestion represents a standardized quiz question structure
 type QuizQuestion struct {
-	ID          string      `json:"id"`                    // Random alphanumeric string
-	Type        string      `json:"type"`                  // "multiple" or "input"
-	Question    string      `json:"question"`              // Question text
-	Answer      interface{} `json:"answer"`                // Array for multiple choice, string for input
-	Correct     *int        `json:"correct"`               // Index of correct answer (null for input)
-	CoinsWorth  int         `json:"coins_worth"`           // Coins awarded for correct answer
-	TimeAlloted int         `json:"time_alloted"`          // Time limit in seconds
-	UserAnswer  interface{} `json:"user_answer,omitempty"` // User's submitted answer
-	IsCorrect   *bool       `json:"is_correct,omitempty"`  // Whether user got it right
+	ID          string        `json:"id"`                    // Random alphanumeric string
+	Type        string        `json:"type"`                  // "multiple", "input", "written", "listen" or "speak"
+	Question    string        `json:"question"`              // Question text
+	Answer      interface{}   `json:"answer"`                // Array for multiple choice, string for input
+	Options     []interface{} `json:"options,omitempty"`     // Choices, when a quiz stores them here instead of in "answer"
+	Correct     *int          `json:"correct"`               // Index of correct answer (null for input)
+	CoinsWorth  int           `json:"coins_worth"`           // Coins awarded for correct answer
+	TimeAlloted int           `json:"time_alloted"`          // Time limit in seconds
+	UserAnswer  interface{}   `json:"user_answer,omitempty"` // User's submitted answer
+	IsCorrect   *bool         `json:"is_correct,omitempty"`  // Whether user got it right
+	MediaID     *int          `json:"media_id,omitempty"`    // Audio clip played by "listen" questions
+
+	// Per-student shuffle bookkeeping (set when the assignment was created with shuffle on)
+	OriginalIndex *int  `json:"original_index,omitempty"` // Position of the question in the teacher's original list
+	ChoiceOrder   []int `json:"choice_order,omitempty"`   // Original choice index for each displayed choice
+
+	// Analytics fields
+	Tags      []string `json:"tags,omitempty"`       // Topics this question practices (e.g. "verbs", "numbers")
+	TimeSpent *int     `json:"time_spent,omitempty"` // Seconds the student spent answering
+
+	// Teacher grading fields (only used by "written" and "speak" questions)
+	GradingStatus string `json:"grading_status,omitempty"` // "pending", "graded" or "released"
+	PointsAwarded *int   `json:"points_awarded,omitempty"` // Points the teacher gave (released grades only)
+	Feedback      string `json:"feedback,omitempty"`       // Teacher comment (released grades only)
+}
+
+// GradedResponse is a student answer waiting for (or graded by) the teacher
+type GradedResponse struct {
+	ID             int     `json:"id"`
+	AssignmentID   int     `json:"assignmentId"` // Database ID of the assignment row
+	AssignmentName string  `json:"assignmentName"`
+	UserID         int     `json:"userId"`
+	StudentName    string  `json:"studentName"`
+	QuestionID     string  `json:"questionId"`
+	QuestionType   string  `json:"questionType"`
+	Question       string  `json:"question"`
+	Response       string  `json:"response"`
+	MediaURL       *string `json:"mediaUrl,omitempty"` // Student recording for "speak" questions
+	PossiblePoints int     `json:"possiblePoints"`
+	PointsAwarded  *int    `json:"pointsAwarded,omitempty"`
+	Comment        string  `json:"comment"`
+	Status         string  `json:"status"` // pending, graded, released
+	SubmittedAt    string  `json:"submittedAt"`
+	GradedAt       *string `json:"gradedAt,omitempty"`
+	ReleasedAt     *string `json:"releasedAt,omitempty"`
+}
+
+// Media is an uploaded audio file (question clips and student recordings)
+type Media struct {
+	ID           int       `json:"id"`
+	OriginalName string    `json:"originalName"`
+	ContentType  string    `json:"contentType"`
+	Size         int64     `json:"size"`
+	Kind         string    `json:"kind"` // "clip" (admin upload) or "recording" (student upload)
+	UploadedBy   int       `json:"uploadedBy"`
+	CreatedAt    time.Time `json:"createdAt"`
+	URL          string    `json:"url"`
 }
 
 type Game struct {
-	ID               int        `json:"id"`
-	Name             string     `json:"name"`
-	Thumbnail        string     `json:"thumbnail"`
-	Rows             int        `json:"rows"`
-	Columns          int        `json:"columns"`
-	CurrentTurnIndex int        `json:"currentTurnIndex"`
-	TurnStartTime    *time.Time `json:"-"`                       // Don't serialize directly
-	TurnStartTimeISO *string    `json:"turnStartTime,omitempty"` // ISO 8601 format for frontend
-	TurnDuration     int        `json:"turnDuration"`            // in seconds
-	BattleID         *int       `json:"battleId,omitempty"`
-	CreatedAt        time.Time  `json:"createdAt"`
-	Avatars          []int      `json:"avatars,omitempty"` // Avatar IDs in turn order
+	ID                 int         `json:"id"`
+	Name               string      `json:"name"`
+	Thumbnail          string      `json:"thumbnail"`
+	Rows               int         `json:"rows"`
+	Columns            int         `json:"columns"`
+	CurrentTurnIndex   int         `json:"currentTurnIndex"`
+	TurnStartTime      *time.Time  `json:"-"`                       // Don't serialize directly
+	TurnStartTimeISO   *string     `json:"turnStartTime,omitempty"` // ISO 8601 format for frontend
+	TurnDuration       int         `json:"turnDuration"`            // in seconds
+	BattleID           *int        `json:"battleId,omitempty"`
+	ActionsPerTurn     int         `json:"actionsPerTurn"` // Placements/moves allowed per turn
+	ActionsTaken       int         `json:"actionsTaken"`   // Actions used in the current turn
+	Status             string      `json:"status"`         // "setup", "active", "paused" or "finished"
+	TurnNumber         int         `json:"turnNumber"`     // Increments every time the turn changes
+	Round              int         `json:"round"`          // Full rounds played (every avatar had a turn)
+	WinCondition       string      `json:"winCondition"`   // "none", "cells", "last_standing", "coins" or "rounds"
+	WinTarget          int         `json:"winTarget"`      // Cells, coins or rounds needed to win
+	Prizes             []GamePrize `json:"prizes"`         // Paid out when the game finishes
+	WinnerAvatarID     *int        `json:"winnerAvatarId,omitempty"`
+	KeepXP             bool        `json:"keepXp"`             // XP earned in the game is added to the warriors for good
+	Permadeath         bool        `json:"permadeath"`         // Warriors defeated in the game die for good ("rip" outside the game too)
+	FogOfWar           bool        `json:"fogOfWar"`           // Players only see enemy warriors near their own
+	VisionRadius       int         `json:"visionRadius"`       // How many steps a warrior sees on a fogged board
+	Topology           string      `json:"topology"`           // "square" or "hex" (see boardNeighbors)
+	BattleFormat       string      `json:"battleFormat"`       // How board battles are fought (see battleFormats)
+	AutoResolveBattles bool        `json:"autoResolveBattles"` // Board battles resolve themselves (see autoResolveBattle)
+	Archived           bool        `json:"archived"`           // Hidden from the games list
+	CreatedAt          time.Time   `json:"createdAt"`
+	Avatars            []int       `json:"avatars,omitempty"`          // Avatar IDs in turn order
+	AbsentAvatars      []int       `json:"absentAvatars,omitempty"`    // Avatars skipped by the turn clock
+	AutopilotAvatars   []int       `json:"autopilotAvatars,omitempty"` // Avatars whose turns a bot plays
+}
+
+// A prize paid out when a game finishes. Place 1 is the winner; place 0 goes to every player.
+type GamePrize struct {
+	Place     int    `json:"place"`
+	Type      string `json:"type"`                // "coins", "xp" or "asset"
+	Amount    int    `json:"amount,omitempty"`    // Coins or XP
+	AssetName string `json:"assetName,omitempty"` // Store asset given for "asset" prizes
+}
+
+// An avatar's position in a game
+type GameStanding struct {
+	Rank           int      `json:"rank"`
+	AvatarID       int      `json:"avatarId"`
+	AvatarName     string   `json:"avatarName"`
+	Cells          int      `json:"cells"`            // Cells held by its warriors
+	WarriorsLeft   int      `json:"warriorsLeft"`     // Warriors deployed in this game and still able to fight
+	CoinsCollected int      `json:"coinsCollected"`   // Coins claimed from cells in this game
+	Prizes         []string `json:"prizes,omitempty"` // What it won when the game finished
 }
 
 type GameCell struct {
@@ -214,6 +312,81 @@ type GameCell struct {
 	Status      string `json:"status"`      // Status of the cell (max 20 chars)
 	RewardCoins int    `json:"rewardCoins"` // Coins reward for landing on this cell
 	RewardXP    int    `json:"rewardXp"`    // XP reward for landing on this cell
+	RegenRounds int    `json:"regenRounds"` // Rounds after a claim until the rewards come back (0 = never)
+}
+
+// A warrior's state in one game. Health, stamina and status start from the asset when it's deployed and from
+// then on only change here, so a warrior hurt in one game is fine in every other.
+type GameWarrior struct {
+	ID        int    `json:"id"`
+	GameID    int    `json:"gameId"`
+	WarriorID int    `json:"warriorId"` // assets.id
+	AvatarID  int    `json:"avatarId"`
+	Health    int    `json:"health"`
+	Stamina   int    `json:"stamina"`
+	XPGained  int    `json:"xpGained"` // XP earned in this game
+	Status    string `json:"status"`   // active, exhausted or rip
+	CellID    *int   `json:"cellId,omitempty"`
+}
+
+// A reward collected from a cell
+type CellRewardClaim struct {
+	ID        int       `json:"id"`
+	CellID    int       `json:"cellId"`
+	WarriorID int       `json:"warriorId"`
+	AvatarID  int       `json:"avatarId"`
+	Coins     int       `json:"coins"`
+	XP        int       `json:"xp"`
+	Round     int       `json:"round"`
+	ClaimedAt time.Time `json:"claimedAt"`
+}
+
+// A special effect on a game cell
+type CellEffect struct {
+	ID                int        `json:"id"`
+	GameID            int        `json:"gameId"`
+	CellID            int        `json:"cellId"`                      // game_cells.id
+	Type              string     `json:"type"`                        // heal, stamina, damage, freeze, teleport, buff or shop
+	Trigger           string     `json:"trigger"`                     // "enter" or "turn_start"
+	Amount            int        `json:"amount"`                      // Health/stamina restored, damage dealt or buff bonus
+	Duration          int        `json:"duration"`                    // Turns a freeze or buff lasts
+	Element           string     `json:"element,omitempty"`           // Buffs only apply to warriors of this element
+	TargetCellID      *int       `json:"targetCellId,omitempty"`      // Where a teleport sends the warrior
+	Charges           int        `json:"charges"`                     // Uses left, -1 for unlimited
+	InitialCharges    int        `json:"initialCharges"`              // Uses it started with
+	Cooldown          int        `json:"cooldown"`                    // Turns between two triggers
+	LastTriggeredTurn *int       `json:"lastTriggeredTurn,omitempty"` // Game turn number it last went off
+	ShopItems         []ShopItem `json:"shopItems,omitempty"`
+}
+
+// A consumable sold by a shop cell
+type ShopItem struct {
+	Name     string `json:"name"`
+	Effect   string `json:"effect"` // heal, stamina or buff
+	Amount   int    `json:"amount"`
+	Duration int    `json:"duration,omitempty"` // Turns a buff lasts
+	Cost     int    `json:"cost"`               // Coins
+}
+
+// A freeze or buff currently on a warrior
+type WarriorEffect struct {
+	ID           int    `json:"id"`
+	WarriorID    int    `json:"warriorId"`
+	Type         string `json:"type"` // "frozen" or "buff"
+	Amount       int    `json:"amount,omitempty"`
+	TurnsLeft    int    `json:"turnsLeft"` // Owner's turns it still lasts
+	SourceCellID *int   `json:"sourceCellId,omitempty"`
+}
+
+// A cell effect that just went off
+type TriggeredEffect struct {
+	EffectID  int    `json:"effectId"`
+	CellID    int    `json:"cellId"`
+	WarriorID int    `json:"warriorId"`
+	Type      string `json:"type"`
+	Amount    int    `json:"amount,omitempty"`
+	ToCellID  *int   `json:"toCellId,omitempty"` // Teleport destination
+	Defeated  bool   `json:"defeated,omitempty"` // The warrior was knocked out
 }
 
 type Battle struct {
@@ -228,6 +401,43 @@ type Battle struct {
 	AttackerAvatarID *int      `json:"attackerAvatarId,omitempty"` // Avatar ID of attacker
 	DefenderAvatarID *int      `json:"defenderAvatarId,omitempty"` // Avatar ID of defender
 	GameID           *int      `json:"gameId,omitempty"`           // Game ID this battle belongs to
+	Format           string    `json:"format"`                     // see battleFormats
+	AutoResolve      bool      `json:"autoResolve"`                // Rounds resolve once both sides answered or time ran out
+	Draw             bool      `json:"draw"`                       // Finished without a winner
+	ResolvedBy       *string   `json:"resolvedBy,omitempty"`       // "teacher", "auto", "timeout" or "override"
+}
+
+// Most rounds a battle can last; also caps "until_ko" battles that do no damage
+const maxBattleRounds = 10
+
+// Battle formats and the most question rounds each lasts. Best-of battles end early once a side has won
+// the majority of rounds; "until_ko" goes on until the defender is knocked out or the attacker runs out of stamina.
+var battleFormats = map[string]int{
+	"single":    1,
+	"best_of_3": 3,
+	"best_of_5": 5,
+	"until_ko":  maxBattleRounds,
+}
+
+// One question round of a battle: the answers given, the damage dealt and the fighters' health
+// and stamina after it. A side wins the round by answering right when the other side doesn't.
+type BattleRound struct {
+	ID                 int       `json:"id"`
+	BattleID           int       `json:"battleId"`
+	Round              int       `json:"round"`
+	AttackerQuestionID *int      `json:"attackerQuestionId,omitempty"`
+	DefenderQuestionID *int      `json:"defenderQuestionId,omitempty"`
+	AttackerAnswer     *string   `json:"attackerAnswer"`
+	DefenderAnswer     *string   `json:"defenderAnswer"`
+	AttackerCorrect    bool      `json:"attackerCorrect"`
+	DefenderCorrect    bool      `json:"defenderCorrect"`
+	Damage             int       `json:"damage"`      // Health the defender lost
+	StaminaLoss        int       `json:"staminaLoss"` // Stamina the attacker lost
+	AttackerHealth     int       `json:"attackerHealth"`
+	AttackerStamina    int       `json:"attackerStamina"`
+	DefenderHealth     int       `json:"defenderHealth"`
+	DefenderStamina    int       `json:"defenderStamina"`
+	CreatedAt          time.Time `json:"createdAt"`
 }
 
 type BattleQuestion struct {
@@ -249,6 +459,68 @@ type CreateGameRequest struct {
 	Rows      int    `json:"rows"`
 	Columns   int    `json:"columns"`
 	AvatarIDs []int  `json:"avatarIds"` // Selected avatars for this game
+
+	ActionsPerTurn int `json:"actionsPerTurn"` // Optional, defaults to 1
+
+	Status       string      `json:"status"`       // Optional: "setup" to prepare the board before starting, defaults to "active"
+	WinCondition string      `json:"winCondition"` // Optional, defaults to "none"
+	WinTarget    int         `json:"winTarget"`
+	Prizes       []GamePrize `json:"prizes"`
+	KeepXP       *bool       `json:"keepXp"`       // Optional, defaults to true
+	Permadeath   bool        `json:"permadeath"`   // Optional, defaults to false
+	FogOfWar     bool        `json:"fogOfWar"`     // Optional, defaults to false
+	VisionRadius int         `json:"visionRadius"` // Optional, defaults to 2
+
+	Topology string   `json:"topology"` // Optional: "square" (default) or "hex"
+	Shape    string   `json:"shape"`    // Optional: "rectangle" (default), "circle" (square boards) or "hexagon" (hex boards)
+	Mask     []string `json:"mask"`     // Optional cells ("B3") or ranges ("C3:D5") to leave inactive, e.g. water between islands
+
+	BattleFormat       string `json:"battleFormat"`       // Optional: "single" (default), "best_of_3", "best_of_5" or "until_ko"
+	AutoResolveBattles bool   `json:"autoResolveBattles"` // Optional, defaults to false (the teacher completes each battle)
+
+	// Optional board to lay out: a saved template or one given inline. Its size overrides rows and columns.
+	TemplateID int            `json:"templateId"`
+	Template   *BoardTemplate `json:"template"`
+}
+
+// A whole board as JSON, saved as a template, exported from a game or generated from a seed
+type BoardTemplate struct {
+	ID          int            `json:"id,omitempty"`
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Rows        int            `json:"rows"`
+	Columns     int            `json:"columns"`
+	Topology    string         `json:"topology,omitempty"` // "square" if empty
+	Seed        *int64         `json:"seed,omitempty"`     // Set on generated boards
+	Cells       []TemplateCell `json:"cells,omitempty"`
+	CreatedAt   string         `json:"createdAt,omitempty"`
+}
+
+// One cell of a board template, addressed by its chess-like ID
+type TemplateCell struct {
+	CellID      string           `json:"cellId"`
+	Name        string           `json:"name"`
+	Description string           `json:"description,omitempty"`
+	Background  string           `json:"background"`
+	Active      bool             `json:"active"`
+	Element     string           `json:"element,omitempty"` // Kingdom the cell belongs to
+	RewardCoins int              `json:"rewardCoins,omitempty"`
+	RewardXP    int              `json:"rewardXp,omitempty"`
+	RegenRounds int              `json:"regenRounds,omitempty"`
+	Effects     []TemplateEffect `json:"effects,omitempty"`
+}
+
+// A cell effect in a board template; teleports name their target by chess-like ID
+type TemplateEffect struct {
+	Type         string     `json:"type"`
+	Trigger      string     `json:"trigger,omitempty"` // Defaults to "enter"
+	Amount       int        `json:"amount,omitempty"`
+	Duration     int        `json:"duration,omitempty"`
+	Element      string     `json:"element,omitempty"`
+	TargetCellID string     `json:"targetCellId,omitempty"`
+	Charges      *int       `json:"charges,omitempty"` // Defaults to unlimited
+	Cooldown     int        `json:"cooldown,omitempty"`
+	ShopItems    []ShopItem `json:"shopItems,omitempty"`
 }
 
 var db *sql.DB
@@ -275,7 +547,14 @@ func initDB() {
 		dbPath = "./data.db"
 	}
 
-	db, err = sql.Open("sqlite3", dbPath)
+	// Wait for a locked database instead of failing, and take the write lock when a transaction starts
+	// so concurrent game mutations queue up instead of deadlocking
+	dsn := dbPath + "?_busy_timeout=5000&_txlock=immediate"
+	if strings.Contains(dbPath, "?") {
+		dsn = dbPath + "&_busy_timeout=5000&_txlock=immediate"
+	}
+
+	db, err = sql.Open("sqlite3", dsn)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -546,6 +825,73 @@ func initDB() {
 		log.Printf("Warning: Could not add retake_count column: %v", err)
 	}
 
+	// Create graded_responses table (teacher grading queue for written answers)
+	createGradedResponsesTableSQL := `CREATE TABLE IF NOT EXISTS graded_responses (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		assignment_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		question_id TEXT NOT NULL,
+		question_type TEXT NOT NULL DEFAULT 'written',
+		question TEXT NOT NULL,
+		response TEXT NOT NULL DEFAULT '',
+		possible_points INTEGER NOT NULL DEFAULT 0,
+		points_awarded INTEGER DEFAULT NULL,
+		comment TEXT DEFAULT '',
+		status TEXT NOT NULL DEFAULT 'pending',
+		submitted_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		graded_at DATETIME,
+		released_at DATETIME,
+		FOREIGN KEY (assignment_id) REFERENCES assignments(id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);`
+
+	_, err = db.Exec(createGradedResponsesTableSQL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Add media_id column for "speak" recordings (migration)
+	_, err = db.Exec(`ALTER TABLE graded_responses ADD COLUMN media_id INTEGER DEFAULT NULL`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add media_id column: %v", err)
+	}
+
+	// Create media table for uploaded audio
+	createMediaTableSQL := `CREATE TABLE IF NOT EXISTS media (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		file_name TEXT NOT NULL,
+		original_name TEXT NOT NULL,
+		content_type TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		kind TEXT NOT NULL,
+		uploaded_by INTEGER NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (uploaded_by) REFERENCES users(id)
+	);`
+
+	_, err = db.Exec(createMediaTableSQL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Add shuffle_seed column if it doesn't exist (for existing databases)
+	_, err = db.Exec(`ALTER TABLE assignments ADD COLUMN shuffle_seed INTEGER DEFAULT NULL`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add shuffle_seed column: %v", err)
+	}
+
+	// Add completed_at column for late tracking (migration)
+	_, err = db.Exec(`ALTER TABLE assignments ADD COLUMN completed_at DATETIME DEFAULT NULL`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add completed_at column: %v", err)
+	}
+
+	// Add category column for gradebook weighting (migration)
+	_, err = db.Exec(`ALTER TABLE assignments ADD COLUMN category TEXT DEFAULT ''`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add category column: %v", err)
+	}
+
 	createGamesTableSQL := `CREATE TABLE IF NOT EXISTS games (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		name TEXT NOT NULL,
@@ -585,6 +931,113 @@ func initDB() {
 	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
 		log.Printf("Warning: Could not add battle_id column: %v", err)
 	}
+
+	// Add action budget columns (how many placements/moves a player gets per turn)
+	_, err = db.Exec(`ALTER TABLE games ADD COLUMN actions_per_turn INTEGER DEFAULT 1`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add actions_per_turn column: %v", err)
+	}
+
+	_, err = db.Exec(`ALTER TABLE games ADD COLUMN actions_taken INTEGER DEFAULT 0`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add actions_taken column: %v", err)
+	}
+
+	// Add turn clock columns (the server advances turns, admins can pause)
+	_, err = db.Exec(`ALTER TABLE games ADD COLUMN status TEXT DEFAULT 'active'`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add games status column: %v", err)
+	}
+
+	_, err = db.Exec(`ALTER TABLE games ADD COLUMN paused_at DATETIME`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add paused_at column: %v", err)
+	}
+
+	_, err = db.Exec(`ALTER TABLE games ADD COLUMN turn_number INTEGER DEFAULT 0`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add turn_number column: %v", err)
+	}
+
+	// Add victory columns (win condition, prizes and the result)
+	_, err = db.Exec(`ALTER TABLE games ADD COLUMN round INTEGER DEFAULT 0`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add round column: %v", err)
+	}
+
+	_, err = db.Exec(`ALTER TABLE games ADD COLUMN keep_xp INTEGER DEFAULT 1`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add keep_xp column: %v", err)
+	}
+
+	_, err = db.Exec(`ALTER TABLE games ADD COLUMN permadeath INTEGER DEFAULT 0`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add permadeath column: %v", err)
+	}
+
+	_, err = db.Exec(`ALTER TABLE games ADD COLUMN fog_of_war INTEGER DEFAULT 0`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add fog_of_war column: %v", err)
+	}
+
+	_, err = db.Exec(`ALTER TABLE games ADD COLUMN vision_radius INTEGER DEFAULT 2`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add vision_radius column: %v", err)
+	}
+
+	_, err = db.Exec(`ALTER TABLE games ADD COLUMN topology TEXT DEFAULT 'square'`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add topology column: %v", err)
+	}
+
+	// How battles started on the board are fought (see battleFormats)
+	_, err = db.Exec(`ALTER TABLE games ADD COLUMN battle_format TEXT DEFAULT 'single'`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add battle_format column: %v", err)
+	}
+
+	// Battles in the game resolve themselves once both sides have answered (see autoResolveBattle)
+	_, err = db.Exec(`ALTER TABLE games ADD COLUMN auto_resolve_battles INTEGER DEFAULT 0`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add auto_resolve_battles column: %v", err)
+	}
+
+	_, err = db.Exec(`ALTER TABLE games ADD COLUMN win_condition TEXT DEFAULT 'none'`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add win_condition column: %v", err)
+	}
+
+	_, err = db.Exec(`ALTER TABLE games ADD COLUMN win_target INTEGER DEFAULT 0`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add win_target column: %v", err)
+	}
+
+	_, err = db.Exec(`ALTER TABLE games ADD COLUMN prizes TEXT DEFAULT ''`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add prizes column: %v", err)
+	}
+
+	_, err = db.Exec(`ALTER TABLE games ADD COLUMN winner_avatar_id INTEGER`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add winner_avatar_id column: %v", err)
+	}
+
+	_, err = db.Exec(`ALTER TABLE games ADD COLUMN finished_at DATETIME`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add finished_at column: %v", err)
+	}
+
+	// Archived games are hidden from the games list but kept with their history
+	_, err = db.Exec(`ALTER TABLE games ADD COLUMN archived INTEGER DEFAULT 0`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add archived column: %v", err)
+	}
+
+	_, err = db.Exec(`ALTER TABLE games ADD COLUMN archived_at DATETIME`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add archived_at column: %v", err)
+	}
+
 	_, err = db.Exec(`ALTER TABLE avatars ADD COLUMN last_streak_reward_claimed INTEGER DEFAULT 0`)
 	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
 		log.Printf("Warning: Could not add last_streak_reward_claimed column: %v", err)
@@ -609,6 +1062,128 @@ func initDB() {
 		log.Fatal(err)
 	}
 
+	// Create game_events table (log of every board action, for replay and undo)
+	createGameEventsTableSQL := `CREATE TABLE IF NOT EXISTS game_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		game_id INTEGER NOT NULL,
+		event_type TEXT NOT NULL,
+		actor_user_id INTEGER DEFAULT NULL,
+		actor_avatar_id INTEGER DEFAULT NULL,
+		turn_index INTEGER DEFAULT 0,
+		turn_number INTEGER DEFAULT 0,
+		before_state TEXT,
+		after_state TEXT,
+		undone INTEGER DEFAULT 0,
+		undo_of INTEGER DEFAULT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (game_id) REFERENCES games(id) ON DELETE CASCADE
+	);`
+
+	_, err = db.Exec(createGameEventsTableSQL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Create game_warriors table (per-game health, stamina, position and status of deployed warriors)
+	createGameWarriorsTableSQL := `CREATE TABLE IF NOT EXISTS game_warriors (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		game_id INTEGER NOT NULL,
+		asset_id INTEGER NOT NULL,
+		avatar_id INTEGER NOT NULL,
+		health INTEGER DEFAULT 100,
+		stamina INTEGER DEFAULT 100,
+		xp_gained INTEGER DEFAULT 0,
+		status TEXT DEFAULT 'active',
+		cell_id INTEGER DEFAULT NULL,
+		deployed_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (game_id, asset_id),
+		FOREIGN KEY (game_id) REFERENCES games(id) ON DELETE CASCADE
+	);`
+
+	_, err = db.Exec(createGameWarriorsTableSQL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Create cell_effects table (healing springs, traps, teleporters, shops...)
+	createCellEffectsTableSQL := `CREATE TABLE IF NOT EXISTS cell_effects (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		game_id INTEGER NOT NULL,
+		cell_id INTEGER NOT NULL,
+		effect_type TEXT NOT NULL,
+		trigger TEXT NOT NULL DEFAULT 'enter',
+		amount INTEGER DEFAULT 0,
+		duration INTEGER DEFAULT 0,
+		element TEXT DEFAULT '',
+		target_cell_id INTEGER DEFAULT NULL,
+		charges INTEGER DEFAULT -1,
+		cooldown INTEGER DEFAULT 0,
+		last_triggered_turn INTEGER DEFAULT NULL,
+		shop_items TEXT DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (game_id) REFERENCES games(id) ON DELETE CASCADE
+	);`
+
+	_, err = db.Exec(createCellEffectsTableSQL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The charges an effect started with, so resetting or cloning a game fills them up again
+	_, err = db.Exec(`ALTER TABLE cell_effects ADD COLUMN initial_charges INTEGER DEFAULT NULL`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add initial_charges column: %v", err)
+	}
+	// Effects from before the column start from the charges they have left
+	if _, err := db.Exec("UPDATE cell_effects SET initial_charges = charges WHERE initial_charges IS NULL"); err != nil {
+		log.Printf("Warning: Could not backfill initial charges: %v", err)
+	}
+
+	// Create warrior_effects table (freezes and buffs wearing off over the owner's turns)
+	createWarriorEffectsTableSQL := `CREATE TABLE IF NOT EXISTS warrior_effects (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		game_id INTEGER NOT NULL,
+		asset_id INTEGER NOT NULL,
+		effect_type TEXT NOT NULL,
+		amount INTEGER DEFAULT 0,
+		turns_left INTEGER DEFAULT 0,
+		source_cell_id INTEGER DEFAULT NULL,
+		FOREIGN KEY (game_id) REFERENCES games(id) ON DELETE CASCADE
+	);`
+
+	_, err = db.Exec(createWarriorEffectsTableSQL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Add absent column (absent avatars are skipped by the turn clock)
+	_, err = db.Exec(`ALTER TABLE game_avatars ADD COLUMN absent INTEGER DEFAULT 0`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add absent column: %v", err)
+	}
+
+	// Add autopilot columns (a bot plays the avatar's turns; the seed makes its choices repeatable)
+	_, err = db.Exec(`ALTER TABLE game_avatars ADD COLUMN autopilot INTEGER DEFAULT 0`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add autopilot column: %v", err)
+	}
+
+	_, err = db.Exec(`ALTER TABLE game_avatars ADD COLUMN autopilot_seed INTEGER DEFAULT 0`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add autopilot_seed column: %v", err)
+	}
+
+	// Add per-game score columns
+	_, err = db.Exec(`ALTER TABLE game_avatars ADD COLUMN coins_collected INTEGER DEFAULT 0`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add coins_collected column: %v", err)
+	}
+
+	_, err = db.Exec(`ALTER TABLE game_avatars ADD COLUMN final_rank INTEGER`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add final_rank column: %v", err)
+	}
+
 	createGameCellsTableSQL := `CREATE TABLE IF NOT EXISTS game_cells (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		game_id INTEGER NOT NULL,
@@ -697,9 +1272,7 @@ func initDB() {
 		status TEXT DEFAULT 'pending',
 		attacker INTEGER DEFAULT NULL,
 		defender INTEGER DEFAULT NULL,
-		FOREIGN KEY (winner) REFERENCES avatars(id),
-		FOREIGN KEY (attacker) REFERENCES avatars(id),
-		FOREIGN KEY (defender) REFERENCES avatars(id)
+		FOREIGN KEY (winner) REFERENCES avatars(id)
 	);`
 
 	_, err = db.Exec(createBattlesTableSQL)
@@ -735,76 +1308,275 @@ func initDB() {
 		log.Printf("Warning: Could not add game_id column: %v", err)
 	}
 
-	// Add reward_coins and reward_xp columns to game_cells table
-	_, err = db.Exec(`ALTER TABLE game_cells ADD COLUMN reward_coins INTEGER DEFAULT 0`)
+	// Add from/to cell columns for battles started by moving onto an enemy
+	_, err = db.Exec(`ALTER TABLE battles ADD COLUMN from_cell_id INTEGER DEFAULT NULL`)
 	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
-		log.Printf("Warning: Could not add reward_coins column: %v", err)
+		log.Printf("Warning: Could not add from_cell_id column: %v", err)
 	}
 
-	_, err = db.Exec(`ALTER TABLE game_cells ADD COLUMN reward_xp INTEGER DEFAULT 0`)
+	_, err = db.Exec(`ALTER TABLE battles ADD COLUMN to_cell_id INTEGER DEFAULT NULL`)
 	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
-		log.Printf("Warning: Could not add reward_xp column: %v", err)
+		log.Printf("Warning: Could not add to_cell_id column: %v", err)
 	}
 
-	// Create battle_questions table
-	createBattleQuestionsTableSQL := `CREATE TABLE IF NOT EXISTS battle_questions (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		battle_id INTEGER DEFAULT NULL,
-		question TEXT NOT NULL,
-		answer TEXT NOT NULL,
-		user_id INTEGER DEFAULT NULL,
-		possible_points INTEGER NOT NULL,
-		received_score INTEGER DEFAULT 0,
-		time INTEGER NOT NULL,
-		user_answer TEXT DEFAULT NULL,
-		submitted_at DATETIME DEFAULT NULL,
-		FOREIGN KEY (battle_id) REFERENCES battles(id) ON DELETE CASCADE,
-		FOREIGN KEY (user_id) REFERENCES avatars(id)
-	);`
+	_, err = db.Exec(`ALTER TABLE battles ADD COLUMN format TEXT DEFAULT 'single'`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add format column: %v", err)
+	}
 
-	_, err = db.Exec(createBattleQuestionsTableSQL)
-	if err != nil {
-		log.Fatal(err)
+	// Auto-resolving battles, how each battle was resolved and draws
+	_, err = db.Exec(`ALTER TABLE battles ADD COLUMN auto_resolve INTEGER DEFAULT 0`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add auto_resolve column: %v", err)
 	}
 
-	// Migrate battle_questions table to make battle_id nullable
-	// Check if we need to migrate by checking the table schema
-	var tableSchema string
-	err = db.QueryRow(`SELECT sql FROM sqlite_master WHERE type='table' AND name='battle_questions'`).Scan(&tableSchema)
-	if err == nil && strings.Contains(tableSchema, "battle_id INTEGER NOT NULL") {
-		log.Println("Migrating battle_questions table to make battle_id nullable...")
+	_, err = db.Exec(`ALTER TABLE battles ADD COLUMN round_started_at DATETIME`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add round_started_at column: %v", err)
+	}
 
-		// Disable foreign keys temporarily
+	_, err = db.Exec(`ALTER TABLE battles ADD COLUMN draw INTEGER DEFAULT 0`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add draw column: %v", err)
+	}
+
+	_, err = db.Exec(`ALTER TABLE battles ADD COLUMN resolved_by TEXT`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add resolved_by column: %v", err)
+	}
+
+	// Migrate battles table to drop the attacker/defender foreign keys: game battles store warrior asset IDs
+	// there, not avatar IDs. The stored schema already includes every column added above, so the table is
+	// rebuilt from it with only those two clauses removed.
+	var battlesSchema string
+	err = db.QueryRow(`SELECT sql FROM sqlite_master WHERE type='table' AND name='battles'`).Scan(&battlesSchema)
+	if err == nil && (strings.Contains(battlesSchema, "FOREIGN KEY (attacker)") || strings.Contains(battlesSchema, "FOREIGN KEY (defender)")) {
+		log.Println("Migrating battles table to drop the attacker/defender foreign keys...")
+
+		for _, clause := range []string{"FOREIGN KEY (attacker) REFERENCES avatars(id)", "FOREIGN KEY (defender) REFERENCES avatars(id)"} {
+			if i := strings.Index(battlesSchema, clause); i >= 0 {
+				start := strings.LastIndex(battlesSchema[:i], ",")
+				battlesSchema = battlesSchema[:start] + battlesSchema[i+len(clause):]
+			}
+		}
+		battlesSchema = strings.Replace(battlesSchema, "CREATE TABLE battles", "CREATE TABLE battles_new", 1)
+
+		// Disable foreign keys so dropping the old table doesn't cascade to battle questions and rounds
 		_, err = db.Exec("PRAGMA foreign_keys = OFF;")
 		if err != nil {
 			log.Fatal("Failed to disable foreign keys:", err)
 		}
 
-		// SQLite doesn't support ALTER COLUMN, so we need to recreate the table
-		_, err = db.Exec(`
-			CREATE TABLE battle_questions_new (
-				id INTEGER PRIMARY KEY AUTOINCREMENT,
-				battle_id INTEGER DEFAULT NULL,
-				question TEXT NOT NULL,
-				answer TEXT NOT NULL,
-				user_id INTEGER DEFAULT NULL,
-				possible_points INTEGER NOT NULL,
-				received_score INTEGER DEFAULT 0,
-				time INTEGER NOT NULL,
-				user_answer TEXT DEFAULT NULL,
-				submitted_at DATETIME DEFAULT NULL
-			)
-		`)
+		_, err = db.Exec(battlesSchema)
 		if err != nil {
-			log.Fatal("Failed to create new battle_questions table:", err)
+			log.Fatal("Failed to create new battles table:", err)
 		}
 
-		// Copy data from old table to new table
-		_, err = db.Exec(`
-			INSERT INTO battle_questions_new (id, battle_id, question, answer, user_id, possible_points, received_score, time, user_answer, submitted_at)
-			SELECT id, battle_id, question, answer, user_id, possible_points, received_score, time, user_answer, submitted_at
-			FROM battle_questions
-		`)
+		_, err = db.Exec(`INSERT INTO battles_new SELECT * FROM battles`)
+		if err != nil {
+			log.Fatal("Failed to copy data to new battles table:", err)
+		}
+
+		_, err = db.Exec(`DROP TABLE battles`)
+		if err != nil {
+			log.Fatal("Failed to drop old battles table:", err)
+		}
+
+		_, err = db.Exec(`ALTER TABLE battles_new RENAME TO battles`)
+		if err != nil {
+			log.Fatal("Failed to rename battles_new table:", err)
+		}
+
+		log.Println("Migration completed successfully!")
+	}
+
+	// Add reward_coins and reward_xp columns to game_cells table
+	_, err = db.Exec(`ALTER TABLE game_cells ADD COLUMN reward_coins INTEGER DEFAULT 0`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add reward_coins column: %v", err)
+	}
+
+	_, err = db.Exec(`ALTER TABLE game_cells ADD COLUMN reward_xp INTEGER DEFAULT 0`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add reward_xp column: %v", err)
+	}
+
+	// Add reward regeneration columns (the rewards a cell refills to, how often, and the round it was last emptied)
+	_, err = db.Exec(`ALTER TABLE game_cells ADD COLUMN base_reward_coins INTEGER DEFAULT 0`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add base_reward_coins column: %v", err)
+	}
+
+	_, err = db.Exec(`ALTER TABLE game_cells ADD COLUMN base_reward_xp INTEGER DEFAULT 0`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add base_reward_xp column: %v", err)
+	}
+
+	// Cells made before base rewards existed refill to the rewards they were given
+	_, err = db.Exec(`UPDATE game_cells SET base_reward_coins = reward_coins, base_reward_xp = reward_xp
+		WHERE COALESCE(base_reward_coins, 0) = 0 AND COALESCE(base_reward_xp, 0) = 0
+			AND (COALESCE(reward_coins, 0) > 0 OR COALESCE(reward_xp, 0) > 0)`)
+	if err != nil {
+		log.Printf("Warning: Could not backfill base rewards: %v", err)
+	}
+
+	_, err = db.Exec(`ALTER TABLE game_cells ADD COLUMN regen_rounds INTEGER DEFAULT 0`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add regen_rounds column: %v", err)
+	}
+
+	_, err = db.Exec(`ALTER TABLE game_cells ADD COLUMN claimed_round INTEGER DEFAULT NULL`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add claimed_round column: %v", err)
+	}
+
+	// Create cell_reward_claims table (who collected which cell's rewards, and when)
+	createCellRewardClaimsTableSQL := `CREATE TABLE IF NOT EXISTS cell_reward_claims (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		game_id INTEGER NOT NULL,
+		cell_id INTEGER NOT NULL,
+		asset_id INTEGER NOT NULL,
+		avatar_id INTEGER NOT NULL,
+		coins INTEGER DEFAULT 0,
+		xp INTEGER DEFAULT 0,
+		round INTEGER DEFAULT 0,
+		event_id INTEGER DEFAULT NULL,
+		claimed_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (game_id) REFERENCES games(id) ON DELETE CASCADE
+	);`
+
+	_, err = db.Exec(createCellRewardClaimsTableSQL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Create board_templates table (cells are stored as JSON)
+	createBoardTemplatesTableSQL := `CREATE TABLE IF NOT EXISTS board_templates (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		description TEXT,
+		rows INTEGER NOT NULL,
+		columns INTEGER NOT NULL,
+		cells TEXT NOT NULL,
+		seed INTEGER DEFAULT NULL,
+		created_by INTEGER,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	_, err = db.Exec(createBoardTemplatesTableSQL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = db.Exec(`ALTER TABLE board_templates ADD COLUMN topology TEXT DEFAULT 'square'`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add topology column to board_templates: %v", err)
+	}
+
+	// Create spectator_tokens table (read-only links to watch a game)
+	createSpectatorTokensTableSQL := `CREATE TABLE IF NOT EXISTS spectator_tokens (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		game_id INTEGER NOT NULL,
+		token TEXT NOT NULL UNIQUE,
+		label TEXT,
+		created_by INTEGER,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_used_at DATETIME,
+		revoked_at DATETIME,
+		FOREIGN KEY (game_id) REFERENCES games(id) ON DELETE CASCADE
+	);`
+
+	_, err = db.Exec(createSpectatorTokensTableSQL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Create battle_rounds table (one row per question round fought in a battle)
+	createBattleRoundsTableSQL := `CREATE TABLE IF NOT EXISTS battle_rounds (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		battle_id INTEGER NOT NULL,
+		round INTEGER NOT NULL,
+		attacker_question_id INTEGER,
+		defender_question_id INTEGER,
+		attacker_answer TEXT,
+		defender_answer TEXT,
+		attacker_correct INTEGER DEFAULT 0,
+		defender_correct INTEGER DEFAULT 0,
+		damage INTEGER DEFAULT 0,
+		stamina_loss INTEGER DEFAULT 0,
+		attacker_health INTEGER DEFAULT 0,
+		attacker_stamina INTEGER DEFAULT 0,
+		defender_health INTEGER DEFAULT 0,
+		defender_stamina INTEGER DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(battle_id, round),
+		FOREIGN KEY (battle_id) REFERENCES battles(id) ON DELETE CASCADE
+	);`
+
+	_, err = db.Exec(createBattleRoundsTableSQL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Create battle_questions table
+	createBattleQuestionsTableSQL := `CREATE TABLE IF NOT EXISTS battle_questions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		battle_id INTEGER DEFAULT NULL,
+		question TEXT NOT NULL,
+		answer TEXT NOT NULL,
+		user_id INTEGER DEFAULT NULL,
+		possible_points INTEGER NOT NULL,
+		received_score INTEGER DEFAULT 0,
+		time INTEGER NOT NULL,
+		user_answer TEXT DEFAULT NULL,
+		submitted_at DATETIME DEFAULT NULL,
+		FOREIGN KEY (battle_id) REFERENCES battles(id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES avatars(id)
+	);`
+
+	_, err = db.Exec(createBattleQuestionsTableSQL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Migrate battle_questions table to make battle_id nullable
+	// Check if we need to migrate by checking the table schema
+	var tableSchema string
+	err = db.QueryRow(`SELECT sql FROM sqlite_master WHERE type='table' AND name='battle_questions'`).Scan(&tableSchema)
+	if err == nil && strings.Contains(tableSchema, "battle_id INTEGER NOT NULL") {
+		log.Println("Migrating battle_questions table to make battle_id nullable...")
+
+		// Disable foreign keys temporarily
+		_, err = db.Exec("PRAGMA foreign_keys = OFF;")
+		if err != nil {
+			log.Fatal("Failed to disable foreign keys:", err)
+		}
+
+		// SQLite doesn't support ALTER COLUMN, so we need to recreate the table
+		_, err = db.Exec(`
+			CREATE TABLE battle_questions_new (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				battle_id INTEGER DEFAULT NULL,
+				question TEXT NOT NULL,
+				answer TEXT NOT NULL,
+				user_id INTEGER DEFAULT NULL,
+				possible_points INTEGER NOT NULL,
+				received_score INTEGER DEFAULT 0,
+				time INTEGER NOT NULL,
+				user_answer TEXT DEFAULT NULL,
+				submitted_at DATETIME DEFAULT NULL
+			)
+		`)
+		if err != nil {
+			log.Fatal("Failed to create new battle_questions table:", err)
+		}
+
+		// Copy data from old table to new table
+		_, err = db.Exec(`
+			INSERT INTO battle_questions_new (id, battle_id, question, answer, user_id, possible_points, received_score, time, user_answer, submitted_at)
+			SELECT id, battle_id, question, answer, user_id, possible_points, received_score, time, user_answer, submitted_at
+			FROM battle_questions
+		`)
 		if err != nil {
 			log.Fatal("Failed to copy data to new battle_questions table:", err)
 		}
@@ -1625,7 +2397,19 @@ func generateToken(userID int, name string) (string, error) {
 }
 
 func getUserFromToken(r *http.Request) (*Claims, error) {
-	authHeader := r.Header.Get("Authorization")
+	return parseAuthToken(r.Header.Get("Authorization"))
+}
+
+// Like getUserFromToken, but also accepts the token as a "token" query parameter. Only for audio players
+// and WebSockets, which can't set headers: tokens in URLs end up in logs and Referer headers.
+func getUserFromTokenOrQuery(r *http.Request) (*Claims, error) {
+	if r.Header.Get("Authorization") == "" {
+		return parseAuthToken(r.URL.Query().Get("token"))
+	}
+	return getUserFromToken(r)
+}
+
+func parseAuthToken(authHeader string) (*Claims, error) {
 	if authHeader == "" {
 		log.Println("No authorization header found")
 		return nil, fmt.Errorf("no authorization header")
@@ -2190,13 +2974,23 @@ func getAssignments(w http.ResponseWriter, r *http.Request) {
 			assignment.CoinsReceived = int(coinsReceived.Int64)
 		}
 		if data.Valid && data.String != "" {
-			assignment.Data = json.RawMessage(data.String)
+			// Students get their own assignments without the answer keys
+			stripped, err := studentQuizData(data.String)
+			if err != nil {
+				http.Error(w, "Error parsing assignment data", http.StatusInternalServerError)
+				return
+			}
+			assignment.Data = json.RawMessage(stripped)
 		}
 		if retakeCount.Valid {
 			assignment.RetakeCount = int(retakeCount.Int64)
 		}
 		assignments = append(assignments, assignment)
 	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(assignments)
@@ -2225,6 +3019,8 @@ func createAssignments(w http.ResponseWriter, r *http.Request) {
 		Name         string  `json:"name"`
 		DueDate      string  `json:"dueDate"`
 		Data         *string `json:"data"`
+		Shuffle      bool    `json:"shuffle"`  // Shuffle question and choice order per student
+		Category     string  `json:"category"` // Gradebook category (e.g. "quiz", "homework")
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -2261,15 +3057,28 @@ func createAssignments(w http.ResponseWriter, r *http.Request) {
 	// Insert assignment for each user
 	for _, userID := range req.UserIDs {
 		var dataValue interface{}
+		var shuffleSeed interface{}
 		if req.Data != nil && *req.Data != "" {
 			dataValue = *req.Data
+
+			// Give every student their own question and choice order
+			if req.Shuffle {
+				seed := rand.Int63()
+				shuffledData, err := shuffleQuizData(*req.Data, seed)
+				if err != nil {
+					http.Error(w, fmt.Sprintf("Error shuffling quiz data: %v", err), http.StatusBadRequest)
+					return
+				}
+				dataValue = shuffledData
+				shuffleSeed = seed
+			}
 		} else {
 			dataValue = nil
 		}
 
-		_, err := tx.Exec(`INSERT INTO assignments (coins, assignment_id, user_id, completed, name, due_date, coins_received, data)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
-			req.Coins, req.AssignmentID, userID, 0, req.Name, dueDate, 0, dataValue)
+		_, err := tx.Exec(`INSERT INTO assignments (coins, assignment_id, user_id, completed, name, due_date, coins_received, data, shuffle_seed, category)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			req.Coins, req.AssignmentID, userID, 0, req.Name, dueDate, 0, dataValue, shuffleSeed, strings.ToLower(strings.TrimSpace(req.Category)))
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Error creating assignment for user %d: %v", userID, err), http.StatusInternalServerError)
 			return
@@ -2311,6 +3120,7 @@ func createDailyVocabAssignments(w http.ResponseWriter, r *http.Request) {
 		WordWorth int    `json:"wordWorth"`
 		WordType  string `json:"wordType"`
 		Name      string `json:"name"`
+		Shuffle   bool   `json:"shuffle"` // Shuffle word order per student
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -2444,9 +3254,21 @@ func createDailyVocabAssignments(w http.ResponseWriter, r *http.Request) {
 
 	// Insert assignment for each student
 	for _, studentID := range studentIDs {
-		_, err := tx.Exec(`INSERT INTO assignments (coins, assignment_id, user_id, completed, name, due_date, coins_received, data)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
-			totalCoins, "1005", studentID, 0, req.Name, dueDate, 0, string(quizDataJSON))
+		studentData := string(quizDataJSON)
+		var shuffleSeed interface{}
+		if req.Shuffle {
+			seed := rand.Int63()
+			studentData, err = shuffleQuizData(studentData, seed)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Error shuffling quiz data: %v", err), http.StatusInternalServerError)
+				return
+			}
+			shuffleSeed = seed
+		}
+
+		_, err := tx.Exec(`INSERT INTO assignments (coins, assignment_id, user_id, completed, name, due_date, coins_received, data, shuffle_seed, category)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			totalCoins, "1005", studentID, 0, req.Name, dueDate, 0, studentData, shuffleSeed, "vocab")
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Error creating assignment for student %d: %v", studentID, err), http.StatusInternalServerError)
 			return
@@ -2495,14 +3317,109 @@ func generateRandomID(length int) string {
 	return string(b)
 }
 
+// Shuffle question order and multiple-choice options with a per-student seed.
+// Each question keeps its original position in "original_index" and, for choice
+// questions, "choice_order" maps every displayed choice back to its original index.
+// Questions are handled as raw maps so fields unknown to QuizQuestion survive.
+func shuffleQuizData(data string, seed int64) (string, error) {
+	var questions []map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &questions); err != nil {
+		return "", err
+	}
+
+	rng := rand.New(rand.NewSource(seed))
+
+	for i, q := range questions {
+		q["original_index"] = i
+
+		questionType, _ := q["type"].(string)
+		if questionType != "multiple" && questionType != "multiple-choice" && questionType != "listen" {
+			continue
+		}
+
+		// Support both "answer" and "options" field names for the choices
+		choicesKey := "answer"
+		if _, ok := q["options"].([]interface{}); ok {
+			choicesKey = "options"
+		}
+		choices, ok := q[choicesKey].([]interface{})
+		if !ok || len(choices) < 2 {
+			continue
+		}
+
+		perm := rng.Perm(len(choices))
+		shuffled := make([]interface{}, len(choices))
+		for displayed, original := range perm {
+			shuffled[displayed] = choices[original]
+		}
+		q[choicesKey] = shuffled
+		q["choice_order"] = perm
+
+		// Point "correct" at the new position of the right choice
+		if correct, ok := q["correct"].(float64); ok {
+			for displayed, original := range perm {
+				if original == int(correct) {
+					q["correct"] = displayed
+					break
+				}
+			}
+		}
+	}
+
+	rng.Shuffle(len(questions), func(i, j int) {
+		questions[i], questions[j] = questions[j], questions[i]
+	})
+
+	shuffledData, err := json.Marshal(questions)
+	if err != nil {
+		return "", err
+	}
+	return string(shuffledData), nil
+}
+
+// Remove what a student must not see from quiz data: the per-student shuffle fields ("original_index"
+// and "choice_order") and the answer keys. Choice questions lose "correct" but keep their choices;
+// every other question loses "answer", which holds its acceptable or model answers.
+func studentQuizData(data string) (string, error) {
+	var questions []map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &questions); err != nil {
+		return "", err
+	}
+	for _, q := range questions {
+		delete(q, "original_index")
+		delete(q, "choice_order")
+
+		questionType, _ := q["type"].(string)
+		_, hasCorrect := q["correct"].(float64)
+		_, hasOptions := q["options"]
+		isChoice := questionType == "multiple" || questionType == "multiple-choice" || (questionType == "listen" && hasCorrect)
+		if !isChoice || hasOptions {
+			delete(q, "answer")
+		}
+		delete(q, "correct")
+	}
+	stripped, err := json.Marshal(questions)
+	if err != nil {
+		return "", err
+	}
+	return string(stripped), nil
+}
+
 // Get single assignment by assignment_id for student
 func getStudentAssignment(w http.ResponseWriter, r *http.Request) {
-	_, err := getUserFromToken(r)
+	claims, err := getUserFromToken(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
 	vars := mux.Vars(r)
 	assignmentID := vars["assignmentId"] // This is the assignment_id (e.g., "1005"), not the database id
 
@@ -2527,6 +3444,12 @@ func getStudentAssignment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Students only see their own assignments
+	if role != "admin" && assignment.UserID != claims.UserID {
+		http.Error(w, "Assignment not found or access denied", http.StatusNotFound)
+		return
+	}
+
 	assignment.Completed = completed == 1
 	if dueDate.Valid {
 		assignment.DueDate = dueDate.Time
@@ -2536,6 +3459,15 @@ func getStudentAssignment(w http.ResponseWriter, r *http.Request) {
 	}
 	if data.Valid && data.String != "" {
 		assignment.Data = json.RawMessage(data.String)
+		// Answer keys and shuffle bookkeeping would give the answers away; only admins get them
+		if role != "admin" {
+			stripped, err := studentQuizData(data.String)
+			if err != nil {
+				http.Error(w, "Error parsing assignment data", http.StatusInternalServerError)
+				return
+			}
+			assignment.Data = json.RawMessage(stripped)
+		}
 	}
 	if retakeCount.Valid {
 		assignment.RetakeCount = int(retakeCount.Int64)
@@ -2561,7 +3493,7 @@ func getAllAssignments(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rows, err := db.Query(`SELECT id, coins, assignment_id, user_id, completed, name, due_date, coins_received, data, COALESCE(retake_count, 0)
+	rows, err := db.Query(`SELECT id, coins, assignment_id, user_id, completed, name, due_date, coins_received, data, COALESCE(retake_count, 0), shuffle_seed
 		FROM assignments ORDER BY due_date DESC`)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -2577,8 +3509,9 @@ func getAllAssignments(w http.ResponseWriter, r *http.Request) {
 		var coinsReceived sql.NullInt64
 		var data sql.NullString
 		var retakeCount sql.NullInt64
+		var shuffleSeed sql.NullInt64
 		if err := rows.Scan(&assignment.ID, &assignment.Coins, &assignment.AssignmentID,
-			&assignment.UserID, &completed, &assignment.Name, &dueDate, &coinsReceived, &data, &retakeCount); err != nil {
+			&assignment.UserID, &completed, &assignment.Name, &dueDate, &coinsReceived, &data, &retakeCount, &shuffleSeed); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -2595,6 +3528,9 @@ func getAllAssignments(w http.ResponseWriter, r *http.Request) {
 		if retakeCount.Valid {
 			assignment.RetakeCount = int(retakeCount.Int64)
 		}
+		if shuffleSeed.Valid {
+			assignment.ShuffleSeed = &shuffleSeed.Int64
+		}
 		assignments = append(assignments, assignment)
 	}
 
@@ -2627,10 +3563,11 @@ func getAssignmentByID(w http.ResponseWriter, r *http.Request) {
 	var coinsReceived sql.NullInt64
 	var data sql.NullString
 	var retakeCount sql.NullInt64
+	var shuffleSeed sql.NullInt64
 
-	err = db.QueryRow(`SELECT id, coins, assignment_id, user_id, completed, name, due_date, coins_received, data, COALESCE(retake_count, 0)
+	err = db.QueryRow(`SELECT id, coins, assignment_id, user_id, completed, name, due_date, coins_received, data, COALESCE(retake_count, 0), shuffle_seed
 		FROM assignments WHERE id = ?`, id).Scan(&assignment.ID, &assignment.Coins, &assignment.AssignmentID,
-		&assignment.UserID, &completed, &assignment.Name, &dueDate, &coinsReceived, &data, &retakeCount)
+		&assignment.UserID, &completed, &assignment.Name, &dueDate, &coinsReceived, &data, &retakeCount, &shuffleSeed)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -2654,6 +3591,9 @@ func getAssignmentByID(w http.ResponseWriter, r *http.Request) {
 	if retakeCount.Valid {
 		assignment.RetakeCount = int(retakeCount.Int64)
 	}
+	if shuffleSeed.Valid {
+		assignment.ShuffleSeed = &shuffleSeed.Int64
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(assignment)
@@ -2819,13 +3759,11 @@ func submitAssignment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Coins and XP are worked out here from the answers; the client's own tally is ignored
 	var req struct {
-		AssignmentID  int                      `json:"assignmentId"` // This is the database ID
-		CoinsReceived int                      `json:"coinsReceived"`
-		UserAnswers   []map[string]interface{} `json:"userAnswers,omitempty"`
-		AssetID       *int                     `json:"assetId,omitempty"`
-		XPGain        int                      `json:"xpGain,omitempty"`
-		IsRetake      bool                     `json:"isRetake,omitempty"`
+		AssignmentID int                      `json:"assignmentId"` // This is the database ID
+		UserAnswers  []map[string]interface{} `json:"userAnswers,omitempty"`
+		AssetID      *int                     `json:"assetId,omitempty"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -2833,8 +3771,8 @@ func submitAssignment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("Submit assignment request: user_id=%d, assignment_db_id=%d, coins=%d, asset_id=%v, xp_gain=%d, is_retake=%v",
-		claims.UserID, req.AssignmentID, req.CoinsReceived, req.AssetID, req.XPGain, req.IsRetake)
+	log.Printf("Submit assignment request: user_id=%d, assignment_db_id=%d, asset_id=%v",
+		claims.UserID, req.AssignmentID, req.AssetID)
 
 	// Start transaction
 	tx, err := db.Begin()
@@ -2863,24 +3801,22 @@ func submitAssignment(w http.ResponseWriter, r *http.Request) {
 	// Use the assignment database ID directly (no need to query)
 	assignmentDBID := req.AssignmentID
 
-	// Check if this is a retake and get assignment info
-	var assignmentIDStr string
+	// Check if this is a retake and get assignment info (students can only submit their own assignments)
 	var completed int
 	var retakeCount sql.NullInt64
-	err = tx.QueryRow(`SELECT assignment_id, completed, COALESCE(retake_count, 0) FROM assignments WHERE id = ?`,
-		assignmentDBID).Scan(&assignmentIDStr, &completed, &retakeCount)
+	err = tx.QueryRow(`SELECT completed, COALESCE(retake_count, 0) FROM assignments WHERE id = ? AND user_id = ?`,
+		assignmentDBID, claims.UserID).Scan(&completed, &retakeCount)
 	if err != nil {
 		http.Error(w, "Assignment not found", http.StatusNotFound)
 		return
 	}
 
-	// Frontend already applies 20% reduction for retakes, so just use the values as-is
-	actualCoinsReceived := req.CoinsReceived
-	actualXPGain := req.XPGain
-	if req.IsRetake && assignmentIDStr == "1005" {
-		log.Printf("Retake detected for assignment 1005: using frontend-calculated rewards (coins: %d, xp: %d)",
-			actualCoinsReceived, actualXPGain)
-	}
+	// Submitting an assignment that was already completed is a retake, which earns 20% of the rewards
+	isRetake := completed == 1
+	actualCoinsReceived := 0
+	actualXPGain := 0
+	// Per-question results for the student; the answer keys are only revealed once they have submitted
+	results := []map[string]interface{}{}
 
 	// If userAnswers are provided, update the assignment data with user answers
 	if req.UserAnswers != nil && len(req.UserAnswers) > 0 {
@@ -2909,7 +3845,18 @@ func submitAssignment(w http.ResponseWriter, r *http.Request) {
 				if userAnswerID, ok := userAnswer["questionId"]; ok {
 					if quizData[i].ID == userAnswerID {
 						quizData[i].UserAnswer = userAnswer["userAnswer"]
-						if isCorrect, ok := userAnswer["isCorrect"].(bool); ok {
+						if timeSpent, ok := userAnswer["timeSpent"].(float64); ok {
+							seconds := int(timeSpent)
+							quizData[i].TimeSpent = &seconds
+						}
+						if isTeacherGradedType(quizData[i].Type) {
+							// Written and spoken answers are graded by the teacher, never by the client
+							quizData[i].IsCorrect = nil
+							quizData[i].PointsAwarded = nil
+							quizData[i].Feedback = ""
+							quizData[i].GradingStatus = "pending"
+						} else {
+							isCorrect := isQuizAnswerCorrect(quizData[i])
 							quizData[i].IsCorrect = &isCorrect
 						}
 						break
@@ -2918,6 +3865,74 @@ func submitAssignment(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		actualCoinsReceived, actualXPGain = quizRewards(quizData, isRetake)
+
+		for _, q := range quizData {
+			coinsEarned := 0
+			if q.IsCorrect != nil && *q.IsCorrect {
+				coinsEarned = q.CoinsWorth
+				if isRetake {
+					coinsEarned = q.CoinsWorth * 20 / 100
+				}
+			}
+			results = append(results, map[string]interface{}{
+				"questionId":    q.ID,
+				"isCorrect":     q.IsCorrect,
+				"correctAnswer": quizCorrectAnswer(q),
+				"coinsEarned":   coinsEarned,
+			})
+		}
+
+		// Queue written and spoken answers for teacher grading. A new attempt replaces the earlier response,
+		// so every question has one response (grades already released stay credited).
+		for _, q := range quizData {
+			if !isTeacherGradedType(q.Type) || q.GradingStatus != "pending" {
+				continue
+			}
+
+			responseText := ""
+			var mediaID interface{}
+			if q.Type == "speak" {
+				// The answer of a "speak" question is the ID of the student's uploaded recording (none if unanswered)
+				if answer := fmt.Sprintf("%v", q.UserAnswer); q.UserAnswer != nil && answer != "" {
+					recordingID, err := strconv.Atoi(answer)
+					if err != nil {
+						http.Error(w, fmt.Sprintf("Invalid recording for question %s", q.ID), http.StatusBadRequest)
+						return
+					}
+					var recordingCount int
+					err = tx.QueryRow("SELECT COUNT(*) FROM media WHERE id = ? AND uploaded_by = ? AND kind = 'recording'",
+						recordingID, claims.UserID).Scan(&recordingCount)
+					if err != nil {
+						http.Error(w, err.Error(), http.StatusInternalServerError)
+						return
+					}
+					if recordingCount == 0 {
+						http.Error(w, fmt.Sprintf("Recording %d not found for question %s", recordingID, q.ID), http.StatusBadRequest)
+						return
+					}
+					mediaID = recordingID
+				}
+			} else if q.UserAnswer != nil {
+				responseText = fmt.Sprintf("%v", q.UserAnswer)
+			}
+
+			_, err = tx.Exec(`DELETE FROM graded_responses WHERE assignment_id = ? AND question_id = ?`,
+				assignmentDBID, q.ID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			_, err = tx.Exec(`INSERT INTO graded_responses (assignment_id, user_id, question_id, question_type, question, response, possible_points, status, media_id)
+				VALUES (?, ?, ?, ?, ?, ?, ?, 'pending', ?)`,
+				assignmentDBID, claims.UserID, q.ID, q.Type, q.Question, responseText, q.CoinsWorth, mediaID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
 		// Convert back to JSON
 		updatedData, err := json.Marshal(quizData)
 		if err != nil {
@@ -2927,18 +3942,18 @@ func submitAssignment(w http.ResponseWriter, r *http.Request) {
 
 		// Update assignment with new data, mark as completed, and set coins_received
 		// If it's a retake, increment retake_count
-		if req.IsRetake {
+		if isRetake {
 			newRetakeCount := 0
 			if retakeCount.Valid {
 				newRetakeCount = int(retakeCount.Int64) + 1
 			} else {
 				newRetakeCount = 1
 			}
-			_, err = tx.Exec(`UPDATE assignments SET completed = 1, coins_received = ?, data = ?, retake_count = ?
-				WHERE id = ?`, actualCoinsReceived, string(updatedData), newRetakeCount, assignmentDBID)
+			_, err = tx.Exec(`UPDATE assignments SET completed = 1, coins_received = ?, completed_at = COALESCE(completed_at, ?), data = ?, retake_count = ?
+				WHERE id = ?`, actualCoinsReceived, time.Now(), string(updatedData), newRetakeCount, assignmentDBID)
 		} else {
-			_, err = tx.Exec(`UPDATE assignments SET completed = 1, coins_received = ?, data = ?
-				WHERE id = ?`, actualCoinsReceived, string(updatedData), assignmentDBID)
+			_, err = tx.Exec(`UPDATE assignments SET completed = 1, coins_received = ?, completed_at = COALESCE(completed_at, ?), data = ?
+				WHERE id = ?`, actualCoinsReceived, time.Now(), string(updatedData), assignmentDBID)
 		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -2947,18 +3962,18 @@ func submitAssignment(w http.ResponseWriter, r *http.Request) {
 	} else {
 		// No user answers provided, just mark as completed
 		// If it's a retake, increment retake_count
-		if req.IsRetake {
+		if isRetake {
 			newRetakeCount := 0
 			if retakeCount.Valid {
 				newRetakeCount = int(retakeCount.Int64) + 1
 			} else {
 				newRetakeCount = 1
 			}
-			_, err = tx.Exec(`UPDATE assignments SET completed = 1, coins_received = ?, retake_count = ?
-				WHERE id = ?`, actualCoinsReceived, newRetakeCount, assignmentDBID)
+			_, err = tx.Exec(`UPDATE assignments SET completed = 1, coins_received = ?, completed_at = COALESCE(completed_at, ?), retake_count = ?
+				WHERE id = ?`, actualCoinsReceived, time.Now(), newRetakeCount, assignmentDBID)
 		} else {
-			_, err = tx.Exec(`UPDATE assignments SET completed = 1, coins_received = ?
-				WHERE id = ?`, actualCoinsReceived, assignmentDBID)
+			_, err = tx.Exec(`UPDATE assignments SET completed = 1, coins_received = ?, completed_at = COALESCE(completed_at, ?)
+				WHERE id = ?`, actualCoinsReceived, time.Now(), assignmentDBID)
 		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -3092,6 +4107,9 @@ func submitAssignment(w http.ResponseWriter, r *http.Request) {
 		"success":        true,
 		"message":        "Assignment submitted successfully",
 		"coins":          newCoins,
+		"coinsReceived":  actualCoinsReceived,
+		"xpGain":         actualXPGain,
+		"results":        results,
 		"assetLeveledUp": assetLeveledUp,
 		"assetData":      assetData,
 	}
@@ -3100,43 +4118,88 @@ func submitAssignment(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-// Mark notification as read
-func markNotificationRead(w http.ResponseWriter, r *http.Request) {
+// Get the teacher grading queue (admin only)
+func getGradingQueue(w http.ResponseWriter, r *http.Request) {
 	claims, err := getUserFromToken(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	vars := mux.Vars(r)
-	notifID := vars["id"]
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
 
-	// Verify notification belongs to user
-	var userID int
-	err = db.QueryRow("SELECT user_id FROM notifications WHERE id = ?", notifID).Scan(&userID)
-	if err != nil {
-		http.Error(w, "Notification not found", http.StatusNotFound)
-		return
+	// Default to the responses that still need a grade
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "pending"
 	}
 
-	if userID != claims.UserID {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		return
+	query := `SELECT gr.id, gr.assignment_id, a.name, gr.user_id, u.name, gr.question_id, gr.question_type, gr.question, gr.response,
+		gr.possible_points, gr.points_awarded, COALESCE(gr.comment, ''), gr.status, gr.submitted_at, gr.graded_at, gr.released_at, gr.media_id
+		FROM graded_responses gr
+		JOIN assignments a ON gr.assignment_id = a.id
+		JOIN users u ON gr.user_id = u.id
+		WHERE gr.status = ?`
+	args := []interface{}{status}
+
+	if assignmentID := r.URL.Query().Get("assignmentId"); assignmentID != "" {
+		query += " AND gr.assignment_id = ?"
+		args = append(args, assignmentID)
 	}
 
-	// Mark as read
-	_, err = db.Exec("UPDATE notifications SET is_read = 1, read_at = CURRENT_TIMESTAMP WHERE id = ?", notifID)
+	query += " ORDER BY gr.submitted_at ASC, gr.id ASC"
+
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	defer rows.Close()
+
+	responses := []GradedResponse{}
+	for rows.Next() {
+		var gr GradedResponse
+		var pointsAwarded sql.NullInt64
+		var submittedAt time.Time
+		var gradedAt, releasedAt sql.NullTime
+		var mediaID sql.NullInt64
+		if err := rows.Scan(&gr.ID, &gr.AssignmentID, &gr.AssignmentName, &gr.UserID, &gr.StudentName, &gr.QuestionID, &gr.QuestionType,
+			&gr.Question, &gr.Response, &gr.PossiblePoints, &pointsAwarded, &gr.Comment, &gr.Status, &submittedAt, &gradedAt, &releasedAt, &mediaID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		gr.SubmittedAt = submittedAt.Format(time.RFC3339)
+		if pointsAwarded.Valid {
+			points := int(pointsAwarded.Int64)
+			gr.PointsAwarded = &points
+		}
+		if gradedAt.Valid {
+			gradedAtStr := gradedAt.Time.Format(time.RFC3339)
+			gr.GradedAt = &gradedAtStr
+		}
+		if releasedAt.Valid {
+			releasedAtStr := releasedAt.Time.Format(time.RFC3339)
+			gr.ReleasedAt = &releasedAtStr
+		}
+		if mediaID.Valid {
+			mediaURL := fmt.Sprintf("/api/media/%d", mediaID.Int64)
+			gr.MediaURL = &mediaURL
+		}
+		responses = append(responses, gr)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+	json.NewEncoder(w).Encode(responses)
 }
 
-// Get all notifications with user info (admin only)
-func getAllNotifications(w http.ResponseWriter, r *http.Request) {
+// Grade a single response in the queue (admin only)
+func gradeResponse(w http.ResponseWriter, r *http.Request) {
 	claims, err := getUserFromToken(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
@@ -3151,52 +4214,54 @@ func getAllNotifications(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	type NotificationWithUser struct {
-		ID        int        `json:"id"`
-		UserID    int        `json:"userId"`
-		UserName  string     `json:"userName"`
-		Title     string     `json:"title"`
-		Message   string     `json:"message"`
-		IsRead    bool       `json:"isRead"`
-		CreatedAt time.Time  `json:"createdAt"`
-		ReadAt    *time.Time `json:"readAt,omitempty"`
+	vars := mux.Vars(r)
+	responseID := vars["id"]
+
+	var req struct {
+		Points  int    `json:"points"`
+		Comment string `json:"comment"`
 	}
 
-	rows, err := db.Query(`
-		SELECT n.id, n.user_id, u.name, n.title, n.message, n.is_read, n.created_at, n.read_at
-		FROM notifications n
-		JOIN users u ON n.user_id = u.id
-		ORDER BY n.created_at DESC
-	`)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
-	defer rows.Close()
 
-	var notifications []NotificationWithUser
-	for rows.Next() {
-		var notif NotificationWithUser
-		var isRead int
-		var readAt sql.NullTime
-		if err := rows.Scan(&notif.ID, &notif.UserID, &notif.UserName, &notif.Title, &notif.Message,
-			&isRead, &notif.CreatedAt, &readAt); err != nil {
+	var possiblePoints int
+	var status string
+	err = db.QueryRow("SELECT possible_points, status FROM graded_responses WHERE id = ?", responseID).Scan(&possiblePoints, &status)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Response not found", http.StatusNotFound)
+		} else {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		notif.IsRead = isRead == 1
-		if readAt.Valid {
-			notif.ReadAt = &readAt.Time
 		}
-		notifications = append(notifications, notif)
+		return
+	}
+
+	if status == "released" {
+		http.Error(w, "This grade has already been released", http.StatusConflict)
+		return
+	}
+
+	if req.Points < 0 || req.Points > possiblePoints {
+		http.Error(w, fmt.Sprintf("Points must be between 0 and %d", possiblePoints), http.StatusBadRequest)
+		return
+	}
+
+	_, err = db.Exec(`UPDATE graded_responses SET points_awarded = ?, comment = ?, status = 'graded', graded_at = CURRENT_TIMESTAMP
+		WHERE id = ?`, req.Points, req.Comment, responseID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(notifications)
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
 }
 
-// Delete notification (admin only)
-func deleteNotification(w http.ResponseWriter, r *http.Request) {
+// Release the teacher grades of an assignment - credits coins and XP and notifies the student (admin only)
+func releaseAssignmentGrades(w http.ResponseWriter, r *http.Request) {
 	claims, err := getUserFromToken(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
@@ -3212,440 +4277,8338 @@ func deleteNotification(w http.ResponseWriter, r *http.Request) {
 	}
 
 	vars := mux.Vars(r)
-	notifID := vars["id"]
+	assignmentDBID := vars["id"]
 
-	result, err := db.Exec("DELETE FROM notifications WHERE id = ?", notifID)
+	// Start transaction
+	tx, err := db.Begin()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	defer tx.Rollback()
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		http.Error(w, "Notification not found", http.StatusNotFound)
+	var studentID int
+	var assignmentName string
+	var currentData sql.NullString
+	err = tx.QueryRow("SELECT user_id, name, data FROM assignments WHERE id = ?", assignmentDBID).Scan(&studentID, &assignmentName, &currentData)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Assignment not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]bool{"success": true})
-}
-
-// Get unread release notes for the logged-in user
-func getUnreadReleaseNotes(w http.ResponseWriter, r *http.Request) {
-	claims, err := getUserFromToken(r)
+	// Every response must be graded before the assignment can be released
+	var pendingCount int
+	err = tx.QueryRow("SELECT COUNT(*) FROM graded_responses WHERE assignment_id = ? AND status = 'pending'", assignmentDBID).Scan(&pendingCount)
 	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if pendingCount > 0 {
+		http.Error(w, fmt.Sprintf("%d response(s) still need a grade", pendingCount), http.StatusBadRequest)
 		return
 	}
 
-	rows, err := db.Query(`SELECT id, user_id, message, is_read, created_at, read_at
-		FROM app_release_notes WHERE user_id = ? AND is_read = 0 ORDER BY created_at DESC`, claims.UserID)
+	rows, err := tx.Query(`SELECT id, question_id, possible_points, COALESCE(points_awarded, 0), COALESCE(comment, '')
+		FROM graded_responses WHERE assignment_id = ? AND status = 'graded'`, assignmentDBID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
 
-	var releaseNotes []AppReleaseNote
+	type releasedGrade struct {
+		ID             int
+		QuestionID     string
+		PossiblePoints int
+		PointsAwarded  int
+		Comment        string
+	}
+	var grades []releasedGrade
 	for rows.Next() {
-		var note AppReleaseNote
-		var isRead int
-		var readAt sql.NullTime
-		if err := rows.Scan(&note.ID, &note.UserID, &note.Message,
-			&isRead, &note.CreatedAt, &readAt); err != nil {
+		var g releasedGrade
+		if err := rows.Scan(&g.ID, &g.QuestionID, &g.PossiblePoints, &g.PointsAwarded, &g.Comment); err != nil {
+			rows.Close()
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		note.IsRead = isRead == 1
-		if readAt.Valid {
-			note.ReadAt = &readAt.Time
-		}
-		releaseNotes = append(releaseNotes, note)
+		grades = append(grades, g)
 	}
+	rows.Close()
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(releaseNotes)
-}
-
-// Mark release note as read
-func markReleaseNoteRead(w http.ResponseWriter, r *http.Request) {
-	claims, err := getUserFromToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	if len(grades) == 0 {
+		http.Error(w, "No graded responses to release", http.StatusBadRequest)
 		return
 	}
 
-	vars := mux.Vars(r)
-	noteID := vars["id"]
+	totalPoints := 0
+	totalPossible := 0
+	for _, g := range grades {
+		totalPoints += g.PointsAwarded
+		totalPossible += g.PossiblePoints
+	}
 
-	// Verify release note belongs to user
-	var userID int
-	err = db.QueryRow("SELECT user_id FROM app_release_notes WHERE id = ?", noteID).Scan(&userID)
-	if err != nil {
-		http.Error(w, "Release note not found", http.StatusNotFound)
-		return
+	// XP follows the quiz rule: half of the success rate
+	xpGain := 0
+	if totalPossible > 0 {
+		xpGain = (totalPoints * 100 / totalPossible) / 2
 	}
 
-	if userID != claims.UserID {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		return
+	// Copy the grades and comments into the assignment data so the student can see them
+	if currentData.Valid && currentData.String != "" {
+		var quizData []QuizQuestion
+		if err := json.Unmarshal([]byte(currentData.String), &quizData); err != nil {
+			http.Error(w, "Error parsing assignment data", http.StatusInternalServerError)
+			return
+		}
+
+		for i := range quizData {
+			for _, g := range grades {
+				if quizData[i].ID == g.QuestionID {
+					points := g.PointsAwarded
+					isCorrect := g.PointsAwarded == g.PossiblePoints
+					quizData[i].PointsAwarded = &points
+					quizData[i].IsCorrect = &isCorrect
+					quizData[i].Feedback = g.Comment
+					quizData[i].GradingStatus = "released"
+					break
+				}
+			}
+		}
+
+		updatedData, err := json.Marshal(quizData)
+		if err != nil {
+			http.Error(w, "Error encoding updated data", http.StatusInternalServerError)
+			return
+		}
+
+		_, err = tx.Exec("UPDATE assignments SET data = ? WHERE id = ?", string(updatedData), assignmentDBID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 	}
 
-	// Mark as read
-	_, err = db.Exec("UPDATE app_release_notes SET is_read = 1, read_at = CURRENT_TIMESTAMP WHERE id = ?", noteID)
+	_, err = tx.Exec("UPDATE assignments SET coins_received = COALESCE(coins_received, 0) + ? WHERE id = ?", totalPoints, assignmentDBID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]bool{"success": true})
-}
-
-// Create release notes (admin only)
-func createReleaseNotes(w http.ResponseWriter, r *http.Request) {
-	claims, err := getUserFromToken(r)
+	// Credit coins and banked XP to the student's avatar
+	_, err = tx.Exec("UPDATE avatars SET coins = coins + ?, xp_bank = COALESCE(xp_bank, 0) + ? WHERE user_id = ?", totalPoints, xpGain, studentID)
 	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Check if user is admin
-	var role string
-	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
-	if err != nil || role != "admin" {
-		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+	_, err = tx.Exec(`UPDATE graded_responses SET status = 'released', released_at = CURRENT_TIMESTAMP
+		WHERE assignment_id = ? AND status = 'graded'`, assignmentDBID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	var req CreateReleaseNoteRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+	// Notify the student
+	title := "Assignment Graded"
+	message := fmt.Sprintf("Your teacher graded **%s**. You earned **%d** coins and **%d** XP.\n\n[View Feedback](/assignments/quiz/%s)",
+		assignmentName, totalPoints, xpGain, assignmentDBID)
+	_, err = tx.Exec("INSERT INTO notifications (user_id, title, message) VALUES (?, ?, ?)", studentID, title, message)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if req.Message == "" {
-		http.Error(w, "Message is required", http.StatusBadRequest)
+	// Commit transaction
+	if err = tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	var userIDs []int
-	if len(req.UserIDs) == 0 {
-		// Get all users
-		rows, err := db.Query("SELECT id FROM users")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":      true,
+		"coinsAwarded": totalPoints,
+		"xpAwarded":    xpGain,
+		"released":     len(grades),
+	})
+}
+
+// analyticsFilter holds the query filters shared by the analytics endpoints
+type analyticsFilter struct {
+	Class        *int
+	From         *time.Time
+	To           *time.Time
+	AssignmentID string // Assignment definition (e.g. "1005")
+}
+
+// analyticsAssignment is one student's assignment with its parsed questions
+type analyticsAssignment struct {
+	ID           int
+	AssignmentID string
+	Name         string
+	UserID       int
+	StudentName  string
+	Class        *int
+	Completed    bool
+	DueDate      time.Time
+	Questions    []QuizQuestion
+}
+
+// Parse ?class=3&from=2025-01-01&to=2025-01-31&assignmentId=1005
+func parseAnalyticsFilter(r *http.Request) (analyticsFilter, error) {
+	var filter analyticsFilter
+	query := r.URL.Query()
+
+	if classParam := query.Get("class"); classParam != "" {
+		class, err := strconv.Atoi(classParam)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			return filter, fmt.Errorf("invalid class")
 		}
-		defer rows.Close()
+		filter.Class = &class
+	}
 
-		for rows.Next() {
-			var userID int
-			if err := rows.Scan(&userID); err != nil {
-				continue
-			}
-			userIDs = append(userIDs, userID)
+	if fromParam := query.Get("from"); fromParam != "" {
+		from, err := time.Parse("2006-01-02", fromParam)
+		if err != nil {
+			return filter, fmt.Errorf("invalid from date, use YYYY-MM-DD")
 		}
-	} else {
-		userIDs = req.UserIDs
+		filter.From = &from
 	}
 
-	// Create release note for each user
-	for _, userID := range userIDs {
-		_, err := db.Exec("INSERT INTO app_release_notes (user_id, message) VALUES (?, ?)",
-			userID, req.Message)
+	if toParam := query.Get("to"); toParam != "" {
+		to, err := time.Parse("2006-01-02", toParam)
 		if err != nil {
-			log.Printf("Error creating release note for user %d: %v", userID, err)
+			return filter, fmt.Errorf("invalid to date, use YYYY-MM-DD")
 		}
+		// Include the whole "to" day
+		to = to.Add(24 * time.Hour)
+		filter.To = &to
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"message": fmt.Sprintf("Release notes created for %d users", len(userIDs)),
-	})
+	filter.AssignmentID = query.Get("assignmentId")
+	return filter, nil
 }
 
-// Create a new game with grid cells
-func createGame(w http.ResponseWriter, r *http.Request) {
-	claims, err := getUserFromToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+// Load student assignments matching the filter
+func loadAnalyticsAssignments(filter analyticsFilter) ([]analyticsAssignment, error) {
+	query := `SELECT a.id, a.assignment_id, a.name, a.user_id, u.name, u.class, a.completed, a.due_date, a.data
+		FROM assignments a
+		JOIN users u ON a.user_id = u.id
+		WHERE u.role = 'student'`
+	var args []interface{}
 
-	// Check if user is admin
-	var role string
-	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
-	if err != nil || role != "admin" {
-		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
-		return
+	if filter.Class != nil {
+		query += " AND u.class = ?"
+		args = append(args, *filter.Class)
 	}
-
-	var req CreateGameRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
-		return
+	if filter.From != nil {
+		query += " AND a.due_date >= ?"
+		args = append(args, *filter.From)
 	}
-
-	// Validate input
-	if req.Rows < 1 || req.Rows > 100 || req.Columns < 1 || req.Columns > 100 {
-		http.Error(w, "Rows and columns must be between 1 and 100", http.StatusBadRequest)
-		return
+	if filter.To != nil {
+		query += " AND a.due_date < ?"
+		args = append(args, *filter.To)
+	}
+	if filter.AssignmentID != "" {
+		query += " AND a.assignment_id = ?"
+		args = append(args, filter.AssignmentID)
 	}
 
-	// Create game with turn tracking initialized
-	result, err := db.Exec("INSERT INTO games (name, thumbnail, rows, columns, current_turn_index, turn_start_time, turn_duration) VALUES (?, ?, ?, ?, 0, datetime('now'), 20)",
-		req.Name, req.Thumbnail, req.Rows, req.Columns)
+	query += " ORDER BY a.due_date ASC"
+
+	rows, err := db.Query(query, args...)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var assignments []analyticsAssignment
+	for rows.Next() {
+		var a analyticsAssignment
+		var class sql.NullInt64
+		var completed int
+		var dueDate sql.NullTime
+		var data sql.NullString
+		if err := rows.Scan(&a.ID, &a.AssignmentID, &a.Name, &a.UserID, &a.StudentName, &class, &completed, &dueDate, &data); err != nil {
+			return nil, err
+		}
+		if class.Valid {
+			classValue := int(class.Int64)
+			a.Class = &classValue
+		}
+		a.Completed = completed == 1
+		if dueDate.Valid {
+			a.DueDate = dueDate.Time
+		}
+		if data.Valid && data.String != "" {
+			// Assignments with unparseable data are still counted for completion
+			json.Unmarshal([]byte(data.String), &a.Questions)
+		}
+		assignments = append(assignments, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return assignments, nil
+}
+
+// Per-question item analysis: percent correct, common wrong answers and average time (admin only)
+func getQuestionAnalytics(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	filter, err := parseAnalyticsFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	assignments, err := loadAnalyticsAssignments(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	type WrongAnswer struct {
+		Answer string `json:"answer"`
+		Count  int    `json:"count"`
+	}
+
+	type QuestionStats struct {
+		QuestionID         string        `json:"questionId"`
+		Question           string        `json:"question"`
+		Type               string        `json:"type"`
+		AssignmentID       string        `json:"assignmentId"`
+		AssignmentName     string        `json:"assignmentName"`
+		Attempts           int           `json:"attempts"`
+		Correct            int           `json:"correct"`
+		PercentCorrect     float64       `json:"percentCorrect"`
+		AverageTime        *float64      `json:"averageTimeSeconds"`
+		CommonWrongAnswers []WrongAnswer `json:"commonWrongAnswers"`
+
+		wrongCounts map[string]int
+		totalTime   int
+		timedCount  int
+	}
+
+	statsByID := make(map[string]*QuestionStats)
+	var order []string
+
+	for _, a := range assignments {
+		if !a.Completed {
+			continue
+		}
+		for _, q := range a.Questions {
+			// Only graded answers count (written answers count once released)
+			if q.IsCorrect == nil {
+				continue
+			}
+
+			stats, ok := statsByID[q.ID]
+			if !ok {
+				stats = &QuestionStats{
+					QuestionID:     q.ID,
+					Question:       q.Question,
+					Type:           q.Type,
+					AssignmentID:   a.AssignmentID,
+					AssignmentName: a.Name,
+					wrongCounts:    make(map[string]int),
+				}
+				statsByID[q.ID] = stats
+				order = append(order, q.ID)
+			}
+
+			stats.Attempts++
+			if *q.IsCorrect {
+				stats.Correct++
+			} else if q.UserAnswer != nil {
+				answer := strings.ToLower(strings.TrimSpace(fmt.Sprintf("%v", q.UserAnswer)))
+				if answer != "" && answer != "no answer" {
+					stats.wrongCounts[answer]++
+				}
+			}
+
+			if q.TimeSpent != nil {
+				stats.totalTime += *q.TimeSpent
+				stats.timedCount++
+			}
+		}
+	}
+
+	results := make([]*QuestionStats, 0, len(order))
+	for _, id := range order {
+		stats := statsByID[id]
+		stats.PercentCorrect = float64(stats.Correct) * 100.0 / float64(stats.Attempts)
+		if stats.timedCount > 0 {
+			averageTime := float64(stats.totalTime) / float64(stats.timedCount)
+			stats.AverageTime = &averageTime
+		}
+
+		stats.CommonWrongAnswers = []WrongAnswer{}
+		for answer, count := range stats.wrongCounts {
+			stats.CommonWrongAnswers = append(stats.CommonWrongAnswers, WrongAnswer{Answer: answer, Count: count})
+		}
+		sort.Slice(stats.CommonWrongAnswers, func(i, j int) bool {
+			if stats.CommonWrongAnswers[i].Count != stats.CommonWrongAnswers[j].Count {
+				return stats.CommonWrongAnswers[i].Count > stats.CommonWrongAnswers[j].Count
+			}
+			return stats.CommonWrongAnswers[i].Answer < stats.CommonWrongAnswers[j].Answer
+		})
+		if len(stats.CommonWrongAnswers) > 5 {
+			stats.CommonWrongAnswers = stats.CommonWrongAnswers[:5]
+		}
+
+		results = append(results, stats)
+	}
+
+	// Most missed questions first
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].PercentCorrect < results[j].PercentCorrect
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// Per-student mastery by topic (question tags, falling back to the assignment name) (admin only)
+func getMasteryAnalytics(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	filter, err := parseAnalyticsFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	assignments, err := loadAnalyticsAssignments(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	type TopicMastery struct {
+		Topic          string  `json:"topic"`
+		Attempts       int     `json:"attempts"`
+		Correct        int     `json:"correct"`
+		PercentCorrect float64 `json:"percentCorrect"`
+	}
+
+	type StudentMastery struct {
+		UserID      int             `json:"userId"`
+		StudentName string          `json:"studentName"`
+		Class       *int            `json:"class,omitempty"`
+		Topics      []*TopicMastery `json:"topics"`
+
+		topicsByName map[string]*TopicMastery
+	}
+
+	studentsByID := make(map[int]*StudentMastery)
+	var order []int
+
+	for _, a := range assignments {
+		if !a.Completed {
+			continue
+		}
+
+		student, ok := studentsByID[a.UserID]
+		if !ok {
+			student = &StudentMastery{
+				UserID:       a.UserID,
+				StudentName:  a.StudentName,
+				Class:        a.Class,
+				Topics:       []*TopicMastery{},
+				topicsByName: make(map[string]*TopicMastery),
+			}
+			studentsByID[a.UserID] = student
+			order = append(order, a.UserID)
+		}
+
+		for _, q := range a.Questions {
+			if q.IsCorrect == nil {
+				continue
+			}
+
+			topics := q.Tags
+			if len(topics) == 0 {
+				topics = []string{a.Name}
+			}
+
+			for _, topicName := range topics {
+				topic, ok := student.topicsByName[topicName]
+				if !ok {
+					topic = &TopicMastery{Topic: topicName}
+					student.topicsByName[topicName] = topic
+					student.Topics = append(student.Topics, topic)
+				}
+				topic.Attempts++
+				if *q.IsCorrect {
+					topic.Correct++
+				}
+			}
+		}
+	}
+
+	results := make([]*StudentMastery, 0, len(order))
+	for _, userID := range order {
+		student := studentsByID[userID]
+		for _, topic := range student.Topics {
+			topic.PercentCorrect = float64(topic.Correct) * 100.0 / float64(topic.Attempts)
+		}
+		// Weakest topics first
+		sort.SliceStable(student.Topics, func(i, j int) bool {
+			return student.Topics[i].PercentCorrect < student.Topics[j].PercentCorrect
+		})
+		results = append(results, student)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].StudentName < results[j].StudentName
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// Completion rate by class over time, bucketed by due date (admin only)
+func getCompletionAnalytics(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	filter, err := parseAnalyticsFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	interval := r.URL.Query().Get("interval")
+	if interval == "" {
+		interval = "week"
+	}
+	if interval != "day" && interval != "week" && interval != "month" {
+		http.Error(w, "Invalid interval. Must be 'day', 'week' or 'month'", http.StatusBadRequest)
+		return
+	}
+
+	assignments, err := loadAnalyticsAssignments(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	type CompletionBucket struct {
+		Class          *int    `json:"class"`
+		PeriodStart    string  `json:"periodStart"`
+		Assigned       int     `json:"assigned"`
+		Completed      int     `json:"completed"`
+		CompletionRate float64 `json:"completionRate"`
+	}
+
+	bucketsByKey := make(map[string]*CompletionBucket)
+	var buckets []*CompletionBucket
+
+	for _, a := range assignments {
+		// Start of the period the due date falls in
+		dueDate := a.DueDate
+		periodStart := time.Date(dueDate.Year(), dueDate.Month(), dueDate.Day(), 0, 0, 0, 0, dueDate.Location())
+		switch interval {
+		case "week":
+			// Weeks start on Monday
+			offset := (int(periodStart.Weekday()) + 6) % 7
+			periodStart = periodStart.AddDate(0, 0, -offset)
+		case "month":
+			periodStart = time.Date(dueDate.Year(), dueDate.Month(), 1, 0, 0, 0, 0, dueDate.Location())
+		}
+
+		classKey := "none"
+		if a.Class != nil {
+			classKey = strconv.Itoa(*a.Class)
+		}
+		key := classKey + "|" + periodStart.Format("2006-01-02")
+
+		bucket, ok := bucketsByKey[key]
+		if !ok {
+			bucket = &CompletionBucket{Class: a.Class, PeriodStart: periodStart.Format("2006-01-02")}
+			bucketsByKey[key] = bucket
+			buckets = append(buckets, bucket)
+		}
+
+		bucket.Assigned++
+		if a.Completed {
+			bucket.Completed++
+		}
+	}
+
+	for _, bucket := range buckets {
+		bucket.CompletionRate = float64(bucket.Completed) * 100.0 / float64(bucket.Assigned)
+	}
+
+	sort.SliceStable(buckets, func(i, j int) bool {
+		if buckets[i].PeriodStart != buckets[j].PeriodStart {
+			return buckets[i].PeriodStart < buckets[j].PeriodStart
+		}
+		if buckets[i].Class == nil || buckets[j].Class == nil {
+			return buckets[j].Class == nil && buckets[i].Class != nil
+		}
+		return *buckets[i].Class < *buckets[j].Class
+	})
+
+	if buckets == nil {
+		buckets = []*CompletionBucket{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buckets)
+}
+
+// GradebookColumn is one assignment in the gradebook (shared by every student it was assigned to)
+type GradebookColumn struct {
+	Key          string    `json:"key"`
+	AssignmentID string    `json:"assignmentId"`
+	Name         string    `json:"name"`
+	Category     string    `json:"category"`
+	DueDate      time.Time `json:"dueDate"`
+}
+
+// GradebookEntry is one student's result for one gradebook column
+type GradebookEntry struct {
+	AssignmentDBID int      `json:"id"`
+	Completed      bool     `json:"completed"`
+	ScorePercent   *float64 `json:"scorePercent"`
+	Coins          int      `json:"coins"`
+	CoinsEarned    int      `json:"coinsEarned"`
+	Late           bool     `json:"late"`
+	LateUnknown    bool     `json:"lateUnknown,omitempty"` // Completed after the due date passed, but before completion times were recorded
+	Missing        bool     `json:"missing"`
+}
+
+// GradebookRow is one student's line in the gradebook
+type GradebookRow struct {
+	UserID      int                        `json:"userId"`
+	StudentName string                     `json:"studentName"`
+	Class       *int                       `json:"class,omitempty"`
+	Entries     map[string]*GradebookEntry `json:"entries"`
+	TermGrade   *float64                   `json:"termGrade"`
+}
+
+// Parse ?weights=quiz:40,homework:20,vocab:40 into category -> weight
+func parseGradebookWeights(param string) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	if param == "" {
+		return weights, nil
+	}
+
+	for _, part := range strings.Split(param, ",") {
+		pieces := strings.SplitN(part, ":", 2)
+		if len(pieces) != 2 {
+			return nil, fmt.Errorf("invalid weight %q, use category:weight", part)
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(pieces[1]), 64)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight %q, use category:weight", part)
+		}
+		weights[strings.ToLower(strings.TrimSpace(pieces[0]))] = weight
+	}
+
+	return weights, nil
+}
+
+// Score of a submitted quiz as a percentage of its points: each correct answer earns its coins_worth and
+// teacher-graded answers earn the points released to the student. Reports false for quizzes without points.
+func quizScorePercent(questions []QuizQuestion) (float64, bool) {
+	earned, possible := 0, 0
+	for _, q := range questions {
+		possible += q.CoinsWorth
+		if isTeacherGradedType(q.Type) {
+			if q.GradingStatus == "released" && q.PointsAwarded != nil {
+				earned += *q.PointsAwarded
+			}
+		} else if q.IsCorrect != nil && *q.IsCorrect {
+			earned += q.CoinsWorth
+		}
+	}
+	if possible == 0 {
+		return 0, false
+	}
+	return float64(earned) * 100.0 / float64(possible), true
+}
+
+// Get gradebook (student x assignment matrix) as JSON, CSV or XLSX (admin only)
+func getGradebook(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	filter, err := parseAnalyticsFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	weights, err := parseGradebookWeights(r.URL.Query().Get("weights"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" && format != "xlsx" {
+		http.Error(w, "Invalid format. Must be 'json', 'csv' or 'xlsx'", http.StatusBadRequest)
+		return
+	}
+
+	query := `SELECT a.id, a.assignment_id, a.name, COALESCE(a.category, ''), a.due_date, a.completed_at,
+		a.completed, a.coins, COALESCE(a.coins_received, 0), a.data, u.id, u.name, u.class
+		FROM assignments a
+		JOIN users u ON a.user_id = u.id
+		WHERE u.role = 'student'`
+	var args []interface{}
+
+	if filter.Class != nil {
+		query += " AND u.class = ?"
+		args = append(args, *filter.Class)
+	}
+	if filter.From != nil {
+		query += " AND a.due_date >= ?"
+		args = append(args, *filter.From)
+	}
+	if filter.To != nil {
+		query += " AND a.due_date < ?"
+		args = append(args, *filter.To)
+	}
+	if filter.AssignmentID != "" {
+		query += " AND a.assignment_id = ?"
+		args = append(args, filter.AssignmentID)
+	}
+
+	query += " ORDER BY a.due_date ASC, a.name ASC"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	columns := []GradebookColumn{}
+	columnsByKey := make(map[string]GradebookColumn)
+	studentsByID := make(map[int]*GradebookRow)
+	var students []*GradebookRow
+
+	for rows.Next() {
+		var column GradebookColumn
+		var entry GradebookEntry
+		var dueDate, completedAt sql.NullTime
+		var completed int
+		var data sql.NullString
+		var userID int
+		var studentName string
+		var class sql.NullInt64
+		if err := rows.Scan(&entry.AssignmentDBID, &column.AssignmentID, &column.Name, &column.Category, &dueDate, &completedAt,
+			&completed, &entry.Coins, &entry.CoinsEarned, &data, &userID, &studentName, &class); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if dueDate.Valid {
+			column.DueDate = dueDate.Time
+		}
+		if column.Category == "" {
+			column.Category = "uncategorized"
+		}
+
+		// The same assignment handed to several students shares one column
+		column.Key = fmt.Sprintf("%s|%s|%s", column.AssignmentID, column.Name, column.DueDate.Format("2006-01-02"))
+		if _, ok := columnsByKey[column.Key]; !ok {
+			columnsByKey[column.Key] = column
+			columns = append(columns, column)
+		}
+
+		entry.Completed = completed == 1
+		if entry.Completed {
+			// Score the stored answers; the coins paid out shrink on retakes, so they only stand in
+			// for assignments without quiz points
+			score := 0.0
+			var questions []QuizQuestion
+			if data.Valid && data.String != "" {
+				if err := json.Unmarshal([]byte(data.String), &questions); err != nil {
+					http.Error(w, fmt.Sprintf("Error parsing data of assignment %d", entry.AssignmentDBID), http.StatusInternalServerError)
+					return
+				}
+			}
+			if percent, ok := quizScorePercent(questions); ok {
+				score = percent
+			} else if entry.Coins > 0 {
+				score = float64(entry.CoinsEarned) * 100.0 / float64(entry.Coins)
+			}
+			entry.ScorePercent = &score
+
+			// Work completed before completion times were recorded has no completed_at; once its
+			// due date has passed there is no telling whether it was late
+			if completedAt.Valid {
+				entry.Late = dueDate.Valid && completedAt.Time.After(dueDate.Time)
+			} else {
+				entry.LateUnknown = dueDate.Valid && now.After(dueDate.Time)
+			}
+		} else {
+			entry.Missing = dueDate.Valid && now.After(dueDate.Time)
+		}
+
+		student, ok := studentsByID[userID]
+		if !ok {
+			student = &GradebookRow{
+				UserID:      userID,
+				StudentName: studentName,
+				Entries:     make(map[string]*GradebookEntry),
+			}
+			if class.Valid {
+				classValue := int(class.Int64)
+				student.Class = &classValue
+			}
+			studentsByID[userID] = student
+			students = append(students, student)
+		}
+		student.Entries[column.Key] = &entry
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Once weights are given every category in the gradebook needs one
+	if len(weights) > 0 {
+		for _, column := range columns {
+			if _, ok := weights[column.Category]; !ok {
+				http.Error(w, fmt.Sprintf("No weight given for category %q", column.Category), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	// Term grade: average score per category (missing work counts as 0, work not yet due is skipped),
+	// then a weighted average of the categories. Without weights every category counts equally.
+	for _, student := range students {
+		categoryTotals := make(map[string]float64)
+		categoryCounts := make(map[string]int)
+		for _, column := range columns {
+			entry, ok := student.Entries[column.Key]
+			if !ok {
+				continue
+			}
+			if entry.ScorePercent != nil {
+				categoryTotals[column.Category] += *entry.ScorePercent
+				categoryCounts[column.Category]++
+			} else if entry.Missing {
+				categoryCounts[column.Category]++
+			}
+		}
+
+		var weightedTotal, weightSum float64
+		for category, count := range categoryCounts {
+			weight := 1.0
+			if given, ok := weights[category]; ok {
+				weight = given
+			}
+			if weight == 0 {
+				continue
+			}
+			weightedTotal += categoryTotals[category] / float64(count) * weight
+			weightSum += weight
+		}
+		if weightSum > 0 {
+			termGrade := weightedTotal / weightSum
+			student.TermGrade = &termGrade
+		}
+	}
+
+	sort.SliceStable(students, func(i, j int) bool {
+		return students[i].StudentName < students[j].StudentName
+	})
+
+	if students == nil {
+		students = []*GradebookRow{}
+	}
+
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"columns":  columns,
+			"students": students,
+			"weights":  weights,
+		})
+		return
+	}
+
+	// Flatten into a sheet: three columns per assignment (score, coins, late/missing)
+	header := []interface{}{"Student", "Class"}
+	for _, column := range columns {
+		label := fmt.Sprintf("%s (%s)", column.Name, column.DueDate.Format("Jan 2"))
+		header = append(header, label+" %", label+" coins", label+" status")
+	}
+	header = append(header, "Term Grade")
+	table := [][]interface{}{header}
+
+	for _, student := range students {
+		var class interface{} = ""
+		if student.Class != nil {
+			class = *student.Class
+		}
+		row := []interface{}{student.StudentName, class}
+		for _, column := range columns {
+			entry, ok := student.Entries[column.Key]
+			if !ok {
+				row = append(row, "", "", "")
+				continue
+			}
+
+			var score interface{} = ""
+			if entry.ScorePercent != nil {
+				score = math.Round(*entry.ScorePercent*10) / 10
+			}
+			status := ""
+			if entry.Late {
+				status = "late"
+			} else if entry.LateUnknown {
+				status = "late unknown"
+			} else if entry.Missing {
+				status = "missing"
+			}
+			row = append(row, score, entry.CoinsEarned, status)
+		}
+
+		var termGrade interface{} = ""
+		if student.TermGrade != nil {
+			termGrade = math.Round(*student.TermGrade*10) / 10
+		}
+		row = append(row, termGrade)
+		table = append(table, row)
+	}
+
+	fileName := fmt.Sprintf("gradebook-%s", now.Format("2006-01-02"))
+	if filter.Class != nil {
+		fileName = fmt.Sprintf("gradebook-class-%d-%s", *filter.Class, now.Format("2006-01-02"))
+	}
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName+".csv"))
+		writer := csv.NewWriter(w)
+		for _, row := range table {
+			record := make([]string, len(row))
+			for i, value := range row {
+				record[i] = fmt.Sprintf("%v", value)
+			}
+			writer.Write(record)
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			log.Printf("Error writing gradebook CSV: %v", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName+".xlsx"))
+	if err := writeXLSX(w, "Gradebook", table); err != nil {
+		log.Printf("Error writing gradebook XLSX: %v", err)
+	}
+}
+
+// Convert a zero-based column index to a spreadsheet column name (0 -> A, 26 -> AA)
+func xlsxColumnName(index int) string {
+	name := ""
+	for index >= 0 {
+		name = string(rune('A'+index%26)) + name
+		index = index/26 - 1
+	}
+	return name
+}
+
+// Write a single-sheet XLSX workbook. Numbers become numeric cells, everything else inline strings.
+func writeXLSX(w io.Writer, sheetName string, table [][]interface{}) error {
+	files := []struct {
+		Name    string
+		Content string
+	}{
+		{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+</Types>`},
+		{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`},
+		{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+</Relationships>`},
+	}
+
+	var sheetNameEscaped strings.Builder
+	xml.EscapeText(&sheetNameEscaped, []byte(sheetName))
+	files = append(files, struct {
+		Name    string
+		Content string
+	}{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="` + sheetNameEscaped.String() + `" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`})
+
+	var sheet strings.Builder
+	sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	for rowIndex, row := range table {
+		fmt.Fprintf(&sheet, `<row r="%d">`, rowIndex+1)
+		for columnIndex, value := range row {
+			ref := fmt.Sprintf("%s%d", xlsxColumnName(columnIndex), rowIndex+1)
+			switch v := value.(type) {
+			case int, int64, float64:
+				fmt.Fprintf(&sheet, `<c r="%s"><v>%v</v></c>`, ref, v)
+			default:
+				text := fmt.Sprintf("%v", v)
+				if text == "" {
+					continue
+				}
+				fmt.Fprintf(&sheet, `<c r="%s" t="inlineStr"><is><t>`, ref)
+				xml.EscapeText(&sheet, []byte(text))
+				sheet.WriteString(`</t></is></c>`)
+			}
+		}
+		sheet.WriteString(`</row>`)
+	}
+	sheet.WriteString(`</sheetData></worksheet>`)
+	files = append(files, struct {
+		Name    string
+		Content string
+	}{"xl/worksheets/sheet1.xml", sheet.String()})
+
+	archive := zip.NewWriter(w)
+	for _, file := range files {
+		f, err := archive.Create(file.Name)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write([]byte(file.Content)); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// Question types whose answers go to the teacher grading queue instead of being auto-graded
+func isTeacherGradedType(questionType string) bool {
+	return questionType == "written" || questionType == "speak"
+}
+
+// Check a student's answer to an auto-graded question. Choice questions accept the index of the
+// chosen option or its text; typed questions accept any of the acceptable answers, ignoring case.
+// "listen" questions are choice questions when they have a correct index and typed otherwise.
+func isQuizAnswerCorrect(q QuizQuestion) bool {
+	if q.UserAnswer == nil {
+		return false
+	}
+
+	questionType := q.Type
+	if questionType == "listen" && q.Correct == nil {
+		questionType = "typed"
+	}
+
+	switch questionType {
+	case "multiple", "multiple-choice", "listen":
+		// Quizzes keep their choices under "options" or "answer"
+		choices := q.Options
+		if choices == nil {
+			choices, _ = q.Answer.([]interface{})
+		}
+		if q.Correct == nil || *q.Correct < 0 || *q.Correct >= len(choices) {
+			return false
+		}
+		if index, ok := q.UserAnswer.(float64); ok {
+			return int(index) == *q.Correct
+		}
+		return fmt.Sprintf("%v", q.UserAnswer) == fmt.Sprintf("%v", choices[*q.Correct])
+	case "input", "typed":
+		answer := strings.ToLower(strings.TrimSpace(fmt.Sprintf("%v", q.UserAnswer)))
+		acceptable := []interface{}{q.Answer}
+		if list, ok := q.Answer.([]interface{}); ok {
+			acceptable = list
+		}
+		for _, a := range acceptable {
+			if strings.ToLower(strings.TrimSpace(fmt.Sprintf("%v", a))) == answer {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// The answer shown to a student after they submit: the right choice, or the first acceptable typed answer.
+// Teacher-graded questions have none.
+func quizCorrectAnswer(q QuizQuestion) interface{} {
+	if isTeacherGradedType(q.Type) {
+		return nil
+	}
+	if q.Correct != nil {
+		choices := q.Options
+		if choices == nil {
+			choices, _ = q.Answer.([]interface{})
+		}
+		if *q.Correct < 0 || *q.Correct >= len(choices) {
+			return nil
+		}
+		return choices[*q.Correct]
+	}
+	if list, ok := q.Answer.([]interface{}); ok {
+		if len(list) == 0 {
+			return nil
+		}
+		return list[0]
+	}
+	return q.Answer
+}
+
+// Coins and XP earned by a graded quiz: each correct answer earns its coins, and XP is half the success
+// rate over all questions. Retakes earn 20% of both. Teacher-graded questions earn theirs on release.
+func quizRewards(questions []QuizQuestion, isRetake bool) (int, int) {
+	if len(questions) == 0 {
+		return 0, 0
+	}
+
+	coins, correct := 0, 0
+	for _, q := range questions {
+		if isTeacherGradedType(q.Type) || q.IsCorrect == nil || !*q.IsCorrect {
+			continue
+		}
+		correct++
+		if isRetake {
+			coins += q.CoinsWorth * 20 / 100
+		} else {
+			coins += q.CoinsWorth
+		}
+	}
+
+	xp := correct * 100 / len(questions) / 2
+	if isRetake {
+		xp = xp * 20 / 100
+	}
+	return coins, xp
+}
+
+// Supported audio formats by file extension
+var audioContentTypes = map[string]string{
+	".mp3":  "audio/mpeg",
+	".wav":  "audio/wav",
+	".ogg":  "audio/ogg",
+	".oga":  "audio/ogg",
+	".webm": "audio/webm",
+	".m4a":  "audio/mp4",
+	".aac":  "audio/aac",
+}
+
+// Whether the start of a file is audio. Recordings in webm/ogg/mp4 containers are sniffed as
+// video/application types, and raw MP3/AAC streams (no ID3 tag) only show an MPEG frame sync.
+func looksLikeAudio(head []byte) bool {
+	switch sniffed := http.DetectContentType(head); {
+	case strings.HasPrefix(sniffed, "audio/"), sniffed == "application/ogg", sniffed == "video/webm", sniffed == "video/mp4":
+		return true
+	}
+	return len(head) >= 2 && head[0] == 0xFF && head[1]&0xE0 == 0xE0
+}
+
+// Directory where uploaded media is stored
+func mediaDir() string {
+	dir := os.Getenv("MEDIA_PATH")
+	if dir == "" {
+		dir = "./media"
+	}
+	return dir
+}
+
+// Save an uploaded audio file to disk and record it in the media table
+func saveMediaFile(fileHeader *multipart.FileHeader, kind string, userID int) (Media, error) {
+	var media Media
+
+	ext := strings.ToLower(filepath.Ext(fileHeader.Filename))
+	contentType, ok := audioContentTypes[ext]
+	if !ok {
+		return media, fmt.Errorf("unsupported audio format %q", ext)
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return media, err
+	}
+	defer file.Close()
+
+	// Make sure the content really is audio
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	if !looksLikeAudio(head[:n]) {
+		return media, fmt.Errorf("file does not look like audio (%s)", http.DetectContentType(head[:n]))
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return media, err
+	}
+
+	if err := os.MkdirAll(mediaDir(), 0755); err != nil {
+		return media, err
+	}
+
+	// Store under a random name so uploads never overwrite each other
+	fileName := fmt.Sprintf("%s-%s%s", kind, generateRandomID(16), ext)
+	destFile, err := os.Create(filepath.Join(mediaDir(), fileName))
+	if err != nil {
+		return media, err
+	}
+	defer destFile.Close()
+
+	size, err := io.Copy(destFile, file)
+	if err != nil {
+		return media, err
+	}
+
+	result, err := db.Exec(`INSERT INTO media (file_name, original_name, content_type, size, kind, uploaded_by) VALUES (?, ?, ?, ?, ?, ?)`,
+		fileName, fileHeader.Filename, contentType, size, kind, userID)
+	if err != nil {
+		os.Remove(filepath.Join(mediaDir(), fileName))
+		return media, err
+	}
+
+	mediaID, _ := result.LastInsertId()
+	media = Media{
+		ID:           int(mediaID),
+		OriginalName: fileHeader.Filename,
+		ContentType:  contentType,
+		Size:         size,
+		Kind:         kind,
+		UploadedBy:   userID,
+		CreatedAt:    time.Now(),
+		URL:          fmt.Sprintf("/api/media/%d", mediaID),
+	}
+	return media, nil
+}
+
+// Upload an audio clip for "listen" questions (admin only)
+func uploadMedia(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	// Parse multipart form (max 20MB)
+	err = r.ParseMultipartForm(20 << 20)
+	if err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	files := r.MultipartForm.File["file"]
+	if len(files) == 0 {
+		http.Error(w, "No file uploaded", http.StatusBadRequest)
+		return
+	}
+
+	var uploaded []Media
+	for _, fileHeader := range files {
+		media, err := saveMediaFile(fileHeader, "clip", claims.UserID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to save %s: %v", fileHeader.Filename, err), http.StatusBadRequest)
+			return
+		}
+		uploaded = append(uploaded, media)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"media":   uploaded,
+	})
+}
+
+// Upload a student recording for a "speak" question
+func uploadRecording(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	assignmentDBID := vars["id"]
+
+	// Recordings are short, 10MB is plenty
+	err = r.ParseMultipartForm(10 << 20)
+	if err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	questionID := r.FormValue("questionId")
+	if questionID == "" {
+		http.Error(w, "questionId is required", http.StatusBadRequest)
+		return
+	}
+
+	// Verify the assignment belongs to the student and has this "speak" question
+	var ownerID int
+	var data sql.NullString
+	err = db.QueryRow("SELECT user_id, data FROM assignments WHERE id = ?", assignmentDBID).Scan(&ownerID, &data)
+	if err != nil || ownerID != claims.UserID {
+		http.Error(w, "Assignment not found or access denied", http.StatusNotFound)
+		return
+	}
+
+	var quizData []QuizQuestion
+	if data.Valid && data.String != "" {
+		if err := json.Unmarshal([]byte(data.String), &quizData); err != nil {
+			http.Error(w, "Error parsing assignment data", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	isSpeakQuestion := false
+	for _, q := range quizData {
+		if q.ID == questionID && q.Type == "speak" {
+			isSpeakQuestion = true
+			break
+		}
+	}
+	if !isSpeakQuestion {
+		http.Error(w, "Question is not a speaking question", http.StatusBadRequest)
+		return
+	}
+
+	files := r.MultipartForm.File["recording"]
+	if len(files) == 0 {
+		http.Error(w, "No recording uploaded", http.StatusBadRequest)
+		return
+	}
+
+	media, err := saveMediaFile(files[0], "recording", claims.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to save recording: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"media":   media,
+	})
+}
+
+// Serve an uploaded media file (supports range requests for seeking)
+func serveMedia(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	mediaID := vars["id"]
+
+	var fileName, originalName, contentType, kind string
+	var uploadedBy int
+	var createdAt time.Time
+	err := db.QueryRow("SELECT file_name, original_name, content_type, kind, uploaded_by, created_at FROM media WHERE id = ?", mediaID).
+		Scan(&fileName, &originalName, &contentType, &kind, &uploadedBy, &createdAt)
+	if err != nil {
+		http.Error(w, "Media not found", http.StatusNotFound)
+		return
+	}
+
+	// Student recordings are only available to their owner and admins
+	if kind == "recording" {
+		claims, err := getUserFromTokenOrQuery(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if claims.UserID != uploadedBy {
+			var role string
+			err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+			if err != nil || role != "admin" {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+		}
+	}
+
+	file, err := os.Open(filepath.Join(mediaDir(), fileName))
+	if err != nil {
+		http.Error(w, "Media file missing", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", originalName))
+	http.ServeContent(w, r, originalName, createdAt, file)
+}
+
+// Mark notification as read
+func markNotificationRead(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	notifID := vars["id"]
+
+	// Verify notification belongs to user
+	var userID int
+	err = db.QueryRow("SELECT user_id FROM notifications WHERE id = ?", notifID).Scan(&userID)
+	if err != nil {
+		http.Error(w, "Notification not found", http.StatusNotFound)
+		return
+	}
+
+	if userID != claims.UserID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// Mark as read
+	_, err = db.Exec("UPDATE notifications SET is_read = 1, read_at = CURRENT_TIMESTAMP WHERE id = ?", notifID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// Get all notifications with user info (admin only)
+func getAllNotifications(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	type NotificationWithUser struct {
+		ID        int        `json:"id"`
+		UserID    int        `json:"userId"`
+		UserName  string     `json:"userName"`
+		Title     string     `json:"title"`
+		Message   string     `json:"message"`
+		IsRead    bool       `json:"isRead"`
+		CreatedAt time.Time  `json:"createdAt"`
+		ReadAt    *time.Time `json:"readAt,omitempty"`
+	}
+
+	rows, err := db.Query(`
+		SELECT n.id, n.user_id, u.name, n.title, n.message, n.is_read, n.created_at, n.read_at
+		FROM notifications n
+		JOIN users u ON n.user_id = u.id
+		ORDER BY n.created_at DESC
+	`)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var notifications []NotificationWithUser
+	for rows.Next() {
+		var notif NotificationWithUser
+		var isRead int
+		var readAt sql.NullTime
+		if err := rows.Scan(&notif.ID, &notif.UserID, &notif.UserName, &notif.Title, &notif.Message,
+			&isRead, &notif.CreatedAt, &readAt); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		notif.IsRead = isRead == 1
+		if readAt.Valid {
+			notif.ReadAt = &readAt.Time
+		}
+		notifications = append(notifications, notif)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notifications)
+}
+
+// Delete notification (admin only)
+func deleteNotification(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	vars := mux.Vars(r)
+	notifID := vars["id"]
+
+	result, err := db.Exec("DELETE FROM notifications WHERE id = ?", notifID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		http.Error(w, "Notification not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// Get unread release notes for the logged-in user
+func getUnreadReleaseNotes(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := db.Query(`SELECT id, user_id, message, is_read, created_at, read_at
+		FROM app_release_notes WHERE user_id = ? AND is_read = 0 ORDER BY created_at DESC`, claims.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var releaseNotes []AppReleaseNote
+	for rows.Next() {
+		var note AppReleaseNote
+		var isRead int
+		var readAt sql.NullTime
+		if err := rows.Scan(&note.ID, &note.UserID, &note.Message,
+			&isRead, &note.CreatedAt, &readAt); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		note.IsRead = isRead == 1
+		if readAt.Valid {
+			note.ReadAt = &readAt.Time
+		}
+		releaseNotes = append(releaseNotes, note)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(releaseNotes)
+}
+
+// Mark release note as read
+func markReleaseNoteRead(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	noteID := vars["id"]
+
+	// Verify release note belongs to user
+	var userID int
+	err = db.QueryRow("SELECT user_id FROM app_release_notes WHERE id = ?", noteID).Scan(&userID)
+	if err != nil {
+		http.Error(w, "Release note not found", http.StatusNotFound)
+		return
+	}
+
+	if userID != claims.UserID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// Mark as read
+	_, err = db.Exec("UPDATE app_release_notes SET is_read = 1, read_at = CURRENT_TIMESTAMP WHERE id = ?", noteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// Create release notes (admin only)
+func createReleaseNotes(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	var req CreateReleaseNoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if req.Message == "" {
+		http.Error(w, "Message is required", http.StatusBadRequest)
+		return
+	}
+
+	var userIDs []int
+	if len(req.UserIDs) == 0 {
+		// Get all users
+		rows, err := db.Query("SELECT id FROM users")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var userID int
+			if err := rows.Scan(&userID); err != nil {
+				continue
+			}
+			userIDs = append(userIDs, userID)
+		}
+	} else {
+		userIDs = req.UserIDs
+	}
+
+	// Create release note for each user
+	for _, userID := range userIDs {
+		_, err := db.Exec("INSERT INTO app_release_notes (user_id, message) VALUES (?, ?)",
+			userID, req.Message)
+		if err != nil {
+			log.Printf("Error creating release note for user %d: %v", userID, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": fmt.Sprintf("Release notes created for %d users", len(userIDs)),
+	})
+}
+
+// Create a new game with grid cells
+func createGame(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	var req CreateGameRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	// A template brings its own board size
+	template := req.Template
+	if template == nil && req.TemplateID != 0 {
+		template, err = loadBoardTemplate(req.TemplateID)
+		if err != nil {
+			http.Error(w, "Template not found", http.StatusNotFound)
+			return
+		}
+	}
+	if template != nil {
+		if err := validateBoardTemplate(template); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.Rows, req.Columns = template.Rows, template.Columns
+		req.Topology = template.Topology
+	}
+
+	// Validate input
+	if req.Rows < 1 || req.Rows > 100 || req.Columns < 1 || req.Columns > 100 {
+		http.Error(w, "Rows and columns must be between 1 and 100", http.StatusBadRequest)
+		return
+	}
+
+	if req.ActionsPerTurn == 0 {
+		req.ActionsPerTurn = 1
+	}
+	if req.ActionsPerTurn < 1 || req.ActionsPerTurn > 10 {
+		http.Error(w, "Actions per turn must be between 1 and 10", http.StatusBadRequest)
+		return
+	}
+
+	if req.Status == "" {
+		req.Status = "active"
+	}
+	if req.Status != "setup" && req.Status != "active" {
+		http.Error(w, "Status must be setup or active", http.StatusBadRequest)
+		return
+	}
+
+	if req.WinCondition == "" {
+		req.WinCondition = "none"
+	}
+	if err := validateWinCondition(req.WinCondition, req.WinTarget); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateGamePrizes(req.Prizes); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	prizesJSON, _ := json.Marshal(req.Prizes)
+
+	keepXP := req.KeepXP == nil || *req.KeepXP
+
+	if req.VisionRadius == 0 {
+		req.VisionRadius = 2
+	}
+	if req.VisionRadius < 1 || req.VisionRadius > 20 {
+		http.Error(w, "Vision radius must be between 1 and 20", http.StatusBadRequest)
+		return
+	}
+
+	if req.Topology == "" {
+		req.Topology = "square"
+	}
+	if _, ok := boardDirections[req.Topology]; !ok {
+		http.Error(w, "Topology must be square or hex", http.StatusBadRequest)
+		return
+	}
+	inactive, err := boardMask(req.Topology, req.Shape, req.Mask, req.Rows, req.Columns)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.BattleFormat == "" {
+		req.BattleFormat = "single"
+	}
+	if _, ok := battleFormats[req.BattleFormat]; !ok {
+		http.Error(w, "Battle format must be single, best_of_3, best_of_5 or until_ko", http.StatusBadRequest)
+		return
+	}
+
+	// The game, its players and the whole board are created in one transaction
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Create game with turn tracking initialized
+	result, err := tx.Exec(`INSERT INTO games (name, thumbnail, rows, columns, current_turn_index, turn_start_time, turn_duration, actions_per_turn, actions_taken, status, win_condition, win_target, prizes,
+		keep_xp, permadeath, fog_of_war, vision_radius, topology, battle_format, auto_resolve_battles)
+		VALUES (?, ?, ?, ?, 0, datetime('now'), 20, ?, 0, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		req.Name, req.Thumbnail, req.Rows, req.Columns, req.ActionsPerTurn, req.Status, req.WinCondition, req.WinTarget, string(prizesJSON),
+		keepXP, req.Permadeath, req.FogOfWar, req.VisionRadius, req.Topology, req.BattleFormat, req.AutoResolveBattles)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	gameID, _ := result.LastInsertId()
+
+	// Store avatar IDs in turn order
+	for i, avatarID := range req.AvatarIDs {
+		_, err := tx.Exec(`INSERT INTO game_avatars (game_id, avatar_id, turn_order) VALUES (?, ?, ?)`,
+			gameID, avatarID, i)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := createBoardCells(tx, int(gameID), req.Rows, req.Columns, inactive); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if template != nil {
+		if err := applyBoardTemplate(tx, int(gameID), template); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"gameId":  gameID,
+		"message": fmt.Sprintf("Game created with %d cells", req.Rows*req.Columns),
+	})
+}
+
+// Elements that have a kingdom (frontend/src/assets/kingdoms/<element>.webp), in the order the generator hands them out
+var kingdomElements = []string{"earth", "electricity", "fire", "gravity", "ice", "metal", "time", "water", "wind"}
+
+// Board colour of each kingdom's land
+var kingdomColors = map[string]string{
+	"earth":       "#5b7a3a",
+	"electricity": "#b59f2a",
+	"fire":        "#a3402c",
+	"gravity":     "#4b3b6b",
+	"ice":         "#9cc7d9",
+	"metal":       "#6e6e73",
+	"time":        "#8a6d4b",
+	"water":       "#2f6596",
+	"wind":        "#8fb3a8",
+}
+
+const roadColor = "#9c8a6a"
+
+// Make sure a template describes a whole, consistent board
+func validateBoardTemplate(template *BoardTemplate) error {
+	if template.Rows < 1 || template.Rows > 100 || template.Columns < 1 || template.Columns > 100 {
+		return fmt.Errorf("rows and columns must be between 1 and 100")
+	}
+	if _, ok := boardDirections[template.Topology]; !ok && template.Topology != "" {
+		return fmt.Errorf("topology must be square or hex")
+	}
+
+	seen := make(map[string]bool)
+	for _, cell := range template.Cells {
+		row, col, ok := parseCellID(cell.CellID)
+		if !ok || row >= template.Rows || col >= template.Columns {
+			return fmt.Errorf("cell %s is not on a %dx%d board", cell.CellID, template.Rows, template.Columns)
+		}
+		if seen[cell.CellID] {
+			return fmt.Errorf("cell %s appears twice", cell.CellID)
+		}
+		seen[cell.CellID] = true
+
+		if cell.RewardCoins < 0 || cell.RewardXP < 0 || cell.RegenRounds < 0 {
+			return fmt.Errorf("cell %s has negative rewards", cell.CellID)
+		}
+		for _, effect := range cell.Effects {
+			if err := validateTemplateEffect(effect, template.Rows, template.Columns); err != nil {
+				return fmt.Errorf("cell %s: %v", cell.CellID, err)
+			}
+			if effect.Type == "teleport" && effect.TargetCellID == cell.CellID {
+				return fmt.Errorf("cell %s: a teleport can't send warriors to its own cell", cell.CellID)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Check the settings of a cell effect, however it's added. Where a teleport's target is depends on the
+// caller, so only its trigger is checked here.
+func validateCellEffect(effect TemplateEffect) error {
+	if !cellEffectTypes[effect.Type] {
+		return fmt.Errorf("effect type must be heal, stamina, damage, freeze, teleport, buff or shop")
+	}
+	if effect.Trigger != "" && effect.Trigger != "enter" && effect.Trigger != "turn_start" {
+		return fmt.Errorf("trigger must be enter or turn_start")
+	}
+
+	charges := -1
+	if effect.Charges != nil {
+		charges = *effect.Charges
+	}
+	if charges < -1 || effect.Cooldown < 0 || effect.Amount < 0 || effect.Duration < 0 {
+		return fmt.Errorf("amount, duration, charges and cooldown can't be negative (charges -1 means unlimited)")
+	}
+
+	switch effect.Type {
+	case "heal", "stamina", "damage":
+		if effect.Amount < 1 {
+			return fmt.Errorf("%s needs an amount", effect.Type)
+		}
+	case "freeze":
+		if effect.Duration < 1 {
+			return fmt.Errorf("freeze needs a duration in turns")
+		}
+	case "buff":
+		if effect.Amount < 1 || effect.Duration < 1 {
+			return fmt.Errorf("buff needs an amount and a duration in turns")
+		}
+	case "teleport":
+		if effect.Trigger != "" && effect.Trigger != "enter" {
+			return fmt.Errorf("teleports trigger on enter")
+		}
+	case "shop":
+		if len(effect.ShopItems) == 0 {
+			return fmt.Errorf("a shop needs at least one item")
+		}
+		for _, item := range effect.ShopItems {
+			if item.Name == "" || item.Cost < 0 || item.Amount < 1 || (item.Effect != "heal" && item.Effect != "stamina" && item.Effect != "buff") {
+				return fmt.Errorf("shop items need a name, a cost, an amount and an effect of heal, stamina or buff")
+			}
+			if item.Effect == "buff" && item.Duration < 1 {
+				return fmt.Errorf("buff items need a duration in turns")
+			}
+		}
+	}
+
+	return nil
+}
+
+// Check a template effect for a board of the given size
+func validateTemplateEffect(effect TemplateEffect, rows, columns int) error {
+	if err := validateCellEffect(effect); err != nil {
+		return err
+	}
+
+	// Teleports need a target on the board
+	if effect.Type == "teleport" {
+		row, col, ok := parseCellID(effect.TargetCellID)
+		if !ok || row >= rows || col >= columns {
+			return fmt.Errorf("a teleport needs a cell of the board as its target")
+		}
+	}
+
+	return nil
+}
+
+// Add a template effect to a cell; targetCellID is the game_cells ID a teleport sends warriors to
+func insertTemplateEffect(tx *sql.Tx, gameID, cellID int, effect TemplateEffect, targetCellID interface{}) error {
+	trigger := effect.Trigger
+	if trigger == "" {
+		trigger = "enter"
+	}
+	charges := -1
+	if effect.Charges != nil {
+		charges = *effect.Charges
+	}
+	var shopItems string
+	if len(effect.ShopItems) > 0 {
+		encoded, _ := json.Marshal(effect.ShopItems)
+		shopItems = string(encoded)
+	}
+	_, err := tx.Exec(`INSERT INTO cell_effects (game_id, cell_id, effect_type, trigger, amount, duration, element, target_cell_id, charges,
+		initial_charges, cooldown, shop_items)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		gameID, cellID, effect.Type, trigger, effect.Amount, effect.Duration, effect.Element, targetCellID, charges, charges, effect.Cooldown, shopItems)
+	return err
+}
+
+// Write a template's cells and effects onto a game's board (whose cells already exist). Cells the template
+// doesn't mention are left as they are; the game's old cell effects are replaced.
+func applyBoardTemplate(tx *sql.Tx, gameID int, template *BoardTemplate) error {
+	rows, err := tx.Query("SELECT id, cell_id FROM game_cells WHERE game_id = ?", gameID)
+	if err != nil {
+		return err
+	}
+	ids := make(map[string]int)
+	for rows.Next() {
+		var id int
+		var cellID string
+		if rows.Scan(&id, &cellID) == nil {
+			ids[cellID] = id
+		}
+	}
+	rows.Close()
+
+	update, err := tx.Prepare(`UPDATE game_cells SET name = ?, description = ?, background = ?, active = ?, element = ?,
+		reward_coins = ?, reward_xp = ?, base_reward_coins = ?, base_reward_xp = ?, regen_rounds = ?, claimed_round = NULL
+		WHERE id = ?`)
+	if err != nil {
+		return err
+	}
+	defer update.Close()
+
+	for _, cell := range template.Cells {
+		id, ok := ids[cell.CellID]
+		if !ok {
+			continue
+		}
+		name := cell.Name
+		if name == "" {
+			name = cell.CellID
+		}
+		background := cell.Background
+		if background == "" {
+			background = "#3a3a3a"
+		}
+		active := 0
+		if cell.Active {
+			active = 1
+		}
+		_, err := update.Exec(name, cell.Description, background, active, cell.Element,
+			cell.RewardCoins, cell.RewardXP, cell.RewardCoins, cell.RewardXP, cell.RegenRounds, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM cell_effects WHERE game_id = ?", gameID); err != nil {
+		return err
+	}
+	for _, cell := range template.Cells {
+		for _, effect := range cell.Effects {
+			var targetCellID interface{}
+			if effect.Type == "teleport" {
+				targetCellID = ids[effect.TargetCellID]
+			}
+			if err := insertTemplateEffect(tx, gameID, ids[cell.CellID], effect, targetCellID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Turn a game's board into a template
+func exportBoardTemplate(gameID int) (*BoardTemplate, error) {
+	template := &BoardTemplate{Cells: []TemplateCell{}}
+	err := db.QueryRow("SELECT name, rows, columns, COALESCE(topology, 'square') FROM games WHERE id = ?", gameID).
+		Scan(&template.Name, &template.Rows, &template.Columns, &template.Topology)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT id, cell_id, COALESCE(name, ''), COALESCE(description, ''), COALESCE(background, ''), active, COALESCE(element, ''),
+		COALESCE(base_reward_coins, 0), COALESCE(base_reward_xp, 0), COALESCE(reward_coins, 0), COALESCE(reward_xp, 0), COALESCE(regen_rounds, 0)
+		FROM game_cells WHERE game_id = ? ORDER BY id`, gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cellIDs := make(map[int]string)
+	index := make(map[int]int)
+	for rows.Next() {
+		var id, active, baseCoins, baseXP int
+		var cell TemplateCell
+		if err := rows.Scan(&id, &cell.CellID, &cell.Name, &cell.Description, &cell.Background, &active, &cell.Element,
+			&baseCoins, &baseXP, &cell.RewardCoins, &cell.RewardXP, &cell.RegenRounds); err != nil {
+			return nil, err
+		}
+		cell.Active = active == 1
+		// A template holds the board as it starts: rewards already collected come back
+		if baseCoins > 0 || baseXP > 0 {
+			cell.RewardCoins, cell.RewardXP = baseCoins, baseXP
+		}
+		cellIDs[id] = cell.CellID
+		index[id] = len(template.Cells)
+		template.Cells = append(template.Cells, cell)
+	}
+
+	effects, err := loadCellEffects(db, gameID, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, effect := range effects {
+		i, ok := index[effect.CellID]
+		if !ok {
+			continue
+		}
+		// A template holds the board as it starts: charges already used come back
+		charges := effect.InitialCharges
+		exported := TemplateEffect{
+			Type:      effect.Type,
+			Trigger:   effect.Trigger,
+			Amount:    effect.Amount,
+			Duration:  effect.Duration,
+			Element:   effect.Element,
+			Charges:   &charges,
+			Cooldown:  effect.Cooldown,
+			ShopItems: effect.ShopItems,
+		}
+		if effect.TargetCellID != nil {
+			exported.TargetCellID = cellIDs[*effect.TargetCellID]
+		}
+		template.Cells[i].Effects = append(template.Cells[i].Effects, exported)
+	}
+
+	return template, nil
+}
+
+// Lay out a board from a seed: element kingdoms grown around capitals, roads joining the capitals and rewards
+// scattered over the land. Kingdoms and roads follow the board's topology. The same seed, size and topology
+// always give the same board.
+func generateBoard(topology string, rows, columns int, seed int64) *BoardTemplate {
+	rng := rand.New(rand.NewSource(seed))
+	area := rows * columns
+
+	// Roughly one kingdom per 25 cells, at least two when the board is big enough
+	kingdoms := area / 25
+	if kingdoms < 2 {
+		kingdoms = 2
+	}
+	if kingdoms > len(kingdomElements) {
+		kingdoms = len(kingdomElements)
+	}
+	if kingdoms > area {
+		kingdoms = area
+	}
+
+	elements := append([]string(nil), kingdomElements...)
+	rng.Shuffle(len(elements), func(i, j int) { elements[i], elements[j] = elements[j], elements[i] })
+
+	positions := rng.Perm(area)[:kingdoms]
+	capitals := make([][2]int, kingdoms)
+	for i, position := range positions {
+		capitals[i] = [2]int{position / columns, position % columns}
+	}
+
+	// Every cell belongs to the kingdom of its nearest capital, the kingdoms growing out one step at a time
+	owner := make([][]int, rows)
+	for row := range owner {
+		owner[row] = make([]int, columns)
+		for col := range owner[row] {
+			owner[row][col] = -1
+		}
+	}
+	queue := make([][2]int, 0, area)
+	for i, capital := range capitals {
+		owner[capital[0]][capital[1]] = i
+		queue = append(queue, capital)
+	}
+	for len(queue) > 0 {
+		cell := queue[0]
+		queue = queue[1:]
+		for _, next := range boardNeighbors(topology, rows, columns, cell[0], cell[1]) {
+			if owner[next[0]][next[1]] == -1 {
+				owner[next[0]][next[1]] = owner[cell[0]][cell[1]]
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	// Roads from each capital to the next, each step a random one of those that get closer
+	road := make(map[[2]int]bool)
+	for i := 1; i < kingdoms; i++ {
+		cell, to := capitals[i-1], capitals[i]
+		for cell != to {
+			var closer [][2]int
+			for _, next := range boardNeighbors(topology, rows, columns, cell[0], cell[1]) {
+				if boardDistance(topology, next, to) < boardDistance(topology, cell, to) {
+					closer = append(closer, next)
+				}
+			}
+			cell = closer[rng.Intn(len(closer))]
+			road[cell] = true
+		}
+	}
+
+	isCapital := make(map[[2]int]int)
+	for i, capital := range capitals {
+		isCapital[capital] = i + 1
+	}
+
+	template := &BoardTemplate{Rows: rows, Columns: columns, Topology: topology, Seed: &seed, Cells: []TemplateCell{}}
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			coord := [2]int{row, col}
+			element := elements[owner[row][col]]
+			kingdom := strings.ToUpper(element[:1]) + element[1:]
+			cell := TemplateCell{
+				CellID:     formatCellID(row, col),
+				Name:       formatCellID(row, col),
+				Background: kingdomColors[element],
+				Active:     true,
+				Element:    kingdom,
+			}
+
+			if i := isCapital[coord]; i > 0 {
+				// Capitals are safe havens: they heal and pay well, now and then
+				cell.Name = kingdom + " Kingdom"
+				cell.Description = fmt.Sprintf("Capital of the %s kingdom", kingdom)
+				cell.RewardCoins = 25
+				cell.RewardXP = 50
+				cell.RegenRounds = 3
+				cell.Effects = []TemplateEffect{{Type: "heal", Trigger: "turn_start", Amount: 20}}
+			} else if road[coord] {
+				// Roads have no element, so everyone crosses them at the base cost
+				cell.Name = "Road"
+				cell.Background = roadColor
+				cell.Element = ""
+			} else if roll := rng.Intn(100); roll < 8 {
+				cell.RewardCoins = 5 * (1 + rng.Intn(5))
+			} else if roll < 14 {
+				cell.RewardXP = 10 * (1 + rng.Intn(5))
+			}
+
+			template.Cells = append(template.Cells, cell)
+		}
+	}
+
+	return template
+}
+
+// Load a saved template
+func loadBoardTemplate(templateID int) (*BoardTemplate, error) {
+	template := &BoardTemplate{}
+	var cellsJSON string
+	var seed sql.NullInt64
+	err := db.QueryRow(`SELECT id, name, COALESCE(description, ''), rows, columns, COALESCE(topology, 'square'), cells, seed, created_at
+		FROM board_templates WHERE id = ?`, templateID).
+		Scan(&template.ID, &template.Name, &template.Description, &template.Rows, &template.Columns, &template.Topology, &cellsJSON, &seed,
+			&template.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if seed.Valid {
+		template.Seed = &seed.Int64
+	}
+	if err := json.Unmarshal([]byte(cellsJSON), &template.Cells); err != nil {
+		return nil, err
+	}
+	return template, nil
+}
+
+// Save a template; returns its ID
+func saveBoardTemplate(template *BoardTemplate, userID int) (int64, error) {
+	cellsJSON, err := json.Marshal(template.Cells)
+	if err != nil {
+		return 0, err
+	}
+	topology := template.Topology
+	if topology == "" {
+		topology = "square"
+	}
+	result, err := db.Exec(`INSERT INTO board_templates (name, description, rows, columns, topology, cells, seed, created_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		template.Name, template.Description, template.Rows, template.Columns, topology, string(cellsJSON), template.Seed, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// List saved board templates (without their cells)
+func getBoardTemplates(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	rows, err := db.Query(`SELECT id, name, COALESCE(description, ''), rows, columns, COALESCE(topology, 'square'), seed, created_at
+		FROM board_templates ORDER BY created_at DESC`)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	templates := []BoardTemplate{}
+	for rows.Next() {
+		var template BoardTemplate
+		var seed sql.NullInt64
+		if err := rows.Scan(&template.ID, &template.Name, &template.Description, &template.Rows, &template.Columns, &template.Topology, &seed, &template.CreatedAt); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if seed.Valid {
+			template.Seed = &seed.Int64
+		}
+		templates = append(templates, template)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(templates)
+}
+
+// Get a saved board template with its cells
+func getBoardTemplate(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	vars := mux.Vars(r)
+	templateID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid template ID", http.StatusBadRequest)
+		return
+	}
+
+	template, err := loadBoardTemplate(templateID)
+	if err != nil {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(template)
+}
+
+// Import a board template from JSON (e.g. one exported from another game)
+func createBoardTemplate(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	var template BoardTemplate
+	if err := json.NewDecoder(r.Body).Decode(&template); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if template.Name == "" {
+		http.Error(w, "Template name is required", http.StatusBadRequest)
+		return
+	}
+	if err := validateBoardTemplate(&template); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	templateID, err := saveBoardTemplate(&template, claims.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"templateId": templateID,
+	})
+}
+
+// Delete a board template
+func deleteBoardTemplate(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	vars := mux.Vars(r)
+	templateID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid template ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := db.Exec("DELETE FROM board_templates WHERE id = ?", templateID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// Generate a board from a seed (a random one if none is given). Returns the template, and saves it when asked.
+func generateBoardTemplate(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		Name     string `json:"name"`
+		Rows     int    `json:"rows"`
+		Columns  int    `json:"columns"`
+		Topology string `json:"topology"` // "square" (default) or "hex"
+		Seed     *int64 `json:"seed"`
+		Save     bool   `json:"save"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if req.Rows < 1 || req.Rows > 100 || req.Columns < 1 || req.Columns > 100 {
+		http.Error(w, "Rows and columns must be between 1 and 100", http.StatusBadRequest)
+		return
+	}
+	if req.Topology == "" {
+		req.Topology = "square"
+	}
+	if _, ok := boardDirections[req.Topology]; !ok {
+		http.Error(w, "Topology must be square or hex", http.StatusBadRequest)
+		return
+	}
+
+	seed := time.Now().UnixNano()
+	if req.Seed != nil {
+		seed = *req.Seed
+	}
+
+	template := generateBoard(req.Topology, req.Rows, req.Columns, seed)
+	template.Name = req.Name
+	if template.Name == "" {
+		template.Name = fmt.Sprintf("Kingdoms %dx%d #%d", req.Rows, req.Columns, seed)
+	}
+
+	if req.Save {
+		templateID, err := saveBoardTemplate(template, claims.UserID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		template.ID = int(templateID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(template)
+}
+
+// Export a game's board as a template
+func exportGameBoard(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	vars := mux.Vars(r)
+	gameID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid game ID", http.StatusBadRequest)
+		return
+	}
+
+	template, err := exportBoardTemplate(gameID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Game not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"board-%d.json\"", gameID))
+	json.NewEncoder(w).Encode(template)
+}
+
+// Replace a game's board with a template of the same size ({"templateId": N} or {"template": {...}}).
+// Only while no warriors are on the board.
+func importGameBoard(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	vars := mux.Vars(r)
+	gameID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid game ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		TemplateID int            `json:"templateId"`
+		Template   *BoardTemplate `json:"template"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	template := req.Template
+	if template == nil {
+		template, err = loadBoardTemplate(req.TemplateID)
+		if err != nil {
+			http.Error(w, "Template not found", http.StatusNotFound)
+			return
+		}
+	}
+	if err := validateBoardTemplate(template); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var rows, columns int
+	var topology string
+	err = db.QueryRow("SELECT rows, columns, COALESCE(topology, 'square') FROM games WHERE id = ?", gameID).Scan(&rows, &columns, &topology)
+	if err != nil {
+		http.Error(w, "Game not found", http.StatusNotFound)
+		return
+	}
+	if rows != template.Rows || columns != template.Columns {
+		http.Error(w, fmt.Sprintf("The template is %dx%d but the board is %dx%d", template.Rows, template.Columns, rows, columns), http.StatusBadRequest)
+		return
+	}
+	if template.Topology != "" && template.Topology != topology {
+		http.Error(w, fmt.Sprintf("The template is for a %s board but this board is %s", template.Topology, topology), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var occupied int
+	tx.QueryRow("SELECT COUNT(*) FROM game_cells WHERE game_id = ? AND COALESCE(occupied_by, 0) != 0", gameID).Scan(&occupied)
+	if occupied > 0 {
+		http.Error(w, "Clear the warriors off the board before importing a new one", http.StatusConflict)
+		return
+	}
+
+	if err := applyBoardTemplate(tx, gameID, template); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Clients reload the whole board
+	if state, err := loadGameState(gameID); err == nil {
+		broadcastGameEvent(gameID, "board_replaced", map[string]interface{}{"cells": state["cells"]})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// Generate a new game's grid cells (chess-like notation: A1, A2, B1, B2, etc.)
+// For rows > 26, use AA, AB, AC... (like Excel columns)
+// Cells cut off by the shape or mask are created inactive so they can be switched on later
+func createBoardCells(tx *sql.Tx, gameID, rows, columns int, inactive map[[2]int]bool) error {
+	cellStmt, err := tx.Prepare(`INSERT INTO game_cells (game_id, cell_id, name, background, active, occupied_by)
+		VALUES (?, ?, ?, ?, ?, 0)`)
+	if err != nil {
+		return err
+	}
+	defer cellStmt.Close()
+
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			cellID := formatCellID(row, col)
+			_, err := cellStmt.Exec(gameID, cellID, cellID, "#3a3a3a", !inactive[[2]int{row, col}]) // Default charcoal background
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Get all games; archived ones only with ?archived=true (and then only those)
+func getGames(w http.ResponseWriter, r *http.Request) {
+	archived := r.URL.Query().Get("archived") == "true"
+	rows, err := db.Query("SELECT id, name, thumbnail, rows, columns, created_at FROM games WHERE COALESCE(archived, 0) = ? ORDER BY created_at DESC", archived)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var games []Game
+	for rows.Next() {
+		var game Game
+		if err := rows.Scan(&game.ID, &game.Name, &game.Thumbnail, &game.Rows, &game.Columns, &game.CreatedAt); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		game.Archived = archived
+		games = append(games, game)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(games)
+}
+
+// Get a specific game with all its cells
+func getGame(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	gameID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid game ID", http.StatusBadRequest)
+		return
+	}
+
+	// Signing in is optional; on a fogged board it decides what the caller can see
+	userID := 0
+	if claims, err := getUserFromToken(r); err == nil {
+		userID = claims.UserID
+	}
+
+	state, err := loadGameView(gameID, gameViewer(gameID, userID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Game not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+// Load a game with its avatars, cells and linked battle (the full view; see loadGameView)
+func loadGameState(gameID int) (map[string]interface{}, error) {
+	var game Game
+	var turnStartTime sql.NullTime
+	var battleID, winnerAvatarID sql.NullInt64
+	var prizesJSON string
+	err := db.QueryRow(`SELECT id, name, thumbnail, rows, columns, current_turn_index, turn_start_time, turn_duration, battle_id,
+		COALESCE(actions_per_turn, 1), COALESCE(actions_taken, 0), COALESCE(status, 'active'), COALESCE(turn_number, 0),
+		COALESCE(round, 0), COALESCE(win_condition, 'none'), COALESCE(win_target, 0), COALESCE(prizes, ''), winner_avatar_id,
+		COALESCE(keep_xp, 1), COALESCE(permadeath, 0), COALESCE(fog_of_war, 0), COALESCE(vision_radius, 2), COALESCE(topology, 'square'),
+		COALESCE(battle_format, 'single'), COALESCE(auto_resolve_battles, 0), COALESCE(archived, 0), created_at
+		FROM games WHERE id = ?`, gameID).
+		Scan(&game.ID, &game.Name, &game.Thumbnail, &game.Rows, &game.Columns, &game.CurrentTurnIndex, &turnStartTime, &game.TurnDuration, &battleID,
+			&game.ActionsPerTurn, &game.ActionsTaken, &game.Status, &game.TurnNumber,
+			&game.Round, &game.WinCondition, &game.WinTarget, &prizesJSON, &winnerAvatarID,
+			&game.KeepXP, &game.Permadeath, &game.FogOfWar, &game.VisionRadius, &game.Topology, &game.BattleFormat, &game.AutoResolveBattles, &game.Archived, &game.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	game.Prizes = []GamePrize{}
+	if prizesJSON != "" {
+		json.Unmarshal([]byte(prizesJSON), &game.Prizes)
+	}
+	if winnerAvatarID.Valid {
+		winner := int(winnerAvatarID.Int64)
+		game.WinnerAvatarID = &winner
+	}
+
+	if turnStartTime.Valid {
+		game.TurnStartTime = &turnStartTime.Time
+		// Format as ISO 8601 without timezone (SQLite stores in UTC)
+		isoTime := turnStartTime.Time.UTC().Format("2006-01-02T15:04:05.000")
+		game.TurnStartTimeISO = &isoTime
+	}
+
+	if battleID.Valid {
+		battleIDInt := int(battleID.Int64)
+		game.BattleID = &battleIDInt
+	}
+
+	// Get avatar IDs in turn order
+	avatarRows, err := db.Query(`SELECT avatar_id, COALESCE(absent, 0), COALESCE(autopilot, 0) FROM game_avatars WHERE game_id = ? ORDER BY turn_order`, gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer avatarRows.Close()
+
+	var avatars []int
+	for avatarRows.Next() {
+		var avatarID, absent, autopilot int
+		if err := avatarRows.Scan(&avatarID, &absent, &autopilot); err != nil {
+			return nil, err
+		}
+		avatars = append(avatars, avatarID)
+		if absent == 1 {
+			game.AbsentAvatars = append(game.AbsentAvatars, avatarID)
+		}
+		if autopilot == 1 {
+			game.AutopilotAvatars = append(game.AutopilotAvatars, avatarID)
+		}
+	}
+	game.Avatars = avatars
+
+	// Get all cells for this game
+	rows, err := db.Query(`SELECT id, game_id, cell_id, name, description, background, active, element, occupied_by, status, reward_coins, reward_xp,
+		COALESCE(regen_rounds, 0)
+		FROM game_cells WHERE game_id = ? ORDER BY cell_id`, gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cells []GameCell
+	for rows.Next() {
+		var cell GameCell
+		var active int
+		var name, description, background, element, status sql.NullString
+		var occupiedBy, rewardCoins, rewardXP sql.NullInt64
+		if err := rows.Scan(&cell.ID, &cell.GameID, &cell.CellID, &name, &description, &background,
+			&active, &element, &occupiedBy, &status, &rewardCoins, &rewardXP, &cell.RegenRounds); err != nil {
+			return nil, err
+		}
+		cell.Active = active == 1
+		if occupiedBy.Valid {
+			cell.OccupiedBy = int(occupiedBy.Int64)
+		}
+		if name.Valid {
+			cell.Name = name.String
+		}
+		if description.Valid {
+			cell.Description = description.String
+		}
+		if background.Valid {
+			cell.Background = background.String
+		}
+		if element.Valid {
+			cell.Element = element.String
+		}
+		if status.Valid {
+			cell.Status = status.String
+		}
+		if rewardCoins.Valid {
+			cell.RewardCoins = int(rewardCoins.Int64)
+		}
+		if rewardXP.Valid {
+			cell.RewardXP = int(rewardXP.Int64)
+		}
+		cells = append(cells, cell)
+	}
+
+	// Get battle details if game has a battle linked
+	var battleResponse map[string]interface{}
+	if game.BattleID != nil {
+		var b Battle
+		err = db.QueryRow(`SELECT id, name, reward, winner, date, status, attacker, defender, attacker_avatar_id, defender_avatar_id,
+			COALESCE(format, 'single'), COALESCE(auto_resolve, 0) FROM battles WHERE id = ?`, *game.BattleID).
+			Scan(&b.ID, &b.Name, &b.Reward, &b.Winner, &b.Date, &b.Status, &b.Attacker, &b.Defender, &b.AttackerAvatarID, &b.DefenderAvatarID, &b.Format, &b.AutoResolve)
+		if err == nil {
+			battleResponse = map[string]interface{}{
+				"id":               b.ID,
+				"name":             b.Name,
+				"reward":           b.Reward,
+				"winner":           b.Winner,
+				"date":             b.Date,
+				"status":           b.Status,
+				"attacker":         b.Attacker,
+				"defender":         b.Defender,
+				"attackerAvatarId": b.AttackerAvatarID,
+				"defenderAvatarId": b.DefenderAvatarID,
+				"format":           b.Format,
+				"autoResolve":      b.AutoResolve,
+			}
+
+			// Get each side's question for the current round
+			if b.AttackerAvatarID != nil {
+				if q, err := loadCurrentBattleQuestion(db, b.ID, *b.AttackerAvatarID, true); err == nil {
+					battleResponse["attackerQuestion"] = *q
+				}
+			}
+			if b.DefenderAvatarID != nil {
+				if q, err := loadCurrentBattleQuestion(db, b.ID, *b.DefenderAvatarID, true); err == nil {
+					battleResponse["defenderQuestion"] = *q
+				}
+			}
+
+			if rounds, err := loadBattleRounds(db, b.ID); err == nil {
+				battleResponse["rounds"] = rounds
+			}
+		}
+	}
+
+	cellEffects, err := loadCellEffects(db, gameID, 0)
+	if err != nil {
+		return nil, err
+	}
+	warriorEffects, err := loadWarriorEffects(db, gameID)
+	if err != nil {
+		return nil, err
+	}
+	warriors, err := loadGameWarriors(db, gameID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"game":           game,
+		"cells":          cells,
+		"battle":         battleResponse,
+		"cellEffects":    cellEffects,
+		"warriorEffects": warriorEffects,
+		"warriors":       warriors,
+	}, nil
+}
+
+// Who is looking at a game: 0 for the full view (admins), the avatar ID for a player, or -1 for anyone
+// else, signed in or not, who sees no warriors at all on a fogged board.
+// Spectator screens are spectatorViewer.
+func gameViewer(gameID, userID int) int {
+	if userID == 0 {
+		return -1
+	}
+
+	var role string
+	db.QueryRow("SELECT role FROM users WHERE id = ?", userID).Scan(&role)
+	if role == "admin" {
+		return 0
+	}
+
+	var avatarID int
+	err := db.QueryRow(`SELECT ga.avatar_id FROM game_avatars ga JOIN avatars av ON av.id = ga.avatar_id
+		WHERE ga.game_id = ? AND av.user_id = ?`, gameID, userID).Scan(&avatarID)
+	if err != nil {
+		return -1
+	}
+	return avatarID
+}
+
+// Viewer of spectator screens: the whole board like the teacher, but never the battle's answers
+const spectatorViewer = -2
+
+// Whether a viewer's view of a fogged board is cut down to what it can see
+func viewerInFog(viewer int) bool {
+	return viewer != 0 && viewer != spectatorViewer
+}
+
+// Whether a game hides enemy warriors, and how far warriors see
+func gameFog(gameID int) (bool, int) {
+	var fog bool
+	var radius int
+	db.QueryRow("SELECT COALESCE(fog_of_war, 0), COALESCE(vision_radius, 2) FROM games WHERE id = ?", gameID).Scan(&fog, &radius)
+	return fog, radius
+}
+
+// fogBoard is what fog of war needs to know about a board: its shape and whose warriors stand where
+type fogBoard struct {
+	rows, columns int
+	topology      string
+	cells         map[[2]int]int // Coordinates -> game_cells ID
+	owners        map[[2]int]int // Coordinates -> avatar whose warrior stands there
+}
+
+// Load a game's board for working out what its players can see
+func loadFogBoard(q dbQuerier, gameID int) (*fogBoard, error) {
+	board := &fogBoard{cells: make(map[[2]int]int), owners: make(map[[2]int]int)}
+	err := q.QueryRow("SELECT rows, columns, COALESCE(topology, 'square') FROM games WHERE id = ?", gameID).Scan(&board.rows, &board.columns, &board.topology)
+	if err != nil {
+		return nil, err
+	}
+
+	cellRows, err := q.Query(`SELECT gc.id, gc.cell_id, COALESCE(a.avatar_id, 0)
+		FROM game_cells gc
+		LEFT JOIN assets a ON a.id = gc.occupied_by
+		WHERE gc.game_id = ?`, gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer cellRows.Close()
+
+	for cellRows.Next() {
+		var id, ownerID int
+		var cellID string
+		if err := cellRows.Scan(&id, &cellID, &ownerID); err != nil {
+			return nil, err
+		}
+		row, col, ok := parseCellID(cellID)
+		if !ok {
+			continue
+		}
+		board.cells[[2]int{row, col}] = id
+		if ownerID != 0 {
+			board.owners[[2]int{row, col}] = ownerID
+		}
+	}
+	return board, cellRows.Err()
+}
+
+// Cells an avatar can see: everything within the vision radius (in steps across the board) of its warriors
+func (b *fogBoard) visibleCells(avatarID, radius int) map[int]bool {
+	visible := make(map[int]bool)
+	if avatarID <= 0 {
+		return visible
+	}
+
+	distance := make(map[[2]int]int)
+	var queue [][2]int
+	for coord, ownerID := range b.owners {
+		if ownerID == avatarID {
+			distance[coord] = 0
+			queue = append(queue, coord)
+		}
+	}
+
+	// Breadth-first out from every own warrior
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if id, ok := b.cells[current]; ok {
+			visible[id] = true
+		}
+		if distance[current] == radius {
+			continue
+		}
+		for _, next := range boardNeighbors(b.topology, b.rows, b.columns, current[0], current[1]) {
+			if _, seen := distance[next]; !seen {
+				distance[next] = distance[current] + 1
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	return visible
+}
+
+// Cells an avatar can see on a game's board (see fogBoard.visibleCells)
+func visibleCells(gameID, avatarID, radius int) (map[int]bool, error) {
+	if avatarID <= 0 {
+		return make(map[int]bool), nil
+	}
+	board, err := loadFogBoard(db, gameID)
+	if err != nil {
+		return nil, err
+	}
+	return board.visibleCells(avatarID, radius), nil
+}
+
+// Load a game as a viewer sees it. On a fogged board players only see who stands on the cells their warriors can
+// see (plus their own warriors) and get the list of those cells; the full view is returned unchanged.
+func loadGameView(gameID, viewerAvatarID int) (map[string]interface{}, error) {
+	state, err := loadGameState(gameID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the teacher sees the battle's answers before their round is logged
+	if viewerAvatarID != 0 {
+		hideBattleAnswers(state, viewerAvatarID)
+	}
+
+	fog, radius := gameFog(gameID)
+	if !fog || !viewerInFog(viewerAvatarID) {
+		return state, nil
+	}
+
+	visible, err := visibleCells(gameID, viewerAvatarID, radius)
+	if err != nil {
+		return nil, err
+	}
+	return fogGameView(state, viewerAvatarID, visible), nil
+}
+
+// A copy of a game state with only what a player sees through the fog. The state itself is left as it is,
+// so one loaded state can be shared by several viewers.
+func fogGameView(state map[string]interface{}, viewerAvatarID int, visible map[int]bool) map[string]interface{} {
+	view := make(map[string]interface{}, len(state)+1)
+	for key, value := range state {
+		view[key] = value
+	}
+
+	allCells, _ := state["cells"].([]GameCell)
+	cells := make([]GameCell, len(allCells))
+	copy(cells, allCells)
+	visibleIDs := []int{}
+	for i := range cells {
+		if visible[cells[i].ID] {
+			visibleIDs = append(visibleIDs, cells[i].ID)
+			continue
+		}
+		if cells[i].OccupiedBy != 0 {
+			cells[i].OccupiedBy = 0
+			cells[i].Status = ""
+		}
+	}
+
+	seen := make(map[int]bool)
+	warriors, _ := state["warriors"].([]GameWarrior)
+	shown := []GameWarrior{}
+	for _, warrior := range warriors {
+		if warrior.AvatarID == viewerAvatarID || (warrior.CellID != nil && visible[*warrior.CellID]) {
+			shown = append(shown, warrior)
+			seen[warrior.WarriorID] = true
+		}
+	}
+
+	warriorEffects, _ := state["warriorEffects"].([]WarriorEffect)
+	shownEffects := []WarriorEffect{}
+	for _, effect := range warriorEffects {
+		if seen[effect.WarriorID] {
+			shownEffects = append(shownEffects, effect)
+		}
+	}
+
+	view["cells"] = cells
+	view["warriors"] = shown
+	view["warriorEffects"] = shownEffects
+	view["visibleCells"] = visibleIDs
+	return view
+}
+
+// A read-only link to watch a game, e.g. on the classroom projector
+type SpectatorToken struct {
+	ID         int        `json:"id"`
+	GameID     int        `json:"gameId"`
+	Token      string     `json:"token"`
+	Label      string     `json:"label"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+// How often spectator screens without a WebSocket should poll, in seconds
+const spectatorPollInterval = 2
+
+// Blank the answers a viewer may not see out of a game state's battle. Signed-out viewers and spectator screens
+// never see the correct answers or the players' answers; anyone else sees those of a question once its round is
+// logged, and their own answer before that. The battle is replaced by a copy, so the state itself is left as it is.
+func hideBattleAnswers(state map[string]interface{}, viewerAvatarID int) {
+	original, _ := state["battle"].(map[string]interface{})
+	if original == nil {
+		return
+	}
+	battle := make(map[string]interface{}, len(original))
+	for key, value := range original {
+		battle[key] = value
+	}
+	state["battle"] = battle
+
+	rounds, _ := battle["rounds"].([]BattleRound)
+	for _, key := range []string{"attackerQuestion", "defenderQuestion"} {
+		if question, ok := battle[key].(BattleQuestion); ok {
+			if viewerAvatarID < 0 || !battleQuestionLogged(rounds, question.ID) {
+				hideBattleQuestionAnswers(&question, viewerAvatarID)
+			}
+			battle[key] = question
+		}
+	}
+	if viewerAvatarID < 0 && rounds != nil {
+		hidden := make([]BattleRound, len(rounds))
+		for i, round := range rounds {
+			round.AttackerAnswer, round.DefenderAnswer = nil, nil
+			hidden[i] = round
+		}
+		battle["rounds"] = hidden
+	}
+}
+
+// Whether a battle question was answered in one of the logged rounds
+func battleQuestionLogged(rounds []BattleRound, questionID int) bool {
+	for _, round := range rounds {
+		if (round.AttackerQuestionID != nil && *round.AttackerQuestionID == questionID) ||
+			(round.DefenderQuestionID != nil && *round.DefenderQuestionID == questionID) {
+			return true
+		}
+	}
+	return false
+}
+
+// Blank a battle question's correct answer, and the answer given unless it's the viewer's own
+func hideBattleQuestionAnswers(question *BattleQuestion, viewerAvatarID int) {
+	question.Answer = ""
+	if question.UserID == nil || *question.UserID != viewerAvatarID {
+		question.UserAnswer = nil
+	}
+}
+
+// The game a spectator token watches, if the token is still valid. Returns the game and token IDs.
+func spectatorGame(token string) (int, int, error) {
+	var gameID, tokenID int
+	err := db.QueryRow("SELECT game_id, id FROM spectator_tokens WHERE token = ? AND revoked_at IS NULL", token).Scan(&gameID, &tokenID)
+	if err != nil {
+		return 0, 0, err
+	}
+	db.Exec("UPDATE spectator_tokens SET last_used_at = datetime('now') WHERE id = ?", tokenID)
+	return gameID, tokenID, nil
+}
+
+// Create a spectator token for a game (admin only). Body (optional): {"label": "Projector room 4"}
+func createSpectatorToken(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	vars := mux.Vars(r)
+	gameID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid game ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Label string `json:"label"`
+	}
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var exists int
+	if db.QueryRow("SELECT 1 FROM games WHERE id = ?", gameID).Scan(&exists) != nil {
+		http.Error(w, "Game not found", http.StatusNotFound)
+		return
+	}
+
+	// The token is the only thing guarding the link, so it comes from crypto/rand
+	random := make([]byte, 24)
+	if _, err := crand.Read(random); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	token := hex.EncodeToString(random)
+
+	result, err := db.Exec("INSERT INTO spectator_tokens (game_id, token, label, created_by) VALUES (?, ?, ?, ?)",
+		gameID, token, req.Label, claims.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tokenID, _ := result.LastInsertId()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"id":        tokenID,
+		"token":     token,
+		"url":       "/api/spectate/" + token,
+		"websocket": "/api/spectate/" + token + "/ws",
+	})
+}
+
+// List a game's spectator tokens, revoked ones included (admin only)
+func getSpectatorTokens(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	vars := mux.Vars(r)
+	gameID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid game ID", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query(`SELECT id, game_id, token, COALESCE(label, ''), created_at, last_used_at, revoked_at
+		FROM spectator_tokens WHERE game_id = ? ORDER BY id`, gameID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	tokens := []SpectatorToken{}
+	for rows.Next() {
+		var token SpectatorToken
+		var lastUsedAt, revokedAt sql.NullTime
+		if err := rows.Scan(&token.ID, &token.GameID, &token.Token, &token.Label, &token.CreatedAt, &lastUsedAt, &revokedAt); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if lastUsedAt.Valid {
+			token.LastUsedAt = &lastUsedAt.Time
+		}
+		if revokedAt.Valid {
+			token.RevokedAt = &revokedAt.Time
+		}
+		tokens = append(tokens, token)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// Revoke a spectator token; screens watching with it are disconnected (admin only)
+func revokeSpectatorToken(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	vars := mux.Vars(r)
+	tokenID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := db.Exec("UPDATE spectator_tokens SET revoked_at = datetime('now') WHERE id = ? AND revoked_at IS NULL", tokenID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		http.Error(w, "Token not found or already revoked", http.StatusNotFound)
+		return
+	}
+
+	hub.closeSpectators(tokenID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// What a spectator screen shows: the whole board, fog or not, but not the battle's answers, plus the server clock
+// for the turn timer. Screens that can't keep a WebSocket open poll this.
+func spectateGame(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	gameID, _, err := spectatorGame(vars["token"])
+	if err != nil {
+		http.Error(w, "Invalid or revoked spectator link", http.StatusUnauthorized)
+		return
+	}
+
+	state, err := loadGameView(gameID, spectatorViewer)
+	if err != nil {
+		http.Error(w, "Game not found", http.StatusNotFound)
+		return
+	}
+	state["serverTime"] = time.Now().UTC().Format("2006-01-02T15:04:05.000")
+	state["pollInterval"] = spectatorPollInterval
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(state)
+}
+
+// Live updates for a spectator screen, like gameWebSocket but with the spectator's view
+func spectateGameWebSocket(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	gameID, tokenID, err := spectatorGame(vars["token"])
+	if err != nil {
+		http.Error(w, "Invalid or revoked spectator link", http.StatusUnauthorized)
+		return
+	}
+
+	serveGameSocket(w, r, &gameClient{gameID: gameID, viewer: spectatorViewer, spectatorTokenID: tokenID})
+}
+
+// Hide what a player can't see from a live event on a fogged board. Cell rows out of sight lose their occupant;
+// events about cells out of sight are dropped, and a move seen from only one end loses the other end.
+// The player's own events are passed through. Returns nil when the event shouldn't be sent.
+func redactGameEvent(message []byte, viewerAvatarID int, visible map[int]bool) []byte {
+	var event struct {
+		Type   string                 `json:"type"`
+		GameID int                    `json:"gameId"`
+		Data   map[string]interface{} `json:"data"`
+		Time   time.Time              `json:"time"`
+	}
+	if err := json.Unmarshal(message, &event); err != nil || event.Data == nil {
+		return message
+	}
+
+	if avatarID, ok := event.Data["avatarId"].(float64); ok && int(avatarID) == viewerAvatarID {
+		return message
+	}
+
+	if event.Type == "cell_updated" {
+		if id, ok := event.Data["id"].(float64); ok && !visible[int(id)] {
+			if occupiedBy, _ := event.Data["occupiedBy"].(float64); occupiedBy != 0 {
+				event.Data["occupiedBy"] = 0
+				event.Data["status"] = ""
+			}
+		}
+	} else {
+		hasCell, anyVisible := false, false
+		for _, key := range []string{"cellId", "fromCellId", "toCellId"} {
+			id, ok := event.Data[key].(float64)
+			if !ok {
+				continue
+			}
+			hasCell = true
+			if visible[int(id)] {
+				anyVisible = true
+			} else {
+				event.Data[key] = nil
+			}
+		}
+		if hasCell && !anyVisible {
+			return nil
+		}
+	}
+
+	redacted, err := json.Marshal(event)
+	if err != nil {
+		return nil
+	}
+	return redacted
+}
+
+// GameEvent is a typed update pushed to everyone watching a game over its WebSocket
+type GameEvent struct {
+	Type   string      `json:"type"` // "snapshot", "turn_changed", "warrior_placed", "warrior_moved", "reward_claimed", "battle_started", "battle_resolved", "cell_updated", "game_updated"
+	GameID int         `json:"gameId"`
+	Data   interface{} `json:"data,omitempty"`
+	Time   time.Time   `json:"time"`
+}
+
+// gameClient is one open WebSocket connection watching a game
+type gameClient struct {
+	conn   *websocket.Conn
+	send   chan []byte
+	gameID int
+	userID int
+	viewer int // Avatar whose view of a fogged board it gets (0 for the full view)
+
+	spectatorTokenID int // Set on spectator screens so revoking the token can close them
+}
+
+// gameHub keeps the open connections for every game
+type gameHub struct {
+	mu      sync.Mutex
+	clients map[int]map[*gameClient]bool
+}
+
+var hub = &gameHub{clients: make(map[int]map[*gameClient]bool)}
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     checkWebSocketOrigin,
+}
+
+// Only pages served by this server, or by a frontend origin listed in FRONTEND_ORIGIN (comma-separated,
+// e.g. "http://localhost:5173" for the Vite dev server), may open a game socket. Otherwise any site
+// could connect with a student's token. Clients that send no Origin header are not browsers and may connect.
+func checkWebSocketOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	originURL, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(originURL.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range strings.Split(os.Getenv("FRONTEND_ORIGIN"), ",") {
+		if allowed = strings.TrimRight(strings.TrimSpace(allowed), "/"); allowed != "" && strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *gameHub) register(client *gameClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.clients[client.gameID] == nil {
+		h.clients[client.gameID] = make(map[*gameClient]bool)
+	}
+	h.clients[client.gameID][client] = true
+}
+
+func (h *gameHub) unregister(client *gameClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if clients, ok := h.clients[client.gameID]; ok && clients[client] {
+		delete(clients, client)
+		close(client.send)
+		if len(clients) == 0 {
+			delete(h.clients, client.gameID)
+		}
+	}
+}
+
+// Send an event to every connection watching the game. Slow connections are dropped rather than blocking.
+func (h *gameHub) broadcast(gameID int, message []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for client := range h.clients[gameID] {
+		select {
+		case client.send <- message:
+		default:
+			delete(h.clients[gameID], client)
+			close(client.send)
+		}
+	}
+}
+
+// Close the connections opened with a spectator token
+func (h *gameHub) closeSpectators(tokenID int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, clients := range h.clients {
+		for client := range clients {
+			if client.spectatorTokenID == tokenID {
+				client.conn.Close()
+			}
+		}
+	}
+}
+
+// The viewers of the connections watching a game
+func (h *gameHub) viewers(gameID int) []int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var viewers []int
+	for client := range h.clients[gameID] {
+		viewers = append(viewers, client.viewer)
+	}
+	return viewers
+}
+
+// Send every connection watching the game the version of a message meant for its viewer (none if there isn't one)
+func (h *gameHub) broadcastViews(gameID int, views map[int][]byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for client := range h.clients[gameID] {
+		message := views[client.viewer]
+		if message == nil {
+			continue
+		}
+		select {
+		case client.send <- message:
+		default:
+			delete(h.clients[gameID], client)
+			close(client.send)
+		}
+	}
+}
+
+// Events after which players on a fogged board may see different cells, so they get a fresh view
+var fogRefreshEvents = map[string]bool{
+	"warrior_placed":        true,
+	"warrior_moved":         true,
+	"battle_resolved":       true,
+	"cell_effect_triggered": true,
+	"action_undone":         true,
+}
+
+// Push a typed event to everyone watching a game. On a fogged board each player gets its own redacted copy.
+func broadcastGameEvent(gameID int, eventType string, data interface{}) {
+	message, err := json.Marshal(GameEvent{Type: eventType, GameID: gameID, Data: data, Time: time.Now()})
+	if err != nil {
+		log.Printf("Error encoding %s event: %v", eventType, err)
+		return
+	}
+
+	fog, radius := gameFog(gameID)
+	if !fog {
+		hub.broadcast(gameID, message)
+		return
+	}
+
+	// The board (and for refreshes the game state) is loaded once; each viewer's copy is cut from it
+	board, err := loadFogBoard(db, gameID)
+	if err != nil {
+		log.Printf("Error loading board for %s event: %v", eventType, err)
+		return
+	}
+	var state map[string]interface{}
+	if fogRefreshEvents[eventType] {
+		if state, err = loadGameState(gameID); err != nil {
+			log.Printf("Error loading game for %s event: %v", eventType, err)
+		}
+	}
+
+	// The full view and spectator screens get the event as it is
+	views := map[int][]byte{0: message, spectatorViewer: message}
+	snapshots := make(map[int][]byte)
+	for _, viewer := range hub.viewers(gameID) {
+		if _, done := views[viewer]; done {
+			continue
+		}
+		visible := board.visibleCells(viewer, radius)
+		views[viewer] = redactGameEvent(message, viewer, visible)
+
+		if state != nil {
+			view := state
+			if viewer != 0 {
+				view = make(map[string]interface{}, len(state))
+				for key, value := range state {
+					view[key] = value
+				}
+				hideBattleAnswers(view, viewer)
+			}
+			view = fogGameView(view, viewer, visible)
+			snapshots[viewer], _ = json.Marshal(GameEvent{Type: "snapshot", GameID: gameID, Data: view, Time: time.Now()})
+		}
+	}
+
+	hub.broadcastViews(gameID, views)
+	if len(snapshots) > 0 {
+		hub.broadcastViews(gameID, snapshots)
+	}
+}
+
+// Push the updated cell rows to everyone watching the game
+func broadcastCellUpdates(gameID int, cellIDs ...int) {
+	for _, cellID := range cellIDs {
+		var cell GameCell
+		var active int
+		err := db.QueryRow(`SELECT id, game_id, cell_id, COALESCE(name, ''), COALESCE(description, ''), COALESCE(background, ''), active,
+			COALESCE(element, ''), COALESCE(occupied_by, 0), COALESCE(status, ''), COALESCE(reward_coins, 0), COALESCE(reward_xp, 0),
+			COALESCE(regen_rounds, 0)
+			FROM game_cells WHERE id = ?`, cellID).
+			Scan(&cell.ID, &cell.GameID, &cell.CellID, &cell.Name, &cell.Description, &cell.Background, &active,
+				&cell.Element, &cell.OccupiedBy, &cell.Status, &cell.RewardCoins, &cell.RewardXP, &cell.RegenRounds)
+		if err != nil {
+			continue
+		}
+		cell.Active = active == 1
+		broadcastGameEvent(gameID, "cell_updated", cell)
+	}
+}
+
+// Push the current turn to everyone watching the game
+func broadcastTurnChanged(gameID int) {
+	var currentTurnIndex, turnNumber, turnDuration int
+	var turnStartTime sql.NullTime
+	err := db.QueryRow(`SELECT COALESCE(current_turn_index, 0), COALESCE(turn_number, 0), COALESCE(turn_duration, 20), turn_start_time
+		FROM games WHERE id = ?`, gameID).Scan(&currentTurnIndex, &turnNumber, &turnDuration, &turnStartTime)
+	if err != nil {
+		return
+	}
+
+	avatarID, _ := getCurrentTurnAvatarID(db, gameID)
+	data := map[string]interface{}{
+		"currentTurnIndex": currentTurnIndex,
+		"turnNumber":       turnNumber,
+		"turnDuration":     turnDuration,
+		"avatarId":         avatarID,
+	}
+	if turnStartTime.Valid {
+		data["turnStartTime"] = turnStartTime.Time.UTC().Format("2006-01-02T15:04:05.000")
+	}
+	broadcastGameEvent(gameID, "turn_changed", data)
+}
+
+// Open a WebSocket for live game updates: GET /api/games/{id}/ws?token=<jwt>
+// A full snapshot is sent on connect; the client can send {"type":"resync"} to get a new one.
+func gameWebSocket(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromTokenOrQuery(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	gameID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid game ID", http.StatusBadRequest)
+		return
+	}
+
+	serveGameSocket(w, r, &gameClient{gameID: gameID, userID: claims.UserID, viewer: gameViewer(gameID, claims.UserID)})
+}
+
+// Upgrade the request and stream the game to the client: a snapshot of its view first, then live events
+func serveGameSocket(w http.ResponseWriter, r *http.Request, client *gameClient) {
+	gameID, viewer := client.gameID, client.viewer
+	snapshot, err := loadGameView(gameID, viewer)
+	if err != nil {
+		http.Error(w, "Game not found", http.StatusNotFound)
+		return
+	}
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+
+	client.conn = conn
+	client.send = make(chan []byte, 64)
+	hub.register(client)
+
+	sendSnapshot := func(state map[string]interface{}) {
+		message, err := json.Marshal(GameEvent{Type: "snapshot", GameID: gameID, Data: state, Time: time.Now()})
+		if err != nil {
+			return
+		}
+		hub.mu.Lock()
+		defer hub.mu.Unlock()
+		if hub.clients[gameID][client] {
+			select {
+			case client.send <- message:
+			default:
+			}
+		}
+	}
+	sendSnapshot(snapshot)
+
+	go client.writePump()
+	client.readPump(func() {
+		if state, err := loadGameView(gameID, viewer); err == nil {
+			sendSnapshot(state)
+		}
+	})
+}
+
+// Read client messages until the connection closes
+func (c *gameClient) readPump(resync func()) {
+	defer func() {
+		hub.unregister(c)
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(4096)
+	c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		return nil
+	})
+
+	for {
+		var message struct {
+			Type string `json:"type"`
+		}
+		if err := c.conn.ReadJSON(&message); err != nil {
+			return
+		}
+		if message.Type == "resync" {
+			resync()
+		}
+	}
+}
+
+// Write queued events and keep the connection alive with pings
+func (c *gameClient) writePump() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Delete a game (cascades to delete all cells)
+func deleteGame(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	vars := mux.Vars(r)
+	gameID := vars["id"]
+
+	result, err := db.Exec("DELETE FROM games WHERE id = ?", gameID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		http.Error(w, "Game not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// Update a game (name, thumbnail and optionally turn settings)
+func updateGame(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	vars := mux.Vars(r)
+	gameID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid game ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Name               string       `json:"name"`
+		Thumbnail          string       `json:"thumbnail"`
+		TurnDuration       *int         `json:"turnDuration,omitempty"`
+		ActionsPerTurn     *int         `json:"actionsPerTurn,omitempty"`
+		WinCondition       *string      `json:"winCondition,omitempty"`
+		WinTarget          *int         `json:"winTarget,omitempty"`
+		Prizes             *[]GamePrize `json:"prizes,omitempty"`
+		KeepXP             *bool        `json:"keepXp,omitempty"`
+		Permadeath         *bool        `json:"permadeath,omitempty"`
+		FogOfWar           *bool        `json:"fogOfWar,omitempty"`
+		VisionRadius       *int         `json:"visionRadius,omitempty"`
+		BattleFormat       *string      `json:"battleFormat,omitempty"` // Applies to battles started after the change
+		AutoResolveBattles *bool        `json:"autoResolveBattles,omitempty"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if req.TurnDuration != nil && (*req.TurnDuration < 5 || *req.TurnDuration > 3600) {
+		http.Error(w, "Turn duration must be between 5 and 3600 seconds", http.StatusBadRequest)
+		return
+	}
+
+	if req.ActionsPerTurn != nil && (*req.ActionsPerTurn < 1 || *req.ActionsPerTurn > 10) {
+		http.Error(w, "Actions per turn must be between 1 and 10", http.StatusBadRequest)
+		return
+	}
+
+	if req.VisionRadius != nil && (*req.VisionRadius < 1 || *req.VisionRadius > 20) {
+		http.Error(w, "Vision radius must be between 1 and 20", http.StatusBadRequest)
+		return
+	}
+
+	if req.BattleFormat != nil {
+		if _, ok := battleFormats[*req.BattleFormat]; !ok {
+			http.Error(w, "Battle format must be single, best_of_3, best_of_5 or until_ko", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Win condition and target are checked together, falling back to what the game already has
+	if req.WinCondition != nil || req.WinTarget != nil {
+		var winCondition string
+		var winTarget int
+		db.QueryRow("SELECT COALESCE(win_condition, 'none'), COALESCE(win_target, 0) FROM games WHERE id = ?", gameID).Scan(&winCondition, &winTarget)
+		if req.WinCondition != nil {
+			winCondition = *req.WinCondition
+		}
+		if req.WinTarget != nil {
+			winTarget = *req.WinTarget
+		}
+		if err := validateWinCondition(winCondition, winTarget); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var prizesJSON interface{}
+	if req.Prizes != nil {
+		if err := validateGamePrizes(*req.Prizes); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		encoded, _ := json.Marshal(*req.Prizes)
+		prizesJSON = string(encoded)
+	}
+
+	// Update game
+	_, err = db.Exec(`UPDATE games SET name = ?, thumbnail = ?,
+		turn_duration = COALESCE(?, turn_duration), actions_per_turn = COALESCE(?, actions_per_turn),
+		win_condition = COALESCE(?, win_condition), win_target = COALESCE(?, win_target), prizes = COALESCE(?, prizes),
+		keep_xp = COALESCE(?, keep_xp), permadeath = COALESCE(?, permadeath),
+		fog_of_war = COALESCE(?, fog_of_war), vision_radius = COALESCE(?, vision_radius), battle_format = COALESCE(?, battle_format),
+		auto_resolve_battles = COALESCE(?, auto_resolve_battles)
+		WHERE id = ?`,
+		req.Name, req.Thumbnail, req.TurnDuration, req.ActionsPerTurn, req.WinCondition, req.WinTarget, prizesJSON,
+		req.KeepXP, req.Permadeath, req.FogOfWar, req.VisionRadius, req.BattleFormat, req.AutoResolveBattles, gameID)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	broadcastGameEvent(gameID, "game_updated", map[string]interface{}{
+		"name":               req.Name,
+		"thumbnail":          req.Thumbnail,
+		"turnDuration":       req.TurnDuration,
+		"actionsPerTurn":     req.ActionsPerTurn,
+		"winCondition":       req.WinCondition,
+		"winTarget":          req.WinTarget,
+		"prizes":             req.Prizes,
+		"keepXp":             req.KeepXP,
+		"permadeath":         req.Permadeath,
+		"fogOfWar":           req.FogOfWar,
+		"visionRadius":       req.VisionRadius,
+		"battleFormat":       req.BattleFormat,
+		"autoResolveBattles": req.AutoResolveBattles,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// Update a game cell
+func updateGameCell(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	vars := mux.Vars(r)
+	cellID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid cell ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Background  string `json:"background"`
+		Active      bool   `json:"active"`
+		Element     string `json:"element"`
+		OccupiedBy  int    `json:"occupiedBy"`
+		Status      string `json:"status"`
+		RewardCoins int    `json:"rewardCoins"`
+		RewardXP    int    `json:"rewardXp"`
+		RegenRounds int    `json:"regenRounds"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if req.RewardCoins < 0 || req.RewardXP < 0 || req.RegenRounds < 0 {
+		http.Error(w, "Rewards and regeneration rounds can't be negative", http.StatusBadRequest)
+		return
+	}
+
+	// Update cell
+	activeInt := 0
+	if req.Active {
+		activeInt = 1
+	}
+
+	// Get the game_id for this cell
+	var gameID int
+	err = db.QueryRow("SELECT game_id FROM game_cells WHERE id = ?", cellID).Scan(&gameID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	before, err := captureBoard(tx, gameID, []int{cellID}, nil, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Rewards set here are also what the cell refills to. Saving a cell whose rewards were just collected (both 0)
+	// keeps the old refill amounts so editing its name doesn't stop it from regenerating.
+	_, err = tx.Exec(`UPDATE game_cells
+		SET name = ?, description = ?, background = ?, active = ?, element = ?, occupied_by = ?, status = ?, reward_coins = ?, reward_xp = ?,
+			regen_rounds = ?,
+			base_reward_coins = CASE WHEN ? > 0 OR ? > 0 THEN ? ELSE base_reward_coins END,
+			base_reward_xp = CASE WHEN ? > 0 OR ? > 0 THEN ? ELSE base_reward_xp END
+		WHERE id = ?`,
+		req.Name, req.Description, req.Background, activeInt, req.Element, req.OccupiedBy, req.Status, req.RewardCoins, req.RewardXP,
+		req.RegenRounds,
+		req.RewardCoins, req.RewardXP, req.RewardCoins,
+		req.RewardCoins, req.RewardXP, req.RewardXP,
+		cellID)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := syncGameWarriorCells(tx, gameID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	after, err := captureBoard(tx, gameID, []int{cellID}, nil, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := recordGameEvent(tx, gameID, "cell_edited", claims.UserID, 0, before, after); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	broadcastCellUpdates(gameID, cellID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// Which cells of a board a bulk edit applies to. Cells matching any of ids, cellIds, range, row or column are
+// picked (all of them if none is given); element then keeps only the cells of that element.
+type CellSelector struct {
+	IDs     []int    `json:"ids"`     // game_cells IDs
+	CellIDs []string `json:"cellIds"` // Chess-like IDs such as "B3"
+	Range   string   `json:"range"`   // Rectangle between two corners, e.g. "C3:H9"
+	Row     string   `json:"row"`     // Row letter(s), e.g. "C"
+	Column  int      `json:"column"`  // Column number as in the cell IDs, starting at 1
+	Element string   `json:"element"` // Cells of this element ("none" for cells without one)
+}
+
+// What a bulk edit changes; fields left out stay as they are
+type CellPatch struct {
+	Background   *string         `json:"background"`
+	Element      *string         `json:"element"`
+	Active       *bool           `json:"active"`
+	RewardCoins  *int            `json:"rewardCoins"`
+	RewardXP     *int            `json:"rewardXp"`
+	RegenRounds  *int            `json:"regenRounds"`
+	ClearEffects bool            `json:"clearEffects"` // Remove the cells' effects (before adding the new one)
+	Effect       *TemplateEffect `json:"effect"`       // Effect to add to every cell
+}
+
+// Resolve a selector to game_cells IDs, in board order
+func selectGameCells(q dbQuerier, gameID int, selector CellSelector) ([]int, error) {
+	var rows, columns int
+	if err := q.QueryRow("SELECT rows, columns FROM games WHERE id = ?", gameID).Scan(&rows, &columns); err != nil {
+		return nil, err
+	}
+
+	ids := make(map[int]bool)
+	for _, id := range selector.IDs {
+		ids[id] = true
+	}
+	cellIDs := make(map[string]bool)
+	for _, cellID := range selector.CellIDs {
+		if _, _, ok := parseCellID(cellID); !ok {
+			return nil, fmt.Errorf("invalid cell ID %q", cellID)
+		}
+		cellIDs[cellID] = true
+	}
+
+	fromRow, fromCol, toRow, toCol := -1, -1, -1, -1
+	if selector.Range != "" {
+		var ok bool
+		if fromRow, fromCol, toRow, toCol, ok = parseCellRange(selector.Range); !ok {
+			return nil, fmt.Errorf("a range looks like C3:H9")
+		}
+	}
+
+	row := -1
+	if selector.Row != "" {
+		r, _, ok := parseCellID(selector.Row + "1")
+		if !ok {
+			return nil, fmt.Errorf("invalid row %q", selector.Row)
+		}
+		row = r
+	}
+	if selector.Column < 0 || selector.Column > columns {
+		return nil, fmt.Errorf("column must be between 1 and %d", columns)
+	}
+
+	byPosition := len(ids) > 0 || len(cellIDs) > 0 || selector.Range != "" || selector.Row != "" || selector.Column != 0
+	element := normalizeElement(selector.Element)
+
+	cellRows, err := q.Query("SELECT id, cell_id, COALESCE(element, '') FROM game_cells WHERE game_id = ? ORDER BY id", gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer cellRows.Close()
+
+	selected := []int{}
+	for cellRows.Next() {
+		var id int
+		var cellID, cellElement string
+		if err := cellRows.Scan(&id, &cellID, &cellElement); err != nil {
+			return nil, err
+		}
+		r, c, ok := parseCellID(cellID)
+		if !ok {
+			continue
+		}
+
+		if byPosition {
+			inRange := selector.Range != "" && r >= fromRow && r <= toRow && c >= fromCol && c <= toCol
+			if !ids[id] && !cellIDs[cellID] && !inRange && r != row && c+1 != selector.Column {
+				continue
+			}
+		}
+		if selector.Element != "" {
+			if selector.Element == "none" && cellElement != "" {
+				continue
+			}
+			if selector.Element != "none" && normalizeElement(cellElement) != element {
+				continue
+			}
+		}
+		selected = append(selected, id)
+	}
+
+	return selected, nil
+}
+
+// Apply one partial update to many cells of a board at once, e.g. to paint a kingdom.
+// Body: {"selector": {...}, "patch": {...}}. Returns the cells as they are after the edit.
+func patchGameCells(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	vars := mux.Vars(r)
+	gameID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid game ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Selector CellSelector `json:"selector"`
+		Patch    CellPatch    `json:"patch"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	patch := req.Patch
+	if (patch.RewardCoins != nil && *patch.RewardCoins < 0) || (patch.RewardXP != nil && *patch.RewardXP < 0) ||
+		(patch.RegenRounds != nil && *patch.RegenRounds < 0) {
+		http.Error(w, "Rewards and regeneration rounds can't be negative", http.StatusBadRequest)
+		return
+	}
+
+	// The effect is given as in a board template, teleports naming their target by chess-like ID
+	var targetCellID interface{}
+	if patch.Effect != nil {
+		var rows, columns int
+		if err := db.QueryRow("SELECT rows, columns FROM games WHERE id = ?", gameID).Scan(&rows, &columns); err != nil {
+			http.Error(w, "Game not found", http.StatusNotFound)
+			return
+		}
+		if err := validateTemplateEffect(*patch.Effect, rows, columns); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if patch.Effect.Type == "teleport" {
+			var id int
+			db.QueryRow("SELECT id FROM game_cells WHERE game_id = ? AND cell_id = ?", gameID, patch.Effect.TargetCellID).Scan(&id)
+			targetCellID = id
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	cellIDs, err := selectGameCells(tx, gameID, req.Selector)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Game not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	if len(cellIDs) == 0 {
+		http.Error(w, "No cells match the selector", http.StatusBadRequest)
+		return
+	}
+
+	before, err := captureBoard(tx, gameID, cellIDs, nil, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Only the fields sent are written; rewards also become what the cells refill to, as in updateGameCell
+	var sets []string
+	var args []interface{}
+	if patch.Background != nil {
+		sets = append(sets, "background = ?")
+		args = append(args, *patch.Background)
+	}
+	if patch.Element != nil {
+		sets = append(sets, "element = ?")
+		args = append(args, *patch.Element)
+	}
+	if patch.Active != nil {
+		sets = append(sets, "active = ?")
+		args = append(args, *patch.Active)
+	}
+	if patch.RewardCoins != nil {
+		sets = append(sets, "reward_coins = ?", "base_reward_coins = CASE WHEN ? > 0 THEN ? ELSE base_reward_coins END")
+		args = append(args, *patch.RewardCoins, *patch.RewardCoins, *patch.RewardCoins)
+	}
+	if patch.RewardXP != nil {
+		sets = append(sets, "reward_xp = ?", "base_reward_xp = CASE WHEN ? > 0 THEN ? ELSE base_reward_xp END")
+		args = append(args, *patch.RewardXP, *patch.RewardXP, *patch.RewardXP)
+	}
+	if patch.RegenRounds != nil {
+		sets = append(sets, "regen_rounds = ?")
+		args = append(args, *patch.RegenRounds)
+	}
+
+	for _, cellID := range cellIDs {
+		if len(sets) > 0 {
+			_, err := tx.Exec("UPDATE game_cells SET "+strings.Join(sets, ", ")+" WHERE id = ?", append(args, cellID)...)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if patch.ClearEffects {
+			if _, err := tx.Exec("DELETE FROM cell_effects WHERE cell_id = ?", cellID); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if effect := patch.Effect; effect != nil {
+			// A teleport doesn't go onto its own target
+			if effect.Type == "teleport" && targetCellID == cellID {
+				continue
+			}
+			if err := insertTemplateEffect(tx, gameID, cellID, *effect, targetCellID); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	after, err := captureBoard(tx, gameID, cellIDs, nil, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := recordGameEvent(tx, gameID, "cell_edited", claims.UserID, 0, before, after); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	broadcastCellUpdates(gameID, cellIDs...)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"count":   len(after.Cells),
+		"cells":   after.Cells,
+	})
+}
+
+// Something that can run queries: the database or an open transaction
+type dbQuerier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Asset stats that board actions can change
+type AssetSnapshot struct {
+	ID          int    `json:"id"`
+	Status      string `json:"status"`
+	Health      int    `json:"health"`
+	Stamina     int    `json:"stamina"`
+	XP          int    `json:"xp"`
+	Level       int    `json:"level"`
+	BaseAttack  int    `json:"baseAttack"`
+	BaseDefense int    `json:"baseDefense"`
+	BaseHealing int    `json:"baseHealing"`
+}
+
+// The part of a game an action touched, as it was before or after the action
+type BoardSnapshot struct {
+	TurnNumber    int                     `json:"turnNumber"` // actions_taken only belongs to this turn
+	ActionsTaken  int                     `json:"actionsTaken"`
+	BattleID      *int                    `json:"battleId"`
+	Cells         []GameCell              `json:"cells"`
+	ClaimedRounds map[int]*int            `json:"claimedRounds,omitempty"` // Cell ID -> round its rewards were taken (nil while they're there)
+	BaseRewards   map[int][2]int          `json:"baseRewards,omitempty"`   // Cell ID -> coins and XP it refills to
+	CellEffects   map[int][]CellEffect    `json:"cellEffects,omitempty"`   // Cell ID -> its effects, with their charges and cooldowns
+	Assets        []AssetSnapshot         `json:"assets"`
+	Coins         map[int]int             `json:"coins,omitempty"`          // Avatar ID -> coins
+	Collected     map[int]int             `json:"coinsCollected,omitempty"` // Avatar ID -> coins collected in this game
+	Effects       map[int][]WarriorEffect `json:"warriorEffects,omitempty"` // Asset ID -> freezes and buffs on it
+	EffectUses    []CellEffectUse         `json:"effectUses,omitempty"`     // Charges and cooldowns of the cells' effects (older events)
+	Warriors      map[int]*GameWarrior    `json:"gameWarriors,omitempty"`   // Asset ID -> state in this game (nil if not deployed)
+}
+
+// How much of a cell effect has been used up
+type CellEffectUse struct {
+	ID                int  `json:"id"`
+	Charges           int  `json:"charges"`
+	LastTriggeredTurn *int `json:"lastTriggeredTurn,omitempty"`
+}
+
+// One entry of a game's event log
+type GameLogEvent struct {
+	ID            int            `json:"id"`
+	GameID        int            `json:"gameId"`
+	Type          string         `json:"type"`
+	ActorUserID   *int           `json:"actorUserId"`
+	ActorAvatarID *int           `json:"actorAvatarId"`
+	TurnIndex     int            `json:"turnIndex"`
+	TurnNumber    int            `json:"turnNumber"`
+	Before        *BoardSnapshot `json:"before"`
+	After         *BoardSnapshot `json:"after"`
+	Undone        bool           `json:"undone"`
+	UndoOf        *int           `json:"undoOf,omitempty"`
+	CreatedAt     time.Time      `json:"createdAt"`
+}
+
+// Capture the given cells, assets and avatar coins of a game so an action can be logged and undone
+func captureBoard(q dbQuerier, gameID int, cellIDs, assetIDs, avatarIDs []int) (*BoardSnapshot, error) {
+	snapshot := &BoardSnapshot{Cells: []GameCell{}, Assets: []AssetSnapshot{}}
+
+	var battleID sql.NullInt64
+	err := q.QueryRow("SELECT COALESCE(turn_number, 0), COALESCE(actions_taken, 0), battle_id FROM games WHERE id = ?", gameID).
+		Scan(&snapshot.TurnNumber, &snapshot.ActionsTaken, &battleID)
+	if err != nil {
+		return nil, err
+	}
+	if battleID.Valid {
+		id := int(battleID.Int64)
+		snapshot.BattleID = &id
+	}
+
+	seen := make(map[int]bool)
+	for _, cellID := range cellIDs {
+		if seen[cellID] {
+			continue
+		}
+		seen[cellID] = true
+
+		var cell GameCell
+		var active int
+		var claimedRound sql.NullInt64
+		var baseCoins, baseXP int
+		err := q.QueryRow(`SELECT id, game_id, cell_id, COALESCE(name, ''), COALESCE(description, ''), COALESCE(background, ''), active,
+			COALESCE(element, ''), COALESCE(occupied_by, 0), COALESCE(status, ''), COALESCE(reward_coins, 0), COALESCE(reward_xp, 0),
+			COALESCE(regen_rounds, 0), claimed_round, COALESCE(base_reward_coins, 0), COALESCE(base_reward_xp, 0)
+			FROM game_cells WHERE id = ? AND game_id = ?`, cellID, gameID).
+			Scan(&cell.ID, &cell.GameID, &cell.CellID, &cell.Name, &cell.Description, &cell.Background, &active,
+				&cell.Element, &cell.OccupiedBy, &cell.Status, &cell.RewardCoins, &cell.RewardXP, &cell.RegenRounds, &claimedRound,
+				&baseCoins, &baseXP)
+		if err != nil {
+			continue
+		}
+		cell.Active = active == 1
+		snapshot.Cells = append(snapshot.Cells, cell)
+
+		if snapshot.ClaimedRounds == nil {
+			snapshot.ClaimedRounds = make(map[int]*int)
+			snapshot.BaseRewards = make(map[int][2]int)
+			snapshot.CellEffects = make(map[int][]CellEffect)
+		}
+		snapshot.ClaimedRounds[cell.ID] = nil
+		if claimedRound.Valid {
+			round := int(claimedRound.Int64)
+			snapshot.ClaimedRounds[cell.ID] = &round
+		}
+		snapshot.BaseRewards[cell.ID] = [2]int{baseCoins, baseXP}
+
+		effects, err := loadCellEffects(q, gameID, cellID)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.CellEffects[cell.ID] = effects
+	}
+
+	seen = make(map[int]bool)
+	for _, assetID := range assetIDs {
+		if assetID == 0 || seen[assetID] {
+			continue
+		}
+		seen[assetID] = true
+
+		var asset AssetSnapshot
+		err := q.QueryRow(`SELECT id, COALESCE(status, ''), COALESCE(health, 0), COALESCE(stamina, 0), COALESCE(xp, 0), COALESCE(level, 1),
+			COALESCE(base_attack, 0), COALESCE(base_defense, 0), COALESCE(base_healing, 0)
+			FROM assets WHERE id = ?`, assetID).
+			Scan(&asset.ID, &asset.Status, &asset.Health, &asset.Stamina, &asset.XP, &asset.Level, &asset.BaseAttack, &asset.BaseDefense, &asset.BaseHealing)
+		if err != nil {
+			continue
+		}
+		snapshot.Assets = append(snapshot.Assets, asset)
+	}
+
+	if len(snapshot.Assets) > 0 {
+		warriors, err := loadGameWarriors(q, gameID)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Warriors = make(map[int]*GameWarrior)
+		for _, asset := range snapshot.Assets {
+			snapshot.Warriors[asset.ID] = nil
+		}
+		for i := range warriors {
+			if _, ok := snapshot.Warriors[warriors[i].WarriorID]; ok {
+				snapshot.Warriors[warriors[i].WarriorID] = &warriors[i]
+			}
+		}
+
+		effects, err := loadWarriorEffects(q, gameID)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Effects = make(map[int][]WarriorEffect)
+		for _, asset := range snapshot.Assets {
+			snapshot.Effects[asset.ID] = []WarriorEffect{}
+		}
+		for _, effect := range effects {
+			if list, ok := snapshot.Effects[effect.WarriorID]; ok {
+				snapshot.Effects[effect.WarriorID] = append(list, effect)
+			}
+		}
+	}
+
+	for _, avatarID := range avatarIDs {
+		var coins int
+		if err := q.QueryRow("SELECT coins FROM avatars WHERE id = ?", avatarID).Scan(&coins); err != nil {
+			continue
+		}
+		if snapshot.Coins == nil {
+			snapshot.Coins = make(map[int]int)
+		}
+		snapshot.Coins[avatarID] = coins
+
+		var collected int
+		if err := q.QueryRow("SELECT COALESCE(coins_collected, 0) FROM game_avatars WHERE game_id = ? AND avatar_id = ?", gameID, avatarID).Scan(&collected); err == nil {
+			if snapshot.Collected == nil {
+				snapshot.Collected = make(map[int]int)
+			}
+			snapshot.Collected[avatarID] = collected
+		}
+	}
+
+	return snapshot, nil
+}
+
+// Write an action to the game's event log, stamped with the current turn.
+// Player actions only need the avatar; the user is looked up from it.
+func recordGameEvent(q dbQuerier, gameID int, eventType string, actorUserID, actorAvatarID int, before, after *BoardSnapshot) (int64, error) {
+	if actorUserID == 0 && actorAvatarID != 0 {
+		q.QueryRow("SELECT user_id FROM avatars WHERE id = ?", actorAvatarID).Scan(&actorUserID)
+	}
+
+	beforeJSON, err := json.Marshal(before)
+	if err != nil {
+		return 0, err
+	}
+	afterJSON, err := json.Marshal(after)
+	if err != nil {
+		return 0, err
+	}
+
+	var userID, avatarID interface{}
+	if actorUserID != 0 {
+		userID = actorUserID
+	}
+	if actorAvatarID != 0 {
+		avatarID = actorAvatarID
+	}
+
+	result, err := q.Exec(`INSERT INTO game_events (game_id, event_type, actor_user_id, actor_avatar_id, turn_index, turn_number, before_state, after_state)
+		SELECT id, ?, ?, ?, COALESCE(current_turn_index, 0), COALESCE(turn_number, 0), ?, ?
+		FROM games WHERE id = ?`,
+		eventType, userID, avatarID, string(beforeJSON), string(afterJSON), gameID)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// Put the cells, asset stats, coins and turn state of a snapshot back. The actions taken are only put back
+// while it's still the snapshot's turn; a later turn keeps its own count.
+func restoreBoard(q dbQuerier, gameID int, snapshot *BoardSnapshot) error {
+	for _, cell := range snapshot.Cells {
+		activeInt := 0
+		if cell.Active {
+			activeInt = 1
+		}
+		var occupiedBy interface{}
+		if cell.OccupiedBy != 0 {
+			occupiedBy = cell.OccupiedBy
+		}
+		_, err := q.Exec(`UPDATE game_cells
+			SET name = ?, description = ?, background = ?, active = ?, element = ?, occupied_by = ?, status = ?, reward_coins = ?, reward_xp = ?,
+				regen_rounds = ?
+			WHERE id = ? AND game_id = ?`,
+			cell.Name, cell.Description, cell.Background, activeInt, cell.Element, occupiedBy, cell.Status, cell.RewardCoins, cell.RewardXP,
+			cell.RegenRounds, cell.ID, gameID)
+		if err != nil {
+			return err
+		}
+	}
+
+	for cellID, claimedRound := range snapshot.ClaimedRounds {
+		if _, err := q.Exec("UPDATE game_cells SET claimed_round = ? WHERE id = ? AND game_id = ?", claimedRound, cellID, gameID); err != nil {
+			return err
+		}
+	}
+
+	for cellID, base := range snapshot.BaseRewards {
+		_, err := q.Exec("UPDATE game_cells SET base_reward_coins = ?, base_reward_xp = ? WHERE id = ? AND game_id = ?", base[0], base[1], cellID, gameID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Effects are put back whole, so ones added since are removed and removed ones come back with their IDs
+	for cellID, effects := range snapshot.CellEffects {
+		if _, err := q.Exec("DELETE FROM cell_effects WHERE game_id = ? AND cell_id = ?", gameID, cellID); err != nil {
+			return err
+		}
+		for _, effect := range effects {
+			var shopItems string
+			if len(effect.ShopItems) > 0 {
+				encoded, err := json.Marshal(effect.ShopItems)
+				if err != nil {
+					return err
+				}
+				shopItems = string(encoded)
+			}
+			_, err := q.Exec(`INSERT INTO cell_effects (id, game_id, cell_id, effect_type, trigger, amount, duration, element, target_cell_id,
+				charges, initial_charges, cooldown, last_triggered_turn, shop_items)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				effect.ID, gameID, cellID, effect.Type, effect.Trigger, effect.Amount, effect.Duration, effect.Element, effect.TargetCellID,
+				effect.Charges, effect.InitialCharges, effect.Cooldown, effect.LastTriggeredTurn, shopItems)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, asset := range snapshot.Assets {
+		_, err := q.Exec(`UPDATE assets
+			SET status = ?, health = ?, stamina = ?, xp = ?, level = ?, base_attack = ?, base_defense = ?, base_healing = ?
+			WHERE id = ?`,
+			asset.Status, asset.Health, asset.Stamina, asset.XP, asset.Level, asset.BaseAttack, asset.BaseDefense, asset.BaseHealing, asset.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	for assetID, effects := range snapshot.Effects {
+		if _, err := q.Exec("DELETE FROM warrior_effects WHERE game_id = ? AND asset_id = ?", gameID, assetID); err != nil {
+			return err
+		}
+		for _, effect := range effects {
+			_, err := q.Exec(`INSERT INTO warrior_effects (id, game_id, asset_id, effect_type, amount, turns_left, source_cell_id)
+				VALUES (?, ?, ?, ?, ?, ?, ?)`, effect.ID, gameID, assetID, effect.Type, effect.Amount, effect.TurnsLeft, effect.SourceCellID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for assetID, warrior := range snapshot.Warriors {
+		if warrior == nil {
+			// Not deployed yet at the time: undoing its deployment
+			if _, err := q.Exec("DELETE FROM game_warriors WHERE game_id = ? AND asset_id = ?", gameID, assetID); err != nil {
+				return err
+			}
+			continue
+		}
+		_, err := q.Exec(`INSERT OR REPLACE INTO game_warriors (id, game_id, asset_id, avatar_id, health, stamina, xp_gained, status)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			warrior.ID, gameID, assetID, warrior.AvatarID, warrior.Health, warrior.Stamina, warrior.XPGained, warrior.Status)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, use := range snapshot.EffectUses {
+		_, err := q.Exec("UPDATE cell_effects SET charges = ?, last_triggered_turn = ? WHERE id = ? AND game_id = ?",
+			use.Charges, use.LastTriggeredTurn, use.ID, gameID)
+		if err != nil {
+			return err
+		}
+	}
+
+	for avatarID, coins := range snapshot.Coins {
+		if _, err := q.Exec("UPDATE avatars SET coins = ? WHERE id = ?", coins, avatarID); err != nil {
+			return err
+		}
+	}
+
+	for avatarID, collected := range snapshot.Collected {
+		if _, err := q.Exec("UPDATE game_avatars SET coins_collected = ? WHERE game_id = ? AND avatar_id = ?", collected, gameID, avatarID); err != nil {
+			return err
+		}
+	}
+
+	_, err := q.Exec(`UPDATE games SET actions_taken = CASE WHEN COALESCE(turn_number, 0) = ? THEN ? ELSE actions_taken END, battle_id = ?
+		WHERE id = ?`, snapshot.TurnNumber, snapshot.ActionsTaken, snapshot.BattleID, gameID)
+	if err != nil {
+		return err
+	}
+	return syncGameWarriorCells(q, gameID)
+}
+
+// Scan a game_events row
+func scanGameLogEvent(rows *sql.Rows) (GameLogEvent, error) {
+	var event GameLogEvent
+	var actorUserID, actorAvatarID, undoOf sql.NullInt64
+	var beforeJSON, afterJSON sql.NullString
+	var undone int
+	err := rows.Scan(&event.ID, &event.GameID, &event.Type, &actorUserID, &actorAvatarID, &event.TurnIndex, &event.TurnNumber,
+		&beforeJSON, &afterJSON, &undone, &undoOf, &event.CreatedAt)
+	if err != nil {
+		return event, err
+	}
+
+	if actorUserID.Valid {
+		id := int(actorUserID.Int64)
+		event.ActorUserID = &id
+	}
+	if actorAvatarID.Valid {
+		id := int(actorAvatarID.Int64)
+		event.ActorAvatarID = &id
+	}
+	if undoOf.Valid {
+		id := int(undoOf.Int64)
+		event.UndoOf = &id
+	}
+	if beforeJSON.Valid {
+		json.Unmarshal([]byte(beforeJSON.String), &event.Before)
+	}
+	if afterJSON.Valid {
+		json.Unmarshal([]byte(afterJSON.String), &event.After)
+	}
+	event.Undone = undone == 1
+
+	return event, nil
+}
+
+// Replay a game: its event log grouped by turn, oldest first
+func getGameEvents(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	gameID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid game ID", http.StatusBadRequest)
+		return
+	}
+
+	var status string
+	err = db.QueryRow("SELECT COALESCE(status, 'active') FROM games WHERE id = ?", gameID).Scan(&status)
+	if err != nil {
+		http.Error(w, "Game not found", http.StatusNotFound)
+		return
+	}
+
+	// The log shows every warrior, so players of a fogged game only get it once the game is over
+	if fog, _ := gameFog(gameID); fog && status != "finished" && gameViewer(gameID, claims.UserID) != 0 {
+		http.Error(w, "The replay of a fogged game is available when it's over", http.StatusForbidden)
+		return
+	}
+
+	// Turn order, to say whose turn each group was
+	var turnOrder []int
+	avatarRows, err := db.Query("SELECT avatar_id FROM game_avatars WHERE game_id = ? ORDER BY turn_order", gameID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for avatarRows.Next() {
+		var avatarID int
+		if avatarRows.Scan(&avatarID) == nil {
+			turnOrder = append(turnOrder, avatarID)
+		}
+	}
+	avatarRows.Close()
+
+	rows, err := db.Query(`SELECT id, game_id, event_type, actor_user_id, actor_avatar_id, turn_index, turn_number,
+		before_state, after_state, undone, undo_of, created_at
+		FROM game_events WHERE game_id = ? ORDER BY id`, gameID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	type replayTurn struct {
+		TurnNumber int            `json:"turnNumber"`
+		TurnIndex  int            `json:"turnIndex"`
+		AvatarID   *int           `json:"avatarId"`
+		Events     []GameLogEvent `json:"events"`
+	}
+
+	turns := []*replayTurn{}
+	for rows.Next() {
+		event, err := scanGameLogEvent(rows)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if len(turns) == 0 || turns[len(turns)-1].TurnNumber != event.TurnNumber || turns[len(turns)-1].TurnIndex != event.TurnIndex {
+			turn := &replayTurn{TurnNumber: event.TurnNumber, TurnIndex: event.TurnIndex, Events: []GameLogEvent{}}
+			if event.TurnIndex >= 0 && event.TurnIndex < len(turnOrder) {
+				avatarID := turnOrder[event.TurnIndex]
+				turn.AvatarID = &avatarID
+			}
+			turns = append(turns, turn)
+		}
+		turns[len(turns)-1].Events = append(turns[len(turns)-1].Events, event)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"gameId": gameID,
+		"turns":  turns,
+	})
+}
+
+// Undo the last action on the board (admin only). Reverts the cells, asset stats and coins it changed;
+// a battle it started is cancelled and its unanswered questions go back to the bank.
+func undoGameAction(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	vars := mux.Vars(r)
+	gameID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid game ID", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT id, game_id, event_type, actor_user_id, actor_avatar_id, turn_index, turn_number,
+		before_state, after_state, undone, undo_of, created_at
+		FROM game_events WHERE game_id = ? AND undone = 0 AND event_type != 'undo'
+		ORDER BY id DESC LIMIT 1`, gameID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var event GameLogEvent
+	found := rows.Next()
+	if found {
+		event, err = scanGameLogEvent(rows)
+	}
+	rows.Close()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found || event.Before == nil {
+		http.Error(w, "Nothing to undo", http.StatusNotFound)
+		return
+	}
+
+	var cellIDs, assetIDs, avatarIDs []int
+	for _, cell := range event.Before.Cells {
+		cellIDs = append(cellIDs, cell.ID)
+	}
+	for _, asset := range event.Before.Assets {
+		assetIDs = append(assetIDs, asset.ID)
+	}
+	for avatarID := range event.Before.Coins {
+		avatarIDs = append(avatarIDs, avatarID)
+	}
+
+	current, err := captureBoard(tx, gameID, cellIDs, assetIDs, avatarIDs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := restoreBoard(tx, gameID, event.Before); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// A battle the action started is called off
+	if event.After != nil && event.After.BattleID != nil && event.Before.BattleID == nil {
+		tx.Exec("UPDATE battles SET status = 'cancelled' WHERE id = ?", *event.After.BattleID)
+		tx.Exec("UPDATE battle_questions SET battle_id = NULL WHERE battle_id = ? AND submitted_at IS NULL", *event.After.BattleID)
+	}
+
+	// Reward claims the action made are taken back along with the rewards
+	tx.Exec("UPDATE game_cells SET claimed_round = NULL WHERE id IN (SELECT cell_id FROM cell_reward_claims WHERE event_id = ?)", event.ID)
+	tx.Exec("DELETE FROM cell_reward_claims WHERE event_id = ?", event.ID)
+
+	// Never freeze the game on a battle that is already over
+	tx.Exec("UPDATE games SET battle_id = NULL WHERE id = ? AND battle_id IN (SELECT id FROM battles WHERE status != 'in_progress')", gameID)
+
+	if _, err := tx.Exec("UPDATE game_events SET undone = 1 WHERE id = ?", event.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	undoID, err := recordGameEvent(tx, gameID, "undo", claims.UserID, 0, current, event.Before)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tx.Exec("UPDATE game_events SET undo_of = ? WHERE id = ?", event.ID, undoID)
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	broadcastCellUpdates(gameID, cellIDs...)
+	broadcastGameEvent(gameID, "action_undone", map[string]interface{}{
+		"eventId":   event.ID,
+		"eventType": event.Type,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"undoneId":   event.ID,
+		"undoneType": event.Type,
+	})
+}
+
+// Get the avatar whose turn it is (current_turn_index is a position in the turn order).
+// Pass the transaction when there is one so the turn can't change under the caller.
+func getCurrentTurnAvatarID(q dbQuerier, gameID int) (int, error) {
+	var avatarID int
+	err := q.QueryRow(`SELECT avatar_id FROM game_avatars
+		WHERE game_id = ?
+		ORDER BY turn_order
+		LIMIT 1 OFFSET (SELECT COALESCE(current_turn_index, 0) FROM games WHERE id = ?)`,
+		gameID, gameID).Scan(&avatarID)
+	return avatarID, err
+}
+
+// Check that the avatar plays in this game and that it is its turn. Runs inside the board transaction
+// so the turn can't change between the check and the move. Returns the HTTP status to send when the action is not allowed.
+func checkGameTurn(tx *sql.Tx, gameID, avatarID int) (int, error) {
+	var status string
+	var battleID sql.NullInt64
+	err := tx.QueryRow("SELECT COALESCE(status, 'active'), battle_id FROM games WHERE id = ?", gameID).Scan(&status, &battleID)
+	if err != nil {
+		return http.StatusNotFound, fmt.Errorf("game not found")
+	}
+	if status != "active" {
+		return http.StatusConflict, fmt.Errorf("the game is %s", status)
+	}
+	if battleID.Valid {
+		return http.StatusConflict, fmt.Errorf("a battle is in progress")
+	}
+
+	var memberCount int
+	err = tx.QueryRow("SELECT COUNT(*) FROM game_avatars WHERE game_id = ? AND avatar_id = ?", gameID, avatarID).Scan(&memberCount)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if memberCount == 0 {
+		return http.StatusForbidden, fmt.Errorf("your avatar is not playing in this game")
+	}
+
+	currentAvatarID, err := getCurrentTurnAvatarID(tx, gameID)
+	if err != nil || currentAvatarID != avatarID {
+		return http.StatusForbidden, fmt.Errorf("it is not your turn")
+	}
+
+	return http.StatusOK, nil
+}
+
+// Use up one action of the current turn. Returns the actions left, or an error if the budget is spent.
+func consumeGameAction(tx *sql.Tx, gameID int) (int, error) {
+	result, err := tx.Exec(`UPDATE games SET actions_taken = COALESCE(actions_taken, 0) + 1
+		WHERE id = ? AND COALESCE(actions_taken, 0) < COALESCE(actions_per_turn, 1)`, gameID)
+	if err != nil {
+		return 0, err
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return 0, fmt.Errorf("no actions left this turn")
+	}
+
+	var actionsRemaining int
+	err = tx.QueryRow("SELECT COALESCE(actions_per_turn, 1) - COALESCE(actions_taken, 0) FROM games WHERE id = ?", gameID).Scan(&actionsRemaining)
+	return actionsRemaining, err
+}
+
+// Terrain movement cost by element ("Fire 🔥" and "fire" are the same terrain). Unknown or empty terrain costs 1.
+var terrainMoveCost = map[string]int{
+	"earth":       1,
+	"wind":        1,
+	"light":       1,
+	"fire":        2,
+	"water":       2,
+	"ice":         2,
+	"metal":       2,
+	"electricity": 2,
+	"gravity":     3,
+	"time":        3,
+}
+
+// Normalize an element label ("Water 💧" -> "water")
+func normalizeElement(element string) string {
+	fields := strings.Fields(strings.ToLower(element))
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// Cost for a warrior to enter a cell. Warriors move through their avatar's own element at the base cost.
+func terrainCost(cellElement, avatarElement string) int {
+	terrain := normalizeElement(cellElement)
+	if terrain == "" || terrain == normalizeElement(avatarElement) {
+		return 1
+	}
+	if cost, ok := terrainMoveCost[terrain]; ok {
+		return cost
+	}
+	return 1
+}
+
+// Movement points per move: endurance sets the range, a tired warrior (low stamina) only goes half as far
+func movementRange(endurance, stamina int) int {
+	if stamina <= 0 {
+		return 0
+	}
+	points := 1 + endurance/25
+	if stamina < 50 {
+		points = (points + 1) / 2
+	}
+	return points
+}
+
+// Format zero-based row/column coordinates as a cell ID (0,0 -> A1; row 26 -> AA)
+func formatCellID(row, col int) string {
+	var rowLetter string
+	if row < 26 {
+		rowLetter = string(rune('A' + row))
+	} else {
+		// For rows 26-51: AA, AB, AC... AZ
+		// For rows 52-77: BA, BB, BC... BZ
+		firstLetter := rune('A' + (row / 26) - 1)
+		secondLetter := rune('A' + (row % 26))
+		rowLetter = string(firstLetter) + string(secondLetter)
+	}
+	return fmt.Sprintf("%s%d", rowLetter, col+1)
+}
+
+// Parse a cell ID such as "B3" or "AA12" back into zero-based row/column coordinates
+func parseCellID(cellID string) (int, int, bool) {
+	i := 0
+	for i < len(cellID) && cellID[i] >= 'A' && cellID[i] <= 'Z' {
+		i++
+	}
+	if i == 0 || i > 2 || i == len(cellID) {
+		return 0, 0, false
+	}
+
+	col, err := strconv.Atoi(cellID[i:])
+	if err != nil || col < 1 {
+		return 0, 0, false
+	}
+
+	row := int(cellID[0] - 'A')
+	if i == 2 {
+		row = (row+1)*26 + int(cellID[1]-'A')
+	}
+
+	return row, col - 1, true
+}
+
+// Steps to the cells next to a cell. Square boards use the 4 sides. Hex boards use axial coordinates: the row
+// letter is r and the column number is q, and each row is drawn half a cell further right than the one above,
+// so a cell also touches the one up-right and the one down-left of it.
+var boardDirections = map[string][][2]int{
+	"square": {{-1, 0}, {1, 0}, {0, -1}, {0, 1}},
+	"hex":    {{-1, 0}, {1, 0}, {0, -1}, {0, 1}, {-1, 1}, {1, -1}},
+}
+
+// Parse a rectangle of cells such as "C3:H9" into its top-left and bottom-right coordinates; the corners may
+// come in any order
+func parseCellRange(cellRange string) (int, int, int, int, bool) {
+	corners := strings.Split(cellRange, ":")
+	if len(corners) != 2 {
+		return 0, 0, 0, 0, false
+	}
+	row1, col1, ok1 := parseCellID(strings.TrimSpace(corners[0]))
+	row2, col2, ok2 := parseCellID(strings.TrimSpace(corners[1]))
+	if !ok1 || !ok2 {
+		return 0, 0, 0, 0, false
+	}
+	return min(row1, row2), min(col1, col2), max(row1, row2), max(col1, col2), true
+}
+
+// Which cells of a new board start inactive, given its shape and mask (see CreateGameRequest)
+func boardMask(topology, shape string, mask []string, rows, columns int) (map[[2]int]bool, error) {
+	inactive := make(map[[2]int]bool)
+
+	switch shape {
+	case "", "rectangle":
+	case "circle":
+		// The ellipse touching the sides of the grid, measured from cell centres
+		if topology == "hex" {
+			return nil, fmt.Errorf("hex boards use the hexagon shape")
+		}
+		for row := 0; row < rows; row++ {
+			for col := 0; col < columns; col++ {
+				dy := (float64(row) + 0.5 - float64(rows)/2) / (float64(rows) / 2)
+				dx := (float64(col) + 0.5 - float64(columns)/2) / (float64(columns) / 2)
+				if dx*dx+dy*dy > 1 {
+					inactive[[2]int{row, col}] = true
+				}
+			}
+		}
+	case "hexagon":
+		// Every cell within hex distance of the middle cell, cutting the corners off the axial grid
+		if topology != "hex" {
+			return nil, fmt.Errorf("the hexagon shape needs a hex board")
+		}
+		center := [2]int{rows / 2, columns / 2}
+		radius := min(rows, columns) / 2
+		for row := 0; row < rows; row++ {
+			for col := 0; col < columns; col++ {
+				if boardDistance(topology, [2]int{row, col}, center) > radius {
+					inactive[[2]int{row, col}] = true
+				}
+			}
+		}
+	default:
+		return nil, fmt.Errorf("shape must be rectangle, circle or hexagon")
+	}
+
+	for _, entry := range mask {
+		fromRow, fromCol, toRow, toCol, ok := parseCellRange(entry)
+		if !ok {
+			row, col, isCell := parseCellID(strings.TrimSpace(entry))
+			if !isCell {
+				return nil, fmt.Errorf("invalid mask entry %q (use cells like B3 or ranges like C3:D5)", entry)
+			}
+			fromRow, fromCol, toRow, toCol = row, col, row, col
+		}
+		for row := fromRow; row <= toRow && row < rows; row++ {
+			for col := fromCol; col <= toCol && col < columns; col++ {
+				inactive[[2]int{row, col}] = true
+			}
+		}
+	}
+
+	if len(inactive) >= rows*columns {
+		return nil, fmt.Errorf("the shape and mask leave no cells on the board")
+	}
+
+	return inactive, nil
+}
+
+// Absolute value of an int
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Number of steps between two cells of a board that has no gaps
+func boardDistance(topology string, from, to [2]int) int {
+	dr, dq := to[0]-from[0], to[1]-from[1]
+	if topology == "hex" {
+		return (abs(dr) + abs(dq) + abs(dr+dq)) / 2
+	}
+	return abs(dr) + abs(dq)
+}
+
+// Coordinates of the cells next to (row, col) on the board
+func boardNeighbors(topology string, rows, columns, row, col int) [][2]int {
+	directions, ok := boardDirections[topology]
+	if !ok {
+		directions = boardDirections["square"]
+	}
+
+	var neighbors [][2]int
+	for _, d := range directions {
+		r, c := row+d[0], col+d[1]
+		if r >= 0 && r < rows && c >= 0 && c < columns {
+			neighbors = append(neighbors, [2]int{r, c})
+		}
+	}
+	return neighbors
+}
+
+// WarriorMove is a cell a warrior can legally move to
+type WarriorMove struct {
+	ID     int    `json:"id"`               // game_cells row ID
+	CellID string `json:"cellId"`           // Chess-like ID (e.g., B3)
+	Cost   int    `json:"cost"`             // Movement points spent to get there
+	Attack bool   `json:"attack,omitempty"` // Cell holds an enemy warrior; moving there starts a battle
+}
+
+// boardCell is the part of a game cell the movement rules need
+type boardCell struct {
+	ID         int
+	CellID     string
+	Active     bool
+	Element    string
+	OccupiedBy int
+	OwnerID    int // Avatar that owns the occupying warrior
+}
+
+// Work out where a warrior standing on the board can move this turn.
+// Returns the cell it stands on, its movement points and the legal destinations.
+func computeWarriorMoves(q dbQuerier, gameID, warriorID int) (boardCell, int, []WarriorMove, error) {
+	var from boardCell
+
+	var rows, columns int
+	var topology string
+	err := q.QueryRow("SELECT rows, columns, COALESCE(topology, 'square') FROM games WHERE id = ?", gameID).Scan(&rows, &columns, &topology)
+	if err != nil {
+		return from, 0, nil, fmt.Errorf("game not found")
+	}
+
+	var endurance, stamina, ownerID int
+	var avatarElement string
+	err = q.QueryRow(`SELECT a.endurance, COALESCE(gw.stamina, a.stamina), COALESCE(a.avatar_id, 0), COALESCE(av.element, '')
+		FROM assets a
+		LEFT JOIN avatars av ON a.avatar_id = av.id
+		LEFT JOIN game_warriors gw ON gw.asset_id = a.id AND gw.game_id = ?
+		WHERE a.id = ?`, gameID, warriorID).Scan(&endurance, &stamina, &ownerID, &avatarElement)
+	if err != nil {
+		return from, 0, nil, fmt.Errorf("warrior not found")
+	}
+
+	cellRows, err := q.Query(`SELECT gc.id, gc.cell_id, gc.active, COALESCE(gc.element, ''), COALESCE(gc.occupied_by, 0), COALESCE(a.avatar_id, 0)
+		FROM game_cells gc
+		LEFT JOIN assets a ON a.id = gc.occupied_by
+		WHERE gc.game_id = ?`, gameID)
+	if err != nil {
+		return from, 0, nil, err
+	}
+	defer cellRows.Close()
+
+	board := make(map[[2]int]boardCell)
+	found := false
+	for cellRows.Next() {
+		var cell boardCell
+		var active int
+		if err := cellRows.Scan(&cell.ID, &cell.CellID, &active, &cell.Element, &cell.OccupiedBy, &cell.OwnerID); err != nil {
+			return from, 0, nil, err
+		}
+		cell.Active = active == 1
+
+		row, col, ok := parseCellID(cell.CellID)
+		if !ok {
+			continue
+		}
+		board[[2]int{row, col}] = cell
+
+		if cell.OccupiedBy == warriorID {
+			from = cell
+			found = true
+		}
+	}
+
+	if !found {
+		return from, 0, nil, fmt.Errorf("warrior is not on this board")
+	}
+
+	points := movementRange(endurance, stamina)
+	// A frozen warrior can't go anywhere or attack until the freeze wears off
+	frozen, err := isWarriorFrozen(q, gameID, warriorID)
+	if err != nil {
+		return from, 0, nil, err
+	}
+	if frozen {
+		points = 0
+	}
+
+	// Cheapest path to every reachable cell (Dijkstra; boards are small so a linear scan is enough)
+	startRow, startCol, _ := parseCellID(from.CellID)
+	start := [2]int{startRow, startCol}
+	best := map[[2]int]int{start: 0}
+	attacks := make(map[[2]int]int)
+	visited := make(map[[2]int]bool)
+
+	for {
+		current, currentCost, ok := [2]int{}, 0, false
+		for coord, cost := range best {
+			if !visited[coord] && (!ok || cost < currentCost) {
+				current, currentCost, ok = coord, cost, true
+			}
+		}
+		if !ok {
+			break
+		}
+		visited[current] = true
+
+		for _, next := range boardNeighbors(topology, rows, columns, current[0], current[1]) {
+			cell, exists := board[next]
+			// Inactive cells can't be entered
+			if !exists || !cell.Active {
+				continue
+			}
+
+			cost := currentCost + terrainCost(cell.Element, avatarElement)
+			if cost > points {
+				continue
+			}
+
+			// Occupied cells block the way; an enemy warrior in reach can be attacked
+			if cell.OccupiedBy != 0 {
+				if cell.OwnerID != ownerID {
+					if previous, seen := attacks[next]; !seen || cost < previous {
+						attacks[next] = cost
+					}
+				}
+				continue
+			}
+
+			if previous, seen := best[next]; !seen || cost < previous {
+				best[next] = cost
+			}
+		}
+	}
+
+	moves := []WarriorMove{}
+	for coord, cost := range best {
+		if coord == start {
+			continue
+		}
+		cell := board[coord]
+		moves = append(moves, WarriorMove{ID: cell.ID, CellID: cell.CellID, Cost: cost})
+	}
+	for coord, cost := range attacks {
+		cell := board[coord]
+		moves = append(moves, WarriorMove{ID: cell.ID, CellID: cell.CellID, Cost: cost, Attack: true})
+	}
+	sort.Slice(moves, func(i, j int) bool {
+		if moves[i].Cost != moves[j].Cost {
+			return moves[i].Cost < moves[j].Cost
+		}
+		return moves[i].ID < moves[j].ID
+	})
+
+	return from, points, moves, nil
+}
+
+// Get the legal destinations for a warrior on the board (for highlighting in the UI)
+func getWarriorMoves(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	gameID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid game ID", http.StatusBadRequest)
+		return
+	}
+	warriorID, err := strconv.Atoi(vars["wid"])
+	if err != nil {
+		http.Error(w, "Invalid warrior ID", http.StatusBadRequest)
+		return
+	}
+
+	// Players can only look up their own warriors, admins can look up any
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if role != "admin" {
+		var ownerUserID sql.NullInt64
+		err = db.QueryRow(`SELECT av.user_id FROM assets a JOIN avatars av ON a.avatar_id = av.id WHERE a.id = ?`, warriorID).Scan(&ownerUserID)
+		if err != nil {
+			http.Error(w, "Warrior not found", http.StatusNotFound)
+			return
+		}
+		if !ownerUserID.Valid || int(ownerUserID.Int64) != claims.UserID {
+			http.Error(w, "This warrior does not belong to you", http.StatusForbidden)
+			return
+		}
+	}
+
+	from, points, moves, err := computeWarriorMoves(db, gameID, warriorID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// On a fogged board enemies out of sight aren't offered as targets (moving there still starts the battle)
+	if fog, radius := gameFog(gameID); fog && role != "admin" {
+		var ownerAvatarID int
+		db.QueryRow("SELECT COALESCE(avatar_id, 0) FROM assets WHERE id = ?", warriorID).Scan(&ownerAvatarID)
+		visible, err := visibleCells(gameID, ownerAvatarID, radius)
+		if err == nil {
+			shown := []WarriorMove{}
+			for _, move := range moves {
+				if !move.Attack || visible[move.ID] {
+					shown = append(shown, move)
+				}
+			}
+			moves = shown
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"warriorId":      warriorID,
+		"fromCellId":     from.ID,
+		"fromCell":       from.CellID,
+		"movementPoints": points,
+		"moves":          moves,
+	})
+}
+
+// Cells and warriors the start of an avatar's turn can change: its warriors, the cells they stand on, where
+// teleports lead and the cells that regenerate
+func turnStartScope(q dbQuerier, gameID, avatarID int) ([]int, []int, error) {
+	var assetIDs []int
+	rows, err := q.Query(`SELECT DISTINCT id FROM assets WHERE avatar_id = ? AND id IN (
+			SELECT asset_id FROM game_warriors WHERE game_id = ? UNION SELECT asset_id FROM warrior_effects WHERE game_id = ?)`,
+		avatarID, gameID, gameID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var assetID int
+		if err := rows.Scan(&assetID); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		assetIDs = append(assetIDs, assetID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var cellIDs []int
+	rows, err = q.Query(`SELECT gc.id FROM game_cells gc
+		LEFT JOIN assets a ON a.id = gc.occupied_by
+		WHERE gc.game_id = ? AND (a.avatar_id = ? OR COALESCE(gc.regen_rounds, 0) > 0
+			OR gc.id IN (SELECT target_cell_id FROM cell_effects WHERE game_id = ? AND effect_type = 'teleport'))`,
+		gameID, avatarID, gameID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cellID int
+		if err := rows.Scan(&cellID); err != nil {
+			return nil, nil, err
+		}
+		cellIDs = append(cellIDs, cellID)
+	}
+	return cellIDs, assetIDs, rows.Err()
+}
+
+// Advance a game to the next avatar that isn't marked absent and restart the turn clock.
+// The update only applies if nobody else advanced the turn first. With fromAvatarID set, only that
+// avatar's turn is ended ("turn was already advanced" otherwise). Returns the new turn index.
+func advanceGameTurn(gameID, fromAvatarID int) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if fromAvatarID != 0 {
+		currentAvatarID, err := getCurrentTurnAvatarID(tx, gameID)
+		if err != nil {
+			return 0, err
+		}
+		if currentAvatarID != fromAvatarID {
+			return 0, fmt.Errorf("turn was already advanced")
+		}
+	}
+
+	var currentTurnIndex, turnNumber int
+	var status string
+	err = tx.QueryRow("SELECT COALESCE(current_turn_index, 0), COALESCE(turn_number, 0), COALESCE(status, 'active') FROM games WHERE id = ?", gameID).
+		Scan(&currentTurnIndex, &turnNumber, &status)
+	if err != nil {
+		return 0, fmt.Errorf("game not found")
+	}
+	if status != "active" {
+		return 0, fmt.Errorf("the game is %s", status)
+	}
+
+	rows, err := tx.Query("SELECT COALESCE(absent, 0) FROM game_avatars WHERE game_id = ? ORDER BY turn_order", gameID)
+	if err != nil {
+		return 0, err
+	}
+	var absent []bool
+	for rows.Next() {
+		var isAbsent int
+		if err := rows.Scan(&isAbsent); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		absent = append(absent, isAbsent == 1)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return 0, err
+	}
+	rows.Close()
+
+	if len(absent) == 0 {
+		return 0, fmt.Errorf("no avatars in game")
+	}
+
+	// Next present avatar (wrap around); if everyone is absent just move one seat
+	nextTurnIndex := (currentTurnIndex + 1) % len(absent)
+	for step := 1; step <= len(absent); step++ {
+		index := (currentTurnIndex + step) % len(absent)
+		if !absent[index] {
+			nextTurnIndex = index
+			break
+		}
+	}
+
+	// Wrapping back to the start of the turn order completes a round
+	roundsCompleted := 0
+	if nextTurnIndex <= currentTurnIndex {
+		roundsCompleted = 1
+	}
+
+	result, err := tx.Exec(`UPDATE games SET current_turn_index = ?, turn_start_time = datetime('now'), actions_taken = 0, turn_number = ?,
+		round = COALESCE(round, 0) + ?
+		WHERE id = ? AND COALESCE(current_turn_index, 0) = ? AND COALESCE(turn_number, 0) = ?`,
+		nextTurnIndex, turnNumber+1, roundsCompleted, gameID, currentTurnIndex, turnNumber)
+	if err != nil {
+		return 0, err
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return 0, fmt.Errorf("turn was already advanced")
+	}
+
+	var nextAvatarID int
+	err = tx.QueryRow("SELECT avatar_id FROM game_avatars WHERE game_id = ? ORDER BY turn_order LIMIT 1 OFFSET ?", gameID, nextTurnIndex).Scan(&nextAvatarID)
+	if err != nil {
+		return 0, err
+	}
+	effects, refilled, err := startAvatarTurn(tx, gameID, nextAvatarID, roundsCompleted > 0)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	broadcastTurnChanged(gameID)
+	broadcastTriggeredEffects(gameID, effects)
+	broadcastCellUpdates(gameID, refilled...)
+	checkGameVictory(gameID)
+	return nextTurnIndex, nil
+}
+
+// Start an avatar's turn once the game has handed it over: its freezes and buffs tick down, its warriors'
+// turn-start cells go off and, when a round was completed, emptied cells that regenerate refill. What changed is
+// logged so undoing past it puts the board back. Returns the effects that went off and the refilled cells.
+func startAvatarTurn(tx *sql.Tx, gameID, avatarID int, roundCompleted bool) ([]TriggeredEffect, []int, error) {
+	cellIDs, assetIDs, err := turnStartScope(tx, gameID, avatarID)
+	if err != nil {
+		return nil, nil, err
+	}
+	before, err := captureBoard(tx, gameID, cellIDs, assetIDs, []int{avatarID})
+	if err != nil {
+		return nil, nil, err
+	}
+	effects, err := triggerTurnStartEffects(tx, gameID, avatarID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var refilled []int
+	if roundCompleted {
+		var round int
+		tx.QueryRow("SELECT COALESCE(round, 0) FROM games WHERE id = ?", gameID).Scan(&round)
+		refilled, err = regenerateCellRewards(tx, gameID, round)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if len(effects) > 0 || len(refilled) > 0 {
+		after, err := captureBoard(tx, gameID, cellIDs, assetIDs, []int{avatarID})
+		if err != nil {
+			return nil, nil, err
+		}
+		eventID, err := recordGameEvent(tx, gameID, "turn_started", 0, avatarID, before, after)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := linkRewardClaims(tx, gameID, eventID); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return effects, refilled, nil
+}
+
+// Turn scheduler: advances every active game whose turn_duration has elapsed.
+// Games that are paused or in the middle of a battle are left alone.
+func runTurnScheduler() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		resolveTimedOutBattles()
+
+		rows, err := db.Query(`SELECT id FROM games
+			WHERE COALESCE(status, 'active') = 'active'
+			AND battle_id IS NULL
+			AND turn_start_time IS NOT NULL
+			AND datetime(turn_start_time, '+' || COALESCE(turn_duration, 20) || ' seconds') <= datetime('now')`)
+		if err != nil {
+			log.Printf("Turn scheduler: %v", err)
+			continue
+		}
+
+		var gameIDs []int
+		for rows.Next() {
+			var gameID int
+			if err := rows.Scan(&gameID); err == nil {
+				gameIDs = append(gameIDs, gameID)
+			}
+		}
+		rows.Close()
+
+		for _, gameID := range gameIDs {
+			if _, err := advanceGameTurn(gameID, 0); err != nil && err.Error() != "no avatars in game" {
+				log.Printf("Turn scheduler: could not advance game %d: %v", gameID, err)
+			}
+		}
+
+		// Avatars on autopilot play once their turn has been showing for botTurnDelay
+		rows, err = db.Query(`SELECT DISTINCT g.id FROM games g
+			JOIN game_avatars ga ON ga.game_id = g.id AND COALESCE(ga.autopilot, 0) = 1
+			WHERE COALESCE(g.status, 'active') = 'active'
+			AND g.battle_id IS NULL
+			AND g.turn_start_time IS NOT NULL
+			AND datetime(g.turn_start_time, '+' || ? || ' seconds') <= datetime('now')`, int(botTurnDelay/time.Second))
+		if err != nil {
+			log.Printf("Turn scheduler: %v", err)
+			continue
+		}
+
+		gameIDs = nil
+		for rows.Next() {
+			var gameID int
+			if err := rows.Scan(&gameID); err == nil {
+				gameIDs = append(gameIDs, gameID)
+			}
+		}
+		rows.Close()
+
+		for _, gameID := range gameIDs {
+			avatarID, err := getCurrentTurnAvatarID(db, gameID)
+			if err != nil {
+				continue
+			}
+			var autopilot bool
+			db.QueryRow("SELECT COALESCE(autopilot, 0) FROM game_avatars WHERE game_id = ? AND avatar_id = ?", gameID, avatarID).Scan(&autopilot)
+			if !autopilot {
+				continue
+			}
+			if err := playBotTurn(gameID, avatarID); err != nil {
+				log.Printf("Turn scheduler: autopilot of avatar %d in game %d: %v", avatarID, gameID, err)
+			}
+		}
+	}
+}
+
+// How long an autopilot's turn shows before the bot plays it, so the class can follow along
+const botTurnDelay = 2 * time.Second
+
+// One thing the autopilot can do with an action: place a reserve warrior or move one already on the board
+type botAction struct {
+	WarriorID  int
+	FromCellID int // 0 when placing
+	ToCellID   int
+	Score      int
+}
+
+// Bot scoring weights
+const (
+	botRewardWeight  = 10   // Per coin (or 2 XP) on the destination
+	botDangerPenalty = 1000 // Per stronger enemy within two steps
+	botPlaceBonus    = 50   // Getting a reserve onto the board
+)
+
+// Pick the autopilot's next action for an avatar, or nil if nothing is worth doing. The bot walks towards
+// rewards, keeps out of reach of stronger enemies and deploys its reserves. It never attacks: a battle needs a
+// student to answer the questions. Ties are broken by rng, so the same seed on the same board gives the same choice.
+func chooseBotAction(q dbQuerier, gameID, avatarID int, rng *rand.Rand) (*botAction, error) {
+	var rows, columns, radius int
+	var topology string
+	var fog bool
+	err := q.QueryRow(`SELECT rows, columns, COALESCE(topology, 'square'), COALESCE(fog_of_war, 0), COALESCE(vision_radius, 2)
+		FROM games WHERE id = ?`, gameID).Scan(&rows, &columns, &topology, &fog, &radius)
+	if err != nil {
+		return nil, err
+	}
+
+	// Enemies hidden by the fog are unknown to the bot too
+	var visible map[int]bool
+	if fog {
+		board, err := loadFogBoard(q, gameID)
+		if err != nil {
+			return nil, err
+		}
+		visible = board.visibleCells(avatarID, radius)
+	}
+
+	type botCell struct {
+		id, row, col, reward, occupiedBy, ownerID int
+		active                                    bool
+	}
+	cellRows, err := q.Query(`SELECT gc.id, gc.cell_id, gc.active, COALESCE(gc.reward_coins, 0), COALESCE(gc.reward_xp, 0),
+		COALESCE(gc.occupied_by, 0), COALESCE(a.avatar_id, 0)
+		FROM game_cells gc
+		LEFT JOIN assets a ON a.id = gc.occupied_by
+		WHERE gc.game_id = ? ORDER BY gc.id`, gameID)
+	if err != nil {
+		return nil, err
+	}
+	var cells []botCell
+	byCoord := make(map[[2]int]*botCell)
+	for cellRows.Next() {
+		var cell botCell
+		var cellID string
+		var active, coins, xp int
+		if err := cellRows.Scan(&cell.id, &cellID, &active, &coins, &xp, &cell.occupiedBy, &cell.ownerID); err != nil {
+			cellRows.Close()
+			return nil, err
+		}
+		row, col, ok := parseCellID(cellID)
+		if !ok {
+			continue
+		}
+		cell.row, cell.col, cell.active, cell.reward = row, col, active == 1, coins+xp/2
+		cells = append(cells, cell)
+	}
+	cellRows.Close()
+	if err := cellRows.Err(); err != nil {
+		return nil, err
+	}
+	byID := make(map[int]*botCell)
+	for i := range cells {
+		byCoord[[2]int{cells[i].row, cells[i].col}] = &cells[i]
+		byID[cells[i].id] = &cells[i]
+	}
+
+	// How strong a warrior is in this game
+	strength := func(warriorID int) int {
+		var attack, defense int
+		q.QueryRow("SELECT COALESCE(attack, 0), COALESCE(defense, 0) FROM assets WHERE id = ?", warriorID).Scan(&attack, &defense)
+		health, _ := gameWarriorVitals(q, gameID, warriorID)
+		return attack + defense + health
+	}
+
+	// Steps from every active cell to the nearest reward (breadth-first out from all rewards at once)
+	rewardDistance := make(map[int]int)
+	var queue []*botCell
+	for i := range cells {
+		if cells[i].active && cells[i].reward > 0 {
+			rewardDistance[cells[i].id] = 0
+			queue = append(queue, &cells[i])
+		}
+	}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, next := range boardNeighbors(topology, rows, columns, current.row, current.col) {
+			cell, ok := byCoord[next]
+			if !ok || !cell.active {
+				continue
+			}
+			if _, seen := rewardDistance[cell.id]; !seen {
+				rewardDistance[cell.id] = rewardDistance[current.id] + 1
+				queue = append(queue, cell)
+			}
+		}
+	}
+
+	// Stronger visible enemies within two steps of a cell
+	enemyStrength := make(map[int]int)
+	for _, cell := range cells {
+		if cell.occupiedBy != 0 && cell.ownerID != avatarID && (!fog || visible[cell.id]) {
+			enemyStrength[cell.id] = strength(cell.occupiedBy)
+		}
+	}
+	danger := func(cell *botCell, own int) int {
+		count := 0
+		seen := map[int]bool{cell.id: true}
+		frontier := []*botCell{cell}
+		for step := 0; step < 2; step++ {
+			var next []*botCell
+			for _, c := range frontier {
+				for _, coord := range boardNeighbors(topology, rows, columns, c.row, c.col) {
+					neighbor, ok := byCoord[coord]
+					if !ok || seen[neighbor.id] {
+						continue
+					}
+					seen[neighbor.id] = true
+					if enemy, isEnemy := enemyStrength[neighbor.id]; isEnemy && enemy > own {
+						count++
+					}
+					next = append(next, neighbor)
+				}
+			}
+			frontier = next
+		}
+		return count
+	}
+
+	// What standing on a cell is worth to a warrior of the given strength
+	value := func(cellID, own int) int {
+		cell, ok := byID[cellID]
+		if !ok {
+			return 0
+		}
+		score := cell.reward * botRewardWeight
+		if distance, ok := rewardDistance[cell.id]; ok {
+			score -= distance
+		} else {
+			score -= rows + columns
+		}
+		return score - danger(cell, own)*botDangerPenalty
+	}
+
+	var candidates []botAction
+
+	// Moves of the warriors already on the board
+	for _, cell := range cells {
+		if cell.occupiedBy == 0 || cell.ownerID != avatarID {
+			continue
+		}
+		_, _, moves, err := computeWarriorMoves(q, gameID, cell.occupiedBy)
+		if err != nil {
+			continue
+		}
+		sort.Slice(moves, func(i, j int) bool { return moves[i].ID < moves[j].ID })
+
+		own := strength(cell.occupiedBy)
+		stay := value(cell.id, own)
+		for _, move := range moves {
+			if move.Attack {
+				continue
+			}
+			if gain := value(move.ID, own) - stay; gain > 0 {
+				candidates = append(candidates, botAction{WarriorID: cell.occupiedBy, FromCellID: cell.id, ToCellID: move.ID, Score: gain})
+			}
+		}
+	}
+
+	// Reserves that can still join this game
+	reserveRows, err := q.Query(`SELECT a.id FROM assets a
+		LEFT JOIN game_warriors gw ON gw.asset_id = a.id AND gw.game_id = ?
+		WHERE a.avatar_id = ? AND a.status = 'warrior' AND COALESCE(gw.status, 'active') = 'active'
+			AND NOT EXISTS (SELECT 1 FROM game_cells WHERE game_id = ? AND occupied_by = a.id)
+		ORDER BY a.id`, gameID, avatarID, gameID)
+	if err != nil {
+		return nil, err
+	}
+	var reserves []int
+	for reserveRows.Next() {
+		var warriorID int
+		if reserveRows.Scan(&warriorID) == nil {
+			reserves = append(reserves, warriorID)
+		}
+	}
+	reserveRows.Close()
+	if err := reserveRows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(reserves) > 0 {
+		warriorID := reserves[0]
+		own := strength(warriorID)
+		for _, cell := range cells {
+			if !cell.active || cell.occupiedBy != 0 {
+				continue
+			}
+			if score := value(cell.id, own) + botPlaceBonus; score > 0 {
+				candidates = append(candidates, botAction{WarriorID: warriorID, ToCellID: cell.id, Score: score})
+			}
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	rng.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	best := candidates[0]
+	for _, candidate := range candidates[1:] {
+		if candidate.Score > best.Score {
+			best = candidate
+		}
+	}
+	return &best, nil
+}
+
+// Play an autopilot avatar's whole turn, then hand the turn on
+func playBotTurn(gameID, avatarID int) error {
+	var seed int64
+	var turnNumber int
+	err := db.QueryRow(`SELECT COALESCE(ga.autopilot_seed, 0), COALESCE(g.turn_number, 0)
+		FROM game_avatars ga JOIN games g ON g.id = ga.game_id
+		WHERE ga.game_id = ? AND ga.avatar_id = ?`, gameID, avatarID).Scan(&seed, &turnNumber)
+	if err != nil {
+		return err
+	}
+	// Every turn gets its own stream of choices, the same one each time the game is replayed
+	rng := rand.New(rand.NewSource(seed*1000003 + int64(turnNumber)))
+
+	for {
+		action, err := chooseBotAction(db, gameID, avatarID, rng)
+		if err != nil {
+			return err
+		}
+		if action == nil {
+			break
+		}
+
+		var actionsRemaining int
+		if action.FromCellID == 0 {
+			actionsRemaining, _, err = placeWarrior(gameID, action.ToCellID, action.WarriorID, avatarID)
+		} else {
+			actionsRemaining, _, err = moveWarriorOnBoard(gameID, action.FromCellID, action.ToCellID, action.WarriorID, avatarID)
+		}
+		if err != nil {
+			// Out of actions, or the game changed under the bot: its turn is over
+			break
+		}
+		if actionsRemaining <= 0 {
+			break
+		}
+	}
+
+	// The game may have ended, been paused or moved on meanwhile
+	var status string
+	db.QueryRow("SELECT COALESCE(status, 'active') FROM games WHERE id = ?", gameID).Scan(&status)
+	if status != "active" {
+		return nil
+	}
+	if _, err := advanceGameTurn(gameID, avatarID); err != nil && err.Error() != "turn was already advanced" {
+		return err
+	}
+	return nil
+}
+
+// Turn on or off the autopilot that plays an avatar's turns (admin only). Body: {"autopilot": true, "seed": 42};
+// without a seed a random one is picked. An avatar on autopilot is no longer skipped as absent.
+func setAvatarAutopilot(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	vars := mux.Vars(r)
+	gameID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid game ID", http.StatusBadRequest)
+		return
+	}
+	avatarID, err := strconv.Atoi(vars["avatarId"])
+	if err != nil {
+		http.Error(w, "Invalid avatar ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Autopilot bool   `json:"autopilot"`
+		Seed      *int64 `json:"seed"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	seed := time.Now().UnixNano() % 1000000
+	if req.Seed != nil {
+		seed = *req.Seed
+	}
+
+	var result sql.Result
+	if req.Autopilot {
+		result, err = db.Exec("UPDATE game_avatars SET autopilot = 1, autopilot_seed = ?, absent = 0 WHERE game_id = ? AND avatar_id = ?", seed, gameID, avatarID)
+	} else {
+		result, err = db.Exec("UPDATE game_avatars SET autopilot = 0 WHERE game_id = ? AND avatar_id = ?", gameID, avatarID)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		http.Error(w, "Avatar not found in this game", http.StatusNotFound)
+		return
+	}
+
+	broadcastGameEvent(gameID, "game_updated", map[string]interface{}{"avatarId": avatarID, "autopilot": req.Autopilot})
+
+	response := map[string]interface{}{
+		"success":   true,
+		"autopilot": req.Autopilot,
+	}
+	if req.Autopilot {
+		response["seed"] = seed
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Load the warriors deployed in a game
+func loadGameWarriors(q dbQuerier, gameID int) ([]GameWarrior, error) {
+	rows, err := q.Query(`SELECT id, game_id, asset_id, avatar_id, COALESCE(health, 0), COALESCE(stamina, 0), COALESCE(xp_gained, 0),
+		COALESCE(status, 'active'), cell_id
+		FROM game_warriors WHERE game_id = ? ORDER BY id`, gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	warriors := []GameWarrior{}
+	for rows.Next() {
+		var warrior GameWarrior
+		var cellID sql.NullInt64
+		if err := rows.Scan(&warrior.ID, &warrior.GameID, &warrior.WarriorID, &warrior.AvatarID, &warrior.Health, &warrior.Stamina,
+			&warrior.XPGained, &warrior.Status, &cellID); err != nil {
+			return nil, err
+		}
+		if cellID.Valid {
+			id := int(cellID.Int64)
+			warrior.CellID = &id
+		}
+		warriors = append(warriors, warrior)
+	}
+	return warriors, nil
+}
+
+// Make sure a warrior has a state in a game, starting from the asset's current health and stamina.
+// Returns its status in the game (a warrior knocked out of a game stays out of it).
+func ensureGameWarrior(q dbQuerier, gameID, warriorID int) (string, error) {
+	_, err := q.Exec(`INSERT OR IGNORE INTO game_warriors (game_id, asset_id, avatar_id, health, stamina)
+		SELECT ?, id, COALESCE(avatar_id, 0), COALESCE(health, 100), COALESCE(stamina, 100) FROM assets WHERE id = ?`, gameID, warriorID)
+	if err != nil {
+		return "", err
+	}
+	var status string
+	err = q.QueryRow("SELECT COALESCE(status, 'active') FROM game_warriors WHERE game_id = ? AND asset_id = ?", gameID, warriorID).Scan(&status)
+	return status, err
+}
+
+// Health and stamina of a warrior in a game (the asset's own values if it was never deployed there)
+func gameWarriorVitals(q dbQuerier, gameID, warriorID int) (int, int) {
+	var health, stamina int
+	q.QueryRow(`SELECT COALESCE(gw.health, a.health, 0), COALESCE(gw.stamina, a.stamina, 0)
+		FROM assets a
+		LEFT JOIN game_warriors gw ON gw.asset_id = a.id AND gw.game_id = ?
+		WHERE a.id = ?`, gameID, warriorID).Scan(&health, &stamina)
+	return health, stamina
+}
+
+// Take a warrior out of a game ("exhausted" or "rip") and off its board.
+// In a permadeath game a defeated warrior is also dead outside the game.
+func knockOutGameWarrior(q dbQuerier, gameID, warriorID int, status string) error {
+	if _, err := q.Exec("UPDATE game_warriors SET status = ? WHERE game_id = ? AND asset_id = ?", status, gameID, warriorID); err != nil {
+		return err
+	}
+	if _, err := q.Exec("UPDATE game_cells SET occupied_by = NULL, status = '' WHERE game_id = ? AND occupied_by = ?", gameID, warriorID); err != nil {
+		return err
+	}
+
+	if status == "rip" {
+		var permadeath bool
+		q.QueryRow("SELECT COALESCE(permadeath, 0) FROM games WHERE id = ?", gameID).Scan(&permadeath)
+		if permadeath {
+			if _, err := q.Exec("UPDATE assets SET status = 'rip' WHERE id = ?", warriorID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return syncGameWarriorCells(q, gameID)
+}
+
+// Count XP a warrior earned in a game; it's only added to the warrior itself (and its level) if the game keeps XP
+func awardGameXP(q dbQuerier, gameID, warriorID, xp int) error {
+	_, err := q.Exec("UPDATE game_warriors SET xp_gained = COALESCE(xp_gained, 0) + ? WHERE game_id = ? AND asset_id = ?", xp, gameID, warriorID)
+	if err != nil {
+		return err
+	}
+
+	var keepXP bool
+	q.QueryRow("SELECT COALESCE(keep_xp, 1) FROM games WHERE id = ?", gameID).Scan(&keepXP)
+	if !keepXP {
+		return nil
+	}
+	return addWarriorXP(q, warriorID, xp)
+}
+
+// game_cells.occupied_by is what the board shows; copy it onto the warriors' rows so their position is part of their state
+func syncGameWarriorCells(q dbQuerier, gameID int) error {
+	_, err := q.Exec(`UPDATE game_warriors SET cell_id = (
+			SELECT gc.id FROM game_cells gc WHERE gc.game_id = game_warriors.game_id AND gc.occupied_by = game_warriors.asset_id LIMIT 1)
+		WHERE game_id = ?`, gameID)
+	return err
+}
+
+// Effects a cell can have. Shops sell consumables; every other effect triggers on a warrior.
+var cellEffectTypes = map[string]bool{
+	"heal":     true, // Restore health
+	"stamina":  true, // Restore stamina
+	"damage":   true, // Take health away
+	"freeze":   true, // Warrior can't move for a number of its owner's turns
+	"teleport": true, // Send the warrior to a linked cell
+	"buff":     true, // Attack and defense bonus in battles, optionally only for one element
+	"shop":     true, // Sells consumables to warriors standing on the cell
+}
+
+// Load a cell effect row
+func scanCellEffect(rows *sql.Rows) (CellEffect, error) {
+	var effect CellEffect
+	var targetCellID, lastTriggeredTurn sql.NullInt64
+	var shopItems string
+	err := rows.Scan(&effect.ID, &effect.GameID, &effect.CellID, &effect.Type, &effect.Trigger, &effect.Amount, &effect.Duration,
+		&effect.Element, &targetCellID, &effect.Charges, &effect.InitialCharges, &effect.Cooldown, &lastTriggeredTurn, &shopItems)
+	if err != nil {
+		return effect, err
+	}
+	if targetCellID.Valid {
+		id := int(targetCellID.Int64)
+		effect.TargetCellID = &id
+	}
+	if lastTriggeredTurn.Valid {
+		turn := int(lastTriggeredTurn.Int64)
+		effect.LastTriggeredTurn = &turn
+	}
+	if shopItems != "" {
+		if err := json.Unmarshal([]byte(shopItems), &effect.ShopItems); err != nil {
+			return effect, fmt.Errorf("invalid shop items on cell effect %d: %v", effect.ID, err)
+		}
+	}
+	return effect, nil
+}
+
+const cellEffectColumns = `id, game_id, cell_id, effect_type, trigger, COALESCE(amount, 0), COALESCE(duration, 0),
+	COALESCE(element, ''), target_cell_id, COALESCE(charges, -1), COALESCE(initial_charges, charges, -1), COALESCE(cooldown, 0),
+	last_triggered_turn, COALESCE(shop_items, '')`
+
+// Load the effects of a game, optionally only those of one cell (cellID 0 means all cells)
+func loadCellEffects(q dbQuerier, gameID, cellID int) ([]CellEffect, error) {
+	rows, err := q.Query(`SELECT `+cellEffectColumns+` FROM cell_effects
+		WHERE game_id = ? AND (? = 0 OR cell_id = ?)
+		ORDER BY cell_id, id`, gameID, cellID, cellID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	effects := []CellEffect{}
+	for rows.Next() {
+		effect, err := scanCellEffect(rows)
+		if err != nil {
+			return nil, err
+		}
+		effects = append(effects, effect)
+	}
+	return effects, nil
+}
+
+// Load the freezes and buffs currently on the warriors of a game
+func loadWarriorEffects(q dbQuerier, gameID int) ([]WarriorEffect, error) {
+	rows, err := q.Query(`SELECT id, asset_id, effect_type, COALESCE(amount, 0), COALESCE(turns_left, 0), source_cell_id
+		FROM warrior_effects WHERE game_id = ? ORDER BY id`, gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	effects := []WarriorEffect{}
+	for rows.Next() {
+		var effect WarriorEffect
+		var sourceCellID sql.NullInt64
+		if err := rows.Scan(&effect.ID, &effect.WarriorID, &effect.Type, &effect.Amount, &effect.TurnsLeft, &sourceCellID); err != nil {
+			return nil, err
+		}
+		if sourceCellID.Valid {
+			id := int(sourceCellID.Int64)
+			effect.SourceCellID = &id
+		}
+		effects = append(effects, effect)
+	}
+	return effects, nil
+}
+
+// Whether a warrior is frozen in a game
+func isWarriorFrozen(q dbQuerier, gameID, warriorID int) (bool, error) {
+	var count int
+	err := q.QueryRow("SELECT COUNT(*) FROM warrior_effects WHERE game_id = ? AND asset_id = ? AND effect_type = 'frozen'", gameID, warriorID).Scan(&count)
+	return count > 0, err
+}
+
+// Battle bonus from the buffs on a warrior in a game
+func warriorBuff(q dbQuerier, gameID, warriorID int) (int, error) {
+	var bonus int
+	err := q.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM warrior_effects WHERE game_id = ? AND asset_id = ? AND effect_type = 'buff'", gameID, warriorID).Scan(&bonus)
+	return bonus, err
+}
+
+// Teleport destinations linked to a cell, so they can be captured for the event log alongside it
+func teleportTargets(q dbQuerier, cellID int) []int {
+	var targets []int
+	rows, err := q.Query("SELECT target_cell_id FROM cell_effects WHERE cell_id = ? AND effect_type = 'teleport' AND target_cell_id IS NOT NULL", cellID)
+	if err != nil {
+		return targets
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var target int
+		if rows.Scan(&target) == nil {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// Apply a heal, stamina, damage, freeze or buff to a warrior's state in a game. Health and stamina stay between
+// 0 and 100; a warrior brought to 0 health is knocked out of the game. Returns whether it was defeated.
+func applyWarriorEffect(tx *sql.Tx, gameID, warriorID int, effectType string, amount, duration, sourceCellID int) (bool, error) {
+	var source interface{}
+	if sourceCellID != 0 {
+		source = sourceCellID
+	}
+
+	if _, err := ensureGameWarrior(tx, gameID, warriorID); err != nil {
+		return false, err
+	}
+
+	switch effectType {
+	case "heal":
+		_, err := tx.Exec("UPDATE game_warriors SET health = MIN(100, health + ?) WHERE game_id = ? AND asset_id = ?", amount, gameID, warriorID)
+		return false, err
+	case "stamina":
+		_, err := tx.Exec("UPDATE game_warriors SET stamina = MIN(100, stamina + ?) WHERE game_id = ? AND asset_id = ?", amount, gameID, warriorID)
+		return false, err
+	case "damage":
+		health, _ := gameWarriorVitals(tx, gameID, warriorID)
+		health -= amount
+		if health < 0 {
+			health = 0
+		}
+		if _, err := tx.Exec("UPDATE game_warriors SET health = ? WHERE game_id = ? AND asset_id = ?", health, gameID, warriorID); err != nil {
+			return false, err
+		}
+		if health > 0 {
+			return false, nil
+		}
+		return true, knockOutGameWarrior(tx, gameID, warriorID, "rip")
+	case "freeze":
+		_, err := tx.Exec(`INSERT INTO warrior_effects (game_id, asset_id, effect_type, amount, turns_left, source_cell_id)
+			VALUES (?, ?, 'frozen', 0, ?, ?)`, gameID, warriorID, duration, source)
+		return false, err
+	case "buff":
+		_, err := tx.Exec(`INSERT INTO warrior_effects (game_id, asset_id, effect_type, amount, turns_left, source_cell_id)
+			VALUES (?, ?, 'buff', ?, ?, ?)`, gameID, warriorID, amount, duration, source)
+		return false, err
+	}
+	return false, fmt.Errorf("unknown effect %s", effectType)
+}
+
+// Trigger the effects of a cell on the warrior standing on it ("enter" right after it arrives, "turn_start" when
+// its owner's turn begins). Effects out of charges or still cooling down are skipped; a teleport or a defeat ends
+// the chain because the warrior is no longer on the cell.
+func triggerCellEffects(tx *sql.Tx, gameID, cellID, warriorID int, trigger string) ([]TriggeredEffect, error) {
+	triggered := []TriggeredEffect{}
+
+	var turnNumber int
+	if err := tx.QueryRow("SELECT COALESCE(turn_number, 0) FROM games WHERE id = ?", gameID).Scan(&turnNumber); err != nil {
+		return triggered, err
+	}
+
+	var warriorElement string
+	tx.QueryRow(`SELECT COALESCE(av.element, '') FROM assets a LEFT JOIN avatars av ON av.id = a.avatar_id WHERE a.id = ?`, warriorID).Scan(&warriorElement)
+
+	rows, err := tx.Query(`SELECT `+cellEffectColumns+` FROM cell_effects
+		WHERE game_id = ? AND cell_id = ? AND trigger = ? AND effect_type != 'shop' AND COALESCE(charges, -1) != 0
+		ORDER BY id`, gameID, cellID, trigger)
+	if err != nil {
+		return triggered, err
+	}
+	var effects []CellEffect
+	for rows.Next() {
+		effect, err := scanCellEffect(rows)
+		if err != nil {
+			rows.Close()
+			return triggered, err
+		}
+		effects = append(effects, effect)
+	}
+	rows.Close()
+
+	for _, effect := range effects {
+		if effect.Cooldown > 0 && effect.LastTriggeredTurn != nil && turnNumber < *effect.LastTriggeredTurn+effect.Cooldown {
+			continue
+		}
+		// Element buffs only work for warriors of that element
+		if effect.Type == "buff" && effect.Element != "" && normalizeElement(effect.Element) != normalizeElement(warriorElement) {
+			continue
+		}
+
+		result := TriggeredEffect{EffectID: effect.ID, CellID: cellID, WarriorID: warriorID, Type: effect.Type, Amount: effect.Amount}
+		left := false
+
+		if effect.Type == "teleport" {
+			if effect.TargetCellID == nil {
+				continue
+			}
+			// Only teleport onto a free, playable cell of the same game
+			moved, err := tx.Exec(`UPDATE game_cells SET occupied_by = ?, status = 'warrior'
+				WHERE id = ? AND game_id = ? AND active = 1 AND COALESCE(occupied_by, 0) = 0`, warriorID, *effect.TargetCellID, gameID)
+			if err != nil {
+				return triggered, err
+			}
+			if rowsAffected, _ := moved.RowsAffected(); rowsAffected == 0 {
+				continue
+			}
+			if _, err := tx.Exec("UPDATE game_cells SET occupied_by = NULL, status = '' WHERE id = ? AND occupied_by = ?", cellID, warriorID); err != nil {
+				return triggered, err
+			}
+			if err := syncGameWarriorCells(tx, gameID); err != nil {
+				return triggered, err
+			}
+			result.ToCellID = effect.TargetCellID
+			left = true
+		} else {
+			defeated, err := applyWarriorEffect(tx, gameID, warriorID, effect.Type, effect.Amount, effect.Duration, cellID)
+			if err != nil {
+				return triggered, err
+			}
+			result.Defeated = defeated
+			left = defeated
+		}
+
+		_, err := tx.Exec(`UPDATE cell_effects SET last_triggered_turn = ?,
+			charges = CASE WHEN COALESCE(charges, -1) > 0 THEN charges - 1 ELSE charges END
+			WHERE id = ?`, turnNumber, effect.ID)
+		if err != nil {
+			return triggered, err
+		}
+
+		triggered = append(triggered, result)
+		if left {
+			break
+		}
+	}
+
+	return triggered, nil
+}
+
+// Start of an avatar's turn: its warriors' freezes and buffs count down, then turn-start cells trigger under them
+func triggerTurnStartEffects(tx *sql.Tx, gameID, avatarID int) ([]TriggeredEffect, error) {
+	_, err := tx.Exec(`DELETE FROM warrior_effects
+		WHERE game_id = ? AND turns_left <= 0 AND asset_id IN (SELECT id FROM assets WHERE avatar_id = ?)`, gameID, avatarID)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(`UPDATE warrior_effects SET turns_left = turns_left - 1
+		WHERE game_id = ? AND asset_id IN (SELECT id FROM assets WHERE avatar_id = ?)`, gameID, avatarID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`SELECT DISTINCT gc.id, gc.occupied_by
+		FROM game_cells gc
+		JOIN assets a ON a.id = gc.occupied_by
+		JOIN cell_effects ce ON ce.cell_id = gc.id AND ce.trigger = 'turn_start'
+		WHERE gc.game_id = ? AND a.avatar_id = ?`, gameID, avatarID)
+	if err != nil {
+		return nil, err
+	}
+	var occupied [][2]int
+	for rows.Next() {
+		var cellID, warriorID int
+		if rows.Scan(&cellID, &warriorID) == nil {
+			occupied = append(occupied, [2]int{cellID, warriorID})
+		}
+	}
+	rows.Close()
+
+	triggered := []TriggeredEffect{}
+	for _, cell := range occupied {
+		effects, err := triggerCellEffects(tx, gameID, cell[0], cell[1], "turn_start")
+		if err != nil {
+			return triggered, err
+		}
+		triggered = append(triggered, effects...)
+	}
+	return triggered, nil
+}
+
+// Tell everyone watching which effects went off and refresh the cells involved
+func broadcastTriggeredEffects(gameID int, effects []TriggeredEffect) {
+	for _, effect := range effects {
+		broadcastGameEvent(gameID, "cell_effect_triggered", effect)
+		cellIDs := []int{effect.CellID}
+		if effect.ToCellID != nil {
+			cellIDs = append(cellIDs, *effect.ToCellID)
+		}
+		broadcastCellUpdates(gameID, cellIDs...)
+	}
+}
+
+// Get the effects on a cell
+func getCellEffects(w http.ResponseWriter, r *http.Request) {
+	_, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	cellID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid cell ID", http.StatusBadRequest)
+		return
+	}
+
+	var gameID int
+	err = db.QueryRow("SELECT game_id FROM game_cells WHERE id = ?", cellID).Scan(&gameID)
+	if err != nil {
+		http.Error(w, "Cell not found", http.StatusNotFound)
+		return
+	}
+
+	effects, err := loadCellEffects(db, gameID, cellID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(effects)
+}
+
+// Add an effect to a cell (admin only)
+func createCellEffect(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	vars := mux.Vars(r)
+	cellID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid cell ID", http.StatusBadRequest)
+		return
+	}
+
+	var gameID int
+	err = db.QueryRow("SELECT game_id FROM game_cells WHERE id = ?", cellID).Scan(&gameID)
+	if err != nil {
+		http.Error(w, "Cell not found", http.StatusNotFound)
+		return
+	}
+
+	var req struct {
+		Type         string     `json:"type"`
+		Trigger      string     `json:"trigger"`
+		Amount       int        `json:"amount"`
+		Duration     int        `json:"duration"`
+		Element      string     `json:"element"`
+		TargetCellID *int       `json:"targetCellId"`
+		Charges      *int       `json:"charges"` // Defaults to unlimited (-1)
+		Cooldown     int        `json:"cooldown"`
+		ShopItems    []ShopItem `json:"shopItems"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	err = validateCellEffect(TemplateEffect{
+		Type: req.Type, Trigger: req.Trigger, Amount: req.Amount, Duration: req.Duration,
+		Charges: req.Charges, Cooldown: req.Cooldown, ShopItems: req.ShopItems,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Trigger == "" {
+		req.Trigger = "enter"
+	}
+	charges := -1
+	if req.Charges != nil {
+		charges = *req.Charges
+	}
+
+	if req.Type == "teleport" {
+		var targetGameID int
+		if req.TargetCellID == nil || *req.TargetCellID == cellID ||
+			db.QueryRow("SELECT game_id FROM game_cells WHERE id = ?", *req.TargetCellID).Scan(&targetGameID) != nil || targetGameID != gameID {
+			http.Error(w, "Teleport needs another cell of the same game as its target", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var shopItems string
+	if req.Type == "shop" {
+		encoded, _ := json.Marshal(req.ShopItems)
+		shopItems = string(encoded)
+	}
+
+	result, err := db.Exec(`INSERT INTO cell_effects (game_id, cell_id, effect_type, trigger, amount, duration, element, target_cell_id, charges,
+		initial_charges, cooldown, shop_items)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		gameID, cellID, req.Type, req.Trigger, req.Amount, req.Duration, req.Element, req.TargetCellID, charges, charges, req.Cooldown, shopItems)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	effectID, _ := result.LastInsertId()
+
+	effects, _ := loadCellEffects(db, gameID, cellID)
+	broadcastGameEvent(gameID, "cell_effects_updated", map[string]interface{}{
+		"cellId":  cellID,
+		"effects": effects,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"effectId": effectID,
+	})
+}
+
+// Remove an effect from a cell (admin only)
+func deleteCellEffect(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	vars := mux.Vars(r)
+	effectID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid effect ID", http.StatusBadRequest)
+		return
+	}
+
+	var gameID, cellID int
+	err = db.QueryRow("SELECT game_id, cell_id FROM cell_effects WHERE id = ?", effectID).Scan(&gameID, &cellID)
+	if err != nil {
+		http.Error(w, "Effect not found", http.StatusNotFound)
+		return
+	}
+
+	if _, err := db.Exec("DELETE FROM cell_effects WHERE id = ?", effectID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	effects, _ := loadCellEffects(db, gameID, cellID)
+	broadcastGameEvent(gameID, "cell_effects_updated", map[string]interface{}{
+		"cellId":  cellID,
+		"effects": effects,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// Buy a consumable from a shop cell for the warrior standing on it. Only during your own turn; doesn't use an action.
+func buyFromCellShop(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	cellID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid cell ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		WarriorID int    `json:"warriorId"`
+		Item      string `json:"item"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	var avatarID int
+	err = db.QueryRow("SELECT id FROM avatars WHERE user_id = ?", claims.UserID).Scan(&avatarID)
+	if err != nil {
+		http.Error(w, "No avatar found for user", http.StatusNotFound)
+		return
+	}
+
+	var gameID int
+	err = db.QueryRow("SELECT game_id FROM game_cells WHERE id = ?", cellID).Scan(&gameID)
+	if err != nil {
+		http.Error(w, "Cell not found", http.StatusNotFound)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if status, err := checkGameTurn(tx, gameID, avatarID); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	// The buyer's warrior has to be standing in the shop
+	var onCell int
+	tx.QueryRow(`SELECT COUNT(*) FROM game_cells gc JOIN assets a ON a.id = gc.occupied_by
+		WHERE gc.id = ? AND gc.occupied_by = ? AND a.avatar_id = ?`, cellID, req.WarriorID, avatarID).Scan(&onCell)
+	if onCell == 0 {
+		http.Error(w, "Your warrior has to be on the shop's cell", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := tx.Query(`SELECT `+cellEffectColumns+` FROM cell_effects
+		WHERE cell_id = ? AND effect_type = 'shop' AND COALESCE(charges, -1) != 0
+		ORDER BY id`, cellID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var shop *CellEffect
+	var item ShopItem
+	for rows.Next() && shop == nil {
+		effect, err := scanCellEffect(rows)
+		if err != nil {
+			rows.Close()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, candidate := range effect.ShopItems {
+			if strings.EqualFold(candidate.Name, req.Item) {
+				shop, item = &effect, candidate
+				break
+			}
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if shop == nil {
+		http.Error(w, "This shop doesn't sell that item (or is sold out)", http.StatusNotFound)
+		return
+	}
+
+	before, err := captureBoard(tx, gameID, []int{cellID}, []int{req.WarriorID}, []int{avatarID})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result, err := tx.Exec("UPDATE avatars SET coins = coins - ? WHERE id = ? AND coins >= ?", item.Cost, avatarID, item.Cost)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		http.Error(w, "Not enough coins", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := applyWarriorEffect(tx, gameID, req.WarriorID, item.Effect, item.Amount, item.Duration, cellID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// A shop with charges has limited stock
+	if _, err := tx.Exec("UPDATE cell_effects SET charges = charges - 1 WHERE id = ? AND charges > 0", shop.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	after, err := captureBoard(tx, gameID, []int{cellID}, []int{req.WarriorID}, []int{avatarID})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := recordGameEvent(tx, gameID, "item_bought", 0, avatarID, before, after); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var coins int
+	tx.QueryRow("SELECT coins FROM avatars WHERE id = ?", avatarID).Scan(&coins)
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	broadcastGameEvent(gameID, "item_bought", map[string]interface{}{
+		"cellId":    cellID,
+		"warriorId": req.WarriorID,
+		"avatarId":  avatarID,
+		"item":      item,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"item":    item,
+		"coins":   coins,
+	})
+}
+
+// Ways a game can be won. "none" games run until an admin finishes them.
+var gameWinConditions = map[string]bool{
+	"none":          true,
+	"cells":         true, // First avatar whose warriors hold win_target cells
+	"last_standing": true, // Last avatar with warriors left to fight
+	"coins":         true, // First avatar to collect win_target coins from cells
+	"rounds":        true, // Most cells held after win_target full rounds
+}
+
+// Check a win condition and its target
+func validateWinCondition(condition string, target int) error {
+	if !gameWinConditions[condition] {
+		return fmt.Errorf("win condition must be one of none, cells, last_standing, coins or rounds")
+	}
+	if (condition == "cells" || condition == "coins" || condition == "rounds") && target < 1 {
+		return fmt.Errorf("win target must be at least 1 for the %s win condition", condition)
+	}
+	return nil
+}
+
+// Check prizes before storing them
+func validateGamePrizes(prizes []GamePrize) error {
+	for _, prize := range prizes {
+		if prize.Place < 0 {
+			return fmt.Errorf("prize place must be 0 (everyone) or a finishing position")
+		}
+		switch prize.Type {
+		case "coins", "xp":
+			if prize.Amount <= 0 {
+				return fmt.Errorf("%s prizes need a positive amount", prize.Type)
+			}
+		case "asset":
+			if prize.AssetName == "" {
+				return fmt.Errorf("asset prizes need an asset name")
+			}
+		default:
+			return fmt.Errorf("prize type must be coins, xp or asset")
+		}
+	}
+	return nil
+}
+
+// Rank the avatars of a game. What counts first depends on the win condition; ties share a rank.
+func computeGameStandings(q dbQuerier, gameID int) ([]GameStanding, error) {
+	var winCondition string
+	err := q.QueryRow("SELECT COALESCE(win_condition, 'none') FROM games WHERE id = ?", gameID).Scan(&winCondition)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.Query(`SELECT ga.avatar_id, COALESCE(av.name, ''), COALESCE(ga.coins_collected, 0),
+		(SELECT COUNT(*) FROM game_cells gc JOIN assets a ON a.id = gc.occupied_by WHERE gc.game_id = ga.game_id AND a.avatar_id = ga.avatar_id),
+		(SELECT COUNT(*) FROM game_warriors gw WHERE gw.game_id = ga.game_id AND gw.avatar_id = ga.avatar_id AND COALESCE(gw.status, 'active') = 'active')
+		FROM game_avatars ga
+		LEFT JOIN avatars av ON av.id = ga.avatar_id
+		WHERE ga.game_id = ?
+		ORDER BY ga.turn_order`, gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	standings := []GameStanding{}
+	for rows.Next() {
+		var standing GameStanding
+		if err := rows.Scan(&standing.AvatarID, &standing.AvatarName, &standing.CoinsCollected, &standing.Cells, &standing.WarriorsLeft); err != nil {
+			return nil, err
+		}
+		standings = append(standings, standing)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rankGameStandings(standings, winCondition)
+	return standings, nil
+}
+
+// Sort standings best first by the game's win condition and number them; ties share a rank
+func rankGameStandings(standings []GameStanding, winCondition string) {
+	score := func(s GameStanding) [2]int {
+		switch winCondition {
+		case "coins":
+			return [2]int{s.CoinsCollected, s.Cells}
+		case "last_standing":
+			return [2]int{s.WarriorsLeft, s.Cells}
+		default:
+			return [2]int{s.Cells, s.CoinsCollected}
+		}
+	}
+
+	sort.SliceStable(standings, func(i, j int) bool {
+		a, b := score(standings[i]), score(standings[j])
+		if a[0] != b[0] {
+			return a[0] > b[0]
+		}
+		return a[1] > b[1]
+	})
+
+	for i := range standings {
+		if i > 0 && score(standings[i]) == score(standings[i-1]) {
+			standings[i].Rank = standings[i-1].Rank
+		} else {
+			standings[i].Rank = i + 1
+		}
+	}
+}
+
+// Finish the game if its win condition is met. Called after every board change and turn change.
+func checkGameVictory(gameID int) {
+	var status, winCondition string
+	var winTarget, round int
+	err := db.QueryRow(`SELECT COALESCE(status, 'active'), COALESCE(win_condition, 'none'), COALESCE(win_target, 0), COALESCE(round, 0)
+		FROM games WHERE id = ?`, gameID).Scan(&status, &winCondition, &winTarget, &round)
+	if err != nil || status != "active" || winCondition == "none" {
+		return
+	}
+
+	standings, err := computeGameStandings(db, gameID)
+	if err != nil || len(standings) == 0 {
+		return
+	}
+
+	won := false
+	switch winCondition {
+	case "cells":
+		won = standings[0].Cells >= winTarget
+	case "coins":
+		won = standings[0].CoinsCollected >= winTarget
+	case "rounds":
+		won = round >= winTarget
+	case "last_standing":
+		// Only decided once at least two players have fought with warriors in this game
+		var deployed int
+		db.QueryRow("SELECT COUNT(DISTINCT avatar_id) FROM game_warriors WHERE game_id = ?", gameID).Scan(&deployed)
+		alive := 0
+		for _, standing := range standings {
+			if standing.WarriorsLeft > 0 {
+				alive++
+			}
+		}
+		won = deployed > 1 && alive <= 1
+	}
+
+	if won {
+		if _, err := finishGame(gameID, winCondition); err != nil {
+			log.Printf("Could not finish game %d: %v", gameID, err)
+		}
+	}
+}
+
+// Finish a game: freeze the final standings, pay out the prizes and tell every player how they did.
+// Avatars tied for a place all get that place's prizes; a tie for first means there's no single winner.
+func finishGame(gameID int, reason string) ([]GameStanding, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var gameName, prizesJSON string
+	err = tx.QueryRow("SELECT name, COALESCE(prizes, '') FROM games WHERE id = ?", gameID).Scan(&gameName, &prizesJSON)
+	if err != nil {
+		return nil, fmt.Errorf("game not found")
+	}
+
+	standings, err := computeGameStandings(tx, gameID)
+	if err != nil {
+		return nil, err
+	}
+
+	var winner interface{}
+	if len(standings) == 1 || (len(standings) > 1 && standings[1].Rank != 1) {
+		winner = standings[0].AvatarID
+	}
+
+	// Only the first finish counts
+	result, err := tx.Exec(`UPDATE games SET status = 'finished', finished_at = datetime('now'), paused_at = NULL, winner_avatar_id = ?
+		WHERE id = ? AND COALESCE(status, 'active') IN ('setup', 'active', 'paused')`, winner, gameID)
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return nil, fmt.Errorf("the game is already finished")
+	}
+
+	var prizes []GamePrize
+	if prizesJSON != "" {
+		json.Unmarshal([]byte(prizesJSON), &prizes)
+	}
+
+	for i := range standings {
+		standing := &standings[i]
+		if _, err := tx.Exec("UPDATE game_avatars SET final_rank = ? WHERE game_id = ? AND avatar_id = ?", standing.Rank, gameID, standing.AvatarID); err != nil {
+			return nil, err
+		}
+
+		var won []string
+		for _, prize := range prizes {
+			if prize.Place != 0 && prize.Place != standing.Rank {
+				continue
+			}
+
+			switch prize.Type {
+			case "coins":
+				if _, err := tx.Exec("UPDATE avatars SET coins = coins + ? WHERE id = ?", prize.Amount, standing.AvatarID); err != nil {
+					return nil, err
+				}
+				won = append(won, fmt.Sprintf("**%d** coins", prize.Amount))
+			case "xp":
+				if _, err := tx.Exec("UPDATE avatars SET xp_bank = COALESCE(xp_bank, 0) + ? WHERE id = ?", prize.Amount, standing.AvatarID); err != nil {
+					return nil, err
+				}
+				won = append(won, fmt.Sprintf("**%d** XP", prize.Amount))
+			case "asset":
+				// Hand out a copy from the store, same as buying one
+				var assetID int
+				err := tx.QueryRow(`SELECT id FROM assets
+					WHERE name = ? AND avatar_id IS NULL AND status = 'store'
+					AND (is_locked_by IS NULL OR is_locked_by = 0)
+					LIMIT 1`, prize.AssetName).Scan(&assetID)
+				if err != nil {
+					log.Printf("Game %d: no %s left in the store for avatar %d", gameID, prize.AssetName, standing.AvatarID)
+					continue
+				}
+				_, err = tx.Exec("UPDATE assets SET avatar_id = ?, status = 'warrior', is_locked_by = NULL, is_unlocked_for = NULL WHERE id = ?", standing.AvatarID, assetID)
+				if err != nil {
+					return nil, err
+				}
+				won = append(won, fmt.Sprintf("**%s**", prize.AssetName))
+			}
+		}
+		standing.Prizes = won
+
+		// Notify the player
+		var userID int
+		if err := tx.QueryRow("SELECT user_id FROM avatars WHERE id = ?", standing.AvatarID).Scan(&userID); err != nil {
+			continue
+		}
+		title := "Game Over"
+		message := fmt.Sprintf("**%s** is over. You finished **#%d**.", gameName, standing.Rank)
+		if len(won) > 0 {
+			message += fmt.Sprintf(" You won %s!", strings.Join(won, ", "))
+		}
+		message += fmt.Sprintf("\n\n[View Game](/play/%d)", gameID)
+		if _, err := tx.Exec("INSERT INTO notifications (user_id, title, message) VALUES (?, ?, ?)", userID, title, message); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	broadcastGameEvent(gameID, "game_finished", map[string]interface{}{
+		"reason":         reason,
+		"winnerAvatarId": winner,
+		"standings":      standings,
+	})
+
+	return standings, nil
+}
+
+// Start a game that is being set up (admin only). The first turn starts now.
+func startGame(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	vars := mux.Vars(r)
+	gameID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid game ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := db.Exec(`UPDATE games SET status = 'active', current_turn_index = 0, turn_start_time = datetime('now'), actions_taken = 0
+		WHERE id = ? AND status = 'setup'`, gameID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		http.Error(w, "Game not found or already started", http.StatusConflict)
+		return
+	}
+
+	broadcastGameEvent(gameID, "game_updated", map[string]interface{}{"status": "active"})
+	broadcastTurnChanged(gameID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"status":  "active",
+	})
+}
+
+// End a game now, whatever its win condition (admin only)
+func finishGameHandler(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	vars := mux.Vars(r)
+	gameID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid game ID", http.StatusBadRequest)
+		return
+	}
+
+	standings, err := finishGame(gameID, "admin")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"standings": standings,
+	})
+}
+
+// Get the standings of a game (final ones once it's finished)
+func getGameStandings(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	gameID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid game ID", http.StatusBadRequest)
+		return
+	}
+
+	var status, winCondition string
+	var winTarget, round int
+	var winnerAvatarID sql.NullInt64
+	err = db.QueryRow(`SELECT COALESCE(status, 'active'), COALESCE(win_condition, 'none'), COALESCE(win_target, 0), COALESCE(round, 0), winner_avatar_id
+		FROM games WHERE id = ?`, gameID).Scan(&status, &winCondition, &winTarget, &round, &winnerAvatarID)
+	if err != nil {
+		http.Error(w, "Game not found", http.StatusNotFound)
+		return
+	}
+
+	standings, err := computeGameStandings(db, gameID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// While a fogged game is on, players only count the enemy warriors and cells they can see
+	viewer := gameViewer(gameID, claims.UserID)
+	if fog, radius := gameFog(gameID); fog && viewer != 0 && status != "finished" {
+		board, err := loadFogBoard(db, gameID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		visible := board.visibleCells(viewer, radius)
+		seen := make(map[int]int)
+		for coord, ownerID := range board.owners {
+			if visible[board.cells[coord]] {
+				seen[ownerID]++
+			}
+		}
+		for i := range standings {
+			if standings[i].AvatarID != viewer {
+				standings[i].Cells = seen[standings[i].AvatarID]
+				standings[i].WarriorsLeft = seen[standings[i].AvatarID]
+			}
+		}
+		rankGameStandings(standings, winCondition)
+	}
+
+	// A finished game keeps the ranks it was finished with
+	if status == "finished" {
+		finalRanks := make(map[int]int)
+		rows, err := db.Query("SELECT avatar_id, final_rank FROM game_avatars WHERE game_id = ? AND final_rank IS NOT NULL", gameID)
+		if err == nil {
+			for rows.Next() {
+				var avatarID, rank int
+				if rows.Scan(&avatarID, &rank) == nil {
+					finalRanks[avatarID] = rank
+				}
+			}
+			rows.Close()
+		}
+		for i := range standings {
+			if rank, ok := finalRanks[standings[i].AvatarID]; ok {
+				standings[i].Rank = rank
+			}
+		}
+		sort.SliceStable(standings, func(i, j int) bool { return standings[i].Rank < standings[j].Rank })
+	}
+
+	var winner *int
+	if winnerAvatarID.Valid {
+		id := int(winnerAvatarID.Int64)
+		winner = &id
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":         status,
+		"winCondition":   winCondition,
+		"winTarget":      winTarget,
+		"round":          round,
+		"winnerAvatarId": winner,
+		"standings":      standings,
+	})
+}
+
+// Place a warrior on an empty cell as one of the avatar's turn actions. The turn check, the action budget and the
+// cell update run in one transaction, and the cell is only filled if it's still empty, so racing requests get a 409.
+// Returns the actions left, or the HTTP status to send with the error.
+func placeWarrior(gameID, cellID, warriorID, avatarID int) (int, int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, http.StatusInternalServerError, err
+	}
+	defer tx.Rollback()
+
+	// Only the avatar whose turn it is may act, and only within the turn's action budget
+	if status, err := checkGameTurn(tx, gameID, avatarID); err != nil {
+		return 0, status, err
+	}
+
+	cellIDs := append([]int{cellID}, teleportTargets(tx, cellID)...)
+	before, err := captureBoard(tx, gameID, cellIDs, []int{warriorID}, []int{avatarID})
+	if err != nil {
+		return 0, http.StatusInternalServerError, err
+	}
+
+	actionsRemaining, err := consumeGameAction(tx, gameID)
+	if err != nil {
+		return 0, http.StatusConflict, err
+	}
+
+	// Deploying gives the warrior its own state in this game; one knocked out of the game can't come back
+	warriorStatus, err := ensureGameWarrior(tx, gameID, warriorID)
+	if err != nil {
+		return 0, http.StatusInternalServerError, err
+	}
+	if warriorStatus != "active" {
+		return 0, http.StatusConflict, fmt.Errorf("this warrior is out of this game (%s)", warriorStatus)
+	}
+
+	// Place warrior on cell, unless someone got there first or the warrior is already on the board
+	result, err := tx.Exec(`UPDATE game_cells SET occupied_by = ?, status = 'warrior'
+		WHERE id = ? AND game_id = ? AND active = 1 AND COALESCE(occupied_by, 0) = 0
+			AND NOT EXISTS (SELECT 1 FROM game_cells WHERE game_id = ? AND occupied_by = ?)`,
+		warriorID, cellID, gameID, gameID, warriorID)
+	if err != nil {
+		return 0, http.StatusInternalServerError, err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return 0, http.StatusConflict, fmt.Errorf("this cell was just taken or the warrior is already on the board")
+	}
+	if err := syncGameWarriorCells(tx, gameID); err != nil {
+		return 0, http.StatusInternalServerError, err
+	}
+
+	effects, err := triggerCellEffects(tx, gameID, cellID, warriorID, "enter")
+	if err != nil {
+		return 0, http.StatusInternalServerError, err
+	}
+
+	// Whatever cell the warrior ends up on pays out its rewards
+	rewardCellID, coins, xp, err := claimRewardsUnderWarrior(tx, gameID, warriorID)
+	if err != nil {
+		return 0, http.StatusInternalServerError, err
+	}
+
+	after, err := captureBoard(tx, gameID, cellIDs, []int{warriorID}, []int{avatarID})
+	if err != nil {
+		return 0, http.StatusInternalServerError, err
+	}
+	eventID, err := recordGameEvent(tx, gameID, "warrior_placed", 0, avatarID, before, after)
+	if err != nil {
+		return 0, http.StatusInternalServerError, err
+	}
+	if err := linkRewardClaims(tx, gameID, eventID); err != nil {
+		return 0, http.StatusInternalServerError, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, http.StatusInternalServerError, err
+	}
+
+	broadcastGameEvent(gameID, "warrior_placed", map[string]interface{}{
+		"cellId":           cellID,
+		"warriorId":        warriorID,
+		"avatarId":         avatarID,
+		"actionsRemaining": actionsRemaining,
+	})
+	broadcastTriggeredEffects(gameID, effects)
+	if coins > 0 || xp > 0 {
+		broadcastRewardClaimed(gameID, rewardCellID, warriorID, avatarID, coins, xp)
+		broadcastCellUpdates(gameID, rewardCellID)
+	}
+	checkGameVictory(gameID)
+
+	return actionsRemaining, http.StatusOK, nil
+}
+
+// Move a warrior to an empty cell as one of the avatar's turn actions, in one transaction. The source cell is only
+// cleared if the warrior is still on it and the destination only filled if it's still empty; otherwise it's a 409.
+// Returns the actions left, or the HTTP status to send with the error.
+func moveWarriorOnBoard(gameID, fromCellID, toCellID, warriorID, avatarID int) (int, int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, http.StatusInternalServerError, err
+	}
+	defer tx.Rollback()
+
+	if status, err := checkGameTurn(tx, gameID, avatarID); err != nil {
+		return 0, status, err
+	}
+
+	cellIDs := append([]int{fromCellID, toCellID}, teleportTargets(tx, toCellID)...)
+	before, err := captureBoard(tx, gameID, cellIDs, []int{warriorID}, []int{avatarID})
+	if err != nil {
+		return 0, http.StatusInternalServerError, err
+	}
+
+	actionsRemaining, err := consumeGameAction(tx, gameID)
+	if err != nil {
+		return 0, http.StatusConflict, err
+	}
+
+	// Clear the from cell
+	result, err := tx.Exec(`UPDATE game_cells SET occupied_by = NULL, status = '' WHERE id = ? AND occupied_by = ?`, fromCellID, warriorID)
+	if err != nil {
+		return 0, http.StatusInternalServerError, err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return 0, http.StatusConflict, fmt.Errorf("the warrior is no longer on that cell")
+	}
+
+	// Place warrior on destination cell
+	result, err = tx.Exec(`UPDATE game_cells SET occupied_by = ?, status = 'warrior'
+		WHERE id = ? AND game_id = ? AND active = 1 AND COALESCE(occupied_by, 0) = 0`, warriorID, toCellID, gameID)
+	if err != nil {
+		return 0, http.StatusInternalServerError, err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return 0, http.StatusConflict, fmt.Errorf("the destination cell was just taken")
+	}
+
+	// Warriors placed before games kept their own state get one on their first move
+	if _, err := ensureGameWarrior(tx, gameID, warriorID); err != nil {
+		return 0, http.StatusInternalServerError, err
+	}
+	if err := syncGameWarriorCells(tx, gameID); err != nil {
+		return 0, http.StatusInternalServerError, err
+	}
+
+	effects, err := triggerCellEffects(tx, gameID, toCellID, warriorID, "enter")
+	if err != nil {
+		return 0, http.StatusInternalServerError, err
+	}
+
+	// Whatever cell the warrior ends up on pays out its rewards
+	rewardCellID, coins, xp, err := claimRewardsUnderWarrior(tx, gameID, warriorID)
+	if err != nil {
+		return 0, http.StatusInternalServerError, err
+	}
+
+	after, err := captureBoard(tx, gameID, cellIDs, []int{warriorID}, []int{avatarID})
+	if err != nil {
+		return 0, http.StatusInternalServerError, err
+	}
+	eventID, err := recordGameEvent(tx, gameID, "warrior_moved", 0, avatarID, before, after)
+	if err != nil {
+		return 0, http.StatusInternalServerError, err
+	}
+	if err := linkRewardClaims(tx, gameID, eventID); err != nil {
+		return 0, http.StatusInternalServerError, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, http.StatusInternalServerError, err
+	}
+
+	broadcastGameEvent(gameID, "warrior_moved", map[string]interface{}{
+		"fromCellId":       fromCellID,
+		"toCellId":         toCellID,
+		"warriorId":        warriorID,
+		"avatarId":         avatarID,
+		"actionsRemaining": actionsRemaining,
+	})
+	broadcastTriggeredEffects(gameID, effects)
+	if coins > 0 || xp > 0 {
+		broadcastRewardClaimed(gameID, rewardCellID, warriorID, avatarID, coins, xp)
+		broadcastCellUpdates(gameID, rewardCellID)
+	}
+	checkGameVictory(gameID)
+
+	return actionsRemaining, http.StatusOK, nil
+}
+
+// Place warrior on cell (for players)
+func placeWarriorOnCell(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	cellID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid cell ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		WarriorID int `json:"warriorId"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	// Verify the warrior belongs to the user's avatar
+	var avatarID int
+	err = db.QueryRow("SELECT id FROM avatars WHERE user_id = ?", claims.UserID).Scan(&avatarID)
+	if err != nil {
+		http.Error(w, "No avatar found for user", http.StatusNotFound)
+		return
+	}
+
+	// Verify the warrior belongs to this avatar
+	var warriorAvatarID int
+	var warriorStatus string
+	err = db.QueryRow("SELECT avatar_id, status FROM assets WHERE id = ?", req.WarriorID).Scan(&warriorAvatarID, &warriorStatus)
+	if err != nil {
+		http.Error(w, "Warrior not found", http.StatusNotFound)
+		return
+	}
+
+	if warriorAvatarID != avatarID {
+		http.Error(w, "This warrior does not belong to you", http.StatusForbidden)
+		return
+	}
+
+	if warriorStatus != "warrior" {
+		http.Error(w, "This asset is not a warrior", http.StatusBadRequest)
+		return
+	}
+
+	// Check if cell is active and not occupied
+	var cellActive int
+	var cellOccupiedBy sql.NullInt64
+	var gameID int
+	err = db.QueryRow("SELECT active, occupied_by, game_id FROM game_cells WHERE id = ?", cellID).Scan(&cellActive, &cellOccupiedBy, &gameID)
+	if err != nil {
+		http.Error(w, "Cell not found", http.StatusNotFound)
+		return
+	}
+
+	if cellActive != 1 {
+		http.Error(w, "This cell is not active", http.StatusBadRequest)
+		return
+	}
+
+	if cellOccupiedBy.Valid && cellOccupiedBy.Int64 != 0 {
+		http.Error(w, "This cell is already occupied", http.StatusConflict)
+		return
+	}
+
+	actionsRemaining, status, err := placeWarrior(gameID, cellID, req.WarriorID, avatarID)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":          true,
+		"actionsRemaining": actionsRemaining,
+	})
+}
+
+// Returned by pullBattleQuestion when the question bank has nothing left for the avatar
+var errNoBattleQuestions = fmt.Errorf("no battle questions available, ask your teacher to add some")
+
+// Give a battle one question from the question bank for an avatar: its own unused questions first, then unassigned ones
+func pullBattleQuestion(tx *sql.Tx, battleID int64, avatarID int) error {
+	var questionID int
+	err := tx.QueryRow(`SELECT id FROM battle_questions
+		WHERE battle_id IS NULL AND submitted_at IS NULL AND (user_id = ? OR user_id IS NULL)
+		ORDER BY user_id IS NULL, RANDOM()
+		LIMIT 1`, avatarID).Scan(&questionID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return errNoBattleQuestions
+		}
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE battle_questions SET battle_id = ?, user_id = ? WHERE id = ?", battleID, avatarID, questionID)
+	return err
+}
+
+// Start a battle when a warrior moves onto an enemy. Creates the battle, links it to the game
+// (freezing the board until it's resolved), pulls a question for each side and uses up the action, all in one transaction.
+// Returns the HTTP status to send with the error.
+func startBoardBattle(gameID, fromCellID, toCellID, attackerAssetID, attackerAvatarID int) (int64, int, int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, http.StatusInternalServerError, err
+	}
+	defer tx.Rollback()
+
+	if status, err := checkGameTurn(tx, gameID, attackerAvatarID); err != nil {
+		return 0, 0, status, err
+	}
+
+	// The board may have changed since the move was checked: the target must still be an enemy in range
+	from, _, moves, err := computeWarriorMoves(tx, gameID, attackerAssetID)
+	if err != nil {
+		return 0, 0, http.StatusConflict, err
+	}
+	if from.ID != fromCellID {
+		return 0, 0, http.StatusConflict, fmt.Errorf("the warrior is no longer on that cell")
+	}
+	inRange := false
+	for _, move := range moves {
+		if move.ID == toCellID && move.Attack {
+			inRange = true
+			break
+		}
+	}
+	if !inRange {
+		return 0, 0, http.StatusConflict, fmt.Errorf("there is no enemy in range on that cell")
+	}
+
+	before, err := captureBoard(tx, gameID, []int{fromCellID, toCellID}, []int{attackerAssetID}, nil)
+	if err != nil {
+		return 0, 0, http.StatusInternalServerError, err
+	}
+
+	actionsRemaining, err := consumeGameAction(tx, gameID)
+	if err != nil {
+		return 0, 0, http.StatusConflict, err
+	}
+
+	var defenderAssetID, defenderAvatarID int
+	var toCellName string
+	err = tx.QueryRow(`SELECT gc.occupied_by, a.avatar_id, gc.cell_id
+		FROM game_cells gc
+		JOIN assets a ON a.id = gc.occupied_by
+		WHERE gc.id = ?`, toCellID).Scan(&defenderAssetID, &defenderAvatarID, &toCellName)
+	if err == sql.ErrNoRows {
+		return 0, 0, http.StatusConflict, fmt.Errorf("there is no warrior to attack on that cell")
+	}
+	if err != nil {
+		return 0, 0, http.StatusInternalServerError, err
+	}
+	if defenderAvatarID == attackerAvatarID {
+		return 0, 0, http.StatusConflict, fmt.Errorf("you can't attack your own warrior")
+	}
+
+	// The battle is fought in the game's format
+	var format string
+	var autoResolve bool
+	err = tx.QueryRow("SELECT COALESCE(battle_format, 'single'), COALESCE(auto_resolve_battles, 0) FROM games WHERE id = ?", gameID).
+		Scan(&format, &autoResolve)
+	if err != nil {
+		return 0, 0, http.StatusInternalServerError, err
+	}
+
+	result, err := tx.Exec(`INSERT INTO battles (name, reward, status, attacker, defender, attacker_avatar_id, defender_avatar_id, game_id, from_cell_id, to_cell_id, format,
+		auto_resolve, round_started_at)
+		VALUES (?, '', 'in_progress', ?, ?, ?, ?, ?, ?, ?, ?, ?, datetime('now'))`,
+		fmt.Sprintf("Battle for %s", toCellName), attackerAssetID, defenderAssetID, attackerAvatarID, defenderAvatarID, gameID, fromCellID, toCellID, format, autoResolve)
+	if err != nil {
+		return 0, 0, http.StatusInternalServerError, err
+	}
+	battleID, _ := result.LastInsertId()
+
+	// Freeze the game; only one battle at a time
+	result, err = tx.Exec("UPDATE games SET battle_id = ? WHERE id = ? AND battle_id IS NULL", battleID, gameID)
+	if err != nil {
+		return 0, 0, http.StatusInternalServerError, err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return 0, 0, http.StatusConflict, fmt.Errorf("a battle is already in progress")
+	}
+
+	if err := pullBattleQuestion(tx, battleID, attackerAvatarID); err != nil {
+		return 0, 0, http.StatusConflict, err
+	}
+	if err := pullBattleQuestion(tx, battleID, defenderAvatarID); err != nil {
+		return 0, 0, http.StatusConflict, err
+	}
+
+	after, err := captureBoard(tx, gameID, []int{fromCellID, toCellID}, []int{attackerAssetID, defenderAssetID}, nil)
+	if err != nil {
+		return 0, 0, http.StatusInternalServerError, err
+	}
+	if _, err := recordGameEvent(tx, gameID, "battle_started", 0, attackerAvatarID, before, after); err != nil {
+		return 0, 0, http.StatusInternalServerError, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, http.StatusInternalServerError, err
+	}
+
+	broadcastGameEvent(gameID, "battle_started", map[string]interface{}{
+		"battleId":         battleID,
+		"attacker":         attackerAssetID,
+		"defender":         defenderAssetID,
+		"attackerAvatarId": attackerAvatarID,
+		"defenderAvatarId": defenderAvatarID,
+		"fromCellId":       fromCellID,
+		"toCellId":         toCellID,
+		"format":           format,
+	})
+
+	return battleID, actionsRemaining, http.StatusOK, nil
+}
+
+// Move warrior from one cell to another (for players)
+func moveWarrior(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		FromCellID int `json:"fromCellId"`
+		ToCellID   int `json:"toCellId"`
+		WarriorID  int `json:"warriorId"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	// Verify the warrior belongs to the user's avatar
+	var avatarID int
+	err = db.QueryRow("SELECT id FROM avatars WHERE user_id = ?", claims.UserID).Scan(&avatarID)
+	if err != nil {
+		http.Error(w, "No avatar found for user", http.StatusNotFound)
+		return
+	}
+
+	// Verify the warrior belongs to this avatar
+	var warriorAvatarID int
+	err = db.QueryRow("SELECT avatar_id FROM assets WHERE id = ?", req.WarriorID).Scan(&warriorAvatarID)
+	if err != nil {
+		http.Error(w, "Warrior not found", http.StatusNotFound)
+		return
+	}
+
+	if warriorAvatarID != avatarID {
+		http.Error(w, "This warrior does not belong to you", http.StatusForbidden)
+		return
+	}
+
+	// Verify the from cell has this warrior
+	var fromCellOccupiedBy sql.NullInt64
+	var fromGameID int
+	err = db.QueryRow("SELECT occupied_by, game_id FROM game_cells WHERE id = ?", req.FromCellID).Scan(&fromCellOccupiedBy, &fromGameID)
+	if err != nil {
+		http.Error(w, "From cell not found", http.StatusNotFound)
+		return
+	}
+
+	if !fromCellOccupiedBy.Valid || int(fromCellOccupiedBy.Int64) != req.WarriorID {
+		http.Error(w, "Warrior is not in the from cell", http.StatusBadRequest)
+		return
+	}
+
+	// Check if destination cell is active and not occupied by one of your own warriors
+	var toCellActive int
+	var toCellOwnerID sql.NullInt64
+	var toGameID int
+	err = db.QueryRow(`SELECT gc.active, a.avatar_id, gc.game_id
+		FROM game_cells gc
+		LEFT JOIN assets a ON a.id = gc.occupied_by
+		WHERE gc.id = ?`, req.ToCellID).Scan(&toCellActive, &toCellOwnerID, &toGameID)
+	if err != nil {
+		http.Error(w, "Destination cell not found", http.StatusNotFound)
+		return
+	}
+
+	if toCellActive != 1 {
+		http.Error(w, "Destination cell is not active", http.StatusBadRequest)
+		return
+	}
+
+	if toCellOwnerID.Valid && int(toCellOwnerID.Int64) == avatarID {
+		http.Error(w, "Destination cell is already occupied", http.StatusConflict)
+		return
+	}
+
+	// Verify both cells are in the same game
+	if fromGameID != toGameID {
+		http.Error(w, "Cells must be in the same game", http.StatusBadRequest)
+		return
+	}
+
+	// The destination must be within the warrior's movement range
+	_, _, moves, err := computeWarriorMoves(db, fromGameID, req.WarriorID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var target *WarriorMove
+	for i := range moves {
+		if moves[i].ID == req.ToCellID {
+			target = &moves[i]
+			break
+		}
+	}
+	if target == nil {
+		http.Error(w, "Destination is out of this warrior's range", http.StatusBadRequest)
+		return
+	}
+
+	// Moving onto an enemy warrior starts a battle; the winner ends up on the cell
+	if target.Attack {
+		battleID, actionsRemaining, status, err := startBoardBattle(fromGameID, req.FromCellID, req.ToCellID, req.WarriorID, avatarID)
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":          true,
+			"battleId":         battleID,
+			"actionsRemaining": actionsRemaining,
+		})
+		return
+	}
+
+	actionsRemaining, status, err := moveWarriorOnBoard(fromGameID, req.FromCellID, req.ToCellID, req.WarriorID, avatarID)
+	if err != nil {
+		http.Error(w, err.Error(), status)
 		return
 	}
 
-	gameID, _ := result.LastInsertId()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":          true,
+		"actionsRemaining": actionsRemaining,
+	})
+}
+
+// Give a warrior XP; every 100 XP is a level, and each level raises its base stats by 10%
+func addWarriorXP(q dbQuerier, warriorID, xp int) error {
+	var currentXP, level, baseAttack, baseDefense, baseHealing int
+	err := q.QueryRow("SELECT xp, level, base_attack, base_defense, base_healing FROM assets WHERE id = ?", warriorID).
+		Scan(&currentXP, &level, &baseAttack, &baseDefense, &baseHealing)
+	if err != nil {
+		return err
+	}
+
+	newXP := currentXP + xp
+	newLevel := level
+
+	// Check if warrior levels up (100 XP per level)
+	if newXP >= 100 {
+		levelsGained := newXP / 100
+		newLevel = level + levelsGained
+		newXP = newXP % 100
+
+		// Increase base stats by 10% per level
+		for i := 0; i < levelsGained; i++ {
+			baseAttack = int(float64(baseAttack) * 1.1)
+			baseDefense = int(float64(baseDefense) * 1.1)
+			baseHealing = int(float64(baseHealing) * 1.1)
+		}
+	}
+
+	_, err = q.Exec("UPDATE assets SET xp = ?, level = ?, base_attack = ?, base_defense = ?, base_healing = ? WHERE id = ?",
+		newXP, newLevel, baseAttack, baseDefense, baseHealing, warriorID)
+	return err
+}
+
+// Collect a cell's rewards for the warrior standing on it: coins go to its avatar (and this game's score), XP to the
+// warrior. The cell is emptied and the claim recorded in the same transaction, so the rewards can only go out once.
+// Returns 0, 0 when the warrior isn't on the cell or there's nothing to collect.
+func claimCellReward(tx *sql.Tx, gameID, cellID, warriorID int) (int, int, error) {
+	var coins, xp, avatarID, round int
+	err := tx.QueryRow(`SELECT COALESCE(gc.reward_coins, 0), COALESCE(gc.reward_xp, 0), COALESCE(a.avatar_id, 0), COALESCE(g.round, 0)
+		FROM game_cells gc
+		JOIN assets a ON a.id = gc.occupied_by
+		JOIN games g ON g.id = gc.game_id
+		WHERE gc.id = ? AND gc.game_id = ? AND gc.occupied_by = ?`, cellID, gameID, warriorID).Scan(&coins, &xp, &avatarID, &round)
+	if err == sql.ErrNoRows {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	if coins <= 0 && xp <= 0 {
+		return 0, 0, nil
+	}
+
+	result, err := tx.Exec(`UPDATE game_cells SET reward_coins = 0, reward_xp = 0, claimed_round = ?
+		WHERE id = ? AND COALESCE(reward_coins, 0) = ? AND COALESCE(reward_xp, 0) = ?`, round, cellID, coins, xp)
+	if err != nil {
+		return 0, 0, err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return 0, 0, nil
+	}
+
+	if coins > 0 {
+		if _, err := tx.Exec("UPDATE avatars SET coins = coins + ? WHERE id = ?", coins, avatarID); err != nil {
+			return 0, 0, err
+		}
+		_, err := tx.Exec("UPDATE game_avatars SET coins_collected = COALESCE(coins_collected, 0) + ? WHERE game_id = ? AND avatar_id = ?", coins, gameID, avatarID)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
 
-	// Store avatar IDs in turn order
-	for i, avatarID := range req.AvatarIDs {
-		_, err := db.Exec(`INSERT INTO game_avatars (game_id, avatar_id, turn_order) VALUES (?, ?, ?)`,
-			gameID, avatarID, i)
+	if xp > 0 {
+		if err := awardGameXP(tx, gameID, warriorID, xp); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	_, err = tx.Exec(`INSERT INTO cell_reward_claims (game_id, cell_id, asset_id, avatar_id, coins, xp, round)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, gameID, cellID, warriorID, avatarID, coins, xp, round)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return coins, xp, nil
+}
+
+// Collect the rewards of whichever cell a warrior ended up on after an action (teleports included)
+func claimRewardsUnderWarrior(tx *sql.Tx, gameID, warriorID int) (int, int, int, error) {
+	var cellID int
+	err := tx.QueryRow("SELECT id FROM game_cells WHERE game_id = ? AND occupied_by = ?", gameID, warriorID).Scan(&cellID)
+	if err == sql.ErrNoRows {
+		return 0, 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	coins, xp, err := claimCellReward(tx, gameID, cellID, warriorID)
+	return cellID, coins, xp, err
+}
+
+// Tie the claims made by an action to its entry in the event log, so undoing the action drops them
+func linkRewardClaims(q dbQuerier, gameID int, eventID int64) error {
+	_, err := q.Exec("UPDATE cell_reward_claims SET event_id = ? WHERE game_id = ? AND event_id IS NULL", eventID, gameID)
+	return err
+}
+
+// Tell everyone watching that a warrior collected a cell's rewards
+func broadcastRewardClaimed(gameID, cellID, warriorID, avatarID, coins, xp int) {
+	broadcastGameEvent(gameID, "reward_claimed", map[string]interface{}{
+		"cellId":      cellID,
+		"warriorId":   warriorID,
+		"avatarId":    avatarID,
+		"coinsGained": coins,
+		"xpGained":    xp,
+	})
+}
+
+// Refill the rewards of cells whose regeneration period has passed since they were emptied.
+// Returns the refilled cells.
+func regenerateCellRewards(tx *sql.Tx, gameID, round int) ([]int, error) {
+	rows, err := tx.Query(`SELECT id FROM game_cells
+		WHERE game_id = ? AND COALESCE(regen_rounds, 0) > 0 AND claimed_round IS NOT NULL AND claimed_round + regen_rounds <= ?
+			AND (COALESCE(base_reward_coins, 0) > 0 OR COALESCE(base_reward_xp, 0) > 0)`, gameID, round)
+	if err != nil {
+		return nil, err
+	}
+	var cellIDs []int
+	for rows.Next() {
+		var cellID int
+		if rows.Scan(&cellID) == nil {
+			cellIDs = append(cellIDs, cellID)
+		}
+	}
+	rows.Close()
+
+	for _, cellID := range cellIDs {
+		_, err := tx.Exec(`UPDATE game_cells SET reward_coins = base_reward_coins, reward_xp = base_reward_xp, claimed_round = NULL
+			WHERE id = ?`, cellID)
 		if err != nil {
+			return nil, err
+		}
+	}
+	return cellIDs, nil
+}
+
+// Claim cell rewards - add coins to avatar and XP to asset. Rewards are collected automatically when a warrior
+// enters a cell; this is for a warrior already standing on one (e.g. after the rewards regenerated).
+func claimCellRewards(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		CellID    int `json:"cellId"`
+		WarriorID int `json:"warriorId"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	// Get the user's avatar
+	var avatarID int
+	err = db.QueryRow("SELECT id FROM avatars WHERE user_id = ?", claims.UserID).Scan(&avatarID)
+	if err != nil {
+		http.Error(w, "No avatar found for user", http.StatusNotFound)
+		return
+	}
+
+	// Verify the warrior belongs to this avatar
+	var warriorAvatarID int
+	err = db.QueryRow("SELECT avatar_id FROM assets WHERE id = ?", req.WarriorID).Scan(&warriorAvatarID)
+	if err != nil {
+		http.Error(w, "Warrior not found", http.StatusNotFound)
+		return
+	}
+
+	if warriorAvatarID != avatarID {
+		http.Error(w, "This warrior does not belong to you", http.StatusForbidden)
+		return
+	}
+
+	var gameID int
+	err = db.QueryRow("SELECT game_id FROM game_cells WHERE id = ?", req.CellID).Scan(&gameID)
+	if err != nil {
+		http.Error(w, "Cell not found", http.StatusNotFound)
+		return
+	}
+
+	// Claiming runs in one transaction so the same rewards can't be collected twice
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Rewards can only be claimed during your own turn (claiming doesn't use up an action)
+	if status, err := checkGameTurn(tx, gameID, avatarID); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	// Only the warrior standing on the cell can collect its rewards
+	var onCell int
+	tx.QueryRow("SELECT COUNT(*) FROM game_cells WHERE id = ? AND game_id = ? AND occupied_by = ?", req.CellID, gameID, req.WarriorID).Scan(&onCell)
+	if onCell == 0 {
+		http.Error(w, "Your warrior has to be on this cell to claim its rewards", http.StatusBadRequest)
+		return
+	}
+
+	before, err := captureBoard(tx, gameID, []int{req.CellID}, []int{req.WarriorID}, []int{avatarID})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	coins, xp, err := claimCellReward(tx, gameID, req.CellID, req.WarriorID)
+	if err != nil {
+		http.Error(w, "Failed to claim cell rewards", http.StatusInternalServerError)
+		return
+	}
+	// Nothing left (usually already collected when the warrior entered the cell)
+	if coins == 0 && xp == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":     true,
+			"coinsGained": 0,
+			"xpGained":    0,
+		})
+		return
+	}
+
+	after, err := captureBoard(tx, gameID, []int{req.CellID}, []int{req.WarriorID}, []int{avatarID})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	eventID, err := recordGameEvent(tx, gameID, "reward_claimed", 0, avatarID, before, after)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := linkRewardClaims(tx, gameID, eventID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	broadcastRewardClaimed(gameID, req.CellID, req.WarriorID, avatarID, coins, xp)
+	broadcastCellUpdates(gameID, req.CellID)
+	checkGameVictory(gameID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"coinsGained": coins,
+		"xpGained":    xp,
+	})
+}
+
+// List the reward claims of a game, newest first
+func getGameRewardClaims(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	gameID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid game ID", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query(`SELECT id, cell_id, asset_id, avatar_id, coins, xp, round, claimed_at
+		FROM cell_reward_claims WHERE game_id = ? ORDER BY id DESC`, gameID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	// On a fogged board players only see their own claims and those on cells in sight
+	var visible map[int]bool
+	viewer := gameViewer(gameID, claims.UserID)
+	if fog, radius := gameFog(gameID); fog && viewer != 0 {
+		if visible, err = visibleCells(gameID, viewer, radius); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 	}
 
-	// Generate grid cells (chess-like notation: A1, A2, B1, B2, etc.)
-	// For rows > 26, use AA, AB, AC... (like Excel columns)
-	for row := 0; row < req.Rows; row++ {
-		var rowLetter string
-		if row < 26 {
-			rowLetter = string(rune('A' + row))
-		} else {
-			// For rows 26-51: AA, AB, AC... AZ
-			// For rows 52-77: BA, BB, BC... BZ
-			// etc.
-			firstLetter := rune('A' + (row / 26) - 1)
-			secondLetter := rune('A' + (row % 26))
-			rowLetter = string(firstLetter) + string(secondLetter)
-		}
-
-		for col := 1; col <= req.Columns; col++ {
-			cellID := fmt.Sprintf("%s%d", rowLetter, col)
-			_, err := db.Exec(`INSERT INTO game_cells (game_id, cell_id, name, background, active, occupied_by)
-				VALUES (?, ?, ?, ?, 1, 0)`,
-				gameID, cellID, cellID, "#3a3a3a") // Default charcoal background
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
+	claimsList := []CellRewardClaim{}
+	for rows.Next() {
+		var claim CellRewardClaim
+		if err := rows.Scan(&claim.ID, &claim.CellID, &claim.WarriorID, &claim.AvatarID, &claim.Coins, &claim.XP, &claim.Round, &claim.ClaimedAt); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if visible != nil && claim.AvatarID != viewer && !visible[claim.CellID] {
+			continue
+		}
+		claimsList = append(claimsList, claim)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(claimsList)
+}
+
+// Deplete warrior - remove from grid and mark as unavailable (exhausted status). In a game this only takes the
+// warrior out of the games where it ran out of stamina; outside games the asset itself is exhausted.
+func depleteWarrior(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	warriorID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid warrior ID", http.StatusBadRequest)
+		return
+	}
+
+	// Get warrior's current stamina
+	var stamina int
+	err = db.QueryRow("SELECT stamina FROM assets WHERE id = ?", warriorID).Scan(&stamina)
+	if err != nil {
+		http.Error(w, "Warrior not found", http.StatusNotFound)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Boards where the warrior stands with no stamina left in that game
+	occupiedCells := make(map[int][]int)
+	cellRows, err := tx.Query(`SELECT gc.id, gc.game_id
+		FROM game_cells gc
+		JOIN assets a ON a.id = gc.occupied_by
+		LEFT JOIN game_warriors gw ON gw.game_id = gc.game_id AND gw.asset_id = a.id
+		WHERE gc.occupied_by = ? AND COALESCE(gw.stamina, a.stamina) <= 0`, warriorID)
+	if err == nil {
+		for cellRows.Next() {
+			var cellID, gameID int
+			if cellRows.Scan(&cellID, &gameID) == nil {
+				occupiedCells[gameID] = append(occupiedCells[gameID], cellID)
 			}
 		}
+		cellRows.Close()
+	}
+
+	for gameID, cellIDs := range occupiedCells {
+		before, err := captureBoard(tx, gameID, cellIDs, []int{warriorID}, nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// Mark warrior as "exhausted" in this game and remove it from the board
+		if _, err := ensureGameWarrior(tx, gameID, warriorID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := knockOutGameWarrior(tx, gameID, warriorID, "exhausted"); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		after, err := captureBoard(tx, gameID, cellIDs, []int{warriorID}, nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if _, err := recordGameEvent(tx, gameID, "warrior_depleted", claims.UserID, 0, before, after); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Mark warrior as "exhausted" (unavailable due to stamina depletion) when its own stamina ran out
+	if stamina <= 0 {
+		_, err = tx.Exec("UPDATE assets SET status = 'exhausted' WHERE id = ?", warriorID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for gameID, cellIDs := range occupiedCells {
+		broadcastCellUpdates(gameID, cellIDs...)
+		checkGameVictory(gameID)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"gameId":  gameID,
-		"message": fmt.Sprintf("Game created with %d cells", req.Rows*req.Columns),
-	})
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
 }
 
-// Get all games
-func getGames(w http.ResponseWriter, r *http.Request) {
-	rows, err := db.Query("SELECT id, name, thumbnail, rows, columns, created_at FROM games ORDER BY created_at DESC")
+// Revive warrior - change status from "rip" back to "warrior" by paying the cost
+func reviveWarrior(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	warriorID := vars["id"]
+
+	// Start transaction
+	tx, err := db.Begin()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
-	var games []Game
-	for rows.Next() {
-		var game Game
-		if err := rows.Scan(&game.ID, &game.Name, &game.Thumbnail, &game.Rows, &game.Columns, &game.CreatedAt); err != nil {
+	// Get warrior details and verify it belongs to the user
+	var avatarID int
+	var status string
+	var cost int
+	err = tx.QueryRow("SELECT avatar_id, status, cost FROM assets WHERE id = ?", warriorID).Scan(&avatarID, &status, &cost)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Warrior not found", http.StatusNotFound)
+		} else {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
 		}
-		games = append(games, game)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(games)
-}
-
-// Get a specific game with all its cells
-func getGame(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	gameID := vars["id"]
-
-	var game Game
-	var turnStartTime sql.NullTime
-	var battleID sql.NullInt64
-	err := db.QueryRow("SELECT id, name, thumbnail, rows, columns, current_turn_index, turn_start_time, turn_duration, battle_id, created_at FROM games WHERE id = ?", gameID).
-		Scan(&game.ID, &game.Name, &game.Thumbnail, &game.Rows, &game.Columns, &game.CurrentTurnIndex, &turnStartTime, &game.TurnDuration, &battleID, &game.CreatedAt)
+	// Verify the warrior belongs to this user's avatar
+	var userID int
+	err = tx.QueryRow("SELECT user_id FROM avatars WHERE id = ?", avatarID).Scan(&userID)
 	if err != nil {
-		http.Error(w, "Game not found", http.StatusNotFound)
+		http.Error(w, "Avatar not found", http.StatusNotFound)
 		return
 	}
 
-	if turnStartTime.Valid {
-		game.TurnStartTime = &turnStartTime.Time
-		// Format as ISO 8601 without timezone (SQLite stores in UTC)
-		isoTime := turnStartTime.Time.UTC().Format("2006-01-02T15:04:05.000")
-		game.TurnStartTimeISO = &isoTime
+	if userID != claims.UserID {
+		http.Error(w, "This warrior does not belong to you", http.StatusForbidden)
+		return
 	}
 
-	if battleID.Valid {
-		battleIDInt := int(battleID.Int64)
-		game.BattleID = &battleIDInt
+	// Verify the warrior is actually dead (status = "rip")
+	if status != "rip" {
+		http.Error(w, "This warrior is not dead and cannot be revived", http.StatusBadRequest)
+		return
 	}
 
-	// Get avatar IDs in turn order
-	avatarRows, err := db.Query(`SELECT avatar_id FROM game_avatars WHERE game_id = ? ORDER BY turn_order`, gameID)
+	// Get user's current coins
+	var coins int
+	err = tx.QueryRow("SELECT coins FROM avatars WHERE id = ?", avatarID).Scan(&coins)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer avatarRows.Close()
 
-	var avatars []int
-	for avatarRows.Next() {
-		var avatarID int
-		if err := avatarRows.Scan(&avatarID); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+	// Check if user has enough coins
+	if coins < cost {
+		response := map[string]interface{}{
+			"success": false,
+			"message": "Not enough coins to revive this warrior",
+			"coins":   coins,
+			"cost":    cost,
 		}
-		avatars = append(avatars, avatarID)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+		return
 	}
-	game.Avatars = avatars
 
-	// Get all cells for this game
-	rows, err := db.Query(`SELECT id, game_id, cell_id, name, description, background, active, element, occupied_by, status, reward_coins, reward_xp
-		FROM game_cells WHERE game_id = ? ORDER BY cell_id`, gameID)
+	// Deduct coins
+	newCoins := coins - cost
+	_, err = tx.Exec("UPDATE avatars SET coins = ? WHERE id = ?", newCoins, avatarID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
 
-	var cells []GameCell
-	for rows.Next() {
-		var cell GameCell
-		var active int
-		var name, description, background, element, status sql.NullString
-		var occupiedBy, rewardCoins, rewardXP sql.NullInt64
-		if err := rows.Scan(&cell.ID, &cell.GameID, &cell.CellID, &name, &description, &background,
-			&active, &element, &occupiedBy, &status, &rewardCoins, &rewardXP); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		cell.Active = active == 1
-		if occupiedBy.Valid {
-			cell.OccupiedBy = int(occupiedBy.Int64)
-		}
-		if name.Valid {
-			cell.Name = name.String
-		}
-		if description.Valid {
-			cell.Description = description.String
-		}
-		if background.Valid {
-			cell.Background = background.String
-		}
-		if element.Valid {
-			cell.Element = element.String
-		}
-		if status.Valid {
-			cell.Status = status.String
-		}
-		if rewardCoins.Valid {
-			cell.RewardCoins = int(rewardCoins.Int64)
-		}
-		if rewardXP.Valid {
-			cell.RewardXP = int(rewardXP.Int64)
-		}
-		cells = append(cells, cell)
+	// Revive the warrior: change status from "exhausted" or "rip" to "warrior" and restore health and stamina
+	_, err = tx.Exec("UPDATE assets SET status = 'warrior', health = 100, stamina = 100 WHERE id = ?", warriorID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	// Get battle details if game has a battle linked
-	var battleResponse map[string]interface{}
-	if game.BattleID != nil {
-		var b Battle
-		err = db.QueryRow("SELECT id, name, reward, winner, date, status, attacker, defender, attacker_avatar_id, defender_avatar_id FROM battles WHERE id = ?", *game.BattleID).
-			Scan(&b.ID, &b.Name, &b.Reward, &b.Winner, &b.Date, &b.Status, &b.Attacker, &b.Defender, &b.AttackerAvatarID, &b.DefenderAvatarID)
-		if err == nil {
-			battleResponse = map[string]interface{}{
-				"id":               b.ID,
-				"name":             b.Name,
-				"reward":           b.Reward,
-				"winner":           b.Winner,
-				"date":             b.Date,
-				"status":           b.Status,
-				"attacker":         b.Attacker,
-				"defender":         b.Defender,
-				"attackerAvatarId": b.AttackerAvatarID,
-				"defenderAvatarId": b.DefenderAvatarID,
-			}
+	// Commit transaction
+	if err = tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-			// Get attacker question
-			if b.AttackerAvatarID != nil {
-				var q BattleQuestion
-				var submittedAt sql.NullTime
-				var userAnswer sql.NullString
-				err = db.QueryRow(`SELECT id, battle_id, question, answer, user_id, possible_points, received_score, time, user_answer, submitted_at
-					FROM battle_questions
-					WHERE user_id = ? AND battle_id = ?
-					ORDER BY id ASC
-					LIMIT 1`, *b.AttackerAvatarID, b.ID).
-					Scan(&q.ID, &q.BattleID, &q.Question, &q.Answer, &q.UserID,
-						&q.PossiblePoints, &q.ReceivedScore, &q.Time, &userAnswer, &submittedAt)
-				if err == nil {
-					if userAnswer.Valid {
-						q.UserAnswer = &userAnswer.String
-					}
-					if submittedAt.Valid {
-						submittedAtStr := submittedAt.Time.Format(time.RFC3339)
-						q.SubmittedAt = &submittedAtStr
-					}
-					battleResponse["attackerQuestion"] = q
-				}
-			}
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Warrior successfully revived",
+		"coins":   newCoins,
+	}
 
-			// Get defender question
-			if b.DefenderAvatarID != nil {
-				var q BattleQuestion
-				var submittedAt sql.NullTime
-				var userAnswer sql.NullString
-				err = db.QueryRow(`SELECT id, battle_id, question, answer, user_id, possible_points, received_score, time, user_answer, submitted_at
-					FROM battle_questions
-					WHERE user_id = ? AND battle_id = ?
-					ORDER BY id ASC
-					LIMIT 1`, *b.DefenderAvatarID, b.ID).
-					Scan(&q.ID, &q.BattleID, &q.Question, &q.Answer, &q.UserID,
-						&q.PossiblePoints, &q.ReceivedScore, &q.Time, &userAnswer, &submittedAt)
-				if err == nil {
-					if userAnswer.Valid {
-						q.UserAnswer = &userAnswer.String
-					}
-					if submittedAt.Valid {
-						submittedAtStr := submittedAt.Time.Format(time.RFC3339)
-						q.SubmittedAt = &submittedAtStr
-					}
-					battleResponse["defenderQuestion"] = q
-				}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Advance to next turn. Turns are advanced by the server's turn scheduler, so for players this
+// is a no-op that returns the current turn; admins can use it to force the next turn.
+func advanceTurn(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	gameID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid game ID", http.StatusBadRequest)
+		return
+	}
+
+	isAdmin := false
+	if claims, err := getUserFromToken(r); err == nil {
+		var role string
+		if err := db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role); err == nil {
+			isAdmin = role == "admin"
+		}
+	}
+
+	if isAdmin {
+		nextTurnIndex, err := advanceGameTurn(gameID, 0)
+		if err != nil {
+			if err.Error() == "game not found" {
+				http.Error(w, "Game not found", http.StatusNotFound)
+			} else {
+				http.Error(w, err.Error(), http.StatusConflict)
 			}
+			return
 		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":       true,
+			"newTurnIndex":  nextTurnIndex,
+			"turnStartTime": time.Now(),
+		})
+		return
+	}
+
+	var currentTurnIndex int
+	var turnStartTime sql.NullTime
+	err = db.QueryRow("SELECT COALESCE(current_turn_index, 0), turn_start_time FROM games WHERE id = ?", gameID).Scan(&currentTurnIndex, &turnStartTime)
+	if err != nil {
+		http.Error(w, "Game not found", http.StatusNotFound)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"game":   game,
-		"cells":  cells,
-		"battle": battleResponse,
+		"success":       true,
+		"message":       "Turns are advanced by the server",
+		"newTurnIndex":  currentTurnIndex,
+		"turnStartTime": turnStartTime.Time,
 	})
 }
 
-// Delete a game (cascades to delete all cells)
-func deleteGame(w http.ResponseWriter, r *http.Request) {
+// Pause a game's turn clock (admin only)
+func pauseGame(w http.ResponseWriter, r *http.Request) {
 	claims, err := getUserFromToken(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
@@ -3661,9 +12624,14 @@ func deleteGame(w http.ResponseWriter, r *http.Request) {
 	}
 
 	vars := mux.Vars(r)
-	gameID := vars["id"]
+	gameID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid game ID", http.StatusBadRequest)
+		return
+	}
 
-	result, err := db.Exec("DELETE FROM games WHERE id = ?", gameID)
+	result, err := db.Exec(`UPDATE games SET status = 'paused', paused_at = datetime('now')
+		WHERE id = ? AND COALESCE(status, 'active') = 'active'`, gameID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -3671,16 +12639,21 @@ func deleteGame(w http.ResponseWriter, r *http.Request) {
 
 	rowsAffected, _ := result.RowsAffected()
 	if rowsAffected == 0 {
-		http.Error(w, "Game not found", http.StatusNotFound)
+		http.Error(w, "Game not found or not active", http.StatusConflict)
 		return
 	}
 
+	broadcastGameEvent(gameID, "game_updated", map[string]interface{}{"status": "paused"})
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"status":  "paused",
+	})
 }
 
-// Update a game (name and thumbnail)
-func updateGame(w http.ResponseWriter, r *http.Request) {
+// Resume a paused game; the current turn keeps the time it had left (admin only)
+func resumeGame(w http.ResponseWriter, r *http.Request) {
 	claims, err := getUserFromToken(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
@@ -3696,33 +12669,41 @@ func updateGame(w http.ResponseWriter, r *http.Request) {
 	}
 
 	vars := mux.Vars(r)
-	gameID := vars["id"]
-
-	var req struct {
-		Name      string `json:"name"`
-		Thumbnail string `json:"thumbnail"`
-	}
-
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+	gameID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid game ID", http.StatusBadRequest)
 		return
 	}
 
-	// Update game
-	_, err = db.Exec(`UPDATE games SET name = ?, thumbnail = ? WHERE id = ?`,
-		req.Name, req.Thumbnail, gameID)
-
+	// Push the turn start forward by however long the game was paused
+	result, err := db.Exec(`UPDATE games SET status = 'active',
+		turn_start_time = datetime(COALESCE(turn_start_time, datetime('now')), '+' || (strftime('%s', 'now') - strftime('%s', COALESCE(paused_at, datetime('now')))) || ' seconds'),
+		paused_at = NULL
+		WHERE id = ? AND status = 'paused' AND COALESCE(archived, 0) = 0`, gameID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		http.Error(w, "Game not found, not paused or archived", http.StatusConflict)
+		return
+	}
+
+	broadcastGameEvent(gameID, "game_updated", map[string]interface{}{"status": "active"})
+	broadcastTurnChanged(gameID)
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"status":  "active",
+	})
 }
 
-// Update a game cell
-func updateGameCell(w http.ResponseWriter, r *http.Request) {
+// Archive a game or bring it back ({"archived": false}). Archived games are left out of the games list but
+// keep their board, events and results; a running game is paused first (admin only).
+func archiveGame(w http.ResponseWriter, r *http.Request) {
 	claims, err := getUserFromToken(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
@@ -3738,552 +12719,578 @@ func updateGameCell(w http.ResponseWriter, r *http.Request) {
 	}
 
 	vars := mux.Vars(r)
-	cellID := vars["id"]
-
-	var req struct {
-		Name        string `json:"name"`
-		Description string `json:"description"`
-		Background  string `json:"background"`
-		Active      bool   `json:"active"`
-		Element     string `json:"element"`
-		OccupiedBy  int    `json:"occupiedBy"`
-		Status      string `json:"status"`
-		RewardCoins int    `json:"rewardCoins"`
-		RewardXP    int    `json:"rewardXp"`
-	}
-
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+	gameID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid game ID", http.StatusBadRequest)
 		return
 	}
 
-	// Update cell
-	activeInt := 0
-	if req.Active {
-		activeInt = 1
+	req := struct {
+		Archived bool `json:"archived"`
+	}{Archived: true}
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
 	}
 
-	// Get the game_id for this cell
-	var gameID int
-	err = db.QueryRow("SELECT game_id FROM game_cells WHERE id = ?", cellID).Scan(&gameID)
+	var result sql.Result
+	if req.Archived {
+		result, err = db.Exec(`UPDATE games SET archived = 1, archived_at = datetime('now'),
+			status = CASE WHEN COALESCE(status, 'active') = 'active' THEN 'paused' ELSE status END,
+			paused_at = CASE WHEN COALESCE(status, 'active') = 'active' THEN datetime('now') ELSE paused_at END
+			WHERE id = ?`, gameID)
+	} else {
+		result, err = db.Exec("UPDATE games SET archived = 0, archived_at = NULL WHERE id = ?", gameID)
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	_, err = db.Exec(`UPDATE game_cells
-		SET name = ?, description = ?, background = ?, active = ?, element = ?, occupied_by = ?, status = ?, reward_coins = ?, reward_xp = ?
-		WHERE id = ?`,
-		req.Name, req.Description, req.Background, activeInt, req.Element, req.OccupiedBy, req.Status, req.RewardCoins, req.RewardXP, cellID)
-
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		http.Error(w, "Game not found", http.StatusNotFound)
 		return
 	}
 
+	var status string
+	db.QueryRow("SELECT COALESCE(status, 'active') FROM games WHERE id = ?", gameID).Scan(&status)
+	broadcastGameEvent(gameID, "game_updated", map[string]interface{}{"status": status, "archived": req.Archived})
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"archived": req.Archived,
+		"status":   status,
+	})
 }
 
-// Place warrior on cell (for players)
-func placeWarriorOnCell(w http.ResponseWriter, r *http.Request) {
+// Copy a game into a new one in setup: same settings, board (with its rewards full again) and players in the
+// same turn order, but no warriors on it and no history (admin only)
+func cloneGame(w http.ResponseWriter, r *http.Request) {
 	claims, err := getUserFromToken(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	vars := mux.Vars(r)
-	cellID := vars["id"]
-
-	var req struct {
-		WarriorID int `json:"warriorId"`
-	}
-
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
 		return
 	}
 
-	// Verify the warrior belongs to the user's avatar
-	var avatarID int
-	err = db.QueryRow("SELECT id FROM avatars WHERE user_id = ?", claims.UserID).Scan(&avatarID)
+	vars := mux.Vars(r)
+	gameID, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		http.Error(w, "No avatar found for user", http.StatusNotFound)
+		http.Error(w, "Invalid game ID", http.StatusBadRequest)
 		return
 	}
 
-	// Verify the warrior belongs to this avatar
-	var warriorAvatarID int
-	var warriorStatus string
-	err = db.QueryRow("SELECT avatar_id, status FROM assets WHERE id = ?", req.WarriorID).Scan(&warriorAvatarID, &warriorStatus)
+	var req struct {
+		Name string `json:"name"` // Optional, defaults to "<name> (copy)"
+	}
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	template, err := exportBoardTemplate(gameID)
 	if err != nil {
-		http.Error(w, "Warrior not found", http.StatusNotFound)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Game not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
 		return
 	}
+	if req.Name == "" {
+		req.Name = template.Name + " (copy)"
+	}
 
-	if warriorAvatarID != avatarID {
-		http.Error(w, "This warrior does not belong to you", http.StatusForbidden)
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	defer tx.Rollback()
 
-	if warriorStatus != "warrior" {
-		http.Error(w, "This asset is not a warrior", http.StatusBadRequest)
+	result, err := tx.Exec(`INSERT INTO games (name, thumbnail, rows, columns, current_turn_index, turn_start_time, turn_duration, actions_per_turn, actions_taken,
+		status, win_condition, win_target, prizes, keep_xp, permadeath, fog_of_war, vision_radius, topology, battle_format, auto_resolve_battles)
+		SELECT ?, thumbnail, rows, columns, 0, datetime('now'), turn_duration, actions_per_turn, 0,
+			'setup', win_condition, win_target, prizes, keep_xp, permadeath, fog_of_war, vision_radius, topology, battle_format, auto_resolve_battles
+		FROM games WHERE id = ?`, req.Name, gameID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	newGameID, _ := result.LastInsertId()
 
-	// Check if cell is active and not occupied
-	var cellActive int
-	var cellOccupiedBy sql.NullInt64
-	var gameID int
-	err = db.QueryRow("SELECT active, occupied_by, game_id FROM game_cells WHERE id = ?", cellID).Scan(&cellActive, &cellOccupiedBy, &gameID)
+	_, err = tx.Exec(`INSERT INTO game_avatars (game_id, avatar_id, turn_order, autopilot, autopilot_seed)
+		SELECT ?, avatar_id, turn_order, COALESCE(autopilot, 0), COALESCE(autopilot_seed, 0) FROM game_avatars WHERE game_id = ? ORDER BY turn_order`,
+		newGameID, gameID)
 	if err != nil {
-		http.Error(w, "Cell not found", http.StatusNotFound)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if cellActive != 1 {
-		http.Error(w, "This cell is not active", http.StatusBadRequest)
+	if err := createBoardCells(tx, int(newGameID), template.Rows, template.Columns, nil); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	if cellOccupiedBy.Valid && cellOccupiedBy.Int64 != 0 {
-		http.Error(w, "This cell is already occupied", http.StatusBadRequest)
+	if err := applyBoardTemplate(tx, int(newGameID), template); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Place warrior on cell
-	_, err = db.Exec(`UPDATE game_cells SET occupied_by = ?, status = ? WHERE id = ?`,
-		req.WarriorID, "warrior", cellID)
-
-	if err != nil {
+	if err := tx.Commit(); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"gameId":  newGameID,
+	})
 }
 
-// Move warrior from one cell to another (for players)
-func moveWarrior(w http.ResponseWriter, r *http.Request) {
+// Put a game back to the start, in setup: warriors off the board, rewards full again, turn and round counters at
+// zero, cell effects back to their first charges and scores cleared. The events stay for the record, but nothing
+// before the reset can be undone (admin only).
+func resetGame(w http.ResponseWriter, r *http.Request) {
 	claims, err := getUserFromToken(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	var req struct {
-		FromCellID int `json:"fromCellId"`
-		ToCellID   int `json:"toCellId"`
-		WarriorID  int `json:"warriorId"`
-	}
-
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
 		return
 	}
 
-	// Verify the warrior belongs to the user's avatar
-	var avatarID int
-	err = db.QueryRow("SELECT id FROM avatars WHERE user_id = ?", claims.UserID).Scan(&avatarID)
+	vars := mux.Vars(r)
+	gameID, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		http.Error(w, "No avatar found for user", http.StatusNotFound)
+		http.Error(w, "Invalid game ID", http.StatusBadRequest)
 		return
 	}
 
-	// Verify the warrior belongs to this avatar
-	var warriorAvatarID int
-	err = db.QueryRow("SELECT avatar_id FROM assets WHERE id = ?", req.WarriorID).Scan(&warriorAvatarID)
+	tx, err := db.Begin()
 	if err != nil {
-		http.Error(w, "Warrior not found", http.StatusNotFound)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	defer tx.Rollback()
 
-	if warriorAvatarID != avatarID {
-		http.Error(w, "This warrior does not belong to you", http.StatusForbidden)
+	var battleID sql.NullInt64
+	if err := tx.QueryRow("SELECT battle_id FROM games WHERE id = ?", gameID).Scan(&battleID); err != nil {
+		http.Error(w, "Game not found", http.StatusNotFound)
 		return
 	}
 
-	// Verify the from cell has this warrior
-	var fromCellOccupiedBy sql.NullInt64
-	var fromGameID int
-	err = db.QueryRow("SELECT occupied_by, game_id FROM game_cells WHERE id = ?", req.FromCellID).Scan(&fromCellOccupiedBy, &fromGameID)
-	if err != nil {
-		http.Error(w, "From cell not found", http.StatusNotFound)
+	// A battle in progress is called off
+	if battleID.Valid {
+		tx.Exec("UPDATE battles SET status = 'cancelled' WHERE id = ?", battleID.Int64)
+		tx.Exec("UPDATE battle_questions SET battle_id = NULL WHERE battle_id = ? AND submitted_at IS NULL", battleID.Int64)
+	}
+
+	statements := []string{
+		`UPDATE game_cells SET occupied_by = 0, status = '', claimed_round = NULL,
+			reward_coins = CASE WHEN COALESCE(base_reward_coins, 0) > 0 OR COALESCE(base_reward_xp, 0) > 0 THEN COALESCE(base_reward_coins, 0) ELSE reward_coins END,
+			reward_xp = CASE WHEN COALESCE(base_reward_coins, 0) > 0 OR COALESCE(base_reward_xp, 0) > 0 THEN COALESCE(base_reward_xp, 0) ELSE reward_xp END
+			WHERE game_id = ?`,
+		`DELETE FROM game_warriors WHERE game_id = ?`,
+		`DELETE FROM warrior_effects WHERE game_id = ?`,
+		`UPDATE cell_effects SET last_triggered_turn = NULL, charges = COALESCE(initial_charges, charges) WHERE game_id = ?`,
+		`UPDATE game_avatars SET coins_collected = 0, final_rank = NULL WHERE game_id = ?`,
+		`UPDATE games SET status = 'setup', current_turn_index = 0, turn_number = 0, round = 0, actions_taken = 0, battle_id = NULL,
+			winner_avatar_id = NULL, finished_at = NULL, paused_at = NULL, turn_start_time = datetime('now')
+			WHERE id = ?`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, gameID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// An event without a before state is where undo stops
+	if _, err := recordGameEvent(tx, gameID, "game_reset", claims.UserID, 0, nil, nil); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if !fromCellOccupiedBy.Valid || int(fromCellOccupiedBy.Int64) != req.WarriorID {
-		http.Error(w, "Warrior is not in the from cell", http.StatusBadRequest)
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Check if destination cell is active and not occupied
-	var toCellActive int
-	var toCellOccupiedBy sql.NullInt64
-	var toGameID int
-	err = db.QueryRow("SELECT active, occupied_by, game_id FROM game_cells WHERE id = ?", req.ToCellID).Scan(&toCellActive, &toCellOccupiedBy, &toGameID)
+	// Clients reload the whole board
+	if state, err := loadGameState(gameID); err == nil {
+		broadcastGameEvent(gameID, "board_replaced", map[string]interface{}{"cells": state["cells"]})
+	}
+	broadcastGameEvent(gameID, "game_updated", map[string]interface{}{"status": "setup"})
+	broadcastTurnChanged(gameID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"status":  "setup",
+	})
+}
+
+// Rewrite a game's turn order. The avatar whose turn it is keeps the turn (if it's still playing); otherwise the
+// avatar now in its seat gets a fresh turn, started as advanceGameTurn would. Runs inside the caller's transaction;
+// returns whether the turn changed hands and the turn-start effects that went off.
+func setGameTurnOrder(tx *sql.Tx, gameID int, avatarIDs []int, currentAvatarID int) (bool, []TriggeredEffect, error) {
+	var currentTurnIndex int
+	var status string
+	err := tx.QueryRow("SELECT COALESCE(current_turn_index, 0), COALESCE(status, 'active') FROM games WHERE id = ?", gameID).
+		Scan(&currentTurnIndex, &status)
 	if err != nil {
-		http.Error(w, "Destination cell not found", http.StatusNotFound)
-		return
+		return false, nil, err
 	}
 
-	if toCellActive != 1 {
-		http.Error(w, "Destination cell is not active", http.StatusBadRequest)
-		return
+	for i, avatarID := range avatarIDs {
+		if _, err := tx.Exec("UPDATE game_avatars SET turn_order = ? WHERE game_id = ? AND avatar_id = ?", i, gameID, avatarID); err != nil {
+			return false, nil, err
+		}
 	}
 
-	if toCellOccupiedBy.Valid && toCellOccupiedBy.Int64 != 0 {
-		http.Error(w, "Destination cell is already occupied", http.StatusBadRequest)
-		return
+	if len(avatarIDs) == 0 {
+		_, err := tx.Exec("UPDATE games SET current_turn_index = 0 WHERE id = ?", gameID)
+		return false, nil, err
 	}
 
-	// Verify both cells are in the same game
-	if fromGameID != toGameID {
-		http.Error(w, "Cells must be in the same game", http.StatusBadRequest)
-		return
+	for i, avatarID := range avatarIDs {
+		if avatarID == currentAvatarID {
+			_, err := tx.Exec("UPDATE games SET current_turn_index = ? WHERE id = ?", i, gameID)
+			return false, nil, err
+		}
 	}
 
-	// Clear the from cell
-	_, err = db.Exec(`UPDATE game_cells SET occupied_by = NULL, status = '' WHERE id = ?`, req.FromCellID)
+	// The current avatar left: whoever now sits in its seat starts a new turn
+	seat := currentTurnIndex % len(avatarIDs)
+	_, err = tx.Exec(`UPDATE games SET current_turn_index = ?, turn_start_time = datetime('now'), actions_taken = 0,
+		turn_number = COALESCE(turn_number, 0) + 1 WHERE id = ?`, seat, gameID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return false, nil, err
 	}
 
-	// Place warrior on destination cell
-	_, err = db.Exec(`UPDATE game_cells SET occupied_by = ?, status = ? WHERE id = ?`,
-		req.WarriorID, "warrior", req.ToCellID)
+	// Before the game starts nobody's turn has effects
+	if status == "setup" {
+		return true, nil, nil
+	}
+	effects, _, err := startAvatarTurn(tx, gameID, avatarIDs[seat], false)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return false, nil, err
 	}
+	return true, effects, nil
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+// Avatars of a game in turn order
+func gameAvatarOrder(q dbQuerier, gameID int) ([]int, error) {
+	rows, err := q.Query("SELECT avatar_id FROM game_avatars WHERE game_id = ? ORDER BY turn_order, id", gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var avatarIDs []int
+	for rows.Next() {
+		var avatarID int
+		if err := rows.Scan(&avatarID); err != nil {
+			return nil, err
+		}
+		avatarIDs = append(avatarIDs, avatarID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return avatarIDs, nil
 }
 
-// Claim cell rewards - add coins to avatar and XP to asset
-func claimCellRewards(w http.ResponseWriter, r *http.Request) {
+// Change who plays in a game, and in what order (admin only):
+//   - POST   /games/{id}/avatars {"avatarId": N, "position": P}: add a player (at the end if no position is given)
+//   - DELETE /games/{id}/avatars/{avatarId}: remove a player; its warriors leave the board
+//   - PUT    /games/{id}/turn-order {"avatarIds": [...]} or {"shuffle": true, "seed": S}: reorder the players
+func changeGamePlayers(w http.ResponseWriter, r *http.Request) {
 	claims, err := getUserFromToken(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	var req struct {
-		CellID    int `json:"cellId"`
-		WarriorID int `json:"warriorId"`
-	}
-
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
 		return
 	}
 
-	// Get the user's avatar
-	var avatarID int
-	err = db.QueryRow("SELECT id FROM avatars WHERE user_id = ?", claims.UserID).Scan(&avatarID)
+	vars := mux.Vars(r)
+	gameID, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		http.Error(w, "No avatar found for user", http.StatusNotFound)
+		http.Error(w, "Invalid game ID", http.StatusBadRequest)
 		return
 	}
 
-	// Verify the warrior belongs to this avatar
-	var warriorAvatarID int
-	err = db.QueryRow("SELECT avatar_id FROM assets WHERE id = ?", req.WarriorID).Scan(&warriorAvatarID)
+	var req struct {
+		AvatarID  int    `json:"avatarId"`
+		Position  *int   `json:"position"`
+		AvatarIDs []int  `json:"avatarIds"`
+		Shuffle   bool   `json:"shuffle"`
+		Seed      *int64 `json:"seed"`
+	}
+	if r.Method != http.MethodDelete {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	tx, err := db.Begin()
 	if err != nil {
-		http.Error(w, "Warrior not found", http.StatusNotFound)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	defer tx.Rollback()
 
-	if warriorAvatarID != avatarID {
-		http.Error(w, "This warrior does not belong to you", http.StatusForbidden)
+	var status string
+	var battleID sql.NullInt64
+	if err := tx.QueryRow("SELECT COALESCE(status, 'active'), battle_id FROM games WHERE id = ?", gameID).Scan(&status, &battleID); err != nil {
+		http.Error(w, "Game not found", http.StatusNotFound)
+		return
+	}
+	if status == "finished" {
+		http.Error(w, "The game is finished", http.StatusConflict)
+		return
+	}
+	if battleID.Valid {
+		http.Error(w, "Wait for the battle to finish", http.StatusConflict)
 		return
 	}
 
-	// Get cell rewards
-	var rewardCoins, rewardXP sql.NullInt64
-	err = db.QueryRow("SELECT reward_coins, reward_xp FROM game_cells WHERE id = ?", req.CellID).Scan(&rewardCoins, &rewardXP)
+	order, err := gameAvatarOrder(tx, gameID)
 	if err != nil {
-		http.Error(w, "Cell not found", http.StatusNotFound)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	coins := 0
-	xp := 0
-	if rewardCoins.Valid {
-		coins = int(rewardCoins.Int64)
+	var currentAvatarID, currentTurnIndex int
+	tx.QueryRow("SELECT COALESCE(current_turn_index, 0) FROM games WHERE id = ?", gameID).Scan(&currentTurnIndex)
+	if currentTurnIndex < len(order) {
+		currentAvatarID = order[currentTurnIndex]
 	}
-	if rewardXP.Valid {
-		xp = int(rewardXP.Int64)
+
+	playing := make(map[int]bool)
+	for _, avatarID := range order {
+		playing[avatarID] = true
 	}
 
-	// Add coins to avatar if there are any
-	if coins > 0 {
-		_, err = db.Exec("UPDATE avatars SET coins = coins + ? WHERE id = ?", coins, avatarID)
-		if err != nil {
-			http.Error(w, "Failed to update avatar coins", http.StatusInternalServerError)
+	var clearedCells []int
+	switch {
+	case r.Method == http.MethodPost:
+		if playing[req.AvatarID] {
+			http.Error(w, "This avatar already plays in this game", http.StatusConflict)
 			return
 		}
-	}
-
-	// Add XP to warrior if there is any
-	if xp > 0 {
-		// Get current warrior stats
-		var currentXP, level, baseAttack, baseDefense, baseHealing int
-		err = db.QueryRow("SELECT xp, level, base_attack, base_defense, base_healing FROM assets WHERE id = ?", req.WarriorID).
-			Scan(&currentXP, &level, &baseAttack, &baseDefense, &baseHealing)
-		if err != nil {
-			http.Error(w, "Failed to get warrior stats", http.StatusInternalServerError)
+		var exists int
+		if tx.QueryRow("SELECT 1 FROM avatars WHERE id = ?", req.AvatarID).Scan(&exists) != nil {
+			http.Error(w, "Avatar not found", http.StatusNotFound)
 			return
 		}
-
-		newXP := currentXP + xp
-		newLevel := level
-
-		// Check if warrior levels up (100 XP per level)
-		if newXP >= 100 {
-			levelsGained := newXP / 100
-			newLevel = level + levelsGained
-			newXP = newXP % 100
-
-			// Increase base stats by 10% per level
-			for i := 0; i < levelsGained; i++ {
-				baseAttack = int(float64(baseAttack) * 1.1)
-				baseDefense = int(float64(baseDefense) * 1.1)
-				baseHealing = int(float64(baseHealing) * 1.1)
+		position := len(order)
+		if req.Position != nil {
+			if *req.Position < 0 || *req.Position > len(order) {
+				http.Error(w, fmt.Sprintf("Position must be between 0 and %d", len(order)), http.StatusBadRequest)
+				return
 			}
+			position = *req.Position
+		}
+		if _, err := tx.Exec("INSERT INTO game_avatars (game_id, avatar_id, turn_order) VALUES (?, ?, ?)", gameID, req.AvatarID, position); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
+		order = append(order[:position], append([]int{req.AvatarID}, order[position:]...)...)
 
-		// Update warrior
-		_, err = db.Exec("UPDATE assets SET xp = ?, level = ?, base_attack = ?, base_defense = ?, base_healing = ? WHERE id = ?",
-			newXP, newLevel, baseAttack, baseDefense, baseHealing, req.WarriorID)
+	case r.Method == http.MethodDelete:
+		avatarID, err := strconv.Atoi(vars["avatarId"])
 		if err != nil {
-			http.Error(w, "Failed to update warrior XP", http.StatusInternalServerError)
+			http.Error(w, "Invalid avatar ID", http.StatusBadRequest)
+			return
+		}
+		if !playing[avatarID] {
+			http.Error(w, "Avatar not found in this game", http.StatusNotFound)
 			return
 		}
-	}
-
-	// Clear the rewards from the cell so they can't be claimed again
-	_, err = db.Exec("UPDATE game_cells SET reward_coins = 0, reward_xp = 0 WHERE id = ?", req.CellID)
-	if err != nil {
-		http.Error(w, "Failed to clear cell rewards", http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success":     true,
-		"coinsGained": coins,
-		"xpGained":    xp,
-	})
-}
-
-// Deplete warrior - remove from grid and mark as unavailable (exhausted status)
-func depleteWarrior(w http.ResponseWriter, r *http.Request) {
-	_, err := getUserFromToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	vars := mux.Vars(r)
-	warriorID := vars["id"]
-
-	// Get warrior's current stamina
-	var stamina int
-	err = db.QueryRow("SELECT stamina FROM assets WHERE id = ?", warriorID).Scan(&stamina)
-	if err != nil {
-		http.Error(w, "Warrior not found", http.StatusNotFound)
-		return
-	}
 
-	// Only deplete if stamina is <= 0
-	if stamina <= 0 {
-		// Remove warrior from any game cells
-		_, err = db.Exec("UPDATE game_cells SET occupied_by = NULL, status = '' WHERE occupied_by = ?", warriorID)
+		// Its warriors leave the board
+		cellRows, err := tx.Query(`SELECT gc.id FROM game_cells gc JOIN assets a ON a.id = gc.occupied_by
+			WHERE gc.game_id = ? AND a.avatar_id = ?`, gameID, avatarID)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-
-		// Mark warrior as "exhausted" (unavailable due to stamina depletion)
-		_, err = db.Exec("UPDATE assets SET status = 'exhausted' WHERE id = ?", warriorID)
-		if err != nil {
+		for cellRows.Next() {
+			var cellID int
+			if cellRows.Scan(&cellID) == nil {
+				clearedCells = append(clearedCells, cellID)
+			}
+		}
+		cellRows.Close()
+		for _, cellID := range clearedCells {
+			if _, err := tx.Exec("UPDATE game_cells SET occupied_by = 0, status = '' WHERE id = ?", cellID); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := syncGameWarriorCells(tx, gameID); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-	}
+		if _, err := tx.Exec("DELETE FROM game_avatars WHERE game_id = ? AND avatar_id = ?", gameID, avatarID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for i, id := range order {
+			if id == avatarID {
+				order = append(order[:i], order[i+1:]...)
+				break
+			}
+		}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]bool{"success": true})
-}
+	case req.Shuffle:
+		seed := time.Now().UnixNano()
+		if req.Seed != nil {
+			seed = *req.Seed
+		}
+		rand.New(rand.NewSource(seed)).Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
 
-// Revive warrior - change status from "rip" back to "warrior" by paying the cost
-func reviveWarrior(w http.ResponseWriter, r *http.Request) {
-	claims, err := getUserFromToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
+	default:
+		if len(req.AvatarIDs) != len(order) {
+			http.Error(w, "The new order must list every player once", http.StatusBadRequest)
+			return
+		}
+		seen := make(map[int]bool)
+		for _, avatarID := range req.AvatarIDs {
+			if !playing[avatarID] || seen[avatarID] {
+				http.Error(w, "The new order must list every player once", http.StatusBadRequest)
+				return
+			}
+			seen[avatarID] = true
+		}
+		order = req.AvatarIDs
 	}
 
-	vars := mux.Vars(r)
-	warriorID := vars["id"]
-
-	// Start transaction
-	tx, err := db.Begin()
+	turnChanged, effects, err := setGameTurnOrder(tx, gameID, order, currentAvatarID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer tx.Rollback()
 
-	// Get warrior details and verify it belongs to the user
-	var avatarID int
-	var status string
-	var cost int
-	err = tx.QueryRow("SELECT avatar_id, status, cost FROM assets WHERE id = ?", warriorID).Scan(&avatarID, &status, &cost)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "Warrior not found", http.StatusNotFound)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+	// Moving players around isn't something undo can take back
+	if _, err := recordGameEvent(tx, gameID, "players_changed", claims.UserID, 0, nil, nil); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Verify the warrior belongs to this user's avatar
-	var userID int
-	err = tx.QueryRow("SELECT user_id FROM avatars WHERE id = ?", avatarID).Scan(&userID)
-	if err != nil {
-		http.Error(w, "Avatar not found", http.StatusNotFound)
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if userID != claims.UserID {
-		http.Error(w, "This warrior does not belong to you", http.StatusForbidden)
-		return
+	broadcastGameEvent(gameID, "game_updated", map[string]interface{}{"avatars": order})
+	broadcastCellUpdates(gameID, clearedCells...)
+	if turnChanged || r.Method != http.MethodPost {
+		broadcastTurnChanged(gameID)
 	}
+	broadcastTriggeredEffects(gameID, effects)
 
-	// Verify the warrior is actually dead (status = "rip")
-	if status != "rip" {
-		http.Error(w, "This warrior is not dead and cannot be revived", http.StatusBadRequest)
-		return
-	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"avatars": order,
+	})
+}
 
-	// Get user's current coins
-	var coins int
-	err = tx.QueryRow("SELECT coins FROM avatars WHERE id = ?", avatarID).Scan(&coins)
+// Mark an avatar as absent (skipped by the turn clock) or present again (admin only)
+func setAvatarAbsent(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	// Check if user has enough coins
-	if coins < cost {
-		response := map[string]interface{}{
-			"success": false,
-			"message": "Not enough coins to revive this warrior",
-			"coins":   coins,
-			"cost":    cost,
-		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
 		return
 	}
 
-	// Deduct coins
-	newCoins := coins - cost
-	_, err = tx.Exec("UPDATE avatars SET coins = ? WHERE id = ?", newCoins, avatarID)
+	vars := mux.Vars(r)
+	gameID, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Invalid game ID", http.StatusBadRequest)
 		return
 	}
-
-	// Revive the warrior: change status from "exhausted" or "rip" to "warrior" and restore health and stamina
-	_, err = tx.Exec("UPDATE assets SET status = 'warrior', health = 100, stamina = 100 WHERE id = ?", warriorID)
+	avatarID, err := strconv.Atoi(vars["avatarId"])
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Commit transaction
-	if err = tx.Commit(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Invalid avatar ID", http.StatusBadRequest)
 		return
 	}
 
-	response := map[string]interface{}{
-		"success": true,
-		"message": "Warrior successfully revived",
-		"coins":   newCoins,
+	var req struct {
+		Absent bool `json:"absent"`
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
-}
-
-// Advance to next turn (automatically called when timer expires or manually by admin)
-func advanceTurn(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	gameID := vars["id"]
-
-	// Get current turn info including turn start time
-	var currentTurnIndex, avatarCount int
-	var turnStartTime *time.Time
-	err := db.QueryRow("SELECT current_turn_index, turn_start_time FROM games WHERE id = ?", gameID).Scan(&currentTurnIndex, &turnStartTime)
-	if err != nil {
-		http.Error(w, "Game not found", http.StatusNotFound)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
 
-	// Prevent rapid turn advancement (must be at least 0.5 seconds since last turn started)
-	// This prevents race conditions where multiple clients try to advance simultaneously
-	if turnStartTime != nil {
-		elapsed := time.Since(*turnStartTime).Seconds()
-		if elapsed < 0.5 {
-			// Turn was just started, don't advance yet - silently return success
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"success": true,
-				"message": "Turn just started, skipping advance",
-				"elapsed": elapsed,
-			})
-			return
-		}
+	absentInt := 0
+	if req.Absent {
+		absentInt = 1
 	}
 
-	// Get total number of avatars in this game
-	err = db.QueryRow("SELECT COUNT(*) FROM game_avatars WHERE game_id = ?", gameID).Scan(&avatarCount)
+	result, err := db.Exec("UPDATE game_avatars SET absent = ? WHERE game_id = ? AND avatar_id = ?", absentInt, gameID, avatarID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if avatarCount == 0 {
-		http.Error(w, "No avatars in game", http.StatusBadRequest)
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		http.Error(w, "Avatar not found in this game", http.StatusNotFound)
 		return
 	}
 
-	// Calculate next turn index (wrap around)
-	nextTurnIndex := (currentTurnIndex + 1) % avatarCount
-
-	// Update game with new turn
-	_, err = db.Exec(`UPDATE games SET current_turn_index = ?, turn_start_time = datetime('now') WHERE id = ?`,
-		nextTurnIndex, gameID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	// Don't make the class wait for someone who just left
+	if req.Absent {
+		if _, err := advanceGameTurn(gameID, avatarID); err != nil && err.Error() != "turn was already advanced" {
+			log.Printf("Error advancing turn past absent avatar %d: %v", avatarID, err)
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success":       true,
-		"newTurnIndex":  nextTurnIndex,
-		"turnStartTime": time.Now(),
+		"success": true,
+		"absent":  req.Absent,
 	})
 }
 
@@ -4304,7 +13311,11 @@ func setTurn(w http.ResponseWriter, r *http.Request) {
 	}
 
 	vars := mux.Vars(r)
-	gameID := vars["id"]
+	gameID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid game ID", http.StatusBadRequest)
+		return
+	}
 
 	var req struct {
 		AvatarID int `json:"avatarId"`
@@ -4315,23 +13326,35 @@ func setTurn(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Find the turn order index for this avatar in this game
-	var turnOrder int
-	err = db.QueryRow(`SELECT turn_order FROM game_avatars WHERE game_id = ? AND avatar_id = ?`,
-		gameID, req.AvatarID).Scan(&turnOrder)
+	// current_turn_index is a position in the turn order, not the raw turn_order value (which can have gaps)
+	order, err := gameAvatarOrder(db, gameID)
 	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	turnOrder := -1
+	for i, avatarID := range order {
+		if avatarID == req.AvatarID {
+			turnOrder = i
+			break
+		}
+	}
+	if turnOrder < 0 {
 		http.Error(w, "Avatar not found in this game", http.StatusNotFound)
 		return
 	}
 
 	// Update game to set the current turn to this avatar
-	_, err = db.Exec(`UPDATE games SET current_turn_index = ?, turn_start_time = datetime('now') WHERE id = ?`,
+	_, err = db.Exec(`UPDATE games SET current_turn_index = ?, turn_start_time = datetime('now'), actions_taken = 0,
+		turn_number = COALESCE(turn_number, 0) + 1 WHERE id = ?`,
 		turnOrder, gameID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	broadcastTurnChanged(gameID)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success":       true,
@@ -4359,6 +13382,8 @@ func createBattle(w http.ResponseWriter, r *http.Request) {
 		AttackerAvatarID *int    `json:"attackerAvatarId"` // Avatar ID of attacker
 		DefenderAvatarID *int    `json:"defenderAvatarId"` // Avatar ID of defender
 		GameID           *int    `json:"gameId"`           // Optional game ID to link battle to game
+		Format           string  `json:"format"`           // Optional, defaults to "single" (see battleFormats)
+		AutoResolve      bool    `json:"autoResolve"`      // Optional: resolve rounds once both sides answered or time ran out
 		Questions        []struct {
 			Question       string `json:"question"`
 			Answer         string `json:"answer"`
@@ -4378,9 +13403,25 @@ func createBattle(w http.ResponseWriter, r *http.Request) {
 		status = *req.Status
 	}
 
+	if req.Format == "" {
+		req.Format = "single"
+	}
+	if _, ok := battleFormats[req.Format]; !ok {
+		http.Error(w, "Format must be single, best_of_3, best_of_5 or until_ko", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
 	// Create battle
-	result, err := db.Exec("INSERT INTO battles (name, reward, status, attacker, defender, attacker_avatar_id, defender_avatar_id, game_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
-		req.Name, req.Reward, status, req.Attacker, req.Defender, req.AttackerAvatarID, req.DefenderAvatarID, req.GameID)
+	result, err := tx.Exec(`INSERT INTO battles (name, reward, status, attacker, defender, attacker_avatar_id, defender_avatar_id, game_id, format, auto_resolve, round_started_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CASE WHEN ? = 'in_progress' THEN datetime('now') END)`,
+		req.Name, req.Reward, status, req.Attacker, req.Defender, req.AttackerAvatarID, req.DefenderAvatarID, req.GameID, req.Format, req.AutoResolve, status)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -4388,18 +13429,22 @@ func createBattle(w http.ResponseWriter, r *http.Request) {
 
 	battleID, _ := result.LastInsertId()
 
-	// Link battle to game if gameId is provided
+	// Link battle to game if gameId is provided (only one battle at a time)
 	if req.GameID != nil {
-		_, err = db.Exec("UPDATE games SET battle_id = ? WHERE id = ?", battleID, *req.GameID)
+		result, err = tx.Exec("UPDATE games SET battle_id = ? WHERE id = ? AND battle_id IS NULL", battleID, *req.GameID)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			http.Error(w, "A battle is already in progress in this game", http.StatusConflict)
+			return
+		}
 	}
 
 	// Create questions (optional - only if provided)
 	for _, q := range req.Questions {
-		_, err := db.Exec(`INSERT INTO battle_questions
+		_, err := tx.Exec(`INSERT INTO battle_questions
 			(battle_id, question, answer, possible_points, time)
 			VALUES (?, ?, ?, ?, ?)`,
 			battleID, q.Question, q.Answer, q.PossiblePoints, q.Time)
@@ -4409,6 +13454,22 @@ func createBattle(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if req.GameID != nil {
+		broadcastGameEvent(*req.GameID, "battle_started", map[string]interface{}{
+			"battleId":         battleID,
+			"attacker":         req.Attacker,
+			"defender":         req.Defender,
+			"attackerAvatarId": req.AttackerAvatarID,
+			"defenderAvatarId": req.DefenderAvatarID,
+			"format":           req.Format,
+		})
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success":  true,
@@ -4424,7 +13485,8 @@ func getBattles(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rows, err := db.Query("SELECT id, name, reward, winner, date, status, attacker, defender, attacker_avatar_id, defender_avatar_id, game_id FROM battles ORDER BY date DESC")
+	rows, err := db.Query(`SELECT id, name, reward, winner, date, status, attacker, defender, attacker_avatar_id, defender_avatar_id, game_id,
+		COALESCE(format, 'single'), COALESCE(auto_resolve, 0), COALESCE(draw, 0), resolved_by FROM battles ORDER BY date DESC`)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -4434,7 +13496,7 @@ func getBattles(w http.ResponseWriter, r *http.Request) {
 	var battles []Battle
 	for rows.Next() {
 		var b Battle
-		err := rows.Scan(&b.ID, &b.Name, &b.Reward, &b.Winner, &b.Date, &b.Status, &b.Attacker, &b.Defender, &b.AttackerAvatarID, &b.DefenderAvatarID, &b.GameID)
+		err := rows.Scan(&b.ID, &b.Name, &b.Reward, &b.Winner, &b.Date, &b.Status, &b.Attacker, &b.Defender, &b.AttackerAvatarID, &b.DefenderAvatarID, &b.GameID, &b.Format, &b.AutoResolve, &b.Draw, &b.ResolvedBy)
 		if err != nil {
 			continue
 		}
@@ -4447,18 +13509,31 @@ func getBattles(w http.ResponseWriter, r *http.Request) {
 
 // Get a specific battle with questions
 func getBattle(w http.ResponseWriter, r *http.Request) {
-	_, err := getUserFromToken(r)
+	claims, err := getUserFromToken(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
+	// The teacher sees every answer; anyone else only those of logged rounds, and their own
+	var role string
+	if err := db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role); err != nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+	viewerAvatarID := 0
+	if role != "admin" {
+		viewerAvatarID = -1
+		db.QueryRow("SELECT id FROM avatars WHERE user_id = ?", claims.UserID).Scan(&viewerAvatarID)
+	}
+
 	vars := mux.Vars(r)
 	battleID := vars["id"]
 
 	var battle Battle
-	err = db.QueryRow("SELECT id, name, reward, winner, date, status, attacker, defender, attacker_avatar_id, defender_avatar_id, game_id FROM battles WHERE id = ?", battleID).
-		Scan(&battle.ID, &battle.Name, &battle.Reward, &battle.Winner, &battle.Date, &battle.Status, &battle.Attacker, &battle.Defender, &battle.AttackerAvatarID, &battle.DefenderAvatarID, &battle.GameID)
+	err = db.QueryRow(`SELECT id, name, reward, winner, date, status, attacker, defender, attacker_avatar_id, defender_avatar_id, game_id,
+		COALESCE(format, 'single'), COALESCE(auto_resolve, 0), COALESCE(draw, 0), resolved_by FROM battles WHERE id = ?`, battleID).
+		Scan(&battle.ID, &battle.Name, &battle.Reward, &battle.Winner, &battle.Date, &battle.Status, &battle.Attacker, &battle.Defender, &battle.AttackerAvatarID, &battle.DefenderAvatarID, &battle.GameID, &battle.Format, &battle.AutoResolve, &battle.Draw, &battle.ResolvedBy)
 	if err != nil {
 		http.Error(w, "Battle not found", http.StatusNotFound)
 		return
@@ -4500,54 +13575,19 @@ func getBattle(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Get attacker's question for this battle
-	var attackerQuestion *BattleQuestion
+	// Get each side's question for the current round
+	var attackerQuestion, defenderQuestion *BattleQuestion
 	if attackerAvatarID > 0 {
-		var q BattleQuestion
-		var submittedAt sql.NullTime
-		var userAnswer sql.NullString
-		err = db.QueryRow(`SELECT id, battle_id, question, answer, user_id, possible_points, received_score, time, user_answer, submitted_at
-			FROM battle_questions
-			WHERE user_id = ? AND battle_id = ?
-			ORDER BY id ASC
-			LIMIT 1`, attackerAvatarID, battleID).
-			Scan(&q.ID, &q.BattleID, &q.Question, &q.Answer, &q.UserID,
-				&q.PossiblePoints, &q.ReceivedScore, &q.Time, &userAnswer, &submittedAt)
-		if err == nil {
-			if userAnswer.Valid {
-				q.UserAnswer = &userAnswer.String
-			}
-			if submittedAt.Valid {
-				submittedAtStr := submittedAt.Time.Format(time.RFC3339)
-				q.SubmittedAt = &submittedAtStr
-			}
-			attackerQuestion = &q
-		}
+		attackerQuestion, _ = loadCurrentBattleQuestion(db, battle.ID, attackerAvatarID, true)
 	}
-
-	// Get defender's question for this battle
-	var defenderQuestion *BattleQuestion
 	if defenderAvatarID > 0 {
-		var q BattleQuestion
-		var submittedAt sql.NullTime
-		var userAnswer sql.NullString
-		err = db.QueryRow(`SELECT id, battle_id, question, answer, user_id, possible_points, received_score, time, user_answer, submitted_at
-			FROM battle_questions
-			WHERE user_id = ? AND battle_id = ?
-			ORDER BY id ASC
-			LIMIT 1`, defenderAvatarID, battleID).
-			Scan(&q.ID, &q.BattleID, &q.Question, &q.Answer, &q.UserID,
-				&q.PossiblePoints, &q.ReceivedScore, &q.Time, &userAnswer, &submittedAt)
-		if err == nil {
-			if userAnswer.Valid {
-				q.UserAnswer = &userAnswer.String
-			}
-			if submittedAt.Valid {
-				submittedAtStr := submittedAt.Time.Format(time.RFC3339)
-				q.SubmittedAt = &submittedAtStr
-			}
-			defenderQuestion = &q
-		}
+		defenderQuestion, _ = loadCurrentBattleQuestion(db, battle.ID, defenderAvatarID, true)
+	}
+
+	rounds, err := loadBattleRounds(db, battle.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	// Get questions
@@ -4567,8 +13607,16 @@ func getBattle(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			continue
 		}
+		if viewerAvatarID != 0 && !battleQuestionLogged(rounds, q.ID) {
+			hideBattleQuestionAnswers(&q, viewerAvatarID)
+		}
 		questions = append(questions, q)
 	}
+	for _, q := range []*BattleQuestion{attackerQuestion, defenderQuestion} {
+		if q != nil && viewerAvatarID != 0 && !battleQuestionLogged(rounds, q.ID) {
+			hideBattleQuestionAnswers(q, viewerAvatarID)
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -4578,6 +13626,7 @@ func getBattle(w http.ResponseWriter, r *http.Request) {
 		"attackerQuestion": attackerQuestion,
 		"defenderQuestion": defenderQuestion,
 		"questions":        questions,
+		"rounds":           rounds,
 	})
 }
 
@@ -4642,7 +13691,7 @@ func startBattle(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	battleID := vars["id"]
 
-	_, err = db.Exec("UPDATE battles SET status = 'in_progress' WHERE id = ?", battleID)
+	_, err = db.Exec("UPDATE battles SET status = 'in_progress', round_started_at = datetime('now') WHERE id = ?", battleID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -4683,19 +13732,28 @@ func stopBattle(w http.ResponseWriter, r *http.Request) {
 
 // Get unanswered question for a user
 func getUnansweredQuestion(w http.ResponseWriter, r *http.Request) {
-	_, err := getUserFromToken(r)
+	claims, err := getUserFromToken(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
+	var role string
+	if err := db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role); err != nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
 	vars := mux.Vars(r)
 	userID := vars["userId"]
 
 	var question BattleQuestion
+	// Questions of rounds that timed out before they were answered are skipped
 	err = db.QueryRow(`SELECT id, battle_id, question, answer, user_id, possible_points, received_score, time, user_answer, submitted_at
 		FROM battle_questions
 		WHERE user_id = ? AND submitted_at IS NULL
+			AND id NOT IN (SELECT attacker_question_id FROM battle_rounds WHERE attacker_question_id IS NOT NULL)
+			AND id NOT IN (SELECT defender_question_id FROM battle_rounds WHERE defender_question_id IS NOT NULL)
 		ORDER BY id ASC
 		LIMIT 1`, userID).
 		Scan(&question.ID, &question.BattleID, &question.Question, &question.Answer, &question.UserID,
@@ -4711,61 +13769,318 @@ func getUnansweredQuestion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The question isn't answered yet, so only the teacher gets the correct answer
+	if role != "admin" {
+		question.Answer = ""
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"question": question})
+}
+
+// Submit answer (for students)
+func submitAnswer(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		QuestionID int    `json:"questionId"`
+		Answer     string `json:"answer"`
+		BattleID   int    `json:"battleId"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	// Get user's avatar ID
+	var avatarID int
+	err = db.QueryRow("SELECT id FROM avatars WHERE user_id = ?", claims.UserID).Scan(&avatarID)
+	if err != nil {
+		http.Error(w, "Avatar not found", http.StatusNotFound)
+		return
+	}
+
+	// Answers only count while the battle is on
+	var status string
+	err = db.QueryRow(`SELECT COALESCE(b.status, '') FROM battle_questions bq JOIN battles b ON b.id = bq.battle_id
+		WHERE bq.id = ? AND bq.user_id = ?`, req.QuestionID, avatarID).Scan(&status)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Question not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if status != "in_progress" {
+		http.Error(w, "The battle is not in progress", http.StatusConflict)
+		return
+	}
+
+	// Submit answer
+	_, err = db.Exec(`UPDATE battle_questions
+		SET user_answer = ?, submitted_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND user_id = ?`,
+		req.Answer, req.QuestionID, avatarID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Auto-resolving battles play the round as soon as both sides have answered; others wait for the teacher
+	resolved := false
+	var battleID sql.NullInt64
+	db.QueryRow("SELECT battle_id FROM battle_questions WHERE id = ?", req.QuestionID).Scan(&battleID)
+	if battleID.Valid {
+		resolved = autoResolveBattle(int(battleID.Int64))
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{"question": question})
+	json.NewEncoder(w).Encode(map[string]bool{"success": true, "roundResolved": resolved})
 }
 
-// Submit answer (for students)
-func submitAnswer(w http.ResponseWriter, r *http.Request) {
-	claims, err := getUserFromToken(r)
+// The question an avatar answers in a battle's current round: their first one not used in a logged round yet.
+// With orLast, an avatar whose questions are all used up (e.g. the battle is over) gets their last one instead.
+func loadCurrentBattleQuestion(q dbQuerier, battleID, avatarID int, orLast bool) (*BattleQuestion, error) {
+	const columns = `SELECT id, battle_id, question, answer, user_id, possible_points, received_score, time, user_answer, submitted_at
+		FROM battle_questions
+		WHERE user_id = ? AND battle_id = ?`
+
+	var question BattleQuestion
+	var submittedAt sql.NullTime
+	var userAnswer sql.NullString
+	scan := func(row *sql.Row) error {
+		return row.Scan(&question.ID, &question.BattleID, &question.Question, &question.Answer, &question.UserID,
+			&question.PossiblePoints, &question.ReceivedScore, &question.Time, &userAnswer, &submittedAt)
+	}
+
+	err := scan(q.QueryRow(columns+`
+			AND id NOT IN (SELECT attacker_question_id FROM battle_rounds WHERE battle_id = ? AND attacker_question_id IS NOT NULL)
+			AND id NOT IN (SELECT defender_question_id FROM battle_rounds WHERE battle_id = ? AND defender_question_id IS NOT NULL)
+		ORDER BY id ASC
+		LIMIT 1`, avatarID, battleID, battleID, battleID))
+	if err == sql.ErrNoRows && orLast {
+		err = scan(q.QueryRow(columns+`
+		ORDER BY id DESC
+		LIMIT 1`, avatarID, battleID))
+	}
 	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
+		return nil, err
 	}
 
-	var req struct {
-		QuestionID int    `json:"questionId"`
-		Answer     string `json:"answer"`
-		BattleID   int    `json:"battleId"`
+	if userAnswer.Valid {
+		question.UserAnswer = &userAnswer.String
 	}
+	if submittedAt.Valid {
+		submittedAtStr := submittedAt.Time.Format(time.RFC3339)
+		question.SubmittedAt = &submittedAtStr
+	}
+	return &question, nil
+}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
-		return
+// The rounds fought so far in a battle, in order
+func loadBattleRounds(q dbQuerier, battleID int) ([]BattleRound, error) {
+	rows, err := q.Query(`SELECT id, battle_id, round, attacker_question_id, defender_question_id, attacker_answer, defender_answer,
+		attacker_correct, defender_correct, damage, stamina_loss, attacker_health, attacker_stamina, defender_health, defender_stamina, created_at
+		FROM battle_rounds WHERE battle_id = ? ORDER BY round`, battleID)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	// Get user's avatar ID
-	var avatarID int
-	err = db.QueryRow("SELECT id FROM avatars WHERE user_id = ?", claims.UserID).Scan(&avatarID)
+	rounds := []BattleRound{}
+	for rows.Next() {
+		var round BattleRound
+		err := rows.Scan(&round.ID, &round.BattleID, &round.Round, &round.AttackerQuestionID, &round.DefenderQuestionID, &round.AttackerAnswer, &round.DefenderAnswer,
+			&round.AttackerCorrect, &round.DefenderCorrect, &round.Damage, &round.StaminaLoss,
+			&round.AttackerHealth, &round.AttackerStamina, &round.DefenderHealth, &round.DefenderStamina, &round.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		rounds = append(rounds, round)
+	}
+	return rounds, rows.Err()
+}
+
+// Whether a battle in the given format is over after these rounds, and how many rounds each side has won.
+// A knockout (or an attacker who came in with no health) always ends it, as does reaching the format's
+// last round; best-of battles also end once one side has won the majority, and "until_ko" battles
+// once the attacker has no stamina left.
+func battleOutcome(format string, rounds []BattleRound) (bool, int, int) {
+	attackerWins, defenderWins := 0, 0
+	for _, round := range rounds {
+		if round.AttackerCorrect && !round.DefenderCorrect {
+			attackerWins++
+		} else if round.DefenderCorrect && !round.AttackerCorrect {
+			defenderWins++
+		}
+	}
+	if len(rounds) == 0 {
+		return false, attackerWins, defenderWins
+	}
+
+	limit := battleFormats[format]
+	if limit == 0 {
+		limit = 1
+	}
+	last := rounds[len(rounds)-1]
+	if last.DefenderHealth <= 0 || last.AttackerHealth <= 0 || len(rounds) >= limit {
+		return true, attackerWins, defenderWins
+	}
+	if format == "until_ko" {
+		return last.AttackerStamina <= 0, attackerWins, defenderWins
+	}
+	majority := limit/2 + 1
+	return attackerWins >= majority || defenderWins >= majority, attackerWins, defenderWins
+}
+
+// Keeps a battle round from being resolved twice, e.g. by an answer coming in as the scheduler times the round out
+var battleResolveMu sync.Mutex
+
+// Resolve the current round of an auto-resolving battle once both sides have submitted an answer, or once the
+// round's time is up: the longest of its questions' times, counted from when the round started. An unanswered
+// question counts as a wrong answer. Returns whether a round was resolved.
+func autoResolveBattle(battleID int) bool {
+	battleResolveMu.Lock()
+	defer battleResolveMu.Unlock()
+
+	var attackerAvatarID, defenderAvatarID, attackerAssetID, defenderAssetID sql.NullInt64
+	var status string
+	var autoResolve bool
+	err := db.QueryRow(`SELECT attacker_avatar_id, defender_avatar_id, attacker, defender, COALESCE(status, 'pending'), COALESCE(auto_resolve, 0)
+		FROM battles WHERE id = ?`, battleID).Scan(&attackerAvatarID, &defenderAvatarID, &attackerAssetID, &defenderAssetID, &status, &autoResolve)
+	if err != nil || !autoResolve || status != "in_progress" || !attackerAvatarID.Valid || !defenderAvatarID.Valid || !attackerAssetID.Valid || !defenderAssetID.Valid {
+		return false
+	}
+
+	attackerQuestion, attackerErr := loadCurrentBattleQuestion(db, battleID, int(attackerAvatarID.Int64), false)
+	defenderQuestion, defenderErr := loadCurrentBattleQuestion(db, battleID, int(defenderAvatarID.Int64), false)
+	if attackerErr != nil && defenderErr != nil {
+		// No questions handed out for this round yet
+		return false
+	}
+
+	resolvedBy := "auto"
+	if attackerErr != nil || defenderErr != nil || attackerQuestion.SubmittedAt == nil || defenderQuestion.SubmittedAt == nil {
+		timeLimit := 0
+		for _, question := range []*BattleQuestion{attackerQuestion, defenderQuestion} {
+			if question != nil && question.Time > timeLimit {
+				timeLimit = question.Time
+			}
+		}
+		if timeLimit == 0 {
+			return false
+		}
+		var timedOut bool
+		db.QueryRow(`SELECT COUNT(*) FROM battles WHERE id = ? AND round_started_at IS NOT NULL
+			AND datetime(round_started_at, '+' || ? || ' seconds') <= datetime('now')`, battleID, timeLimit).Scan(&timedOut)
+		if !timedOut {
+			return false
+		}
+		resolvedBy = "timeout"
+	}
+
+	if attackerQuestion == nil {
+		attackerQuestion = &BattleQuestion{}
+	}
+	if defenderQuestion == nil {
+		defenderQuestion = &BattleQuestion{}
+	}
+	_, _, err = processBattle(battleID, int(attackerAssetID.Int64), int(defenderAssetID.Int64), int(attackerAvatarID.Int64), int(defenderAvatarID.Int64),
+		attackerQuestion, defenderQuestion, resolvedBy)
 	if err != nil {
-		http.Error(w, "Avatar not found", http.StatusNotFound)
-		return
+		log.Printf("Auto-resolve: %v", err)
+		return false
 	}
+	return true
+}
 
-	// Submit answer
-	_, err = db.Exec(`UPDATE battle_questions
-		SET user_answer = ?, submitted_at = CURRENT_TIMESTAMP
-		WHERE id = ? AND user_id = ?`,
-		req.Answer, req.QuestionID, avatarID)
+// Resolve the rounds of auto-resolving battles whose time has run out (called by the turn scheduler)
+func resolveTimedOutBattles() {
+	rows, err := db.Query(`SELECT id FROM battles
+		WHERE status = 'in_progress' AND COALESCE(auto_resolve, 0) = 1 AND round_started_at IS NOT NULL`)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Printf("Turn scheduler: %v", err)
 		return
 	}
 
-	// Do not automatically complete the battle - admin will do it manually
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+	var battleIDs []int
+	for rows.Next() {
+		var battleID int
+		if err := rows.Scan(&battleID); err == nil {
+			battleIDs = append(battleIDs, battleID)
+		}
+	}
+	rows.Close()
+
+	for _, battleID := range battleIDs {
+		autoResolveBattle(battleID)
+	}
 }
 
-// Process battle calculations
-func processBattle(battleID, attackerAssetID, defenderAssetID, attackerAvatarID, defenderAvatarID int, attackerQuestion, defenderQuestion *BattleQuestion) {
+// Process one round of a battle. The damage formula is applied to the fighters' health and stamina as the
+// previous rounds left them and the round is logged; once the battle's format is decided (see battleOutcome), or
+// the question bank has nothing left for the next round, the result is written to the warriors and the board,
+// all in one transaction, noting what resolved it ("teacher", "auto" or "timeout"). Returns the round and whether
+// the battle is over, or the error that kept the round from being written (nothing of it is kept then).
+func processBattle(battleID, attackerAssetID, defenderAssetID, attackerAvatarID, defenderAvatarID int, attackerQuestion, defenderQuestion *BattleQuestion,
+	resolvedBy string) (BattleRound, bool, error) {
+	var gameID, fromCellID, toCellID sql.NullInt64
+	format := "single"
+	db.QueryRow("SELECT game_id, from_cell_id, to_cell_id, COALESCE(format, 'single') FROM battles WHERE id = ?", battleID).Scan(&gameID, &fromCellID, &toCellID, &format)
+
+	// The round, and the battle's result once it's decided, are written in one transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return BattleRound{}, false, fmt.Errorf("could not process battle %d: %v", battleID, err)
+	}
+	defer tx.Rollback()
+
 	// Get attacker and defender assets
 	var attackerAsset, defenderAsset Asset
-	db.QueryRow("SELECT id, attack, defense, health, stamina FROM assets WHERE id = ?", attackerAssetID).
+	tx.QueryRow("SELECT id, attack, defense, health, stamina FROM assets WHERE id = ?", attackerAssetID).
 		Scan(&attackerAsset.ID, &attackerAsset.Attack, &attackerAsset.Defense, &attackerAsset.Health, &attackerAsset.Stamina)
-	db.QueryRow("SELECT id, attack, defense, health, stamina FROM assets WHERE id = ?", defenderAssetID).
+	tx.QueryRow("SELECT id, attack, defense, health, stamina FROM assets WHERE id = ?", defenderAssetID).
 		Scan(&defenderAsset.ID, &defenderAsset.Attack, &defenderAsset.Defense, &defenderAsset.Health, &defenderAsset.Stamina)
 
+	// Battles in a game use the fighters' health and stamina in that game;
+	// buffs picked up on the board strengthen the attacker's attack and the defender's defense
+	if gameID.Valid {
+		for _, assetID := range []int{attackerAssetID, defenderAssetID} {
+			if _, err := ensureGameWarrior(tx, int(gameID.Int64), assetID); err != nil {
+				return BattleRound{}, false, fmt.Errorf("could not process battle %d: %v", battleID, err)
+			}
+		}
+		attackerAsset.Health, attackerAsset.Stamina = gameWarriorVitals(tx, int(gameID.Int64), attackerAssetID)
+		defenderAsset.Health, defenderAsset.Stamina = gameWarriorVitals(tx, int(gameID.Int64), defenderAssetID)
+
+		attackBuff, err := warriorBuff(tx, int(gameID.Int64), attackerAssetID)
+		if err != nil {
+			return BattleRound{}, false, fmt.Errorf("could not process battle %d: %v", battleID, err)
+		}
+		defenseBuff, err := warriorBuff(tx, int(gameID.Int64), defenderAssetID)
+		if err != nil {
+			return BattleRound{}, false, fmt.Errorf("could not process battle %d: %v", battleID, err)
+		}
+		attackerAsset.Attack += attackBuff
+		defenderAsset.Defense += defenseBuff
+	}
+
+	// Later rounds carry on from where the last round left the fighters; nothing is written to them until the battle is over
+	rounds, err := loadBattleRounds(tx, battleID)
+	if err != nil {
+		return BattleRound{}, false, fmt.Errorf("could not load rounds of battle %d: %v", battleID, err)
+	}
+	if len(rounds) > 0 {
+		last := rounds[len(rounds)-1]
+		attackerAsset.Health, attackerAsset.Stamina = last.AttackerHealth, last.AttackerStamina
+		defenderAsset.Health, defenderAsset.Stamina = last.DefenderHealth, last.DefenderStamina
+	}
+
 	// Check if answers are correct (case-insensitive comparison)
 	attackerCorrect := false
 	defenderCorrect := false
@@ -4806,35 +14121,280 @@ func processBattle(battleID, attackerAssetID, defenderAssetID, attackerAvatarID,
 		newAttackerStamina = 0
 	}
 
-	// Update defender asset in database
-	db.Exec("UPDATE assets SET health = ? WHERE id = ?", newDefenderHealth, defenderAssetID)
+	attackerHealth := attackerAsset.Health
+
+	// Log the round
+	round := BattleRound{
+		BattleID:        battleID,
+		Round:           len(rounds) + 1,
+		AttackerAnswer:  attackerQuestion.UserAnswer,
+		DefenderAnswer:  defenderQuestion.UserAnswer,
+		AttackerCorrect: attackerCorrect,
+		DefenderCorrect: defenderCorrect,
+		Damage:          defenderAsset.Health - newDefenderHealth,
+		StaminaLoss:     attackerAsset.Stamina - newAttackerStamina,
+		AttackerHealth:  attackerHealth,
+		AttackerStamina: newAttackerStamina,
+		DefenderHealth:  newDefenderHealth,
+		DefenderStamina: defenderAsset.Stamina,
+		CreatedAt:       time.Now(),
+	}
+	if attackerQuestion.ID > 0 {
+		round.AttackerQuestionID = &attackerQuestion.ID
+	}
+	if defenderQuestion.ID > 0 {
+		round.DefenderQuestionID = &defenderQuestion.ID
+	}
+	result, err := tx.Exec(`INSERT INTO battle_rounds (battle_id, round, attacker_question_id, defender_question_id, attacker_answer, defender_answer,
+		attacker_correct, defender_correct, damage, stamina_loss, attacker_health, attacker_stamina, defender_health, defender_stamina)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		battleID, round.Round, round.AttackerQuestionID, round.DefenderQuestionID, round.AttackerAnswer, round.DefenderAnswer,
+		round.AttackerCorrect, round.DefenderCorrect, round.Damage, round.StaminaLoss,
+		round.AttackerHealth, round.AttackerStamina, round.DefenderHealth, round.DefenderStamina)
+	if err != nil {
+		return round, false, fmt.Errorf("could not log round %d of battle %d: %v", round.Round, battleID, err)
+	}
+	id, _ := result.LastInsertId()
+	round.ID = int(id)
+	rounds = append(rounds, round)
+
+	finished, attackerWins, defenderWins := battleOutcome(format, rounds)
+
+	// Board battles pull the next round's questions right away; other battles get theirs assigned by the teacher.
+	// When the question bank runs dry the battle ends on the rounds fought so far.
+	outOfQuestions := false
+	if !finished && fromCellID.Valid && toCellID.Valid {
+		for _, avatarID := range []int{attackerAvatarID, defenderAvatarID} {
+			err := pullBattleQuestion(tx, int64(battleID), avatarID)
+			if err == errNoBattleQuestions {
+				outOfQuestions, finished = true, true
+				break
+			}
+			if err != nil {
+				return round, false, fmt.Errorf("could not pull a question for avatar %d in battle %d: %v", avatarID, battleID, err)
+			}
+		}
+		if outOfQuestions {
+			// A question already pulled for the round that won't be fought goes back to the bank
+			if _, err := tx.Exec("UPDATE battle_questions SET battle_id = NULL WHERE battle_id = ? AND submitted_at IS NULL", battleID); err != nil {
+				return round, false, fmt.Errorf("could not end battle %d: %v", battleID, err)
+			}
+		}
+	}
+
+	if !finished {
+		// The next round's clock starts now
+		if _, err := tx.Exec("UPDATE battles SET round_started_at = datetime('now') WHERE id = ?", battleID); err != nil {
+			return round, false, fmt.Errorf("could not start round %d of battle %d: %v", round.Round+1, battleID, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return round, false, fmt.Errorf("could not log round %d of battle %d: %v", round.Round, battleID, err)
+		}
+
+		if gameID.Valid {
+			broadcastGameEvent(int(gameID.Int64), "battle_round", map[string]interface{}{
+				"battleId":        battleID,
+				"round":           round.Round,
+				"format":          format,
+				"attackerCorrect": attackerCorrect,
+				"defenderCorrect": defenderCorrect,
+				"damage":          round.Damage,
+				"staminaLoss":     round.StaminaLoss,
+				"defenderHealth":  newDefenderHealth,
+				"attackerStamina": newAttackerStamina,
+				"attackerWins":    attackerWins,
+				"defenderWins":    defenderWins,
+			})
+		}
+		return round, false, nil
+	}
+
+	// Remember the board around the fighters so the battle's outcome goes into the game's event log
+	var battleCellIDs []int
+	var before *BoardSnapshot
+	if gameID.Valid {
+		if fromCellID.Valid && toCellID.Valid {
+			battleCellIDs = append(battleCellIDs, int(fromCellID.Int64), int(toCellID.Int64))
+		}
+		cellRows, err := tx.Query("SELECT id FROM game_cells WHERE game_id = ? AND occupied_by IN (?, ?)", gameID.Int64, attackerAssetID, defenderAssetID)
+		if err == nil {
+			for cellRows.Next() {
+				var cellID int
+				if cellRows.Scan(&cellID) == nil {
+					battleCellIDs = append(battleCellIDs, cellID)
+				}
+			}
+			cellRows.Close()
+		}
+		before, err = captureBoard(tx, int(gameID.Int64), battleCellIDs, []int{attackerAssetID, defenderAssetID}, []int{attackerAvatarID})
+		if err != nil {
+			return round, false, fmt.Errorf("could not resolve battle %d: %v", battleID, err)
+		}
+	}
+
+	if gameID.Valid {
+		// Only the warriors' state in this game changes; a defeat knocks them out of the game
+		// (and only kills them for good in permadeath games)
+		if _, err := tx.Exec("UPDATE game_warriors SET health = ? WHERE game_id = ? AND asset_id = ?", newDefenderHealth, gameID.Int64, defenderAssetID); err != nil {
+			return round, false, fmt.Errorf("could not resolve battle %d: %v", battleID, err)
+		}
+		if _, err := tx.Exec("UPDATE game_warriors SET stamina = ? WHERE game_id = ? AND asset_id = ?", newAttackerStamina, gameID.Int64, attackerAssetID); err != nil {
+			return round, false, fmt.Errorf("could not resolve battle %d: %v", battleID, err)
+		}
+
+		if newDefenderHealth <= 0 {
+			if err := knockOutGameWarrior(tx, int(gameID.Int64), defenderAssetID, "rip"); err != nil {
+				return round, false, fmt.Errorf("could not resolve battle %d: %v", battleID, err)
+			}
+		}
+		if attackerHealth <= 0 {
+			if err := knockOutGameWarrior(tx, int(gameID.Int64), attackerAssetID, "rip"); err != nil {
+				return round, false, fmt.Errorf("could not resolve battle %d: %v", battleID, err)
+			}
+		}
+	} else {
+		// Update defender asset in database
+		if _, err := tx.Exec("UPDATE assets SET health = ? WHERE id = ?", newDefenderHealth, defenderAssetID); err != nil {
+			return round, false, fmt.Errorf("could not resolve battle %d: %v", battleID, err)
+		}
+
+		// Check if defender health is <= 0 and mark as "rip"
+		if newDefenderHealth <= 0 {
+			log.Printf("Defender asset %d has health <= 0, marking as 'rip'", defenderAssetID)
+			if _, err := tx.Exec("UPDATE assets SET status = 'rip' WHERE id = ?", defenderAssetID); err != nil {
+				return round, false, fmt.Errorf("could not resolve battle %d: %v", battleID, err)
+			}
+			// Clear the defender from any game cell they occupy
+			if _, err := tx.Exec("UPDATE game_cells SET occupied_by = NULL, status = 'active' WHERE occupied_by = ?", defenderAssetID); err != nil {
+				return round, false, fmt.Errorf("could not resolve battle %d: %v", battleID, err)
+			}
+		}
+
+		// Update attacker asset in database
+		if _, err := tx.Exec("UPDATE assets SET stamina = ? WHERE id = ?", newAttackerStamina, attackerAssetID); err != nil {
+			return round, false, fmt.Errorf("could not resolve battle %d: %v", battleID, err)
+		}
 
-	// Check if defender health is <= 0 and mark as "rip"
-	if newDefenderHealth <= 0 {
-		log.Printf("Defender asset %d has health <= 0, marking as 'rip'", defenderAssetID)
-		db.Exec("UPDATE assets SET status = 'rip' WHERE id = ?", defenderAssetID)
-		// Clear the defender from any game cell they occupy
-		db.Exec("UPDATE game_cells SET occupied_by = NULL, status = 'active' WHERE occupied_by = ?", defenderAssetID)
+		// Check if attacker health is <= 0 and mark as "rip" (in case attacker already had low health)
+		if attackerHealth <= 0 {
+			log.Printf("Attacker asset %d has health <= 0, marking as 'rip'", attackerAssetID)
+			if _, err := tx.Exec("UPDATE assets SET status = 'rip' WHERE id = ?", attackerAssetID); err != nil {
+				return round, false, fmt.Errorf("could not resolve battle %d: %v", battleID, err)
+			}
+			// Clear the attacker from any game cell they occupy
+			if _, err := tx.Exec("UPDATE game_cells SET occupied_by = NULL, status = 'active' WHERE occupied_by = ?", attackerAssetID); err != nil {
+				return round, false, fmt.Errorf("could not resolve battle %d: %v", battleID, err)
+			}
+		}
 	}
 
-	// Update attacker asset in database
-	db.Exec("UPDATE assets SET stamina = ? WHERE id = ?", newAttackerStamina, attackerAssetID)
+	// Battles started on the board: the winner ends up on the contested cell
+
+	// The battle goes to a winner, or is a draw when both fighters are down or a battle decided on points ends level.
+	// Best-of battles are decided on points, and so is any battle cut short by running out of questions.
+	var winner interface{}
+	draw := false
+	onPoints := strings.HasPrefix(format, "best_of") || outOfQuestions
+	if newDefenderHealth <= 0 && attackerHealth > 0 {
+		winner = attackerAvatarID
+	} else if newDefenderHealth <= 0 {
+		draw = true
+	} else if onPoints && attackerWins > defenderWins {
+		// Won on points: the battle goes to the attacker, but a defender still standing keeps the cell
+		winner = attackerAvatarID
+	} else if onPoints && attackerWins == defenderWins {
+		draw = true
+	} else {
+		// Defender holds the cell, attacker stays where it was
+		winner = defenderAvatarID
+	}
+	if _, err := tx.Exec("UPDATE battles SET winner = ?, draw = ? WHERE id = ?", winner, draw, battleID); err != nil {
+		return round, false, fmt.Errorf("could not resolve battle %d: %v", battleID, err)
+	}
 
-	// Check if attacker health is <= 0 and mark as "rip" (in case attacker already had low health)
-	var attackerHealth int
-	db.QueryRow("SELECT health FROM assets WHERE id = ?", attackerAssetID).Scan(&attackerHealth)
-	if attackerHealth <= 0 {
-		log.Printf("Attacker asset %d has health <= 0, marking as 'rip'", attackerAssetID)
-		db.Exec("UPDATE assets SET status = 'rip' WHERE id = ?", attackerAssetID)
-		// Clear the attacker from any game cell they occupy
-		db.Exec("UPDATE game_cells SET occupied_by = NULL, status = 'active' WHERE occupied_by = ?", attackerAssetID)
+	var rewardCoins, rewardXP int
+	if fromCellID.Valid && toCellID.Valid {
+		if newDefenderHealth <= 0 && attackerHealth > 0 {
+			// Attacker takes the cell (only if the cell is still free) and collects its rewards
+			result, err := tx.Exec("UPDATE game_cells SET occupied_by = ?, status = 'warrior' WHERE id = ? AND COALESCE(occupied_by, 0) = 0", attackerAssetID, toCellID.Int64)
+			if err != nil {
+				return round, false, fmt.Errorf("could not move the winner of battle %d: %v", battleID, err)
+			}
+			if rowsAffected, _ := result.RowsAffected(); rowsAffected == 1 {
+				if _, err := tx.Exec("UPDATE game_cells SET occupied_by = NULL, status = '' WHERE id = ? AND occupied_by = ?", fromCellID.Int64, attackerAssetID); err != nil {
+					return round, false, fmt.Errorf("could not move the winner of battle %d: %v", battleID, err)
+				}
+				if err := syncGameWarriorCells(tx, int(gameID.Int64)); err != nil {
+					return round, false, fmt.Errorf("could not move the winner of battle %d: %v", battleID, err)
+				}
+				rewardCoins, rewardXP, err = claimCellReward(tx, int(gameID.Int64), int(toCellID.Int64), attackerAssetID)
+				if err != nil {
+					return round, false, fmt.Errorf("could not claim rewards after battle %d: %v", battleID, err)
+				}
+			}
+		}
 	}
 
 	// Mark battle as complete
-	db.Exec("UPDATE battles SET status = 'completed' WHERE id = ?", battleID)
+	if _, err := tx.Exec("UPDATE battles SET status = 'completed', resolved_by = ? WHERE id = ?", resolvedBy, battleID); err != nil {
+		return round, false, fmt.Errorf("could not resolve battle %d: %v", battleID, err)
+	}
+
+	// Clear battle_id from game so it resumes, restarting the turn clock that was frozen during the battle
+	if _, err := tx.Exec("UPDATE games SET battle_id = NULL, turn_start_time = datetime('now') WHERE battle_id = ?", battleID); err != nil {
+		return round, false, fmt.Errorf("could not resolve battle %d: %v", battleID, err)
+	}
+
+	// The outcome goes into the game's event log with the rest of the result
+	if gameID.Valid && before != nil {
+		after, err := captureBoard(tx, int(gameID.Int64), battleCellIDs, []int{attackerAssetID, defenderAssetID}, []int{attackerAvatarID})
+		if err != nil {
+			return round, false, fmt.Errorf("could not log battle %d: %v", battleID, err)
+		}
+		eventID, err := recordGameEvent(tx, int(gameID.Int64), "battle_resolved", 0, 0, before, after)
+		if err != nil {
+			return round, false, fmt.Errorf("could not log battle %d: %v", battleID, err)
+		}
+		if err := linkRewardClaims(tx, int(gameID.Int64), eventID); err != nil {
+			return round, false, fmt.Errorf("could not log battle %d: %v", battleID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return round, false, fmt.Errorf("could not resolve battle %d: %v", battleID, err)
+	}
+
+	if gameID.Valid {
+		broadcastCellUpdates(int(gameID.Int64), battleCellIDs...)
+	}
+
+	if rewardCoins > 0 || rewardXP > 0 {
+		broadcastRewardClaimed(int(gameID.Int64), int(toCellID.Int64), attackerAssetID, attackerAvatarID, rewardCoins, rewardXP)
+	}
+
+	if gameID.Valid {
+		broadcastGameEvent(int(gameID.Int64), "battle_resolved", map[string]interface{}{
+			"battleId":         battleID,
+			"attacker":         attackerAssetID,
+			"defender":         defenderAssetID,
+			"attackerCorrect":  attackerCorrect,
+			"defenderCorrect":  defenderCorrect,
+			"defenderHealth":   newDefenderHealth,
+			"attackerStamina":  newAttackerStamina,
+			"defenderDefeated": newDefenderHealth <= 0,
+			"attackerDefeated": attackerHealth <= 0,
+			"format":           format,
+			"rounds":           len(rounds),
+			"attackerWins":     attackerWins,
+			"defenderWins":     defenderWins,
+			"winner":           winner,
+			"draw":             draw,
+			"outOfQuestions":   outOfQuestions,
+		})
+		checkGameVictory(int(gameID.Int64))
+	}
 
-	// Clear battle_id from game so it resumes
-	db.Exec("UPDATE games SET battle_id = NULL WHERE battle_id = ?", battleID)
+	return round, true, nil
 }
 
 // Grade answers (admin)
@@ -5311,6 +14871,9 @@ func assignQuestionToBattle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The round's clock (re)starts when its questions are handed out
+	db.Exec("UPDATE battles SET round_started_at = datetime('now') WHERE id = ? AND status = 'in_progress'", req.BattleID)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]bool{"success": true})
 }
@@ -5332,54 +14895,164 @@ func completeBattle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	battleResolveMu.Lock()
+	defer battleResolveMu.Unlock()
+
 	// Get battle info
 	var attackerAvatarID, defenderAvatarID int
 	var attackerAssetID, defenderAssetID int
-	err = db.QueryRow("SELECT attacker_avatar_id, defender_avatar_id, attacker, defender FROM battles WHERE id = ?", req.BattleID).
-		Scan(&attackerAvatarID, &defenderAvatarID, &attackerAssetID, &defenderAssetID)
+	var status string
+	err = db.QueryRow("SELECT attacker_avatar_id, defender_avatar_id, attacker, defender, COALESCE(status, 'pending') FROM battles WHERE id = ?", req.BattleID).
+		Scan(&attackerAvatarID, &defenderAvatarID, &attackerAssetID, &defenderAssetID, &status)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if status == "completed" || status == "cancelled" {
+		http.Error(w, "This battle is already over", http.StatusConflict)
+		return
+	}
+
+	// Get both questions for the current round (a side without one counts as answering wrong)
+	attackerQuestion, defenderQuestion := &BattleQuestion{}, &BattleQuestion{}
+	if q, err := loadCurrentBattleQuestion(db, req.BattleID, attackerAvatarID, false); err == nil {
+		attackerQuestion = q
+	}
+	if q, err := loadCurrentBattleQuestion(db, req.BattleID, defenderAvatarID, false); err == nil {
+		defenderQuestion = q
+	}
 
-	// Get both questions
-	var attackerQuestion, defenderQuestion BattleQuestion
-	var attackerSubmittedAt, defenderSubmittedAt sql.NullTime
-	var attackerUserAnswer, defenderUserAnswer sql.NullString
+	// Process the round; the battle is resolved once its format is decided
+	round, finished, err := processBattle(req.BattleID, attackerAssetID, defenderAssetID, attackerAvatarID, defenderAvatarID, attackerQuestion, defenderQuestion, "teacher")
+	if err != nil {
+		log.Printf("Complete battle: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	err = db.QueryRow(`SELECT id, question, answer, user_answer, submitted_at
-		FROM battle_questions
-		WHERE battle_id = ? AND user_id = ?`, req.BattleID, attackerAvatarID).
-		Scan(&attackerQuestion.ID, &attackerQuestion.Question, &attackerQuestion.Answer, &attackerUserAnswer, &attackerSubmittedAt)
-	if err == nil {
-		if attackerUserAnswer.Valid {
-			attackerQuestion.UserAnswer = &attackerUserAnswer.String
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"round":    round,
+		"finished": finished,
+	})
+}
+
+// Override a battle's result (admin only). Body: {"winner": avatarId} or {"draw": true}.
+// A battle still in progress ends there without another round; the board is left as it is either way.
+func overrideBattleResult(w http.ResponseWriter, r *http.Request) {
+	claims, err := getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is admin
+	var role string
+	err = db.QueryRow("SELECT role FROM users WHERE id = ?", claims.UserID).Scan(&role)
+	if err != nil || role != "admin" {
+		http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		return
+	}
+
+	vars := mux.Vars(r)
+	battleID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid battle ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Winner *int `json:"winner"` // Avatar ID of the attacker or the defender
+		Draw   bool `json:"draw"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if (req.Winner != nil) == req.Draw {
+		http.Error(w, "Give either a winner or a draw", http.StatusBadRequest)
+		return
+	}
+
+	battleResolveMu.Lock()
+	defer battleResolveMu.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var attackerAvatarID, defenderAvatarID, gameID sql.NullInt64
+	var status string
+	err = tx.QueryRow("SELECT attacker_avatar_id, defender_avatar_id, game_id, COALESCE(status, 'pending') FROM battles WHERE id = ?", battleID).
+		Scan(&attackerAvatarID, &defenderAvatarID, &gameID, &status)
+	if err != nil {
+		http.Error(w, "Battle not found", http.StatusNotFound)
+		return
+	}
+	if status == "cancelled" {
+		http.Error(w, "This battle was cancelled", http.StatusConflict)
+		return
+	}
+	if req.Winner != nil && int64(*req.Winner) != attackerAvatarID.Int64 && int64(*req.Winner) != defenderAvatarID.Int64 {
+		http.Error(w, "The winner must be the attacker or the defender", http.StatusBadRequest)
+		return
+	}
+
+	_, err = tx.Exec("UPDATE battles SET winner = ?, draw = ?, resolved_by = 'override', status = 'completed' WHERE id = ?", req.Winner, req.Draw, battleID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Ending a battle in progress hands back the questions nobody answered and lets the game go on
+	if status != "completed" {
+		if _, err := tx.Exec("UPDATE battle_questions SET battle_id = NULL WHERE battle_id = ? AND submitted_at IS NULL", battleID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-		if attackerSubmittedAt.Valid {
-			submittedAtStr := attackerSubmittedAt.Time.Format(time.RFC3339)
-			attackerQuestion.SubmittedAt = &submittedAtStr
+		if _, err := tx.Exec("UPDATE games SET battle_id = NULL, turn_start_time = datetime('now') WHERE battle_id = ?", battleID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 	}
 
-	err = db.QueryRow(`SELECT id, question, answer, user_answer, submitted_at
-		FROM battle_questions
-		WHERE battle_id = ? AND user_id = ?`, req.BattleID, defenderAvatarID).
-		Scan(&defenderQuestion.ID, &defenderQuestion.Question, &defenderQuestion.Answer, &defenderUserAnswer, &defenderSubmittedAt)
-	if err == nil {
-		if defenderUserAnswer.Valid {
-			defenderQuestion.UserAnswer = &defenderUserAnswer.String
+	// The new result goes into the game's log. The result isn't part of the board, so undo stops here
+	// (an event without a before state) rather than bringing back a battle that's over.
+	if gameID.Valid {
+		after, err := captureBoard(tx, int(gameID.Int64), nil, nil, nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-		if defenderSubmittedAt.Valid {
-			submittedAtStr := defenderSubmittedAt.Time.Format(time.RFC3339)
-			defenderQuestion.SubmittedAt = &submittedAtStr
+		if _, err := recordGameEvent(tx, int(gameID.Int64), "battle_result_changed", claims.UserID, 0, nil, after); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 	}
 
-	// Process battle
-	processBattle(req.BattleID, attackerAssetID, defenderAssetID, attackerAvatarID, defenderAvatarID, &attackerQuestion, &defenderQuestion)
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if gameID.Valid {
+		broadcastGameEvent(int(gameID.Int64), "battle_result_changed", map[string]interface{}{
+			"battleId": battleID,
+			"winner":   req.Winner,
+			"draw":     req.Draw,
+		})
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"winner":  req.Winner,
+		"draw":    req.Draw,
+	})
 }
 
 func main() {
@@ -5389,6 +15062,9 @@ func main() {
 	initDB()
 	defer db.Close()
 
+	// Advance game turns on the server when their time runs out
+	go runTurnScheduler()
+
 	router := mux.NewRouter()
 
 	// API routes
@@ -5428,6 +15104,14 @@ func main() {
 	api.HandleFunc("/assignments/bulk-update-due-dates", bulkUpdateAssignmentDueDates).Methods("PUT")
 	api.HandleFunc("/assignments/{id}", updateAssignment).Methods("PUT")
 	api.HandleFunc("/assignments/{id}", deleteAssignment).Methods("DELETE")
+	api.HandleFunc("/assignments/{id}/recordings", uploadRecording).Methods("POST")
+	api.HandleFunc("/grading/queue", getGradingQueue).Methods("GET")
+	api.HandleFunc("/grading/responses/{id}", gradeResponse).Methods("PUT")
+	api.HandleFunc("/grading/assignments/{id}/release", releaseAssignmentGrades).Methods("POST")
+	api.HandleFunc("/analytics/questions", getQuestionAnalytics).Methods("GET")
+	api.HandleFunc("/analytics/mastery", getMasteryAnalytics).Methods("GET")
+	api.HandleFunc("/analytics/completion", getCompletionAnalytics).Methods("GET")
+	api.HandleFunc("/gradebook", getGradebook).Methods("GET")
 	api.HandleFunc("/games", getGames).Methods("GET")
 	api.HandleFunc("/games/create", createGame).Methods("POST")
 	api.HandleFunc("/games/{id}", getGame).Methods("GET")
@@ -5435,10 +15119,45 @@ func main() {
 	api.HandleFunc("/games/{id}", deleteGame).Methods("DELETE")
 	api.HandleFunc("/games/{id}/advance-turn", advanceTurn).Methods("POST")
 	api.HandleFunc("/games/{id}/set-turn", setTurn).Methods("POST")
+	api.HandleFunc("/games/{id}/start", startGame).Methods("POST")
+	api.HandleFunc("/games/{id}/pause", pauseGame).Methods("POST")
+	api.HandleFunc("/games/{id}/resume", resumeGame).Methods("POST")
+	api.HandleFunc("/games/{id}/finish", finishGameHandler).Methods("POST")
+	api.HandleFunc("/games/{id}/standings", getGameStandings).Methods("GET")
+	api.HandleFunc("/games/{id}/avatars/{avatarId}/absent", setAvatarAbsent).Methods("PUT")
+	api.HandleFunc("/games/{id}/avatars/{avatarId}/autopilot", setAvatarAutopilot).Methods("PUT")
+	api.HandleFunc("/games/{id}/avatars", changeGamePlayers).Methods("POST")
+	api.HandleFunc("/games/{id}/avatars/{avatarId}", changeGamePlayers).Methods("DELETE")
+	api.HandleFunc("/games/{id}/turn-order", changeGamePlayers).Methods("PUT")
+	api.HandleFunc("/games/{id}/archive", archiveGame).Methods("POST")
+	api.HandleFunc("/games/{id}/spectator-tokens", getSpectatorTokens).Methods("GET")
+	api.HandleFunc("/games/{id}/spectator-tokens", createSpectatorToken).Methods("POST")
+	api.HandleFunc("/spectator-tokens/{id}", revokeSpectatorToken).Methods("DELETE")
+	api.HandleFunc("/spectate/{token}", spectateGame).Methods("GET")
+	api.HandleFunc("/spectate/{token}/ws", spectateGameWebSocket).Methods("GET")
+	api.HandleFunc("/games/{id}/clone", cloneGame).Methods("POST")
+	api.HandleFunc("/games/{id}/reset", resetGame).Methods("POST")
+	api.HandleFunc("/games/{id}/warriors/{wid}/moves", getWarriorMoves).Methods("GET")
+	api.HandleFunc("/games/{id}/ws", gameWebSocket).Methods("GET")
+	api.HandleFunc("/games/{id}/events", getGameEvents).Methods("GET")
+	api.HandleFunc("/games/{id}/undo", undoGameAction).Methods("POST")
+	api.HandleFunc("/games/{id}/reward-claims", getGameRewardClaims).Methods("GET")
+	api.HandleFunc("/games/{id}/cells", patchGameCells).Methods("PATCH")
+	api.HandleFunc("/games/{id}/export", exportGameBoard).Methods("GET")
+	api.HandleFunc("/games/{id}/import", importGameBoard).Methods("POST")
+	api.HandleFunc("/board-templates", getBoardTemplates).Methods("GET")
+	api.HandleFunc("/board-templates", createBoardTemplate).Methods("POST")
+	api.HandleFunc("/board-templates/generate", generateBoardTemplate).Methods("POST")
+	api.HandleFunc("/board-templates/{id}", getBoardTemplate).Methods("GET")
+	api.HandleFunc("/board-templates/{id}", deleteBoardTemplate).Methods("DELETE")
 	api.HandleFunc("/game-cells/{id}", updateGameCell).Methods("PUT")
 	api.HandleFunc("/game-cells/{id}/place-warrior", placeWarriorOnCell).Methods("POST")
 	api.HandleFunc("/game-cells/move-warrior", moveWarrior).Methods("POST")
 	api.HandleFunc("/game-cells/claim-rewards", claimCellRewards).Methods("POST")
+	api.HandleFunc("/game-cells/{id}/effects", getCellEffects).Methods("GET")
+	api.HandleFunc("/game-cells/{id}/effects", createCellEffect).Methods("POST")
+	api.HandleFunc("/game-cells/{id}/shop", buyFromCellShop).Methods("POST")
+	api.HandleFunc("/cell-effects/{id}", deleteCellEffect).Methods("DELETE")
 	api.HandleFunc("/warriors/{id}/deplete", depleteWarrior).Methods("POST")
 	api.HandleFunc("/warriors/{id}/revive", reviveWarrior).Methods("POST")
 
@@ -5455,10 +15174,13 @@ func main() {
 	api.HandleFunc("/battles/questions/unanswered/{userId}", getUnansweredQuestion).Methods("GET")
 	api.HandleFunc("/battles/assign-question", assignQuestionToBattle).Methods("POST")
 	api.HandleFunc("/battles/complete", completeBattle).Methods("POST")
+	api.HandleFunc("/battles/{id}/result", overrideBattleResult).Methods("PUT")
 
 	// Admin store management routes
 	api.HandleFunc("/admin/upload-store-images", uploadStoreImages).Methods("POST")
 	api.HandleFunc("/admin/insert-store-items", insertStoreItems).Methods("POST")
+	api.HandleFunc("/admin/media", uploadMedia).Methods("POST")
+	api.HandleFunc("/media/{id}", serveMedia).Methods("GET")
 
 	// Serve static files from the frontend build
 	staticPath := os.Getenv("STATIC_PATH")
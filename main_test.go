@@ -0,0 +1,1704 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func intPtr(i int) *int { return &i }
+
+func boolPtr(b bool) *bool { return &b }
+
+// Point db at a fresh database in a temporary directory for one test
+func openTestDB(t *testing.T) {
+	t.Helper()
+	t.Setenv("DB_PATH", filepath.Join(t.TempDir(), "test.db"))
+	log.SetOutput(io.Discard)
+	initDB()
+	log.SetOutput(os.Stderr)
+	t.Cleanup(func() { db.Close() })
+}
+
+// Run a statement while setting up a test, failing the test if it doesn't work
+func mustExec(t *testing.T, query string, args ...interface{}) int {
+	t.Helper()
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	id, _ := result.LastInsertId()
+	return int(id)
+}
+
+// Create an active game on an empty board of the given size, played by the given number of students.
+// Returns the game ID and the avatar IDs in turn order.
+func newTestGame(t *testing.T, topology string, rows, columns, players int) (int, []int) {
+	t.Helper()
+	gameID := mustExec(t, `INSERT INTO games (name, thumbnail, rows, columns, topology, status, current_turn_index, turn_number)
+		VALUES ('Test', '', ?, ?, ?, 'active', 0, 1)`, rows, columns, topology)
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			mustExec(t, "INSERT INTO game_cells (game_id, cell_id, name, active) VALUES (?, ?, ?, 1)",
+				gameID, formatCellID(row, col), formatCellID(row, col))
+		}
+	}
+
+	var avatarIDs []int
+	for i := 0; i < players; i++ {
+		userID := mustExec(t, "INSERT INTO users (name, password, role) VALUES (?, 'pw', 'student')", fmt.Sprintf("student%d-%d", gameID, i))
+		avatarID := mustExec(t, `INSERT INTO avatars (user_id, name, avatar_name, thumbnail, coins, element, super_power, personality, weakness, animal_ally, mascot)
+			VALUES (?, ?, ?, 't.png', 0, 'Fire 🔥', 'x', 'x', 'x', 'x', 'x')`, userID, fmt.Sprintf("Student %d", i), fmt.Sprintf("Avatar %d", i))
+		mustExec(t, "INSERT INTO game_avatars (game_id, avatar_id, turn_order) VALUES (?, ?, ?)", gameID, avatarID, i)
+		avatarIDs = append(avatarIDs, avatarID)
+	}
+	return gameID, avatarIDs
+}
+
+// Give an avatar a warrior, standing on the given cell of a game (or in reserve when cellID is empty)
+func newTestWarrior(t *testing.T, gameID, avatarID int, cellID string, attack, defense int) int {
+	t.Helper()
+	warriorID := mustExec(t, `INSERT INTO assets (avatar_id, status, type, name, thumbnail, attack, defense, healing, power, endurance, level,
+		cost, ability, health, stamina, base_attack, base_defense, base_healing)
+		VALUES (?, 'warrior', 'warrior', 'W', 't.png', ?, ?, 50, 50, 40, 1, 10, 'x', 100, 100, ?, ?, 50)`,
+		avatarID, attack, defense, attack, defense)
+	if cellID != "" {
+		mustExec(t, "UPDATE game_cells SET occupied_by = ?, status = 'warrior' WHERE game_id = ? AND cell_id = ?", warriorID, gameID, cellID)
+		mustExec(t, `INSERT INTO game_warriors (game_id, asset_id, avatar_id, health, stamina, status, cell_id)
+			SELECT ?, ?, ?, 100, 100, 'active', id FROM game_cells WHERE game_id = ? AND cell_id = ?`,
+			gameID, warriorID, avatarID, gameID, cellID)
+	}
+	return warriorID
+}
+
+// Look up the game_cells row ID of a cell such as "B2"
+func testCellID(t *testing.T, gameID int, cellID string) int {
+	t.Helper()
+	var id int
+	if err := db.QueryRow("SELECT id FROM game_cells WHERE game_id = ? AND cell_id = ?", gameID, cellID).Scan(&id); err != nil {
+		t.Fatalf("cell %s: %v", cellID, err)
+	}
+	return id
+}
+
+// The user an avatar belongs to
+func testUserID(t *testing.T, avatarID int) int {
+	t.Helper()
+	var userID int
+	if err := db.QueryRow("SELECT user_id FROM avatars WHERE id = ?", avatarID).Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	return userID
+}
+
+// Call a handler as the given user (0 for no token) with the route's path variables
+func callHandler(t *testing.T, handler http.HandlerFunc, method string, userID int, vars map[string]string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, "/", strings.NewReader(body))
+	if userID != 0 {
+		token, err := generateToken(userID, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	log.SetOutput(io.Discard)
+	handler(w, mux.SetURLVars(r, vars))
+	log.SetOutput(os.Stderr)
+	return w
+}
+
+func TestIsQuizAnswerCorrect(t *testing.T) {
+	choices := []interface{}{"uno", "dos", "tres"}
+	tests := []struct {
+		name     string
+		question QuizQuestion
+		want     bool
+	}{
+		{"choice by text", QuizQuestion{Type: "multiple", Answer: choices, Correct: intPtr(1), UserAnswer: "dos"}, true},
+		{"choice by index", QuizQuestion{Type: "multiple", Answer: choices, Correct: intPtr(1), UserAnswer: float64(1)}, true},
+		{"wrong choice", QuizQuestion{Type: "multiple-choice", Answer: choices, Correct: intPtr(1), UserAnswer: "uno"}, false},
+		{"choice from options", QuizQuestion{Type: "multiple", Options: choices, Correct: intPtr(0), UserAnswer: "uno"}, true},
+		{"choice index from options", QuizQuestion{Type: "multiple", Options: choices, Correct: intPtr(2), UserAnswer: float64(2)}, true},
+		{"wrong choice from options", QuizQuestion{Type: "multiple", Options: choices, Correct: intPtr(2), UserAnswer: "dos"}, false},
+		{"listen choice", QuizQuestion{Type: "listen", Answer: choices, Correct: intPtr(2), UserAnswer: "tres"}, true},
+		{"listen typed", QuizQuestion{Type: "listen", Answer: "Buenos días", UserAnswer: "buenos días "}, true},
+		{"listen typed any acceptable", QuizQuestion{Type: "listen", Answer: []interface{}{"gracias", "muchas gracias"}, UserAnswer: "Muchas gracias"}, true},
+		{"listen typed wrong", QuizQuestion{Type: "listen", Answer: "Buenos días", UserAnswer: "buenas noches"}, false},
+		{"correct out of range", QuizQuestion{Type: "multiple", Answer: choices, Correct: intPtr(5), UserAnswer: float64(5)}, false},
+		{"no answer", QuizQuestion{Type: "multiple", Answer: choices, Correct: intPtr(0)}, false},
+		{"typed ignores case and spaces", QuizQuestion{Type: "input", Answer: "Hola", UserAnswer: "  hOLA "}, true},
+		{"typed any acceptable", QuizQuestion{Type: "typed", Answer: []interface{}{"adiós", "adios"}, UserAnswer: "adios"}, true},
+		{"typed wrong", QuizQuestion{Type: "input", Answer: "Hola", UserAnswer: "hello"}, false},
+		{"written is never auto-graded", QuizQuestion{Type: "written", Answer: "x", UserAnswer: "x"}, false},
+	}
+
+	for _, tt := range tests {
+		if got := isQuizAnswerCorrect(tt.question); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestQuizRewards(t *testing.T) {
+	questions := []QuizQuestion{
+		{Type: "multiple", CoinsWorth: 10, IsCorrect: boolPtr(true)},
+		{Type: "input", CoinsWorth: 5, IsCorrect: boolPtr(false)},
+		{Type: "input", CoinsWorth: 7, IsCorrect: boolPtr(true)},
+		{Type: "written", CoinsWorth: 20, IsCorrect: boolPtr(true)}, // paid on release, not here
+	}
+
+	tests := []struct {
+		name      string
+		questions []QuizQuestion
+		retake    bool
+		wantCoins int
+		wantXP    int
+	}{
+		{"first attempt", questions, false, 17, 25},
+		{"retake earns 20%", questions, true, 2 + 1, 5},
+		{"no questions", nil, false, 0, 0},
+	}
+
+	for _, tt := range tests {
+		coins, xp := quizRewards(tt.questions, tt.retake)
+		if coins != tt.wantCoins || xp != tt.wantXP {
+			t.Errorf("%s: got %d coins and %d XP, want %d and %d", tt.name, coins, xp, tt.wantCoins, tt.wantXP)
+		}
+	}
+}
+
+func TestLooksLikeAudio(t *testing.T) {
+	tests := []struct {
+		name string
+		head []byte
+		want bool
+	}{
+		{"mp3 with ID3 tag", []byte("ID3\x03\x00\x00\x00\x00\x00\x00"), true},
+		{"raw mp3 frame", []byte{0xFF, 0xFB, 0x90, 0x64, 0x00}, true},
+		{"ogg", []byte("OggS\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00"), true},
+		{"webm", []byte{0x1A, 0x45, 0xDF, 0xA3, 0x9F, 0x42, 0x86, 0x81, 0x01, 0x42, 0x82, 0x84, 'w', 'e', 'b', 'm'}, true},
+		{"wav", []byte("RIFF\x24\x00\x00\x00WAVEfmt "), true},
+		{"text", []byte("hola, esto no es audio"), false},
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"), false},
+		{"random bytes", []byte{0x00, 0x01, 0x02, 0x03, 0x04}, false},
+	}
+
+	for _, tt := range tests {
+		if got := looksLikeAudio(tt.head); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestShuffleQuizData(t *testing.T) {
+	data := `[{"id":"a","type":"multiple","answer":["uno","dos","tres","cuatro"],"correct":2},` +
+		`{"id":"b","type":"input","answer":"hola"},` +
+		`{"id":"c","type":"listen","options":["rojo","azul"],"correct":0}]`
+
+	tests := []struct {
+		name string
+		seed int64
+	}{
+		{"seed 1", 1},
+		{"seed 42", 42},
+		{"negative seed", -7},
+	}
+
+	for _, tt := range tests {
+		first, err := shuffleQuizData(data, tt.seed)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		again, _ := shuffleQuizData(data, tt.seed)
+		if first != again {
+			t.Errorf("%s: same seed gave different orders", tt.name)
+		}
+
+		var questions []QuizQuestion
+		if err := json.Unmarshal([]byte(first), &questions); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(questions) != 3 {
+			t.Fatalf("%s: got %d questions, want 3", tt.name, len(questions))
+		}
+		for _, q := range questions {
+			if q.OriginalIndex == nil {
+				t.Errorf("%s: question %s has no original_index", tt.name, q.ID)
+			}
+			switch q.ID {
+			case "a":
+				choices := q.Answer.([]interface{})
+				if choices[*q.Correct] != "tres" {
+					t.Errorf("%s: correct points at %v, want tres", tt.name, choices[*q.Correct])
+				}
+				if len(q.ChoiceOrder) != 4 || q.ChoiceOrder[*q.Correct] != 2 {
+					t.Errorf("%s: choice_order %v does not map back to the original choice", tt.name, q.ChoiceOrder)
+				}
+			case "b":
+				if q.Answer != "hola" || q.ChoiceOrder != nil {
+					t.Errorf("%s: input question was changed", tt.name)
+				}
+			}
+		}
+	}
+
+	if _, err := shuffleQuizData("not json", 1); err == nil {
+		t.Error("invalid data: expected an error")
+	}
+}
+
+func TestStudentQuizData(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		hidden []string
+		kept   []string
+	}{
+		{"choice keeps its choices", `[{"id":"a","type":"multiple","answer":["x","y"],"correct":1,"original_index":0,"choice_order":[1,0]}]`,
+			[]string{`"correct"`, "original_index", "choice_order"}, []string{`"answer":["x","y"]`}},
+		{"choice under options", `[{"id":"a","type":"multiple","options":["x","y"],"answer":"y","correct":1}]`,
+			[]string{`"correct"`, `"answer"`}, []string{`"options":["x","y"]`}},
+		{"typed", `[{"id":"a","type":"input","answer":["hola","ola"]}]`,
+			[]string{`"answer"`, "hola"}, []string{`"type":"input"`}},
+		{"listen typed", `[{"id":"a","type":"listen","answer":"gracias","media_id":3}]`,
+			[]string{`"answer"`, "gracias"}, []string{`"media_id":3`}},
+		{"listen choice", `[{"id":"a","type":"listen","answer":["si","no"],"correct":0}]`,
+			[]string{`"correct"`}, []string{`"answer":["si","no"]`}},
+		{"student's own answer stays", `[{"id":"a","type":"input","answer":"hola","user_answer":"ola","is_correct":false}]`,
+			[]string{`"answer":"hola"`}, []string{`"user_answer":"ola"`, `"is_correct":false`}},
+	}
+
+	for _, tt := range tests {
+		got, err := studentQuizData(tt.data)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		for _, field := range tt.hidden {
+			if strings.Contains(got, field) {
+				t.Errorf("%s: %s still present in %s", tt.name, field, got)
+			}
+		}
+		for _, field := range tt.kept {
+			if !strings.Contains(got, field) {
+				t.Errorf("%s: %s missing from %s", tt.name, field, got)
+			}
+		}
+	}
+
+	if _, err := studentQuizData("not json"); err == nil {
+		t.Error("invalid data: expected an error")
+	}
+}
+
+func TestQuizCorrectAnswer(t *testing.T) {
+	choices := []interface{}{"uno", "dos"}
+	tests := []struct {
+		name     string
+		question QuizQuestion
+		want     interface{}
+	}{
+		{"choice", QuizQuestion{Type: "multiple", Answer: choices, Correct: intPtr(1)}, "dos"},
+		{"choice from options", QuizQuestion{Type: "multiple", Options: choices, Correct: intPtr(0)}, "uno"},
+		{"correct out of range", QuizQuestion{Type: "multiple", Answer: choices, Correct: intPtr(4)}, nil},
+		{"typed list", QuizQuestion{Type: "input", Answer: []interface{}{"adiós", "adios"}}, "adiós"},
+		{"typed string", QuizQuestion{Type: "listen", Answer: "hola"}, "hola"},
+		{"teacher graded", QuizQuestion{Type: "written", Answer: "model answer"}, nil},
+	}
+
+	for _, tt := range tests {
+		if got := quizCorrectAnswer(tt.question); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseGradebookWeights(t *testing.T) {
+	tests := []struct {
+		param   string
+		want    map[string]float64
+		wantErr bool
+	}{
+		{"", map[string]float64{}, false},
+		{"quiz:40,Homework:20, vocab : 40", map[string]float64{"quiz": 40, "homework": 20, "vocab": 40}, false},
+		{"quiz", nil, true},
+		{"quiz:abc", nil, true},
+		{"quiz:-5", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseGradebookWeights(tt.param)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: got error %v, wantErr %v", tt.param, err, tt.wantErr)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.param, got, tt.want)
+			continue
+		}
+		for category, weight := range tt.want {
+			if got[category] != weight {
+				t.Errorf("%q: weight for %s is %v, want %v", tt.param, category, got[category], weight)
+			}
+		}
+	}
+}
+
+func TestQuizScorePercent(t *testing.T) {
+	tests := []struct {
+		name      string
+		questions []QuizQuestion
+		want      float64
+		wantOK    bool
+	}{
+		{"all correct", []QuizQuestion{
+			{Type: "multiple", CoinsWorth: 10, IsCorrect: boolPtr(true)},
+			{Type: "input", CoinsWorth: 10, IsCorrect: boolPtr(true)},
+		}, 100, true},
+		{"weighted by coins", []QuizQuestion{
+			{Type: "multiple", CoinsWorth: 30, IsCorrect: boolPtr(true)},
+			{Type: "input", CoinsWorth: 10, IsCorrect: boolPtr(false)},
+		}, 75, true},
+		{"unanswered earns nothing", []QuizQuestion{
+			{Type: "multiple", CoinsWorth: 10, IsCorrect: boolPtr(true)},
+			{Type: "input", CoinsWorth: 10},
+		}, 50, true},
+		{"released teacher grade", []QuizQuestion{
+			{Type: "multiple", CoinsWorth: 10, IsCorrect: boolPtr(true)},
+			{Type: "written", CoinsWorth: 10, GradingStatus: "released", PointsAwarded: intPtr(5), IsCorrect: boolPtr(false)},
+		}, 75, true},
+		{"pending teacher grade", []QuizQuestion{
+			{Type: "multiple", CoinsWorth: 10, IsCorrect: boolPtr(true)},
+			{Type: "speak", CoinsWorth: 10, GradingStatus: "pending"},
+		}, 50, true},
+		{"no points", []QuizQuestion{{Type: "multiple"}}, 0, false},
+		{"no questions", nil, 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := quizScorePercent(tt.questions)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("%s: got %v, %v, want %v, %v", tt.name, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestXLSXColumnName(t *testing.T) {
+	tests := []struct {
+		index int
+		want  string
+	}{
+		{0, "A"},
+		{25, "Z"},
+		{26, "AA"},
+		{51, "AZ"},
+		{52, "BA"},
+		{701, "ZZ"},
+		{702, "AAA"},
+	}
+
+	for _, tt := range tests {
+		if got := xlsxColumnName(tt.index); got != tt.want {
+			t.Errorf("%d: got %s, want %s", tt.index, got, tt.want)
+		}
+	}
+}
+
+func TestWriteXLSX(t *testing.T) {
+	tests := []struct {
+		name      string
+		sheetName string
+		table     [][]interface{}
+		want      []string
+		notWant   []string
+	}{
+		{
+			name:      "numbers and strings",
+			sheetName: "Gradebook",
+			table:     [][]interface{}{{"Student", "Score"}, {"Ana", 95.5}, {"Ben", 80}},
+			want: []string{
+				`<c r="A1" t="inlineStr"><is><t>Student</t></is></c>`,
+				`<c r="B2"><v>95.5</v></c>`,
+				`<c r="B3"><v>80</v></c>`,
+			},
+		},
+		{
+			name:      "escapes text",
+			sheetName: "A&B",
+			table:     [][]interface{}{{"<Tom & Jerry>"}},
+			want:      []string{`&lt;Tom &amp; Jerry&gt;`},
+		},
+		{
+			name:      "skips empty strings",
+			sheetName: "Sheet",
+			table:     [][]interface{}{{"x", "", "y"}},
+			want:      []string{`<c r="C1" t="inlineStr"><is><t>y</t></is></c>`},
+			notWant:   []string{`r="B1"`},
+		},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := writeXLSX(&buf, tt.sheetName, tt.table); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatalf("%s: not a zip archive: %v", tt.name, err)
+		}
+		contents := make(map[string]string)
+		for _, f := range archive.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := io.ReadAll(rc)
+			rc.Close()
+			contents[f.Name] = string(data)
+		}
+
+		for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/worksheets/sheet1.xml"} {
+			if _, ok := contents[name]; !ok {
+				t.Errorf("%s: missing %s", tt.name, name)
+			}
+		}
+		sheet := contents["xl/worksheets/sheet1.xml"]
+		for _, s := range tt.want {
+			if !strings.Contains(sheet, s) {
+				t.Errorf("%s: sheet is missing %s", tt.name, s)
+			}
+		}
+		for _, s := range tt.notWant {
+			if strings.Contains(sheet, s) {
+				t.Errorf("%s: sheet should not contain %s", tt.name, s)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	writeXLSX(&buf, "A&B", nil)
+	archive, _ := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	for _, f := range archive.File {
+		if f.Name == "xl/workbook.xml" {
+			rc, _ := f.Open()
+			data, _ := io.ReadAll(rc)
+			rc.Close()
+			if !strings.Contains(string(data), `name="A&amp;B"`) {
+				t.Errorf("sheet name not escaped: %s", data)
+			}
+		}
+	}
+}
+
+func TestCheckWebSocketOrigin(t *testing.T) {
+	t.Setenv("FRONTEND_ORIGIN", "http://localhost:5173, https://quest.example.org/")
+
+	tests := []struct {
+		name   string
+		origin string
+		want   bool
+	}{
+		{"same origin", "https://game.example.org", true},
+		{"configured dev server", "http://localhost:5173", true},
+		{"configured with trailing slash", "https://quest.example.org", true},
+		{"no origin header", "", true},
+		{"other site", "https://evil.example.com", false},
+		{"configured host on another port", "http://localhost:3000", false},
+		{"invalid origin", "://", false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "http://game.example.org/api/games/1/ws", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := checkWebSocketOrigin(r); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCellIDRoundTrip(t *testing.T) {
+	tests := []struct {
+		row, col int
+		id       string
+	}{
+		{0, 0, "A1"},
+		{1, 2, "B3"},
+		{25, 9, "Z10"},
+		{26, 11, "AA12"},
+		{51, 0, "AZ1"},
+		{52, 4, "BA5"},
+		{701, 99, "ZZ100"},
+	}
+
+	for _, tt := range tests {
+		if got := formatCellID(tt.row, tt.col); got != tt.id {
+			t.Errorf("formatCellID(%d, %d): got %s, want %s", tt.row, tt.col, got, tt.id)
+		}
+		row, col, ok := parseCellID(tt.id)
+		if !ok || row != tt.row || col != tt.col {
+			t.Errorf("parseCellID(%s): got (%d, %d, %v), want (%d, %d, true)", tt.id, row, col, ok, tt.row, tt.col)
+		}
+	}
+}
+
+func TestParseCellIDInvalid(t *testing.T) {
+	for _, id := range []string{"", "A", "1", "A0", "a1", "ABC1", "A-1", "A1B", " A1"} {
+		if _, _, ok := parseCellID(id); ok {
+			t.Errorf("parseCellID(%q): expected failure", id)
+		}
+	}
+}
+
+func TestTerrainCost(t *testing.T) {
+	tests := []struct {
+		cell, avatar string
+		want         int
+	}{
+		{"", "fire", 1},
+		{"Earth 🌍", "water", 1},
+		{"Fire 🔥", "water", 2},
+		{"Fire 🔥", "Fire", 1},
+		{"gravity", "", 3},
+		{"Time ⏳", "time", 1},
+		{"unknown", "fire", 1},
+	}
+
+	for _, tt := range tests {
+		if got := terrainCost(tt.cell, tt.avatar); got != tt.want {
+			t.Errorf("terrainCost(%q, %q): got %d, want %d", tt.cell, tt.avatar, got, tt.want)
+		}
+	}
+}
+
+func TestMovementRange(t *testing.T) {
+	tests := []struct {
+		endurance, stamina int
+		want               int
+	}{
+		{0, 100, 1},
+		{24, 100, 1},
+		{25, 100, 2},
+		{100, 100, 5},
+		{100, 49, 3},
+		{50, 10, 2},
+		{0, 49, 1},
+		{100, 0, 0},
+		{100, -5, 0},
+	}
+
+	for _, tt := range tests {
+		if got := movementRange(tt.endurance, tt.stamina); got != tt.want {
+			t.Errorf("movementRange(%d, %d): got %d, want %d", tt.endurance, tt.stamina, got, tt.want)
+		}
+	}
+}
+
+func TestAdvanceGameTurn(t *testing.T) {
+	openTestDB(t)
+
+	tests := []struct {
+		name      string
+		status    string
+		absent    []int // Turn positions marked absent
+		from      int   // Turn position whose turn is ended, -1 for whoever's turn it is
+		wantIndex int
+		wantErr   string
+	}{
+		{"next avatar", "active", nil, -1, 1, ""},
+		{"skips absent avatars", "active", []int{1}, -1, 2, ""},
+		{"wraps around", "active", []int{1, 2}, -1, 0, ""},
+		{"ends the given avatar's turn", "active", nil, 0, 1, ""},
+		{"another avatar's turn", "active", nil, 2, 0, "turn was already advanced"},
+		{"paused", "paused", nil, -1, 0, "the game is paused"},
+		{"archived", "archived", nil, -1, 0, "the game is archived"},
+		{"setup", "setup", nil, -1, 0, "the game is setup"},
+		{"finished", "finished", nil, -1, 0, "the game is finished"},
+	}
+
+	for _, tt := range tests {
+		gameID, avatars := newTestGame(t, "square", 2, 2, 3)
+		mustExec(t, "UPDATE games SET status = ? WHERE id = ?", tt.status, gameID)
+		for _, position := range tt.absent {
+			mustExec(t, "UPDATE game_avatars SET absent = 1 WHERE game_id = ? AND avatar_id = ?", gameID, avatars[position])
+		}
+		from := 0
+		if tt.from >= 0 {
+			from = avatars[tt.from]
+		}
+
+		index, err := advanceGameTurn(gameID, from)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.wantErr)
+			}
+			var currentIndex int
+			db.QueryRow("SELECT current_turn_index FROM games WHERE id = ?", gameID).Scan(&currentIndex)
+			if currentIndex != 0 {
+				t.Errorf("%s: the turn moved to %d", tt.name, currentIndex)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if index != tt.wantIndex {
+			t.Errorf("%s: got turn index %d, want %d", tt.name, index, tt.wantIndex)
+		}
+	}
+}
+
+func TestValidateWinCondition(t *testing.T) {
+	tests := []struct {
+		condition string
+		target    int
+		wantErr   bool
+	}{
+		{"none", 0, false},
+		{"last_standing", 0, false},
+		{"cells", 5, false},
+		{"cells", 0, true},
+		{"coins", 100, false},
+		{"coins", -1, true},
+		{"rounds", 1, false},
+		{"rounds", 0, true},
+		{"", 0, true},
+		{"points", 10, true},
+	}
+
+	for _, tt := range tests {
+		err := validateWinCondition(tt.condition, tt.target)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateWinCondition(%q, %d): got %v, wantErr %v", tt.condition, tt.target, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRedactGameEvent(t *testing.T) {
+	visible := map[int]bool{1: true, 2: true}
+	tests := []struct {
+		name    string
+		message string
+		want    map[string]interface{} // nil when the event is dropped
+		raw     bool                   // Expect the message back untouched
+	}{
+		{
+			name:    "own event",
+			message: `{"type":"warrior_moved","gameId":1,"data":{"avatarId":7,"fromCellId":5,"toCellId":6}}`,
+			raw:     true,
+		},
+		{
+			name:    "invalid json",
+			message: `not json`,
+			raw:     true,
+		},
+		{
+			name:    "cell update out of sight",
+			message: `{"type":"cell_updated","gameId":1,"data":{"id":5,"occupiedBy":3,"status":"occupied"}}`,
+			want:    map[string]interface{}{"id": 5.0, "occupiedBy": 0.0, "status": ""},
+		},
+		{
+			name:    "cell update in sight",
+			message: `{"type":"cell_updated","gameId":1,"data":{"id":1,"occupiedBy":3,"status":"occupied"}}`,
+			want:    map[string]interface{}{"id": 1.0, "occupiedBy": 3.0, "status": "occupied"},
+		},
+		{
+			name:    "all cells out of sight",
+			message: `{"type":"warrior_placed","gameId":1,"data":{"avatarId":3,"cellId":5}}`,
+		},
+		{
+			name:    "move seen from one end",
+			message: `{"type":"warrior_moved","gameId":1,"data":{"avatarId":3,"fromCellId":5,"toCellId":2}}`,
+			want:    map[string]interface{}{"avatarId": 3.0, "fromCellId": nil, "toCellId": 2.0},
+		},
+		{
+			name:    "no cells",
+			message: `{"type":"turn_changed","gameId":1,"data":{"avatarId":3}}`,
+			want:    map[string]interface{}{"avatarId": 3.0},
+		},
+	}
+
+	for _, tt := range tests {
+		got := redactGameEvent([]byte(tt.message), 7, visible)
+		if tt.raw {
+			if string(got) != tt.message {
+				t.Errorf("%s: got %s, want the message unchanged", tt.name, got)
+			}
+			continue
+		}
+		if tt.want == nil {
+			if got != nil {
+				t.Errorf("%s: got %s, want the event dropped", tt.name, got)
+			}
+			continue
+		}
+		var event struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		if err := json.Unmarshal(got, &event); err != nil {
+			t.Fatalf("%s: could not decode %s: %v", tt.name, got, err)
+		}
+		if len(event.Data) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, event.Data, tt.want)
+			continue
+		}
+		for key, want := range tt.want {
+			if value, ok := event.Data[key]; !ok || value != want {
+				t.Errorf("%s: %s = %v, want %v", tt.name, key, event.Data[key], want)
+			}
+		}
+	}
+}
+
+func TestValidateCellEffect(t *testing.T) {
+	tests := []struct {
+		name    string
+		effect  TemplateEffect
+		wantErr bool
+	}{
+		{"heal", TemplateEffect{Type: "heal", Amount: 20}, false},
+		{"negative damage", TemplateEffect{Type: "damage", Amount: -50}, true},
+		{"damage without amount", TemplateEffect{Type: "damage"}, true},
+		{"stamina on turn start", TemplateEffect{Type: "stamina", Trigger: "turn_start", Amount: 5}, false},
+		{"unknown trigger", TemplateEffect{Type: "heal", Trigger: "leave", Amount: 5}, true},
+		{"unknown type", TemplateEffect{Type: "poison", Amount: 5}, true},
+		{"freeze without duration", TemplateEffect{Type: "freeze"}, true},
+		{"freeze", TemplateEffect{Type: "freeze", Duration: 2}, false},
+		{"buff without duration", TemplateEffect{Type: "buff", Amount: 10}, true},
+		{"unlimited charges", TemplateEffect{Type: "heal", Amount: 5, Charges: intPtr(-1)}, false},
+		{"charges below unlimited", TemplateEffect{Type: "heal", Amount: 5, Charges: intPtr(-2)}, true},
+		{"negative cooldown", TemplateEffect{Type: "heal", Amount: 5, Cooldown: -1}, true},
+		{"teleport on turn start", TemplateEffect{Type: "teleport", Trigger: "turn_start"}, true},
+		{"empty shop", TemplateEffect{Type: "shop"}, true},
+		{"shop", TemplateEffect{Type: "shop", ShopItems: []ShopItem{{Name: "Potion", Effect: "heal", Amount: 20, Cost: 5}}}, false},
+		{"shop item without amount", TemplateEffect{Type: "shop", ShopItems: []ShopItem{{Name: "Potion", Effect: "heal", Cost: 5}}}, true},
+		{"shop item with unknown effect", TemplateEffect{Type: "shop", ShopItems: []ShopItem{{Name: "Bomb", Effect: "damage", Amount: 5}}}, true},
+		{"buff item without duration", TemplateEffect{Type: "shop", ShopItems: []ShopItem{{Name: "Tonic", Effect: "buff", Amount: 5}}}, true},
+	}
+
+	for _, tt := range tests {
+		err := validateCellEffect(tt.effect)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestGenerateBoard(t *testing.T) {
+	tests := []struct {
+		topology      string
+		rows, columns int
+		seed          int64
+	}{
+		{"square", 1, 1, 1},
+		{"square", 5, 5, 42},
+		{"square", 8, 12, 7},
+		{"square", 20, 20, 12345},
+		{"hex", 6, 6, 3},
+		{"hex", 15, 10, 99},
+	}
+
+	for _, tt := range tests {
+		board := generateBoard(tt.topology, tt.rows, tt.columns, tt.seed)
+		if err := validateBoardTemplate(board); err != nil {
+			t.Errorf("generateBoard(%s, %d, %d, %d): invalid template: %v", tt.topology, tt.rows, tt.columns, tt.seed, err)
+			continue
+		}
+		if board.Topology != tt.topology {
+			t.Errorf("generateBoard(%s, %d, %d, %d): got topology %q", tt.topology, tt.rows, tt.columns, tt.seed, board.Topology)
+		}
+		if len(board.Cells) != tt.rows*tt.columns {
+			t.Errorf("generateBoard(%s, %d, %d, %d): got %d cells, want %d", tt.topology, tt.rows, tt.columns, tt.seed, len(board.Cells), tt.rows*tt.columns)
+		}
+
+		// Every road cell touches another road or a capital on this topology
+		kind := make(map[[2]int]string)
+		for _, cell := range board.Cells {
+			row, col, _ := parseCellID(cell.CellID)
+			if cell.Name == "Road" {
+				kind[[2]int{row, col}] = "road"
+			} else if strings.HasSuffix(cell.Name, " Kingdom") {
+				kind[[2]int{row, col}] = "capital"
+			}
+		}
+		capitals := 0
+		for coord, k := range kind {
+			if k == "capital" {
+				capitals++
+				continue
+			}
+			joined := false
+			for _, next := range boardNeighbors(tt.topology, tt.rows, tt.columns, coord[0], coord[1]) {
+				if kind[next] != "" {
+					joined = true
+				}
+			}
+			if !joined {
+				t.Errorf("generateBoard(%s, %d, %d, %d): road at %s leads nowhere", tt.topology, tt.rows, tt.columns, tt.seed, formatCellID(coord[0], coord[1]))
+			}
+		}
+		if capitals < 1 {
+			t.Errorf("generateBoard(%s, %d, %d, %d): no capitals", tt.topology, tt.rows, tt.columns, tt.seed)
+		}
+
+		// The same seed always gives the same board
+		first, _ := json.Marshal(board)
+		again, _ := json.Marshal(generateBoard(tt.topology, tt.rows, tt.columns, tt.seed))
+		if !bytes.Equal(first, again) {
+			t.Errorf("generateBoard(%s, %d, %d, %d): not the same board twice", tt.topology, tt.rows, tt.columns, tt.seed)
+		}
+	}
+}
+
+func TestParseCellRange(t *testing.T) {
+	tests := []struct {
+		cellRange                      string
+		fromRow, fromCol, toRow, toCol int
+		ok                             bool
+	}{
+		{"A1:B2", 0, 0, 1, 1, true},
+		{"C3:H9", 2, 2, 7, 8, true},
+		{"H9:C3", 2, 2, 7, 8, true},
+		{"C9:H3", 2, 2, 7, 8, true},
+		{" B2 : B2 ", 1, 1, 1, 1, true},
+		{"AA1:A1", 0, 0, 26, 0, true},
+		{"A1", 0, 0, 0, 0, false},
+		{"A1:B2:C3", 0, 0, 0, 0, false},
+		{"A1:", 0, 0, 0, 0, false},
+		{"A0:B2", 0, 0, 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		fromRow, fromCol, toRow, toCol, ok := parseCellRange(tt.cellRange)
+		if ok != tt.ok {
+			t.Errorf("parseCellRange(%q): got ok %v, want %v", tt.cellRange, ok, tt.ok)
+			continue
+		}
+		if ok && (fromRow != tt.fromRow || fromCol != tt.fromCol || toRow != tt.toRow || toCol != tt.toCol) {
+			t.Errorf("parseCellRange(%q): got (%d, %d)-(%d, %d), want (%d, %d)-(%d, %d)", tt.cellRange,
+				fromRow, fromCol, toRow, toCol, tt.fromRow, tt.fromCol, tt.toRow, tt.toCol)
+		}
+	}
+}
+
+func TestBoardMask(t *testing.T) {
+	tests := []struct {
+		name          string
+		topology      string
+		shape         string
+		mask          []string
+		rows, columns int
+		inactive      []string // Every cell that should start inactive
+		wantErr       bool
+	}{
+		{"plain", "square", "", nil, 3, 3, nil, false},
+		{"mask cells and ranges", "square", "rectangle", []string{"A1", "B2:C3"}, 3, 3, []string{"A1", "B2", "B3", "C2", "C3"}, false},
+		{"range past the edge", "square", "", []string{"C2:Z9"}, 3, 3, []string{"C2", "C3"}, false},
+		{"circle", "square", "circle", nil, 4, 4, []string{"A1", "A4", "D1", "D4"}, false},
+		{"hexagon", "hex", "hexagon", nil, 3, 3, []string{"A1", "C3"}, false},
+		{"circle on hex", "hex", "circle", nil, 4, 4, nil, true},
+		{"hexagon on square", "square", "hexagon", nil, 4, 4, nil, true},
+		{"unknown shape", "square", "star", nil, 4, 4, nil, true},
+		{"bad mask entry", "square", "", []string{"B2-C3"}, 3, 3, nil, true},
+		{"nothing left", "square", "", []string{"A1:B2"}, 2, 2, nil, true},
+	}
+
+	for _, tt := range tests {
+		inactive, err := boardMask(tt.topology, tt.shape, tt.mask, tt.rows, tt.columns)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		want := make(map[[2]int]bool)
+		for _, cellID := range tt.inactive {
+			row, col, _ := parseCellID(cellID)
+			want[[2]int{row, col}] = true
+		}
+		if len(inactive) != len(want) {
+			t.Errorf("%s: got %d inactive cells, want %d", tt.name, len(inactive), len(want))
+		}
+		for coord := range want {
+			if !inactive[coord] {
+				t.Errorf("%s: %s should be inactive", tt.name, formatCellID(coord[0], coord[1]))
+			}
+		}
+	}
+}
+
+func TestChooseBotActionIsSeeded(t *testing.T) {
+	openTestDB(t)
+
+	tests := []struct {
+		name     string
+		topology string
+		fog      bool
+		seed     int64
+	}{
+		{"square", "square", false, 1},
+		{"square other seed", "square", false, 42},
+		{"hex", "hex", false, 7},
+		{"fogged", "square", true, 99999},
+	}
+
+	for _, tt := range tests {
+		gameID, avatars := newTestGame(t, tt.topology, 5, 5, 2)
+		mustExec(t, "UPDATE games SET fog_of_war = ?, vision_radius = 1 WHERE id = ?", tt.fog, gameID)
+		// Equal rewards in every corner, so the seed has ties to break
+		mustExec(t, "UPDATE game_cells SET reward_coins = 10 WHERE game_id = ? AND cell_id IN ('A1', 'A5', 'E1', 'E5')", gameID)
+		newTestWarrior(t, gameID, avatars[0], "C3", 50, 50)
+		newTestWarrior(t, gameID, avatars[0], "", 50, 50)
+		newTestWarrior(t, gameID, avatars[1], "E3", 10, 10)
+
+		// Two bots with the same seed make the same choices, call after call
+		first, again := rand.New(rand.NewSource(tt.seed)), rand.New(rand.NewSource(tt.seed))
+		for call := 0; call < 3; call++ {
+			want, err := chooseBotAction(db, gameID, avatars[0], first)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			got, err := chooseBotAction(db, gameID, avatars[0], again)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if want == nil || got == nil {
+				t.Fatalf("%s: call %d: the bot found nothing to do", tt.name, call)
+			}
+			if *got != *want {
+				t.Errorf("%s: call %d: got %+v, want %+v", tt.name, call, *got, *want)
+			}
+		}
+	}
+}
+
+func TestBattleOutcome(t *testing.T) {
+	// A round with who answered right and the fighters' state after it
+	round := func(attackerCorrect, defenderCorrect bool, attackerHealth, attackerStamina, defenderHealth int) BattleRound {
+		return BattleRound{AttackerCorrect: attackerCorrect, DefenderCorrect: defenderCorrect,
+			AttackerHealth: attackerHealth, AttackerStamina: attackerStamina, DefenderHealth: defenderHealth}
+	}
+
+	tests := []struct {
+		name         string
+		format       string
+		rounds       []BattleRound
+		finished     bool
+		attackerWins int
+		defenderWins int
+	}{
+		{"no rounds yet", "best_of_3", nil, false, 0, 0},
+		{"single is one round", "single", []BattleRound{round(false, true, 100, 75, 100)}, true, 0, 1},
+		{"unknown format plays one round", "", []BattleRound{round(true, true, 100, 100, 50)}, true, 0, 0},
+		{"best of 3 after one round", "best_of_3", []BattleRound{round(true, false, 100, 100, 60)}, false, 1, 0},
+		{"best of 3 won 2-0", "best_of_3", []BattleRound{round(true, false, 100, 100, 60), round(true, false, 100, 100, 20)}, true, 2, 0},
+		{"best of 3 at 1-1", "best_of_3", []BattleRound{round(true, false, 100, 100, 60), round(false, true, 100, 75, 60)}, false, 1, 1},
+		{"best of 3 with ties runs to the limit", "best_of_3",
+			[]BattleRound{round(true, true, 100, 100, 80), round(false, false, 100, 75, 55), round(true, true, 100, 75, 35)}, true, 0, 0},
+		{"knockout ends best of 5", "best_of_5", []BattleRound{round(true, false, 100, 100, 0)}, true, 1, 0},
+		{"attacker with no health", "best_of_5", []BattleRound{round(false, true, 0, 75, 100)}, true, 0, 1},
+		{"until_ko goes on", "until_ko", []BattleRound{round(true, false, 100, 100, 40), round(true, false, 100, 100, 10)}, false, 2, 0},
+		{"until_ko ends on no stamina", "until_ko", []BattleRound{round(false, true, 100, 0, 40)}, true, 0, 1},
+	}
+
+	for _, tt := range tests {
+		finished, attackerWins, defenderWins := battleOutcome(tt.format, tt.rounds)
+		if finished != tt.finished || attackerWins != tt.attackerWins || defenderWins != tt.defenderWins {
+			t.Errorf("%s: got (%v, %d, %d), want (%v, %d, %d)", tt.name, finished, attackerWins, defenderWins,
+				tt.finished, tt.attackerWins, tt.defenderWins)
+		}
+	}
+}
+
+func TestBoardMutationConflicts(t *testing.T) {
+	openTestDB(t)
+
+	// Each case sets up the board as a competing request would have left it, then acts as avatar 0
+	// with warrior "mine" (on A1) and reserve warrior "reserve"
+	tests := []struct {
+		name       string
+		setup      string // SQL run with the game ID, before acting
+		act        func(gameID, avatarID, mine, reserve int) (int, error)
+		wantStatus int
+	}{
+		{"place on an empty cell", "", func(gameID, avatarID, mine, reserve int) (int, error) {
+			_, status, err := placeWarrior(gameID, testCellID(t, gameID, "B2"), reserve, avatarID)
+			return status, err
+		}, http.StatusOK},
+		{"place on a cell just taken", "", func(gameID, avatarID, mine, reserve int) (int, error) {
+			_, status, err := placeWarrior(gameID, testCellID(t, gameID, "C3"), reserve, avatarID)
+			return status, err
+		}, http.StatusConflict},
+		{"place a warrior already on the board", "", func(gameID, avatarID, mine, reserve int) (int, error) {
+			_, status, err := placeWarrior(gameID, testCellID(t, gameID, "B2"), mine, avatarID)
+			return status, err
+		}, http.StatusConflict},
+		{"no actions left", "UPDATE games SET actions_per_turn = 1, actions_taken = 1 WHERE id = ?", func(gameID, avatarID, mine, reserve int) (int, error) {
+			_, status, err := placeWarrior(gameID, testCellID(t, gameID, "B2"), reserve, avatarID)
+			return status, err
+		}, http.StatusConflict},
+		{"battle started meanwhile", "UPDATE games SET battle_id = (SELECT id FROM battles WHERE name = 'Held') WHERE id = ?", func(gameID, avatarID, mine, reserve int) (int, error) {
+			_, status, err := placeWarrior(gameID, testCellID(t, gameID, "B2"), reserve, avatarID)
+			return status, err
+		}, http.StatusConflict},
+		{"game paused meanwhile", "UPDATE games SET status = 'paused' WHERE id = ?", func(gameID, avatarID, mine, reserve int) (int, error) {
+			_, status, err := placeWarrior(gameID, testCellID(t, gameID, "B2"), reserve, avatarID)
+			return status, err
+		}, http.StatusConflict},
+		{"turn moved on meanwhile", "UPDATE games SET current_turn_index = 1 WHERE id = ?", func(gameID, avatarID, mine, reserve int) (int, error) {
+			_, status, err := placeWarrior(gameID, testCellID(t, gameID, "B2"), reserve, avatarID)
+			return status, err
+		}, http.StatusForbidden},
+		{"move to an empty cell", "", func(gameID, avatarID, mine, reserve int) (int, error) {
+			_, status, err := moveWarriorOnBoard(gameID, testCellID(t, gameID, "A1"), testCellID(t, gameID, "A2"), mine, avatarID)
+			return status, err
+		}, http.StatusOK},
+		{"move a warrior that already left", "", func(gameID, avatarID, mine, reserve int) (int, error) {
+			_, status, err := moveWarriorOnBoard(gameID, testCellID(t, gameID, "B1"), testCellID(t, gameID, "A2"), mine, avatarID)
+			return status, err
+		}, http.StatusConflict},
+		{"move to a cell just taken", "", func(gameID, avatarID, mine, reserve int) (int, error) {
+			_, status, err := moveWarriorOnBoard(gameID, testCellID(t, gameID, "A1"), testCellID(t, gameID, "C3"), mine, avatarID)
+			return status, err
+		}, http.StatusConflict},
+	}
+
+	mustExec(t, "INSERT INTO battles (name, status) VALUES ('Held', 'in_progress')")
+
+	for _, tt := range tests {
+		gameID, avatars := newTestGame(t, "square", 3, 3, 2)
+		mine := newTestWarrior(t, gameID, avatars[0], "A1", 50, 50)
+		reserve := newTestWarrior(t, gameID, avatars[0], "", 50, 50)
+		newTestWarrior(t, gameID, avatars[1], "C3", 50, 50)
+		mustExec(t, "UPDATE games SET actions_per_turn = 3 WHERE id = ?", gameID)
+		if tt.setup != "" {
+			mustExec(t, tt.setup, gameID)
+		}
+
+		var boardBefore string
+		db.QueryRow("SELECT group_concat(cell_id || ':' || COALESCE(occupied_by, 0)) FROM game_cells WHERE game_id = ?", gameID).Scan(&boardBefore)
+		var eventsBefore int
+		db.QueryRow("SELECT COUNT(*) FROM game_events WHERE game_id = ?", gameID).Scan(&eventsBefore)
+
+		status, err := tt.act(gameID, avatars[0], mine, reserve)
+		if status != tt.wantStatus {
+			t.Errorf("%s: got status %d (%v), want %d", tt.name, status, err, tt.wantStatus)
+			continue
+		}
+		if tt.wantStatus == http.StatusOK {
+			continue
+		}
+
+		// A refused action changes nothing: no board change, no event and no action used
+		var boardAfter string
+		var eventsAfter, actionsTaken int
+		db.QueryRow("SELECT group_concat(cell_id || ':' || COALESCE(occupied_by, 0)) FROM game_cells WHERE game_id = ?", gameID).Scan(&boardAfter)
+		db.QueryRow("SELECT COUNT(*) FROM game_events WHERE game_id = ?", gameID).Scan(&eventsAfter)
+		db.QueryRow("SELECT COALESCE(actions_taken, 0) FROM games WHERE id = ?", gameID).Scan(&actionsTaken)
+		if boardAfter != boardBefore || eventsAfter != eventsBefore {
+			t.Errorf("%s: the board changed from %s to %s (%d events, was %d)", tt.name, boardBefore, boardAfter, eventsAfter, eventsBefore)
+		}
+		if tt.setup == "" && actionsTaken != 0 {
+			t.Errorf("%s: used %d actions", tt.name, actionsTaken)
+		}
+	}
+}
+
+func TestCellRewardClaims(t *testing.T) {
+	openTestDB(t)
+	adminID := mustExec(t, "INSERT INTO users (name, password, role) VALUES ('teacher', 'pw', 'admin')")
+
+	gameID, avatars := newTestGame(t, "square", 2, 2, 2)
+	mustExec(t, "UPDATE games SET actions_per_turn = 5, round = 1 WHERE id = ?", gameID)
+	warrior := newTestWarrior(t, gameID, avatars[0], "", 50, 50)
+	cellID := testCellID(t, gameID, "B2")
+	mustExec(t, `UPDATE game_cells SET reward_coins = 20, reward_xp = 10, base_reward_coins = 20, base_reward_xp = 10, regen_rounds = 2
+		WHERE id = ?`, cellID)
+
+	coins := func() (int, int) {
+		var avatarCoins, collected int
+		db.QueryRow("SELECT coins FROM avatars WHERE id = ?", avatars[0]).Scan(&avatarCoins)
+		db.QueryRow("SELECT COALESCE(coins_collected, 0) FROM game_avatars WHERE game_id = ? AND avatar_id = ?", gameID, avatars[0]).Scan(&collected)
+		return avatarCoins, collected
+	}
+	claimCount := func() int {
+		var count int
+		db.QueryRow("SELECT COUNT(*) FROM cell_reward_claims WHERE game_id = ?", gameID).Scan(&count)
+		return count
+	}
+
+	// Entering the cell collects its rewards once, tied to the placement's event
+	if _, status, err := placeWarrior(gameID, cellID, warrior, avatars[0]); err != nil {
+		t.Fatalf("placing the warrior: %d %v", status, err)
+	}
+	if avatarCoins, collected := coins(); avatarCoins != 20 || collected != 20 {
+		t.Errorf("after entering: got %d coins (%d collected), want 20 (20)", avatarCoins, collected)
+	}
+	var xpGained int
+	db.QueryRow("SELECT xp_gained FROM game_warriors WHERE game_id = ? AND asset_id = ?", gameID, warrior).Scan(&xpGained)
+	if xpGained != 10 {
+		t.Errorf("after entering: the warrior gained %d XP, want 10", xpGained)
+	}
+	var linked int
+	db.QueryRow(`SELECT COUNT(*) FROM cell_reward_claims c JOIN game_events e ON e.id = c.event_id
+		WHERE c.game_id = ? AND e.event_type = 'warrior_placed'`, gameID).Scan(&linked)
+	if claimCount() != 1 || linked != 1 {
+		t.Errorf("after entering: got %d claims (%d linked to the placement), want 1 (1)", claimCount(), linked)
+	}
+
+	// Claiming again finds nothing left
+	tx, _ := db.Begin()
+	again, againXP, err := claimCellReward(tx, gameID, cellID, warrior)
+	tx.Commit()
+	if err != nil || again != 0 || againXP != 0 || claimCount() != 1 {
+		t.Errorf("claiming twice: got %d coins and %d XP (%v), %d claims", again, againXP, err, claimCount())
+	}
+
+	// The cell refills once its regeneration period has passed since it was emptied
+	for _, tt := range []struct {
+		round int
+		want  int
+	}{{2, 0}, {3, 1}} {
+		tx, _ := db.Begin()
+		refilled, err := regenerateCellRewards(tx, gameID, tt.round)
+		tx.Rollback()
+		if err != nil || len(refilled) != tt.want {
+			t.Errorf("regenerating in round %d: got %v (%v), want %d cells", tt.round, refilled, err, tt.want)
+		}
+	}
+
+	// Undoing the placement takes back the claim and its coins and puts the rewards back
+	w := callHandler(t, undoGameAction, "POST", adminID, map[string]string{"id": fmt.Sprint(gameID)}, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("undo: got %d %s", w.Code, w.Body.String())
+	}
+	var rewardCoins int
+	var claimedRound sql.NullInt64
+	db.QueryRow("SELECT reward_coins, claimed_round FROM game_cells WHERE id = ?", cellID).Scan(&rewardCoins, &claimedRound)
+	if avatarCoins, collected := coins(); avatarCoins != 0 || collected != 0 || claimCount() != 0 || rewardCoins != 20 || claimedRound.Valid {
+		t.Errorf("after undo: got %d coins (%d collected), %d claims, cell has %d coins (claimed in %v)",
+			avatarCoins, collected, claimCount(), rewardCoins, claimedRound)
+	}
+
+	// Only the teacher can undo
+	var studentID int
+	db.QueryRow("SELECT user_id FROM avatars WHERE id = ?", avatars[0]).Scan(&studentID)
+	w = callHandler(t, undoGameAction, "POST", studentID, map[string]string{"id": fmt.Sprint(gameID)}, "")
+	if w.Code != http.StatusForbidden {
+		t.Errorf("undo by a student: got %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestGameWarriorStateAndUndo(t *testing.T) {
+	openTestDB(t)
+	adminID := mustExec(t, "INSERT INTO users (name, password, role) VALUES ('teacher', 'pw', 'admin')")
+
+	// The same warrior plays in two games
+	gameID, avatars := newTestGame(t, "square", 1, 3, 2)
+	warrior := newTestWarrior(t, gameID, avatars[0], "A1", 50, 50)
+	otherGameID, _ := newTestGame(t, "square", 1, 3, 1)
+	mustExec(t, "INSERT INTO game_avatars (game_id, avatar_id, turn_order) VALUES (?, ?, 1)", otherGameID, avatars[0])
+	mustExec(t, "UPDATE game_cells SET occupied_by = ?, status = 'warrior' WHERE game_id = ? AND cell_id = 'A1'", warrior, otherGameID)
+	mustExec(t, `INSERT INTO game_warriors (game_id, asset_id, avatar_id, health, stamina, status, cell_id)
+		VALUES (?, ?, ?, 100, 100, 'active', ?)`, otherGameID, warrior, avatars[0], testCellID(t, otherGameID, "A1"))
+	mustExec(t, `INSERT INTO cell_effects (game_id, cell_id, effect_type, trigger, amount, charges, initial_charges)
+		VALUES (?, ?, 'damage', 'enter', 30, 1, 1)`, gameID, testCellID(t, gameID, "A2"))
+
+	type warriorState struct {
+		Health int
+		Cell   string
+	}
+	state := func(gameID int) warriorState {
+		var s warriorState
+		db.QueryRow(`SELECT gw.health, COALESCE(gc.cell_id, '') FROM game_warriors gw
+			LEFT JOIN game_cells gc ON gc.id = gw.cell_id
+			WHERE gw.game_id = ? AND gw.asset_id = ?`, gameID, warrior).Scan(&s.Health, &s.Cell)
+		return s
+	}
+	assetHealth := func() int {
+		var health int
+		db.QueryRow("SELECT health FROM assets WHERE id = ?", warrior).Scan(&health)
+		return health
+	}
+
+	// Stepping on the damage cell only hurts the warrior in this game
+	if _, status, err := moveWarriorOnBoard(gameID, testCellID(t, gameID, "A1"), testCellID(t, gameID, "A2"), warrior, avatars[0]); err != nil {
+		t.Fatalf("moving: %d %v", status, err)
+	}
+	tests := []struct {
+		name string
+		got  warriorState
+		want warriorState
+	}{
+		{"this game after the move", state(gameID), warriorState{70, "A2"}},
+		{"other game after the move", state(otherGameID), warriorState{100, "A1"}},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, tt.got, tt.want)
+		}
+	}
+	if health := assetHealth(); health != 100 {
+		t.Errorf("the warrior's own health changed to %d", health)
+	}
+
+	// Undoing the move puts the warrior back where it was, as healthy as it was, and recharges the cell
+	w := callHandler(t, undoGameAction, "POST", adminID, map[string]string{"id": fmt.Sprint(gameID)}, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("undo: got %d %s", w.Code, w.Body.String())
+	}
+	var occupiedBy, charges int
+	db.QueryRow("SELECT COALESCE(occupied_by, 0) FROM game_cells WHERE id = ?", testCellID(t, gameID, "A1")).Scan(&occupiedBy)
+	db.QueryRow("SELECT charges FROM cell_effects WHERE game_id = ?", gameID).Scan(&charges)
+	if got := state(gameID); got != (warriorState{100, "A1"}) || occupiedBy != warrior || charges != 1 {
+		t.Errorf("after undo: got %+v, A1 held by %d, %d charges left", got, occupiedBy, charges)
+	}
+	if got := state(otherGameID); got != (warriorState{100, "A1"}) {
+		t.Errorf("other game after undo: got %+v", got)
+	}
+
+	// The undo itself isn't undone, and there is nothing before the move
+	w = callHandler(t, undoGameAction, "POST", adminID, map[string]string{"id": fmt.Sprint(gameID)}, "")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("second undo: got %d %s, want %d", w.Code, w.Body.String(), http.StatusNotFound)
+	}
+}
+
+func TestGameViewer(t *testing.T) {
+	openTestDB(t)
+	adminID := mustExec(t, "INSERT INTO users (name, password, role) VALUES ('teacher', 'pw', 'admin')")
+	gameID, avatars := newTestGame(t, "square", 2, 2, 1)
+	otherGameID, others := newTestGame(t, "square", 2, 2, 1)
+
+	userOf := func(avatarID int) int {
+		var userID int
+		db.QueryRow("SELECT user_id FROM avatars WHERE id = ?", avatarID).Scan(&userID)
+		return userID
+	}
+
+	tests := []struct {
+		name   string
+		userID int
+		want   int
+	}{
+		{"admin", adminID, 0},
+		{"player", userOf(avatars[0]), avatars[0]},
+		{"classmate in another game", userOf(others[0]), -1},
+		{"anonymous", 0, -1},
+	}
+	for _, tt := range tests {
+		if got := gameViewer(gameID, tt.userID); got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, got, tt.want)
+		}
+	}
+	if got := gameViewer(otherGameID, userOf(others[0])); got != others[0] {
+		t.Errorf("player of the other game: got %d, want %d", got, others[0])
+	}
+}
+
+func TestResetAndCloneGame(t *testing.T) {
+	openTestDB(t)
+	adminID := mustExec(t, "INSERT INTO users (name, password, role) VALUES ('teacher', 'pw', 'admin')")
+	gameID, avatars := newTestGame(t, "square", 2, 2, 2)
+	newTestWarrior(t, gameID, avatars[0], "A1", 50, 50)
+	battleID := mustExec(t, "INSERT INTO battles (name, status) VALUES ('Held', 'in_progress')")
+
+	// A game some rounds in: a reward taken, a trap spent, a portal, coins collected and a battle going on
+	mustExec(t, "UPDATE game_cells SET base_reward_coins = 10, base_reward_xp = 5, reward_coins = 0, reward_xp = 0, claimed_round = 2 WHERE game_id = ? AND cell_id = 'B2'", gameID)
+	mustExec(t, `INSERT INTO cell_effects (game_id, cell_id, effect_type, trigger, amount, charges, initial_charges, last_triggered_turn)
+		VALUES (?, ?, 'damage', 'enter', 20, 0, 3, 4)`, gameID, testCellID(t, gameID, "A2"))
+	mustExec(t, `INSERT INTO cell_effects (game_id, cell_id, effect_type, trigger, target_cell_id, charges, initial_charges)
+		VALUES (?, ?, 'teleport', 'enter', ?, -1, -1)`, gameID, testCellID(t, gameID, "B1"), testCellID(t, gameID, "A2"))
+	mustExec(t, "UPDATE game_avatars SET coins_collected = 15 WHERE game_id = ?", gameID)
+	mustExec(t, "UPDATE games SET turn_number = 5, round = 3, battle_id = ? WHERE id = ?", battleID, gameID)
+
+	vars := map[string]string{"id": fmt.Sprint(gameID)}
+	var studentID int
+	db.QueryRow("SELECT user_id FROM avatars WHERE id = ?", avatars[0]).Scan(&studentID)
+	for name, handler := range map[string]http.HandlerFunc{"reset": resetGame, "clone": cloneGame} {
+		if w := callHandler(t, handler, "POST", studentID, vars, ""); w.Code != http.StatusForbidden {
+			t.Errorf("%s by a student: got %d, want %d", name, w.Code, http.StatusForbidden)
+		}
+		if w := callHandler(t, handler, "POST", adminID, map[string]string{"id": "999"}, ""); w.Code != http.StatusNotFound {
+			t.Errorf("%s of a missing game: got %d, want %d", name, w.Code, http.StatusNotFound)
+		}
+	}
+
+	// Cloning copies the board as it started into a new game in setup, with the same players
+	w := callHandler(t, cloneGame, "POST", adminID, vars, `{"name": "Again"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("clone: got %d %s", w.Code, w.Body.String())
+	}
+	var cloned struct {
+		GameID int `json:"gameId"`
+	}
+	json.NewDecoder(w.Body).Decode(&cloned)
+
+	type boardState struct {
+		Status, Name           string
+		Players, Warriors      int
+		RewardCoins, RewardXP  int
+		TrapCharges            int
+		PortalTarget           string
+		Occupied, CoinsCounted int
+	}
+	board := func(gameID int) boardState {
+		var s boardState
+		db.QueryRow("SELECT status, name FROM games WHERE id = ?", gameID).Scan(&s.Status, &s.Name)
+		db.QueryRow("SELECT COUNT(*), COALESCE(SUM(coins_collected), 0) FROM game_avatars WHERE game_id = ?", gameID).Scan(&s.Players, &s.CoinsCounted)
+		db.QueryRow("SELECT COUNT(*) FROM game_warriors WHERE game_id = ?", gameID).Scan(&s.Warriors)
+		db.QueryRow("SELECT COUNT(*) FROM game_cells WHERE game_id = ? AND occupied_by > 0", gameID).Scan(&s.Occupied)
+		db.QueryRow("SELECT reward_coins, reward_xp FROM game_cells WHERE game_id = ? AND cell_id = 'B2'", gameID).Scan(&s.RewardCoins, &s.RewardXP)
+		db.QueryRow("SELECT charges FROM cell_effects WHERE game_id = ? AND effect_type = 'damage'", gameID).Scan(&s.TrapCharges)
+		db.QueryRow(`SELECT target.cell_id FROM cell_effects ce JOIN game_cells target ON target.id = ce.target_cell_id
+			WHERE ce.game_id = ? AND ce.effect_type = 'teleport'`, gameID).Scan(&s.PortalTarget)
+		return s
+	}
+	if got, want := board(cloned.GameID), (boardState{"setup", "Again", 2, 0, 10, 5, 3, "A2", 0, 0}); got != want {
+		t.Errorf("clone: got %+v, want %+v", got, want)
+	}
+
+	// Resetting puts the game itself back to the start and calls off its battle
+	w = callHandler(t, resetGame, "POST", adminID, vars, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("reset: got %d %s", w.Code, w.Body.String())
+	}
+	if got, want := board(gameID), (boardState{"setup", "Test", 2, 0, 10, 5, 3, "A2", 0, 0}); got != want {
+		t.Errorf("reset: got %+v, want %+v", got, want)
+	}
+	var turnNumber, round int
+	var gameBattle sql.NullInt64
+	var battleStatus string
+	db.QueryRow("SELECT turn_number, round, battle_id FROM games WHERE id = ?", gameID).Scan(&turnNumber, &round, &gameBattle)
+	db.QueryRow("SELECT status FROM battles WHERE id = ?", battleID).Scan(&battleStatus)
+	if turnNumber != 0 || round != 0 || gameBattle.Valid || battleStatus != "cancelled" {
+		t.Errorf("reset: turn %d, round %d, battle %v (%s)", turnNumber, round, gameBattle, battleStatus)
+	}
+
+	// Nothing before the reset can be undone
+	if w := callHandler(t, undoGameAction, "POST", adminID, vars, ""); w.Code != http.StatusNotFound {
+		t.Errorf("undo after reset: got %d %s, want %d", w.Code, w.Body.String(), http.StatusNotFound)
+	}
+}
+
+func TestLoadGameView(t *testing.T) {
+	openTestDB(t)
+	gameID, avatars := newTestGame(t, "square", 1, 5, 2)
+	_, outsiders := newTestGame(t, "square", 1, 1, 1)
+	mine := newTestWarrior(t, gameID, avatars[0], "A1", 50, 50)
+	theirs := newTestWarrior(t, gameID, avatars[1], "A5", 50, 50)
+	mustExec(t, "UPDATE games SET fog_of_war = 1, vision_radius = 1 WHERE id = ?", gameID)
+
+	var outsiderUserID int
+	db.QueryRow("SELECT user_id FROM avatars WHERE id = ?", outsiders[0]).Scan(&outsiderUserID)
+
+	tests := []struct {
+		name     string
+		viewer   int
+		warriors []int
+		occupied []string
+		fogged   bool
+	}{
+		{"player", avatars[0], []int{mine}, []string{"A1"}, true},
+		{"classmate outside the game", gameViewer(gameID, outsiderUserID), []int{}, []string{}, true},
+		{"spectator screen", spectatorViewer, []int{mine, theirs}, []string{"A1", "A5"}, false},
+		{"teacher", 0, []int{mine, theirs}, []string{"A1", "A5"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			view, err := loadGameView(gameID, tt.viewer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			warriors := []int{}
+			for _, warrior := range view["warriors"].([]GameWarrior) {
+				warriors = append(warriors, warrior.WarriorID)
+			}
+			occupied := []string{}
+			for _, cell := range view["cells"].([]GameCell) {
+				if cell.OccupiedBy != 0 {
+					occupied = append(occupied, cell.CellID)
+				}
+			}
+			if !reflect.DeepEqual(warriors, tt.warriors) || !reflect.DeepEqual(occupied, tt.occupied) {
+				t.Errorf("got warriors %v on %v, want %v on %v", warriors, occupied, tt.warriors, tt.occupied)
+			}
+			if _, fogged := view["visibleCells"]; fogged != tt.fogged {
+				t.Errorf("got visibleCells %v, want it listed: %v", view["visibleCells"], tt.fogged)
+			}
+		})
+	}
+
+	// Once the enemy comes into sight the player sees it too
+	mustExec(t, "UPDATE game_cells SET occupied_by = 0, status = '' WHERE game_id = ? AND cell_id = 'A5'", gameID)
+	mustExec(t, "UPDATE game_cells SET occupied_by = ?, status = 'warrior' WHERE game_id = ? AND cell_id = 'A2'", theirs, gameID)
+	mustExec(t, "UPDATE game_warriors SET cell_id = ? WHERE game_id = ? AND asset_id = ?", testCellID(t, gameID, "A2"), gameID, theirs)
+	view, err := loadGameView(gameID, avatars[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if warriors := view["warriors"].([]GameWarrior); len(warriors) != 2 {
+		t.Errorf("enemy in sight: got %d warriors, want 2", len(warriors))
+	}
+}
+
+func TestBattleAnswersHidden(t *testing.T) {
+	openTestDB(t)
+	adminID := mustExec(t, "INSERT INTO users (name, password, role) VALUES ('teacher', 'pw', 'admin')")
+	gameID, avatars := newTestGame(t, "square", 1, 3, 3)
+	attacker := newTestWarrior(t, gameID, avatars[0], "A1", 50, 50)
+	defender := newTestWarrior(t, gameID, avatars[1], "A2", 50, 50)
+	battleID := mustExec(t, `INSERT INTO battles (name, reward, status, attacker, defender, attacker_avatar_id, defender_avatar_id, game_id)
+		VALUES ('Test battle', '', 'in_progress', ?, ?, ?, ?, ?)`, attacker, defender, avatars[0], avatars[1], gameID)
+	mustExec(t, "UPDATE games SET battle_id = ? WHERE id = ?", battleID, gameID)
+	attackerQuestion := mustExec(t, `INSERT INTO battle_questions (battle_id, question, answer, user_id, possible_points, time, user_answer, submitted_at)
+		VALUES (?, 'q', 'dos', ?, 10, 30, 'dos', datetime('now'))`, battleID, avatars[0])
+	defenderQuestion := mustExec(t, `INSERT INTO battle_questions (battle_id, question, answer, user_id, possible_points, time)
+		VALUES (?, 'q', 'tres', ?, 10, 30)`, battleID, avatars[1])
+
+	// What a viewer sees of the attacker's question: its correct answer and the answer given
+	type seen struct{ Answer, UserAnswer string }
+	attackerSees := func(viewer int) seen {
+		view, err := loadGameView(gameID, viewer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		question := view["battle"].(map[string]interface{})["attackerQuestion"].(BattleQuestion)
+		s := seen{Answer: question.Answer}
+		if question.UserAnswer != nil {
+			s.UserAnswer = *question.UserAnswer
+		}
+		return s
+	}
+
+	tests := []struct {
+		name          string
+		viewer        int
+		before, after seen
+	}{
+		{"teacher", 0, seen{"dos", "dos"}, seen{"dos", "dos"}},
+		{"attacker", avatars[0], seen{"", "dos"}, seen{"dos", "dos"}},
+		{"defender", avatars[1], seen{}, seen{"dos", "dos"}},
+		{"player outside the battle", avatars[2], seen{}, seen{"dos", "dos"}},
+		{"signed out", -1, seen{}, seen{}},
+		{"spectator screen", spectatorViewer, seen{}, seen{}},
+	}
+	for _, tt := range tests {
+		if got := attackerSees(tt.viewer); got != tt.before {
+			t.Errorf("%s, round not logged: got %+v, want %+v", tt.name, got, tt.before)
+		}
+	}
+
+	// The battle screen hides them the same way
+	for userID, want := range map[int]seen{adminID: {"dos", "dos"}, testUserID(t, avatars[0]): {"", "dos"}, testUserID(t, avatars[1]): {}} {
+		w := callHandler(t, getBattle, "GET", userID, map[string]string{"id": fmt.Sprint(battleID)}, "")
+		var body struct{ AttackerQuestion BattleQuestion }
+		json.NewDecoder(w.Body).Decode(&body)
+		got := seen{Answer: body.AttackerQuestion.Answer}
+		if body.AttackerQuestion.UserAnswer != nil {
+			got.UserAnswer = *body.AttackerQuestion.UserAnswer
+		}
+		if w.Code != http.StatusOK || got != want {
+			t.Errorf("battle screen for user %d: got %d %+v, want %+v", userID, w.Code, got, want)
+		}
+	}
+
+	// The question the defender hasn't answered yet comes without its answer, except for the teacher
+	for userID, want := range map[int]string{adminID: "tres", testUserID(t, avatars[1]): ""} {
+		w := callHandler(t, getUnansweredQuestion, "GET", userID, map[string]string{"userId": fmt.Sprint(avatars[1])}, "")
+		var body struct{ Question BattleQuestion }
+		json.NewDecoder(w.Body).Decode(&body)
+		if w.Code != http.StatusOK || body.Question.ID != defenderQuestion || body.Question.Answer != want {
+			t.Errorf("unanswered question for user %d: got %d %+v, want answer %q", userID, w.Code, body.Question, want)
+		}
+	}
+
+	// Once the round is logged, everyone but signed-out viewers and spectator screens sees how it went
+	mustExec(t, "UPDATE battle_questions SET user_answer = 'uno', submitted_at = datetime('now') WHERE id = ?", defenderQuestion)
+	mustExec(t, `INSERT INTO battle_rounds (battle_id, round, attacker_question_id, defender_question_id, attacker_answer, defender_answer)
+		VALUES (?, 1, ?, ?, 'dos', 'uno')`, battleID, attackerQuestion, defenderQuestion)
+	for _, tt := range tests {
+		if got := attackerSees(tt.viewer); got != tt.after {
+			t.Errorf("%s, round logged: got %+v, want %+v", tt.name, got, tt.after)
+		}
+	}
+}
+
+func TestSubmitAnswer(t *testing.T) {
+	openTestDB(t)
+	_, avatars := newTestGame(t, "square", 1, 1, 2)
+	userID := testUserID(t, avatars[0])
+
+	tests := []struct {
+		name   string
+		status string
+		owner  int
+		want   int
+	}{
+		{"battle in progress", "in_progress", avatars[0], http.StatusOK},
+		{"battle not started", "pending", avatars[0], http.StatusConflict},
+		{"battle over", "completed", avatars[0], http.StatusConflict},
+		{"someone else's question", "in_progress", avatars[1], http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		battleID := mustExec(t, "INSERT INTO battles (name, status) VALUES ('Test battle', ?)", tt.status)
+		questionID := mustExec(t, "INSERT INTO battle_questions (battle_id, question, answer, user_id, possible_points, time) VALUES (?, 'q', 'a', ?, 10, 30)",
+			battleID, tt.owner)
+
+		w := callHandler(t, submitAnswer, "POST", userID, nil, fmt.Sprintf(`{"questionId": %d, "answer": "a", "battleId": %d}`, questionID, battleID))
+		if w.Code != tt.want {
+			t.Errorf("%s: got %d %s, want %d", tt.name, w.Code, w.Body.String(), tt.want)
+		}
+		var submitted sql.NullString
+		db.QueryRow("SELECT submitted_at FROM battle_questions WHERE id = ?", questionID).Scan(&submitted)
+		if submitted.Valid != (tt.want == http.StatusOK) {
+			t.Errorf("%s: submitted at %v", tt.name, submitted)
+		}
+	}
+}
+
+func TestStartBoardBattle(t *testing.T) {
+	openTestDB(t)
+
+	tests := []struct {
+		name       string
+		fromCell   string // Cell the attack is made from; the attacker stands on A1
+		targetCell string
+		ownTarget  bool // The target warrior belongs to the attacker
+		turn       int  // Turn position whose avatar attacks
+		wantStatus int
+	}{
+		{"enemy next to the warrior", "A1", "A2", false, 0, http.StatusOK},
+		{"own warrior", "A1", "A2", true, 0, http.StatusConflict},
+		{"enemy out of range", "A1", "A5", false, 0, http.StatusConflict},
+		{"warrior moved away", "A3", "A2", false, 0, http.StatusConflict},
+		{"not the attacker's turn", "A1", "A2", false, 1, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		gameID, avatars := newTestGame(t, "square", 1, 5, 2)
+		attacker := newTestWarrior(t, gameID, avatars[0], "A1", 50, 50)
+		targetOwner := avatars[1]
+		if tt.ownTarget {
+			targetOwner = avatars[0]
+		}
+		newTestWarrior(t, gameID, targetOwner, tt.targetCell, 10, 10)
+		mustExec(t, "UPDATE games SET current_turn_index = ? WHERE id = ?", tt.turn, gameID)
+		mustExec(t, "INSERT INTO battle_questions (question, answer, possible_points, time) VALUES ('q1', 'a', 10, 30), ('q2', 'a', 10, 30)")
+
+		var fromCellID, toCellID int
+		db.QueryRow("SELECT id FROM game_cells WHERE game_id = ? AND cell_id = ?", gameID, tt.fromCell).Scan(&fromCellID)
+		db.QueryRow("SELECT id FROM game_cells WHERE game_id = ? AND cell_id = ?", gameID, tt.targetCell).Scan(&toCellID)
+
+		battleID, _, status, err := startBoardBattle(gameID, fromCellID, toCellID, attacker, avatars[0])
+		if status != tt.wantStatus {
+			t.Errorf("%s: got status %d (%v), want %d", tt.name, status, err, tt.wantStatus)
+			continue
+		}
+
+		var gameBattleID sql.NullInt64
+		var battles int
+		db.QueryRow("SELECT battle_id FROM games WHERE id = ?", gameID).Scan(&gameBattleID)
+		db.QueryRow("SELECT COUNT(*) FROM battles WHERE game_id = ?", gameID).Scan(&battles)
+		if tt.wantStatus != http.StatusOK {
+			if gameBattleID.Valid || battles != 0 {
+				t.Errorf("%s: a refused attack left battle %v (%d battles)", tt.name, gameBattleID, battles)
+			}
+			continue
+		}
+		if gameBattleID.Int64 != battleID {
+			t.Errorf("%s: the game holds battle %v, want %d", tt.name, gameBattleID, battleID)
+		}
+	}
+}
+
+func TestAutoResolveBattle(t *testing.T) {
+	openTestDB(t)
+
+	tests := []struct {
+		name           string
+		autoResolve    bool
+		attackerAnswer string // "" leaves the question unanswered
+		defenderAnswer string
+		startedAgo     int // Seconds since the round started; the questions have 30
+		resolved       bool
+		resolvedBy     string
+		winner         int // 0 for the attacker, 1 for the defender
+	}{
+		{"both answered", true, "a", "wrong", 0, true, "auto", 0},
+		{"waiting for the defender", true, "a", "", 5, false, "", 0},
+		{"defender ran out of time", true, "a", "", 60, true, "timeout", 0},
+		{"nobody answered in time", true, "", "", 60, true, "timeout", 1},
+		{"not auto-resolving", false, "a", "a", 60, false, "", 0},
+	}
+
+	for _, tt := range tests {
+		gameID, avatars := newTestGame(t, "square", 3, 3, 2)
+		attacker := newTestWarrior(t, gameID, avatars[0], "B1", 50, 50)
+		defender := newTestWarrior(t, gameID, avatars[1], "B2", 10, 10)
+
+		var fromCellID, toCellID int
+		db.QueryRow("SELECT id FROM game_cells WHERE game_id = ? AND cell_id = 'B1'", gameID).Scan(&fromCellID)
+		db.QueryRow("SELECT id FROM game_cells WHERE game_id = ? AND cell_id = 'B2'", gameID).Scan(&toCellID)
+		battleID := mustExec(t, `INSERT INTO battles (name, status, attacker, defender, attacker_avatar_id, defender_avatar_id, game_id,
+			from_cell_id, to_cell_id, format, auto_resolve, round_started_at)
+			VALUES ('Test battle', 'in_progress', ?, ?, ?, ?, ?, ?, ?, 'single', ?, datetime('now', ?))`,
+			attacker, defender, avatars[0], avatars[1], gameID, fromCellID, toCellID, tt.autoResolve, fmt.Sprintf("-%d seconds", tt.startedAgo))
+		mustExec(t, "UPDATE games SET battle_id = ? WHERE id = ?", battleID, gameID)
+		for i, answer := range []string{tt.attackerAnswer, tt.defenderAnswer} {
+			if answer == "" {
+				mustExec(t, "INSERT INTO battle_questions (battle_id, question, answer, user_id, possible_points, time) VALUES (?, 'q', 'a', ?, 10, 30)",
+					battleID, avatars[i])
+			} else {
+				mustExec(t, `INSERT INTO battle_questions (battle_id, question, answer, user_id, possible_points, time, user_answer, submitted_at)
+					VALUES (?, 'q', 'a', ?, 10, 30, ?, datetime('now'))`, battleID, avatars[i], answer)
+			}
+		}
+
+		if got := autoResolveBattle(battleID); got != tt.resolved {
+			t.Errorf("%s: autoResolveBattle got %v, want %v", tt.name, got, tt.resolved)
+			continue
+		}
+
+		var status string
+		var resolvedBy sql.NullString
+		var winner sql.NullInt64
+		var gameBattleID sql.NullInt64
+		db.QueryRow("SELECT status, resolved_by, winner FROM battles WHERE id = ?", battleID).Scan(&status, &resolvedBy, &winner)
+		db.QueryRow("SELECT battle_id FROM games WHERE id = ?", gameID).Scan(&gameBattleID)
+		if !tt.resolved {
+			if status != "in_progress" || resolvedBy.Valid || !gameBattleID.Valid {
+				t.Errorf("%s: got status %q, resolved by %v, game battle %v; want the battle untouched", tt.name, status, resolvedBy, gameBattleID)
+			}
+			continue
+		}
+		if status != "completed" || resolvedBy.String != tt.resolvedBy {
+			t.Errorf("%s: got status %q resolved by %q, want completed by %q", tt.name, status, resolvedBy.String, tt.resolvedBy)
+		}
+		if int(winner.Int64) != avatars[tt.winner] {
+			t.Errorf("%s: got winner %d, want %d", tt.name, winner.Int64, avatars[tt.winner])
+		}
+		if gameBattleID.Valid {
+			t.Errorf("%s: the game is still held by battle %d", tt.name, gameBattleID.Int64)
+		}
+	}
+}